@@ -0,0 +1,154 @@
+package binary
+
+import (
+	"hash"
+
+	"golang.org/x/xerrors"
+
+	"wsnet2/auth"
+)
+
+// まとめ送り
+//
+// 小さなMsgやEventを1つずつwebsocketのフレームにすると、フレームやHMACのオーバーヘッドが大きい.
+// 送信待ちのものを MsgTypeBatch や EvTypeBatch で1つのフレームにまとめて送る.
+// クライアントが Wsnet2-Batch ヘッダで要求し、サーバが EvPeerReady の PeerFlagBatch で応じたときのみ使う.
+//
+// どちらも先頭のシーケンス番号だけを持ち、以降のMsgやEventは1ずつ増える番号として扱う.
+// 単独で送る場合と同じ順番とシーケンス番号になるので、再送や重複の検出は変わらない.
+
+const (
+	// MaxBatchEntrySize : まとめるMsgやEventのpayloadの最大バイト数. これより大きいものは単独で送る
+	MaxBatchEntrySize = 1024
+	// MaxBatchSize : 1つのフレームにまとめるpayloadの合計の目安
+	MaxBatchSize = 16 * 1024
+)
+
+// BuildBatchMsgFrame : 連続したシーケンス番号のRegularMsgをまとめたフレーム.
+// HMACはフレーム全体に1つだけ付ける.
+func BuildBatchMsgFrame(msgs []RegularMsg, hmac hash.Hash) []byte {
+	size := 1 + 3 + hmac.Size()
+	for _, m := range msgs {
+		size += 4 + len(m.Payload())
+	}
+	data := make([]byte, 4, size)
+	data[0] = byte(MsgTypeBatch)
+	put24(data[1:], int64(msgs[0].SequenceNum()))
+	for _, m := range msgs {
+		var h [4]byte
+		h[0] = byte(m.Type())
+		put24(h[1:], int64(len(m.Payload())))
+		data = append(data, h[:]...)
+		data = append(data, m.Payload()...)
+	}
+	return append(data, auth.CalculateMsgHMAC(hmac, data)...)
+}
+
+// UnmarshalBatchMsgPayload : MsgTypeBatchのpayloadをRegularMsgに分ける
+func UnmarshalBatchMsgPayload(payload []byte) ([]RegularMsg, error) {
+	if len(payload) < 3 {
+		return nil, xerrors.Errorf("data length not enough: %v", len(payload))
+	}
+	seq := get24(payload)
+	payload = payload[3:]
+
+	var msgs []RegularMsg
+	for len(payload) > 0 {
+		if len(payload) < 4 {
+			return nil, xerrors.Errorf("batch[%v]: data length not enough: %v", len(msgs), len(payload))
+		}
+		mt := MsgType(payload[0])
+		l := get24(payload[1:])
+		payload = payload[4:]
+		if mt < regularMsgType {
+			return nil, xerrors.Errorf("batch[%v]: not a regular msg: %v", len(msgs), mt)
+		}
+		if len(payload) < l {
+			return nil, xerrors.Errorf("batch[%v]: data length not enough: %v < %v", len(msgs), len(payload), l)
+		}
+		msgs = append(msgs, &regularMsg{mt, seq, payload[:l:l]})
+		seq++
+		payload = payload[l:]
+	}
+	if len(msgs) == 0 {
+		return nil, xerrors.Errorf("empty batch")
+	}
+	return msgs, nil
+}
+
+// AppendEvBatchHeader : EvTypeBatchのフレームの先頭 (EvType, flags, 先頭のシーケンス番号) をdstに追加する
+func AppendEvBatchHeader(dst []byte, firstSeq int, timestamped bool) []byte {
+	var h [6]byte
+	h[0] = byte(EvTypeBatch)
+	if timestamped {
+		h[1] = PeerFlagEventTimestamp
+	}
+	put32(h[2:], int64(firstSeq))
+	return append(dst, h[:]...)
+}
+
+// AppendBatchEntryHeader : EvTypeBatchに入れるときにpayloadの前に付けるヘッダをdstに追加する
+func (ev *RegularEvent) AppendBatchEntryHeader(dst []byte, timestamped bool) []byte {
+	var h [13]byte
+	h[0] = byte(ev.etype)
+	n := 1
+	if timestamped {
+		put64(h[n:], ev.timestamp)
+		n += 8
+	}
+	put32(h[n:], int64(len(ev.payload)))
+	return append(dst, h[:n+4]...)
+}
+
+// NewEvBatch : 連続したシーケンス番号のRegularEventをまとめたEvent
+func NewEvBatch(firstSeq int, evs []*RegularEvent, timestamped bool) *SystemEvent {
+	buf := AppendEvBatchHeader(nil, firstSeq, timestamped)
+	for _, ev := range evs {
+		buf = ev.AppendBatchEntryHeader(buf, timestamped)
+		buf = append(buf, ev.payload...)
+	}
+	return &SystemEvent{
+		etype:   EvTypeBatch,
+		payload: buf[1:],
+	}
+}
+
+// UnmarshalEvBatchPayload : EvTypeBatchのpayloadを先頭のシーケンス番号とRegularEventに分ける
+func UnmarshalEvBatchPayload(payload []byte) (int, []*RegularEvent, error) {
+	if len(payload) < 5 {
+		return 0, nil, xerrors.Errorf("data length not enough: %v", len(payload))
+	}
+	timestamped := payload[0]&PeerFlagEventTimestamp != 0
+	first := get32(payload[1:])
+	payload = payload[5:]
+
+	hlen := 5
+	if timestamped {
+		hlen += 8
+	}
+	var evs []*RegularEvent
+	for len(payload) > 0 {
+		if len(payload) < hlen {
+			return 0, nil, xerrors.Errorf("batch[%v]: data length not enough: %v", len(evs), len(payload))
+		}
+		ev := &RegularEvent{etype: EvType(payload[0])}
+		if timestamped {
+			ev.timestamp = get64(payload[1:])
+		}
+		l := get32(payload[hlen-4:])
+		payload = payload[hlen:]
+		if !IsRegularEvent(ev) {
+			return 0, nil, xerrors.Errorf("batch[%v]: not a regular event: %v", len(evs), ev.etype)
+		}
+		if len(payload) < l {
+			return 0, nil, xerrors.Errorf("batch[%v]: data length not enough: %v < %v", len(evs), len(payload), l)
+		}
+		ev.payload = payload[:l:l]
+		evs = append(evs, ev)
+		payload = payload[l:]
+	}
+	if len(evs) == 0 {
+		return 0, nil, xerrors.Errorf("empty batch")
+	}
+	return first, evs, nil
+}
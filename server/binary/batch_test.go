@@ -0,0 +1,90 @@
+package binary
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"reflect"
+	"testing"
+)
+
+func TestBatchMsg(t *testing.T) {
+	mac := hmac.New(sha1.New, []byte("mackey"))
+	msgs := []RegularMsg{
+		NewRegularMsg(MsgTypeBroadcast, 10, MarshalStr8("hello")),
+		NewRegularMsg(MsgTypeToMaster, 11, nil),
+		NewRegularMsg(MsgTypeLockstepInput, 12, MarshalLockstepInputPayload(3, MarshalInt(1))),
+	}
+	frame := BuildBatchMsgFrame(msgs, mac)
+
+	msg, err := UnmarshalMsg(mac, frame)
+	if err != nil {
+		t.Fatalf("UnmarshalMsg: %v", err)
+	}
+	if msg.Type() != MsgTypeBatch {
+		t.Fatalf("type = %v, wants %v", msg.Type(), MsgTypeBatch)
+	}
+	got, err := UnmarshalBatchMsgPayload(msg.Payload())
+	if err != nil {
+		t.Fatalf("UnmarshalBatchMsgPayload: %v", err)
+	}
+	if len(got) != len(msgs) {
+		t.Fatalf("msgs: %v, wants %v", len(got), len(msgs))
+	}
+	for i, m := range msgs {
+		g := got[i]
+		if g.Type() != m.Type() || g.SequenceNum() != m.SequenceNum() || len(g.Payload()) != len(m.Payload()) ||
+			(len(m.Payload()) > 0 && !reflect.DeepEqual(g.Payload(), m.Payload())) {
+			t.Errorf("msgs[%v] = %v %v %v, wants %v %v %v", i,
+				g.Type(), g.SequenceNum(), g.Payload(), m.Type(), m.SequenceNum(), m.Payload())
+		}
+	}
+
+	frame[5] ^= 0xff
+	if _, err := UnmarshalMsg(mac, frame); err == nil {
+		t.Errorf("UnmarshalMsg must fail for modified frame")
+	}
+	if _, err := UnmarshalBatchMsgPayload([]byte{0, 0, 1, byte(MsgTypePing), 0, 0, 0}); err == nil {
+		t.Errorf("UnmarshalBatchMsgPayload must fail for nonregular msg")
+	}
+	if _, err := UnmarshalBatchMsgPayload([]byte{0, 0, 1, byte(MsgTypeBroadcast), 0, 0, 5, 1}); err == nil {
+		t.Errorf("UnmarshalBatchMsgPayload must fail for short payload")
+	}
+}
+
+func TestBatchEvent(t *testing.T) {
+	evs := []*RegularEvent{
+		NewRegularEventAt(EvTypeMessage, MarshalStr8("hello"), 1700000000123),
+		NewRegularEventAt(EvTypeLeft, MarshalStr8("p1"), 1700000000456),
+	}
+	for _, timestamped := range []bool{false, true} {
+		ev, _, err := UnmarshalEvent(NewEvBatch(100, evs, timestamped).Marshal())
+		if err != nil {
+			t.Fatalf("UnmarshalEvent: %v", err)
+		}
+		if ev.Type() != EvTypeBatch {
+			t.Fatalf("type = %v, wants %v", ev.Type(), EvTypeBatch)
+		}
+		first, got, err := UnmarshalEvBatchPayload(ev.Payload())
+		if err != nil {
+			t.Fatalf("UnmarshalEvBatchPayload: %v", err)
+		}
+		if first != 100 || len(got) != len(evs) {
+			t.Fatalf("first=%v len=%v, wants 100 %v", first, len(got), len(evs))
+		}
+		for i, e := range evs {
+			var ts uint64
+			if timestamped {
+				ts = e.Timestamp()
+			}
+			g := got[i]
+			if g.Type() != e.Type() || !reflect.DeepEqual(g.Payload(), e.Payload()) || g.Timestamp() != ts {
+				t.Errorf("timestamped=%v: events[%v] = %v %v %v, wants %v %v %v", timestamped, i,
+					g.Type(), g.Payload(), g.Timestamp(), e.Type(), e.Payload(), ts)
+			}
+		}
+	}
+
+	if _, _, err := UnmarshalEvBatchPayload([]byte{0, 0, 0, 0, 1, byte(EvTypePong), 0, 0, 0, 0}); err == nil {
+		t.Errorf("UnmarshalEvBatchPayload must fail for system event")
+	}
+}
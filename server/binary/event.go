@@ -1,6 +1,8 @@
 package binary
 
 import (
+	"time"
+
 	"wsnet2/pb"
 
 	"golang.org/x/xerrors"
@@ -14,9 +16,30 @@ const responseEvType = 128
 const (
 	// NewEvPeerReady : Peer準備完了イベント
 	// payload:
-	// | 24bit-be msg sequence number |
+	// | 24bit-be msg sequence number | 8bit flags (optional) |
 	EvTypePeerReady EvType = 1 + iota
 	EvTypePong
+
+	// EvTypeMigrated : 部屋が別のgameサーバに移動した
+	// payload:
+	// - str16: new websocket URL
+	EvTypeMigrated
+
+	// EvTypeVolatile : 揮発性の値 (MsgVolatile)
+	// evbufに入れず、再送もしない
+	// payload:
+	// - str8: sender client id
+	// - str8: key
+	// - marshaled bytes: value
+	EvTypeVolatile
+
+	// EvTypeBatch : 連続したシーケンス番号のRegularEventをまとめたもの
+	// Wsnet2-Batch ヘッダで要求したクライアントにのみ送る
+	// payload:
+	// - 8bit flags (PeerFlagEventTimestamp: 各Eventに時刻が付いている)
+	// - 32bit-be: sequence number of the first event
+	// - repeated: | 8bit EvType | 64bit-be unixtime (millisec; timestamped only) | 32bit-be payload length | payload ... |
+	EvTypeBatch
 )
 const (
 	// EvTypeJoined : クライアントが入室した
@@ -39,17 +62,20 @@ const (
 	// - UShort: client deadline (second)
 	// - Dict: public props (modified keys only)
 	// - Dict: private props (modified keys only)
+	// - ULong: version of the modified props
 	EvTypeRoomProp
 
 	// EvTypeClientProp : クライアント情報の変更
 	// payload:
 	//  - str8: client ID
 	//  - Dict: properties (modified keys only)
+	//  - ULong: version of the modified props
 	EvTypeClientProp
 
 	// EvTypeMasterSwitched : Masterクライアントが切替わった
 	// payload:
 	//  - str8: new master client ID
+	//  - Byte: reason (MasterSwitchReason)
 	EvTypeMasterSwitched
 
 	// EvTypeMessage : その他の通常メッセージ
@@ -61,6 +87,75 @@ const (
 	//  - str8: client ID
 	//  - Dict: properties
 	EvTypeRejoined
+
+	// EvTypeRoleChanged : クライアントがPlayerとWatcherの間で移動した
+	// payload:
+	//  - str8: client ID
+	//  - Bool: true if the client became a player
+	//  - Dict: properties
+	EvTypeRoleChanged
+
+	// EvTypeTimer : サーバ側のタイマーが発火した
+	// payload:
+	//  - str8: timer id
+	//  - marshaled bytes: data
+	EvTypeTimer
+
+	// EvTypeLockstepFrame : ロックステップのフレームの入力が揃った
+	// payload:
+	//  - UInt: frame number
+	//  - Dict: client id => input (Null if not received)
+	EvTypeLockstepFrame
+
+	// EvTypeLockstepHistory : 途中入室したクライアントに送る過去のフレーム
+	// payload:
+	//  - UInt: first frame number
+	//  - List: Dict of inputs of each frame
+	EvTypeLockstepHistory
+
+	// EvTypeObjectSpawned : ネットワークオブジェクトが生成された
+	// payload:
+	//  - NetObject
+	EvTypeObjectSpawned
+
+	// EvTypeObjectUpdated : ネットワークオブジェクトの状態が更新された
+	// payload:
+	//  - str8: object id
+	//  - Dict: modified state (Null for the removed key)
+	EvTypeObjectUpdated
+
+	// EvTypeObjectOwnerChanged : ネットワークオブジェクトのオーナーが変わった
+	// payload:
+	//  - str8: object id
+	//  - str8: new owner client id
+	EvTypeObjectOwnerChanged
+
+	// EvTypeObjectDespawned : ネットワークオブジェクトが削除された
+	// payload:
+	//  - str8: object id
+	EvTypeObjectDespawned
+
+	// EvTypeObjectSnapshot : 途中入室したクライアントに送るネットワークオブジェクト
+	// payload:
+	//  - Byte: count
+	//  - repeat: NetObject
+	EvTypeObjectSnapshot
+
+	// EvTypeRandom : サーバが生成した乱数
+	// payload:
+	//  - str8: requester client id
+	//  - str8: tag
+	//  - Byte: kind (RandomKind)
+	//  - UInts: values
+	EvTypeRandom
+
+	// EvTypeCached : MsgCachedBroadcastのキャッシュの更新 (Hubにのみ送る)
+	// Hubは途中から観戦を始めたWatcherに送るためにキャッシュを保持する.
+	// payload:
+	//  - str8: cache key
+	//  - str8: sender client id
+	//  - marshaled bytes: data (empty if the cache is removed)
+	EvTypeCached
 )
 const (
 	// EvTypeSucceeded:
@@ -80,6 +175,37 @@ const (
 	//  - List: client IDs
 	//  - marshaled bytes: original msg payload
 	EvTypeTargetNotFound
+
+	// EvTypeRateLimited : 送信レート制限により破棄した
+	// payload:
+	//  - 24bit be: Msg sequence num
+	//  - marshaled bytes: original msg payload
+	EvTypeRateLimited
+
+	// EvTypeInvalidPayload : 大きさの制限やプロパティのスキーマに違反した
+	// payload:
+	//  - 24bit be: Msg sequence num
+	//  - marshaled bytes: original msg payload
+	EvTypeInvalidPayload
+
+	// EvTypeRejected : 部屋の状態により実行できなかった（満室など）
+	// payload:
+	//  - 24bit be: Msg sequence num
+	//  - marshaled bytes: original msg payload
+	EvTypeRejected
+
+	// EvTypePropConflict : プロパティが変更の条件に合わなかった
+	// payload:
+	//  - 24bit be: Msg sequence num
+	//  - VersionedProps: current public props (or client props) of the conflicted keys
+	//  - VersionedProps: current private props of the conflicted keys (MsgRoomProp only)
+	EvTypePropConflict
+
+	// EvTypeTimerList : サーバ側のタイマーの一覧
+	// payload:
+	//  - 24bit be: Msg sequence num
+	//  - Dict: timer id => ULong: remaining time (millisecond)
+	EvTypeTimerList
 )
 
 type Event interface {
@@ -103,24 +229,63 @@ func IsResponseEvent(ev Event) bool {
 //
 // regular event binary format:
 // | 8bit EvType | 32bit-be sequence number | payload ... |
+//
+// with server timestamp (negotiated by EvPeerReady):
+// | 8bit EvType | 32bit-be sequence number | 64bit-be unixtime (millisec) | payload ... |
+//
+// 宛先毎に異なるのはヘッダ（シーケンス番号まで）だけなので、複数の宛先に送るときは
+// AppendHeader で作ったヘッダと共通のpayloadを続けて書き込めば宛先毎にmarshalしなくてよい.
 type RegularEvent struct {
 	etype   EvType
 	payload []byte
+
+	// timestamp : Eventが発生したサーバの時刻 (unixtime millisec). 0なら不明
+	timestamp uint64
 }
 
-func (ev *RegularEvent) Type() EvType    { return ev.etype }
-func (ev *RegularEvent) Payload() []byte { return ev.payload }
+func (ev *RegularEvent) Type() EvType      { return ev.etype }
+func (ev *RegularEvent) Payload() []byte   { return ev.payload }
+func (ev *RegularEvent) Timestamp() uint64 { return ev.timestamp }
 
 func NewRegularEvent(etype EvType, payload []byte) *RegularEvent {
-	return &RegularEvent{etype, payload}
+	return &RegularEvent{etype, payload, uint64(time.Now().UnixMilli())}
+}
+
+// NewRegularEventAt : 発生時刻を指定してEventを作る
+func NewRegularEventAt(etype EvType, payload []byte, timestamp uint64) *RegularEvent {
+	return &RegularEvent{etype, payload, timestamp}
+}
+
+const (
+	// RegularEventHeaderSize : RegularEventのヘッダ（EvTypeとシーケンス番号）のバイト数
+	RegularEventHeaderSize = 5
+	// TimestampedEventHeaderSize : サーバの時刻付きのヘッダのバイト数
+	TimestampedEventHeaderSize = 13
+)
+
+// AppendHeader : payloadの前に付けるヘッダをdstに追加する
+func (ev *RegularEvent) AppendHeader(dst []byte, seqNum int, timestamped bool) []byte {
+	var h [TimestampedEventHeaderSize]byte
+	h[0] = byte(ev.etype)
+	put32(h[1:], int64(seqNum))
+	if !timestamped {
+		return append(dst, h[:RegularEventHeaderSize]...)
+	}
+	put64(h[5:], ev.timestamp)
+	return append(dst, h[:]...)
 }
 
 func (ev *RegularEvent) Marshal(seqNum int) []byte {
-	buf := make([]byte, len(ev.payload)+5)
-	buf[0] = byte(ev.etype)
-	put32(buf[1:], int64(seqNum))
-	copy(buf[5:], ev.payload)
-	return buf
+	buf := make([]byte, 0, len(ev.payload)+RegularEventHeaderSize)
+	buf = ev.AppendHeader(buf, seqNum, false)
+	return append(buf, ev.payload...)
+}
+
+// MarshalWithTimestamp : サーバの時刻付きでmarshalする
+func (ev *RegularEvent) MarshalWithTimestamp(seqNum int) []byte {
+	buf := make([]byte, 0, len(ev.payload)+TimestampedEventHeaderSize)
+	buf = ev.AppendHeader(buf, seqNum, true)
+	return append(buf, ev.payload...)
 }
 
 // ParseMsg parse binary data to Event struct
@@ -142,12 +307,33 @@ func UnmarshalEvent(data []byte) (Event, int, error) {
 	seq := get32(data)
 	data = data[4:]
 
-	return &RegularEvent{et, data}, seq, nil
+	return &RegularEvent{et, data, 0}, seq, nil
+}
+
+// UnmarshalTimestampedEvent : サーバの時刻付きのEventをunmarshalする
+func UnmarshalTimestampedEvent(data []byte) (Event, int, error) {
+	ev, seq, err := UnmarshalEvent(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	rev, ok := ev.(*RegularEvent)
+	if !ok {
+		return ev, seq, nil
+	}
+	if len(rev.payload) < 8 {
+		return nil, 0, xerrors.Errorf("data length not enough: %v", len(data))
+	}
+	rev.timestamp = get64(rev.payload)
+	rev.payload = rev.payload[8:]
+	return rev, seq, nil
 }
 
 // SystemEvent (without sequence number)
 // - EvTypePeerReady
 // - EvTypePong
+// - EvTypeMigrated
+// - EvTypeVolatile
+// - EvTypeBatch
 // binary format:
 // | 8bit MsgType | payload ... |
 type SystemEvent struct {
@@ -158,33 +344,53 @@ type SystemEvent struct {
 func (ev *SystemEvent) Type() EvType    { return ev.etype }
 func (ev *SystemEvent) Payload() []byte { return ev.payload }
 
+// AppendHeader : payloadの前に付けるヘッダ（EvType）をdstに追加する
+func (ev *SystemEvent) AppendHeader(dst []byte) []byte {
+	return append(dst, byte(ev.etype))
+}
+
 func (ev *SystemEvent) Marshal() []byte {
-	buf := make([]byte, len(ev.payload)+1)
-	buf[0] = byte(ev.etype)
-	copy(buf[1:], ev.payload)
-	return buf
+	buf := make([]byte, 0, len(ev.payload)+1)
+	buf = ev.AppendHeader(buf)
+	return append(buf, ev.payload...)
 }
 
 // NewEvPeerReady : Peer準備完了イベント
 // wsnetが受信済みのMsgシーケンス番号を通知.
 // これを受信後、クライアントはMsgを該当シーケンス番号から送信する.
+// クライアントが要求した機能のうち、サーバが応じるものもflagsで通知する.
 // payload:
-// | 24bit-be msg sequence number |
-func NewEvPeerReady(seqNum int) *SystemEvent {
-	payload := make([]byte, 3)
+// | 24bit-be msg sequence number | 8bit flags (optional) |
+func NewEvPeerReady(seqNum int, flags byte) *SystemEvent {
+	payload := make([]byte, 3, 4)
 	put24(payload, int64(seqNum))
+	if flags != 0 {
+		payload = append(payload, flags)
+	}
 	return &SystemEvent{
 		etype:   EvTypePeerReady,
 		payload: payload,
 	}
 }
 
-func UnmarshalEvPeerReadyPayload(payload []byte) (int, error) {
+const (
+	// PeerFlagEventTimestamp : RegularEventにサーバの時刻を付ける
+	PeerFlagEventTimestamp = 1 << iota
+	// PeerFlagBatch : MsgTypeBatch を受け付け、EvTypeBatch を送る
+	PeerFlagBatch
+)
+
+// UnmarshalEvPeerReadyPayload : 受信済みのMsgシーケンス番号とflags
+func UnmarshalEvPeerReadyPayload(payload []byte) (int, byte, error) {
 	if len(payload) < 3 {
-		return 0, xerrors.Errorf("data length not enough: %v", len(payload))
+		return 0, 0, xerrors.Errorf("data length not enough: %v", len(payload))
+	}
+	var flags byte
+	if len(payload) > 3 {
+		flags = payload[3]
 	}
 
-	return get24(payload), nil
+	return get24(payload), flags, nil
 }
 
 // NewEvPong : Pongイベント
@@ -192,10 +398,16 @@ func UnmarshalEvPeerReadyPayload(payload []byte) (int, error) {
 // - unsigned 64bit-be: timestamp on ping sent.
 // - unsigned 32bit-be: watcher count in the room.
 // - dict: last msg timestamps of each player.
-func NewEvPong(pingtime uint64, watchers uint32, lastMsg Dict) *SystemEvent {
+// - unsigned 64bit-be: server time on ping received (unixtime millisec).
+// - unsigned 64bit-be: server time on pong sent (unixtime millisec).
+//
+// クライアントは ((received - pingtime) + (sent - pong受信時刻)) / 2 でサーバの時計とのずれを求められる.
+func NewEvPong(pingtime uint64, watchers uint32, lastMsg Dict, received time.Time) *SystemEvent {
 	payload := MarshalULong(pingtime)
 	payload = append(payload, MarshalUInt(int(watchers))...)
 	payload = append(payload, MarshalDict(lastMsg)...)
+	payload = append(payload, MarshalULong(uint64(received.UnixMilli()))...)
+	payload = append(payload, MarshalULong(uint64(time.Now().UnixMilli()))...)
 
 	return &SystemEvent{
 		etype:   EvTypePong,
@@ -207,6 +419,22 @@ type EvPongPayload struct {
 	Timestamp    uint64
 	Watchers     uint32
 	LastMsgTimes Dict
+
+	// ServerReceived, ServerSent : サーバがPingを受信した時刻とPongを送信した時刻. 古いサーバでは0
+	ServerReceived uint64
+	ServerSent     uint64
+}
+
+// ClockOffset : サーバの時計とのずれ (サーバの時刻 - クライアントの時刻).
+// received はクライアントがPongを受信した時刻.
+func (pp *EvPongPayload) ClockOffset(received time.Time) (time.Duration, bool) {
+	if pp.ServerReceived == 0 || pp.ServerSent == 0 {
+		return 0, false
+	}
+	t0 := int64(pp.Timestamp)
+	t3 := received.UnixMilli()
+	ms := (int64(pp.ServerReceived) - t0 + int64(pp.ServerSent) - t3) / 2
+	return time.Duration(ms) * time.Millisecond, true
 }
 
 func UnmarshalEvPongPayload(payload []byte) (*EvPongPayload, error) {
@@ -229,20 +457,83 @@ func UnmarshalEvPongPayload(payload []byte) (*EvPongPayload, error) {
 	payload = payload[l:]
 
 	// lastmsg
-	pp.LastMsgTimes, _, e = UnmarshalNullDict(payload)
+	pp.LastMsgTimes, l, e = UnmarshalNullDict(payload)
 	if e != nil {
 		return nil, xerrors.Errorf("Invalid EvPong payload (lastmsg): %w", e)
 	}
+	payload = payload[l:]
+
+	// server times (古いサーバでは無い)
+	if len(payload) == 0 {
+		return &pp, nil
+	}
+	d, l, e = UnmarshalAs(payload, TypeULong)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvPong payload (server received): %w", e)
+	}
+	pp.ServerReceived = d.(uint64)
+	d, _, e = UnmarshalAs(payload[l:], TypeULong)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvPong payload (server sent): %w", e)
+	}
+	pp.ServerSent = d.(uint64)
 
 	return &pp, nil
 }
 
+// NewEvMigrated : 部屋移動イベント
+// これを受信後、クライアントは新しいURLに再接続する.
+// payload:
+// - str16: new websocket URL
+func NewEvMigrated(url string) *SystemEvent {
+	return &SystemEvent{
+		etype:   EvTypeMigrated,
+		payload: MarshalStr16(url),
+	}
+}
+
+func UnmarshalEvMigratedPayload(payload []byte) (string, error) {
+	d, _, e := UnmarshalAs(payload, TypeStr8, TypeStr16)
+	if e != nil {
+		return "", xerrors.Errorf("Invalid EvMigrated payload (url): %w", e)
+	}
+	return d.(string), nil
+}
+
+// NewEvVolatile : 揮発性の値
+func NewEvVolatile(sender, key string, value []byte) *SystemEvent {
+	payload := make([]byte, 0, len(sender)+len(key)+4+len(value))
+	payload = append(payload, MarshalStr8(sender)...)
+	payload = append(payload, MarshalStr8(key)...)
+	payload = append(payload, value...)
+	return &SystemEvent{
+		etype:   EvTypeVolatile,
+		payload: payload,
+	}
+}
+
+// UnmarshalEvVolatilePayload : 送信者、キー、値
+func UnmarshalEvVolatilePayload(payload []byte) (string, string, []byte, error) {
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", "", nil, xerrors.Errorf("Invalid EvVolatile payload (sender): %w", e)
+	}
+	sender := d.(string)
+	payload = payload[l:]
+
+	d, l, e = UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", "", nil, xerrors.Errorf("Invalid EvVolatile payload (key): %w", e)
+	}
+	return sender, d.(string), payload[l:], nil
+}
+
 // NewEvJoind : 入室イベント
 func NewEvJoined(cli *pb.ClientInfo) *RegularEvent {
 	payload := MarshalStr8(cli.Id)
 	payload = append(payload, cli.Props...) // cli.Props marshaled as TypeDict
 
-	return &RegularEvent{EvTypeJoined, payload}
+	return NewRegularEvent(EvTypeJoined, payload)
 }
 
 func UnmarshalEvJoinedPayload(payload []byte) (*pb.ClientInfo, error) {
@@ -266,12 +557,307 @@ func UnmarshalEvJoinedPayload(payload []byte) (*pb.ClientInfo, error) {
 	return &um, nil
 }
 
+// NewEvRoleChanged : PlayerとWatcherの間の移動
+func NewEvRoleChanged(cli *pb.ClientInfo, isPlayer bool) *RegularEvent {
+	payload := MarshalStr8(cli.Id)
+	payload = append(payload, MarshalBool(isPlayer)...)
+	payload = append(payload, cli.Props...) // cli.Props marshaled as TypeDict
+
+	return NewRegularEvent(EvTypeRoleChanged, payload)
+}
+
+func UnmarshalEvRoleChangedPayload(payload []byte) (*pb.ClientInfo, bool, error) {
+	um := pb.ClientInfo{}
+
+	// client id
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return nil, false, xerrors.Errorf("Invalid EvRoleChanged payload (client id): %w", e)
+	}
+	um.Id = d.(string)
+	payload = payload[l:]
+
+	// role
+	p, l, e := UnmarshalAs(payload, TypeTrue, TypeFalse)
+	if e != nil {
+		return nil, false, xerrors.Errorf("Invalid EvRoleChanged payload (role): %w", e)
+	}
+	payload = payload[l:]
+
+	// client props
+	_, _, e = UnmarshalNullDict(payload)
+	if e != nil {
+		return nil, false, xerrors.Errorf("Invalid EvRoleChanged payload (client props): %w", e)
+	}
+	um.Props = payload
+
+	return &um, p.(bool), nil
+}
+
+// NewEvTimer : タイマーの発火
+func NewEvTimer(id string, data []byte) *RegularEvent {
+	payload := make([]byte, 0, len(id)+2+len(data))
+	payload = append(payload, MarshalStr8(id)...)
+	payload = append(payload, data...)
+	return NewRegularEvent(EvTypeTimer, payload)
+}
+
+func UnmarshalEvTimerPayload(payload []byte) (string, []byte, error) {
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", nil, xerrors.Errorf("Invalid EvTimer payload (timer id): %w", e)
+	}
+	return d.(string), payload[l:], nil
+}
+
+// NewEvRandom : MsgRandomで要求された乱数
+func NewEvRandom(requester, tag string, kind RandomKind, values []int) *RegularEvent {
+	payload := MarshalStr8(requester)
+	payload = append(payload, MarshalStr8(tag)...)
+	payload = append(payload, MarshalByte(int(kind))...)
+	payload = append(payload, MarshalUInts(values)...)
+	return NewRegularEvent(EvTypeRandom, payload)
+}
+
+// EvRandomPayload : EvRandomの内容
+type EvRandomPayload struct {
+	Requester string
+	Tag       string
+	Kind      RandomKind
+	Values    []int
+}
+
+func UnmarshalEvRandomPayload(payload []byte) (*EvRandomPayload, error) {
+	var rp EvRandomPayload
+
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvRandom payload (requester): %w", e)
+	}
+	rp.Requester = d.(string)
+	payload = payload[l:]
+
+	d, l, e = UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvRandom payload (tag): %w", e)
+	}
+	rp.Tag = d.(string)
+	payload = payload[l:]
+
+	d, l, e = UnmarshalAs(payload, TypeByte)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvRandom payload (kind): %w", e)
+	}
+	rp.Kind = RandomKind(d.(int))
+	payload = payload[l:]
+
+	d, _, e = UnmarshalAs(payload, TypeUInts)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvRandom payload (values): %w", e)
+	}
+	rp.Values = d.([]int)
+
+	return &rp, nil
+}
+
+// NewEvLockstepFrame : ロックステップのフレーム
+func NewEvLockstepFrame(frame uint32, inputs Dict) *RegularEvent {
+	payload := MarshalUInt(int(frame))
+	payload = append(payload, MarshalDict(inputs)...)
+	return NewRegularEvent(EvTypeLockstepFrame, payload)
+}
+
+func UnmarshalEvLockstepFramePayload(payload []byte) (uint32, Dict, error) {
+	d, l, e := UnmarshalAs(payload, TypeUInt)
+	if e != nil {
+		return 0, nil, xerrors.Errorf("Invalid EvLockstepFrame payload (frame): %w", e)
+	}
+	inputs, _, e := UnmarshalNullDict(payload[l:])
+	if e != nil {
+		return 0, nil, xerrors.Errorf("Invalid EvLockstepFrame payload (inputs): %w", e)
+	}
+	return uint32(d.(int)), inputs, nil
+}
+
+// NewEvLockstepHistory : 過去のフレーム.
+// framesはListに入るよう呼び出し側で分割する.
+func NewEvLockstepHistory(first uint32, frames []Dict) *RegularEvent {
+	list := make(List, len(frames))
+	for i, f := range frames {
+		list[i] = MarshalDict(f)
+	}
+	payload := MarshalUInt(int(first))
+	payload = append(payload, MarshalList(list)...)
+	return NewRegularEvent(EvTypeLockstepHistory, payload)
+}
+
+func UnmarshalEvLockstepHistoryPayload(payload []byte) (uint32, []Dict, error) {
+	d, l, e := UnmarshalAs(payload, TypeUInt)
+	if e != nil {
+		return 0, nil, xerrors.Errorf("Invalid EvLockstepHistory payload (first frame): %w", e)
+	}
+	first := uint32(d.(int))
+	d, _, e = UnmarshalAs(payload[l:], TypeList)
+	if e != nil {
+		return 0, nil, xerrors.Errorf("Invalid EvLockstepHistory payload (frames): %w", e)
+	}
+	list := d.(List)
+	frames := make([]Dict, len(list))
+	for i, b := range list {
+		frames[i], _, e = UnmarshalNullDict(b)
+		if e != nil {
+			return 0, nil, xerrors.Errorf("Invalid EvLockstepHistory payload (frame %v): %w", first+uint32(i), e)
+		}
+	}
+	return first, frames, nil
+}
+
+// NetObject : ネットワークオブジェクト
+// format:
+//   - str8: object id
+//   - str8: owner client id
+//   - Byte: class id
+//   - Dict: state
+type NetObject struct {
+	ID      string
+	Owner   string
+	ClassId byte
+	State   Dict
+}
+
+func (o *NetObject) marshal() []byte {
+	p := append(MarshalStr8(o.ID), MarshalStr8(o.Owner)...)
+	p = append(p, MarshalByte(int(o.ClassId))...)
+	return append(p, MarshalDict(o.State)...)
+}
+
+func unmarshalNetObject(payload []byte) (*NetObject, int, error) {
+	var o NetObject
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return nil, 0, xerrors.Errorf("object id: %w", e)
+	}
+	o.ID = d.(string)
+	n := l
+
+	d, l, e = UnmarshalAs(payload[n:], TypeStr8)
+	if e != nil {
+		return nil, 0, xerrors.Errorf("owner: %w", e)
+	}
+	o.Owner = d.(string)
+	n += l
+
+	d, l, e = UnmarshalAs(payload[n:], TypeByte)
+	if e != nil {
+		return nil, 0, xerrors.Errorf("class id: %w", e)
+	}
+	o.ClassId = byte(d.(int))
+	n += l
+
+	o.State, l, e = UnmarshalNullDict(payload[n:])
+	if e != nil {
+		return nil, 0, xerrors.Errorf("state: %w", e)
+	}
+	return &o, n + l, nil
+}
+
+// NewEvObjectSpawned : ネットワークオブジェクトの生成
+func NewEvObjectSpawned(obj *NetObject) *RegularEvent {
+	return NewRegularEvent(EvTypeObjectSpawned, obj.marshal())
+}
+
+func UnmarshalEvObjectSpawnedPayload(payload []byte) (*NetObject, error) {
+	o, _, e := unmarshalNetObject(payload)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvObjectSpawned payload: %w", e)
+	}
+	return o, nil
+}
+
+// NewEvObjectUpdated : ネットワークオブジェクトの状態の更新
+func NewEvObjectUpdated(id string, state Dict) *RegularEvent {
+	payload := MarshalStr8(id)
+	payload = append(payload, MarshalDict(state)...)
+	return NewRegularEvent(EvTypeObjectUpdated, payload)
+}
+
+func UnmarshalEvObjectUpdatedPayload(payload []byte) (string, Dict, error) {
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", nil, xerrors.Errorf("Invalid EvObjectUpdated payload (object id): %w", e)
+	}
+	state, _, e := UnmarshalNullDict(payload[l:])
+	if e != nil {
+		return "", nil, xerrors.Errorf("Invalid EvObjectUpdated payload (state): %w", e)
+	}
+	return d.(string), state, nil
+}
+
+// NewEvObjectOwnerChanged : ネットワークオブジェクトのオーナーの変更
+func NewEvObjectOwnerChanged(id, owner string) *RegularEvent {
+	payload := MarshalStr8(id)
+	payload = append(payload, MarshalStr8(owner)...)
+	return NewRegularEvent(EvTypeObjectOwnerChanged, payload)
+}
+
+func UnmarshalEvObjectOwnerChangedPayload(payload []byte) (string, string, error) {
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", "", xerrors.Errorf("Invalid EvObjectOwnerChanged payload (object id): %w", e)
+	}
+	o, _, e := UnmarshalAs(payload[l:], TypeStr8)
+	if e != nil {
+		return "", "", xerrors.Errorf("Invalid EvObjectOwnerChanged payload (owner): %w", e)
+	}
+	return d.(string), o.(string), nil
+}
+
+// NewEvObjectDespawned : ネットワークオブジェクトの削除
+func NewEvObjectDespawned(id string) *RegularEvent {
+	return NewRegularEvent(EvTypeObjectDespawned, MarshalStr8(id))
+}
+
+func UnmarshalEvObjectDespawnedPayload(payload []byte) (string, error) {
+	d, _, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", xerrors.Errorf("Invalid EvObjectDespawned payload (object id): %w", e)
+	}
+	return d.(string), nil
+}
+
+// NewEvObjectSnapshot : ネットワークオブジェクトの一覧.
+// objsは255個以下になるよう呼び出し側で分割する.
+func NewEvObjectSnapshot(objs []*NetObject) *RegularEvent {
+	payload := MarshalByte(len(objs))
+	for _, o := range objs {
+		payload = append(payload, o.marshal()...)
+	}
+	return NewRegularEvent(EvTypeObjectSnapshot, payload)
+}
+
+func UnmarshalEvObjectSnapshotPayload(payload []byte) ([]*NetObject, error) {
+	d, l, e := UnmarshalAs(payload, TypeByte)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvObjectSnapshot payload (count): %w", e)
+	}
+	payload = payload[l:]
+	objs := make([]*NetObject, d.(int))
+	for i := range objs {
+		objs[i], l, e = unmarshalNetObject(payload)
+		if e != nil {
+			return nil, xerrors.Errorf("Invalid EvObjectSnapshot payload (object %v): %w", i, e)
+		}
+		payload = payload[l:]
+	}
+	return objs, nil
+}
+
 // NewEvRejoined : 再入室イベント
 func NewEvRejoined(cli *pb.ClientInfo) *RegularEvent {
 	payload := MarshalStr8(cli.Id)
 	payload = append(payload, cli.Props...) // cli.Props marshaled as TypeDict
 
-	return &RegularEvent{EvTypeRejoined, payload}
+	return NewRegularEvent(EvTypeRejoined, payload)
 }
 
 func UnmarshalEvRejoinedPayload(payload []byte) (*pb.ClientInfo, error) {
@@ -300,7 +886,7 @@ func NewEvLeft(cliId, masterId, cause string) *RegularEvent {
 	payload = append(payload, MarshalStr8(masterId)...)
 	payload = append(payload, MarshalStr8(cause)...)
 
-	return &RegularEvent{EvTypeLeft, payload}
+	return NewRegularEvent(EvTypeLeft, payload)
 }
 
 type EvLeftPayload struct {
@@ -337,8 +923,11 @@ func UnmarshalEvLeftPayload(payload []byte) (*EvLeftPayload, error) {
 	return &um, nil
 }
 
-func NewEvRoomProp(cliId string, rpp *MsgRoomPropPayload) *RegularEvent {
-	return &RegularEvent{EvTypeRoomProp, rpp.EventPayload}
+func NewEvRoomProp(cliId string, rpp *MsgRoomPropPayload, version uint64) *RegularEvent {
+	payload := make([]byte, 0, len(rpp.EventPayload)+9)
+	payload = append(payload, rpp.EventPayload...)
+	payload = append(payload, MarshalULong(version)...)
+	return NewRegularEvent(EvTypeRoomProp, payload)
 }
 
 type EvRoomPropPayload struct {
@@ -350,13 +939,22 @@ type EvRoomPropPayload struct {
 	ClientDeadline uint32
 	PublicProps    Dict
 	PrivateProps   Dict
+	Version        uint64
 }
 
 func UnmarshalEvRoomPropPayload(payload []byte) (*EvRoomPropPayload, error) {
-	msg, err := UnmarshalRoomPropPayload(payload)
+	msg, l, err := unmarshalRoomPropBody(payload)
 	if err != nil {
 		return nil, xerrors.Errorf("Invalid EvRoomProp payload: %w", err)
 	}
+	var ver uint64
+	if len(payload) > l {
+		d, _, err := UnmarshalAs(payload[l:], TypeULong)
+		if err != nil {
+			return nil, xerrors.Errorf("Invalid EvRoomProp payload (version): %w", err)
+		}
+		ver = d.(uint64)
+	}
 
 	return &EvRoomPropPayload{
 		Visible:        msg.Visible,
@@ -367,20 +965,23 @@ func UnmarshalEvRoomPropPayload(payload []byte) (*EvRoomPropPayload, error) {
 		ClientDeadline: msg.ClientDeadline,
 		PublicProps:    msg.PublicProps,
 		PrivateProps:   msg.PrivateProps,
+		Version:        ver,
 	}, nil
 }
 
-func NewEvClientProp(cliId string, props []byte) *RegularEvent {
-	payload := make([]byte, 0, len(cliId)+1+len(props))
+func NewEvClientProp(cliId string, props []byte, version uint64) *RegularEvent {
+	payload := make([]byte, 0, len(cliId)+1+len(props)+9)
 	payload = append(payload, MarshalStr8(cliId)...)
 	payload = append(payload, props...)
+	payload = append(payload, MarshalULong(version)...)
 
-	return &RegularEvent{EvTypeClientProp, payload}
+	return NewRegularEvent(EvTypeClientProp, payload)
 }
 
 type EvClientPropPayload struct {
-	Id    string
-	Props Dict
+	Id      string
+	Props   Dict
+	Version uint64
 }
 
 func UnmarshalEvClientPropPayload(payload []byte) (*EvClientPropPayload, error) {
@@ -395,32 +996,90 @@ func UnmarshalEvClientPropPayload(payload []byte) (*EvClientPropPayload, error)
 	payload = payload[l:]
 
 	// client props
-	um.Props, _, e = UnmarshalNullDict(payload)
+	um.Props, l, e = UnmarshalNullDict(payload)
 	if e != nil {
 		return nil, xerrors.Errorf("Invalid EvClientProp payload (client props): %w", e)
 	}
+	payload = payload[l:]
+
+	// version
+	if len(payload) > 0 {
+		d, _, e := UnmarshalAs(payload, TypeULong)
+		if e != nil {
+			return nil, xerrors.Errorf("Invalid EvClientProp payload (version): %w", e)
+		}
+		um.Version = d.(uint64)
+	}
 
 	return &um, nil
 }
 
-func NewEvMasterSwitched(cliId, masterId string) *RegularEvent {
-	return &RegularEvent{EvTypeMasterSwitched, MarshalStr8(masterId)}
+// MasterSwitchReason : Masterクライアントが切替わった理由
+type MasterSwitchReason byte
+
+const (
+	// MasterSwitchRequested : MasterがMsgSwitchMasterで切替えた
+	MasterSwitchRequested MasterSwitchReason = iota
+	// MasterSwitchAssigned : 無人の部屋に入室したPlayerがMasterになった
+	MasterSwitchAssigned
+	// MasterSwitchIdle : Masterから一定時間メッセージが届かないのでサーバが切替えた
+	MasterSwitchIdle
+	// MasterSwitchDemoted : MasterがWatcherになった
+	MasterSwitchDemoted
+)
+
+func NewEvMasterSwitched(cliId, masterId string, reason MasterSwitchReason) *RegularEvent {
+	payload := MarshalStr8(masterId)
+	payload = append(payload, MarshalByte(int(reason))...)
+	return NewRegularEvent(EvTypeMasterSwitched, payload)
 }
 
-func UnmarshalEvMasterSwitchedPayload(payload []byte) (string, error) {
-	d, _, e := UnmarshalAs(payload, TypeStr8)
+func UnmarshalEvMasterSwitchedPayload(payload []byte) (string, MasterSwitchReason, error) {
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", 0, xerrors.Errorf("Invalid EvMasterSwitched payload (master id): %w", e)
+	}
+	payload = payload[l:]
+	if len(payload) == 0 {
+		return d.(string), MasterSwitchRequested, nil
+	}
+	r, _, e := UnmarshalAs(payload, TypeByte)
 	if e != nil {
-		return "", xerrors.Errorf("Invalid EvMasterSwitched payload (master id): %w", e)
+		return "", 0, xerrors.Errorf("Invalid EvMasterSwitched payload (reason): %w", e)
 	}
 
-	return d.(string), nil
+	return d.(string), MasterSwitchReason(r.(int)), nil
 }
 
 func NewEvMessage(cliId string, body []byte) *RegularEvent {
 	payload := make([]byte, 0, len(cliId)+1+len(body))
 	payload = append(payload, MarshalStr8(cliId)...)
 	payload = append(payload, body...)
-	return &RegularEvent{EvTypeMessage, payload}
+	return NewRegularEvent(EvTypeMessage, payload)
+}
+
+// NewEvCached : キャッシュの更新をHubに通知する
+func NewEvCached(key, sender string, data []byte) *RegularEvent {
+	payload := make([]byte, 0, len(key)+len(sender)+2+len(data))
+	payload = append(payload, MarshalStr8(key)...)
+	payload = append(payload, MarshalStr8(sender)...)
+	payload = append(payload, data...)
+	return NewRegularEvent(EvTypeCached, payload)
+}
+
+func UnmarshalEvCachedPayload(payload []byte) (key, sender string, data []byte, err error) {
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", "", nil, xerrors.Errorf("Invalid EvCached payload (key): %w", e)
+	}
+	key = d.(string)
+	payload = payload[l:]
+
+	d, l, e = UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", "", nil, xerrors.Errorf("Invalid EvCached payload (sender): %w", e)
+	}
+	return key, d.(string), payload[l:], nil
 }
 
 func UnmarshalEvMessage(payload []byte) (cliId string, body []byte, err error) {
@@ -436,7 +1095,7 @@ func UnmarshalEvMessage(payload []byte) (cliId string, body []byte, err error) {
 func NewEvSucceeded(msg RegularMsg) *RegularEvent {
 	payload := make([]byte, 3)
 	put24(payload, int64(msg.SequenceNum()))
-	return &RegularEvent{EvTypeSucceeded, payload}
+	return NewRegularEvent(EvTypeSucceeded, payload)
 }
 
 // NewEvPermissionDenied : 権限エラー
@@ -445,7 +1104,7 @@ func NewEvPermissionDenied(msg RegularMsg) *RegularEvent {
 	payload := make([]byte, 3+len(msg.Payload()))
 	put24(payload, int64(msg.SequenceNum()))
 	copy(payload[3:], msg.Payload())
-	return &RegularEvent{EvTypePermissionDenied, payload}
+	return NewRegularEvent(EvTypePermissionDenied, payload)
 }
 
 // NewEvTargetNotFound : あて先不明
@@ -455,5 +1114,94 @@ func NewEvTargetNotFound(msg RegularMsg, cliIds []string) *RegularEvent {
 	put24(payload, int64(msg.SequenceNum()))
 	payload = append(payload, MarshalStrings(cliIds)...)
 	payload = append(payload, msg.Payload()...)
-	return &RegularEvent{EvTypeTargetNotFound, payload}
+	return NewRegularEvent(EvTypeTargetNotFound, payload)
+}
+
+// NewEvRateLimited : 送信レート制限により破棄した
+// エラー発生の原因となったメッセージをそのまま返す
+func NewEvRateLimited(msg RegularMsg) *RegularEvent {
+	payload := make([]byte, 3+len(msg.Payload()))
+	put24(payload, int64(msg.SequenceNum()))
+	copy(payload[3:], msg.Payload())
+	return NewRegularEvent(EvTypeRateLimited, payload)
+}
+
+// NewEvInvalidPayload : 大きさの制限やプロパティのスキーマに違反した
+// エラー発生の原因となったメッセージをそのまま返す
+func NewEvInvalidPayload(msg RegularMsg) *RegularEvent {
+	payload := make([]byte, 3+len(msg.Payload()))
+	put24(payload, int64(msg.SequenceNum()))
+	copy(payload[3:], msg.Payload())
+	return NewRegularEvent(EvTypeInvalidPayload, payload)
+}
+
+// NewEvPropConflict : プロパティが変更の条件に合わなかった
+// 条件に合わなかったキーの現在の値とバージョンを返す
+func NewEvPropConflict(msg RegularMsg, current ...*VersionedProps) *RegularEvent {
+	payload := make([]byte, 3)
+	put24(payload, int64(msg.SequenceNum()))
+	for _, vp := range current {
+		payload = append(payload, MarshalVersionedProps(vp)...)
+	}
+	return NewRegularEvent(EvTypePropConflict, payload)
+}
+
+// UnmarshalEvPropConflictPayload : 条件に合わなかったキーの現在の値とバージョン.
+// MsgRoomPropへの応答ではpublic, privateの順に2つ、MsgClientPropへの応答では1つ返す.
+func UnmarshalEvPropConflictPayload(payload []byte) ([]*VersionedProps, error) {
+	if len(payload) < 3 {
+		return nil, xerrors.Errorf("Invalid EvPropConflict payload: too short")
+	}
+	payload = payload[3:]
+	var vps []*VersionedProps
+	for len(payload) > 0 {
+		vp, l, e := UnmarshalVersionedProps(payload)
+		if e != nil {
+			return nil, xerrors.Errorf("Invalid EvPropConflict payload: %w", e)
+		}
+		vps = append(vps, vp)
+		payload = payload[l:]
+	}
+	return vps, nil
+}
+
+// NewEvTimerList : タイマーの一覧
+func NewEvTimerList(msg RegularMsg, remains map[string]time.Duration) *RegularEvent {
+	timers := make(Dict, len(remains))
+	for id, d := range remains {
+		timers[id] = MarshalULong(uint64(d.Milliseconds()))
+	}
+	payload := make([]byte, 3)
+	put24(payload, int64(msg.SequenceNum()))
+	payload = append(payload, MarshalDict(timers)...)
+	return NewRegularEvent(EvTypeTimerList, payload)
+}
+
+// UnmarshalEvTimerListPayload : タイマーIDと残り時間
+func UnmarshalEvTimerListPayload(payload []byte) (map[string]time.Duration, error) {
+	if len(payload) < 3 {
+		return nil, xerrors.Errorf("Invalid EvTimerList payload: too short")
+	}
+	timers, _, e := UnmarshalNullDict(payload[3:])
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvTimerList payload: %w", e)
+	}
+	remains := make(map[string]time.Duration, len(timers))
+	for id, v := range timers {
+		d, _, e := UnmarshalAs(v, TypeULong)
+		if e != nil {
+			return nil, xerrors.Errorf("Invalid EvTimerList payload (%v): %w", id, e)
+		}
+		remains[id] = time.Duration(d.(uint64)) * time.Millisecond
+	}
+	return remains, nil
+}
+
+// NewEvRejected : 部屋の状態により実行できなかった
+// エラー発生の原因となったメッセージをそのまま返す
+func NewEvRejected(msg RegularMsg) *RegularEvent {
+	payload := make([]byte, 3+len(msg.Payload()))
+	put24(payload, int64(msg.SequenceNum()))
+	copy(payload[3:], msg.Payload())
+	return NewRegularEvent(EvTypeRejected, payload)
 }
@@ -0,0 +1,141 @@
+package binary
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTimestampedEvent(t *testing.T) {
+	ev := NewRegularEventAt(EvTypeMessage, MarshalStr8("hello"), 1700000000123)
+
+	data := ev.MarshalWithTimestamp(10)
+	u, seq, err := UnmarshalTimestampedEvent(data)
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if seq != 10 {
+		t.Fatalf("seq = %v, wants 10", seq)
+	}
+	rev, ok := u.(*RegularEvent)
+	if !ok {
+		t.Fatalf("not a regular event: %T", u)
+	}
+	if rev.Type() != EvTypeMessage || !reflect.DeepEqual(rev.Payload(), ev.Payload()) {
+		t.Fatalf("event = %v %v, wants %v %v", rev.Type(), rev.Payload(), ev.Type(), ev.Payload())
+	}
+	if rev.Timestamp() != ev.Timestamp() {
+		t.Fatalf("timestamp = %v, wants %v", rev.Timestamp(), ev.Timestamp())
+	}
+
+	// 時刻なしのフォーマットは従来と同じ
+	u, _, err = UnmarshalEvent(ev.Marshal(11))
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if !reflect.DeepEqual(u.Payload(), ev.Payload()) {
+		t.Fatalf("payload = %v, wants %v", u.Payload(), ev.Payload())
+	}
+}
+
+func TestEvPeerReadyPayload(t *testing.T) {
+	tests := map[string]struct {
+		flags   byte
+		payload []byte
+	}{
+		"plain":       {0, NewEvPeerReady(123, 0).Payload()},
+		"timestamped": {PeerFlagEventTimestamp, NewEvPeerReady(123, PeerFlagEventTimestamp).Payload()},
+		"batch":       {PeerFlagEventTimestamp | PeerFlagBatch, NewEvPeerReady(123, PeerFlagEventTimestamp|PeerFlagBatch).Payload()},
+		"old server":  {0, []byte{0, 0, 123}},
+	}
+	for k, tc := range tests {
+		seq, flags, err := UnmarshalEvPeerReadyPayload(tc.payload)
+		if err != nil {
+			t.Fatalf("%v: %v", k, err)
+		}
+		if seq != 123 || flags != tc.flags {
+			t.Fatalf("%v: seq=%v flags=%v, wants 123 %v", k, seq, flags, tc.flags)
+		}
+	}
+}
+
+func TestEvPongClockOffset(t *testing.T) {
+	received := time.Now()
+	ev := NewEvPong(uint64(received.Add(-time.Second).UnixMilli()), 2, Dict{}, received)
+
+	pp, err := UnmarshalEvPongPayload(ev.Payload())
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if pp.Watchers != 2 || pp.ServerReceived != uint64(received.UnixMilli()) || pp.ServerSent < pp.ServerReceived {
+		t.Fatalf("pong payload: %#v", pp)
+	}
+
+	// サーバの時計が5秒進んでいて、片道50ms、サーバ内で10ms
+	pp = &EvPongPayload{
+		Timestamp:      1000,
+		ServerReceived: 1000 + 5000 + 50,
+		ServerSent:     1000 + 5000 + 50 + 10,
+	}
+	offset, ok := pp.ClockOffset(time.UnixMilli(1000 + 50 + 10 + 50))
+	if !ok || offset != 5*time.Second {
+		t.Fatalf("offset = %v %v, wants 5s", offset, ok)
+	}
+
+	// 古いサーバ
+	pp = &EvPongPayload{Timestamp: 1000}
+	if _, ok := pp.ClockOffset(time.UnixMilli(1100)); ok {
+		t.Fatalf("old server pong must not have clock offset")
+	}
+}
+
+func TestEventHeader(t *testing.T) {
+	ev := NewRegularEventAt(EvTypeMessage, MarshalStr8("hello"), 1700000000123)
+	for _, timestamped := range []bool{false, true} {
+		var buf [TimestampedEventHeaderSize]byte
+		data := append(ev.AppendHeader(buf[:0], 42, timestamped), ev.Payload()...)
+		want := ev.Marshal(42)
+		if timestamped {
+			want = ev.MarshalWithTimestamp(42)
+		}
+		if !reflect.DeepEqual(data, want) {
+			t.Errorf("timestamped=%v: header+payload = %v, wants %v", timestamped, data, want)
+		}
+	}
+
+	sev := NewEvVolatile("p1", "pos", MarshalInt(1))
+	if data := append(sev.AppendHeader(nil), sev.Payload()...); !reflect.DeepEqual(data, sev.Marshal()) {
+		t.Errorf("system event header+payload = %v, wants %v", data, sev.Marshal())
+	}
+}
+
+// benchmarkRecipients : 1つのEventを送る宛先の数.
+// 宛先毎にmarshalする場合と、ヘッダだけ作ってpayloadを共有する場合を比べる.
+const benchmarkRecipients = 100
+
+func benchmarkEvent() *RegularEvent {
+	return NewRegularEvent(EvTypeMessage, make([]byte, 256))
+}
+
+func BenchmarkBroadcastMarshal(b *testing.B) {
+	ev := benchmarkEvent()
+	b.ReportAllocs()
+	b.SetBytes(int64(benchmarkRecipients * len(ev.Payload())))
+	for i := 0; i < b.N; i++ {
+		for seq := 0; seq < benchmarkRecipients; seq++ {
+			_ = ev.MarshalWithTimestamp(seq)
+		}
+	}
+}
+
+func BenchmarkBroadcastHeader(b *testing.B) {
+	ev := benchmarkEvent()
+	b.ReportAllocs()
+	b.SetBytes(int64(benchmarkRecipients * len(ev.Payload())))
+	var h [TimestampedEventHeaderSize]byte
+	for i := 0; i < b.N; i++ {
+		for seq := 0; seq < benchmarkRecipients; seq++ {
+			_ = ev.AppendHeader(h[:0], seq, true)
+		}
+	}
+}
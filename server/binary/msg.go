@@ -2,6 +2,7 @@ package binary
 
 import (
 	"hash"
+	"math"
 	"time"
 	"unicode/utf8"
 
@@ -41,12 +42,28 @@ const (
 	// タイムアウトしないように
 	// payload:
 	// - 64bit-be: unix timestamp (milli seconds)
+	// - 32bit-be: last measured RTT (milli seconds; optional)
 	MsgTypePing MsgType = 1 + iota
 
 	// MsgTypeNodeCount : NodeCountの更新
 	// payload:
 	// - UInt: node count
 	MsgTypeNodeCount
+
+	// MsgTypeVolatile : 揮発性の値（位置など）の送信. EvVolatile で他のクライアントに届く
+	// 再送されず、送信者とキー毎に最新の値のみ届けばよいもの.
+	// Playerからのみ有効
+	// payload:
+	// - str8: key
+	// - marshaled bytes: value
+	MsgTypeVolatile
+
+	// MsgTypeBatch : 連続したシーケンス番号のRegularMsgをまとめたもの. HMACはフレーム全体に1つだけ付ける
+	// EvPeerReadyでPeerFlagBatchが通知されたときのみ送ってよい
+	// payload:
+	// - 24bit-be: sequence number of the first msg
+	// - repeated: | 8bit MsgType | 24bit-be payload length | payload ... |
+	MsgTypeBatch
 )
 const (
 	// regular msg
@@ -57,7 +74,7 @@ const (
 	MsgTypeLeave MsgType = regularMsgType + iota
 
 	// MsgTypeRoomProp : 部屋情報の変更
-	// MasterClientからのみ有効
+	// Master以外のPlayerは RoomOption.PublicPropAcl/PrivatePropAcl で許可されたキーのみ変更できる
 	// payload:
 	// - Byte: flags (1=visible, 2=joinable, 4=watchable)
 	// - UInt: search group
@@ -65,11 +82,14 @@ const (
 	// - UShort: client deadline (second)
 	// - Dict: public props (modified keys only)
 	// - Dict: private props (modified keys only)
+	// - (optional) VersionedProps: condition of public props
+	// - (optional) VersionedProps: condition of private props
 	MsgTypeRoomProp
 
 	// MsgTypeClientProp : 自身のプロパティの変更
 	// payload:
 	// - Dict: properties (modified keys only)
+	// - (optional) VersionedProps: condition of properties
 	MsgTypeClientProp
 
 	// MsgTypeSwitchMaster : Masterクライアントの切替え
@@ -96,6 +116,93 @@ const (
 	// - str8: client id
 	// - string: message
 	MsgTypeKick
+
+	// MsgTypeCachedBroadcast : 全員に送信し、キー毎に最新のものを途中入室者にも送る
+	// dataが空のときは送信せずにキャッシュを削除する
+	// payload:
+	// - str8: cache key
+	// - marshaled data...
+	MsgTypeCachedBroadcast
+
+	// MsgTypeBan : 指定したクライアントの入室と観戦を禁止する. 入室中なら退室させる
+	// MasterClientからのみ有効
+	// payload:
+	// - str8: client id
+	// - UInt: duration (second; 0: until the room is closed)
+	// - string: message
+	MsgTypeBan
+
+	// MsgTypeChangeRole : クライアントをPlayerとWatcherの間で移動する
+	// RoomOption.RoleChange で許可されたときのみ有効
+	// payload:
+	// - str8: client id
+	// - Bool: true to become a player, false to become a watcher
+	MsgTypeChangeRole
+
+	// MsgTypeSetTimer : サーバ側のタイマーを設定する
+	// MasterClientからのみ有効. 同じIDのタイマーは置き換える.
+	// payload:
+	// - str8: timer id
+	// - UInt: delay (millisecond)
+	// - Byte: target (TimerTarget)
+	// - marshaled bytes: data
+	MsgTypeSetTimer
+
+	// MsgTypeCancelTimer : サーバ側のタイマーを取り消す
+	// MasterClientからのみ有効
+	// payload:
+	// - str8: timer id
+	MsgTypeCancelTimer
+
+	// MsgTypeListTimers : サーバ側のタイマーの一覧を EvTypeTimerList で返す
+	// MasterClientからのみ有効
+	// payload: (none)
+	MsgTypeListTimers
+
+	// MsgTypeLockstepInput : ロックステップのフレームの入力
+	// Playerからのみ有効. RoomOption.LockstepTickRate が指定された部屋でのみ有効
+	// payload:
+	// - UInt: frame number
+	// - marshaled bytes: input
+	MsgTypeLockstepInput
+
+	// MsgTypeSpawnObject : ネットワークオブジェクトを生成する
+	// Playerからのみ有効. 送信者がオーナーになる
+	// payload:
+	// - str8: object id
+	// - Byte: class id
+	// - Byte: leave policy (ObjectLeavePolicy)
+	// - Dict: state
+	MsgTypeSpawnObject
+
+	// MsgTypeUpdateObject : ネットワークオブジェクトの状態を更新する
+	// オーナーとMasterClientからのみ有効
+	// payload:
+	// - str8: object id
+	// - Dict: modified state (empty value to remove the key)
+	MsgTypeUpdateObject
+
+	// MsgTypeTransferObject : ネットワークオブジェクトのオーナーを変更する
+	// オーナーとMasterClientからのみ有効
+	// payload:
+	// - str8: object id
+	// - str8: new owner client id
+	MsgTypeTransferObject
+
+	// MsgTypeDespawnObject : ネットワークオブジェクトを削除する
+	// オーナーとMasterClientからのみ有効
+	// payload:
+	// - str8: object id
+	MsgTypeDespawnObject
+
+	// MsgTypeRandom : サーバの乱数を要求する. 結果は EvRandom で全員に届く
+	// Playerからのみ有効
+	// payload:
+	// - str8: tag (結果を識別するための任意の文字列)
+	// - Byte: kind (RandomKind)
+	// - UInt: count (RandomValues: 乱数の個数, RandomShuffle: 並べ替える要素数)
+	// - UInt: max (RandomValues: 0以上max未満の乱数, RandomShuffle: 未使用)
+	MsgTypeRandom
 )
 
 type nonregularMsg struct {
@@ -126,6 +233,11 @@ func (m *regularMsg) Marshal(hmac hash.Hash) []byte {
 	return BuildRegularMsgFrame(m.mtype, m.seqNum, m.payload, hmac)
 }
 
+// NewRegularMsg constructs RegularMsg
+func NewRegularMsg(t MsgType, seq int, payload []byte) RegularMsg {
+	return &regularMsg{t, seq, payload}
+}
+
 func BuildRegularMsgFrame(t MsgType, seq int, payload []byte, hmac hash.Hash) []byte {
 	data := make([]byte, 1+3+len(payload)+hmac.Size())
 	data[0] = byte(t)
@@ -174,22 +286,28 @@ func UnmarshalNullDict(payload []byte) (Dict, int, error) {
 }
 
 // NewMsgPing constructs MsgPing
-func NewMsgPing(timestamp time.Time) Msg {
-	payload := make([]byte, 8)
+func NewMsgPing(timestamp time.Time, rtt time.Duration) Msg {
+	payload := make([]byte, 12)
 	put64(payload, uint64(timestamp.UnixMilli()))
+	put32(payload[8:], rtt.Milliseconds())
 	return &nonregularMsg{
 		mtype:   MsgTypePing,
 		payload: payload,
 	}
 }
 
-// UnmarshalPingPayload parses payload of MsgPing
-func UnmarshalPingPayload(payload []byte) (uint64, error) {
+// UnmarshalPingPayload parses payload of MsgPing.
+// RTT is 0 if the client does not send it.
+func UnmarshalPingPayload(payload []byte) (uint64, time.Duration, error) {
 	if len(payload) < 8 {
-		return 0, xerrors.Errorf("data length not enough: %v", len(payload))
+		return 0, 0, xerrors.Errorf("data length not enough: %v", len(payload))
+	}
+	var rtt time.Duration
+	if len(payload) >= 12 {
+		rtt = time.Duration(get32(payload[8:])) * time.Millisecond
 	}
 
-	return get64(payload), nil
+	return get64(payload), rtt, nil
 }
 
 // NewMsgNodeCount constructs MsgNodeCount
@@ -210,6 +328,23 @@ func UnmarshalNodeCountPayload(payload []byte) (uint32, error) {
 	return uint32(d.(int)), nil
 }
 
+// NewMsgVolatile constructs MsgVolatile
+func NewMsgVolatile(key string, value []byte) Msg {
+	return &nonregularMsg{
+		mtype:   MsgTypeVolatile,
+		payload: append(MarshalStr8(key), value...),
+	}
+}
+
+// UnmarshalVolatilePayload parses payload of MsgTypeVolatile
+func UnmarshalVolatilePayload(payload []byte) (string, []byte, error) {
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", nil, xerrors.Errorf("Invalid MsgVolatile payload (key): %w", e)
+	}
+	return d.(string), payload[l:], nil
+}
+
 // MarshalLeavePayload marshals MsgLeave payload
 func MarshalLeavePayload(message string) []byte {
 	const limit = 123
@@ -236,7 +371,61 @@ func UnmarshalLeavePayload(payload []byte) (string, error) {
 	return m, nil
 }
 
+// VersionedProps : プロパティの値とバージョン.
+// MsgRoomProp/MsgClientPropでは変更の条件（期待する現在の値とバージョン）に、
+// EvPropConflictでは条件に合わなかったキーの現在の値とバージョンに使う.
+// 値が空、バージョンが0のときはキーが存在しないことを表す.
+// marshaled:
+// - Dict: values
+// - Dict: versions (ULong)
+type VersionedProps struct {
+	Props    Dict
+	Versions map[string]uint64
+}
+
+// MarshalVersionedProps marshals VersionedProps
+func MarshalVersionedProps(vp *VersionedProps) []byte {
+	if vp == nil {
+		return append(MarshalNull(), MarshalNull()...)
+	}
+	versions := make(Dict, len(vp.Versions))
+	for k, v := range vp.Versions {
+		versions[k] = MarshalULong(v)
+	}
+	return append(MarshalDict(vp.Props), MarshalDict(versions)...)
+}
+
+// UnmarshalVersionedProps unmarshals VersionedProps
+func UnmarshalVersionedProps(src []byte) (*VersionedProps, int, error) {
+	props, l1, e := UnmarshalNullDict(src)
+	if e != nil {
+		return nil, 0, xerrors.Errorf("props: %w", e)
+	}
+	versions, l2, e := UnmarshalNullDict(src[l1:])
+	if e != nil {
+		return nil, 0, xerrors.Errorf("versions: %w", e)
+	}
+	vp := &VersionedProps{
+		Props:    props,
+		Versions: make(map[string]uint64, len(versions)),
+	}
+	for k, v := range versions {
+		d, _, e := UnmarshalAs(v, TypeULong)
+		if e != nil {
+			return nil, 0, xerrors.Errorf("version of %q: %w", k, e)
+		}
+		vp.Versions[k] = d.(uint64)
+	}
+	return vp, l1 + l2, nil
+}
+
+// IsEmpty : 条件がない
+func (vp *VersionedProps) IsEmpty() bool {
+	return vp == nil || (len(vp.Props) == 0 && len(vp.Versions) == 0)
+}
+
 type MsgRoomPropPayload struct {
+	// EventPayload : EvRoomPropのpayload (条件を除く)
 	EventPayload []byte
 
 	Visible        bool
@@ -247,6 +436,10 @@ type MsgRoomPropPayload struct {
 	ClientDeadline uint32
 	PublicProps    Dict
 	PrivateProps   Dict
+
+	// PublicCond, PrivateCond : 変更の条件. 指定がなければnil
+	PublicCond  *VersionedProps
+	PrivateCond *VersionedProps
 }
 
 // flags (1=visible, 2=joinable, 4=watchable)
@@ -278,16 +471,46 @@ func MarshalRoomPropPayload(visible, joinable, watchable bool, searchGroup, maxP
 	return p
 }
 
+// MarshalPropConditions marshals conditions appended to MsgRoomProp/MsgClientProp payload
+func MarshalPropConditions(conds ...*VersionedProps) []byte {
+	var p []byte
+	for _, c := range conds {
+		p = append(p, MarshalVersionedProps(c)...)
+	}
+	return p
+}
+
 // UnmarshalRoomPropPayload unmarshals MsgRoomProp payload
 func UnmarshalRoomPropPayload(payload []byte) (*MsgRoomPropPayload, error) {
-	rpp := MsgRoomPropPayload{
-		EventPayload: payload,
+	rpp, l, err := unmarshalRoomPropBody(payload)
+	if err != nil {
+		return nil, err
+	}
+	payload = payload[l:]
+	if len(payload) == 0 {
+		return rpp, nil
 	}
 
+	rpp.PublicCond, l, err = UnmarshalVersionedProps(payload)
+	if err != nil {
+		return nil, xerrors.Errorf("Invalid MsgRoomProp payload (public condition): %w", err)
+	}
+	rpp.PrivateCond, _, err = UnmarshalVersionedProps(payload[l:])
+	if err != nil {
+		return nil, xerrors.Errorf("Invalid MsgRoomProp payload (private condition): %w", err)
+	}
+	return rpp, nil
+}
+
+// unmarshalRoomPropBody unmarshals MsgRoomProp payload without conditions
+func unmarshalRoomPropBody(payload []byte) (*MsgRoomPropPayload, int, error) {
+	src := payload
+	rpp := MsgRoomPropPayload{}
+
 	// flags
 	d, l, e := UnmarshalAs(payload, TypeByte)
 	if e != nil {
-		return nil, xerrors.Errorf("Invalid MsgRoomProp payload (flags): %w", e)
+		return nil, 0, xerrors.Errorf("Invalid MsgRoomProp payload (flags): %w", e)
 	}
 	flags := d.(int)
 	rpp.Visible = (flags & roomPropFlagsVisible) != 0
@@ -298,7 +521,7 @@ func UnmarshalRoomPropPayload(payload []byte) (*MsgRoomPropPayload, error) {
 	// search group
 	d, l, e = UnmarshalAs(payload, TypeUInt)
 	if e != nil {
-		return nil, xerrors.Errorf("Invalid MsgRoomProp payload (search group): %w", e)
+		return nil, 0, xerrors.Errorf("Invalid MsgRoomProp payload (search group): %w", e)
 	}
 	rpp.SearchGroup = uint32(d.(int))
 	payload = payload[l:]
@@ -306,7 +529,7 @@ func UnmarshalRoomPropPayload(payload []byte) (*MsgRoomPropPayload, error) {
 	// max players
 	d, l, e = UnmarshalAs(payload, TypeUShort)
 	if e != nil {
-		return nil, xerrors.Errorf("Invalid MsgRoomProp payload (max players): %w", e)
+		return nil, 0, xerrors.Errorf("Invalid MsgRoomProp payload (max players): %w", e)
 	}
 	rpp.MaxPlayer = uint32(d.(int))
 	payload = payload[l:]
@@ -314,7 +537,7 @@ func UnmarshalRoomPropPayload(payload []byte) (*MsgRoomPropPayload, error) {
 	// client deadline
 	d, l, e = UnmarshalAs(payload, TypeUShort)
 	if e != nil {
-		return nil, xerrors.Errorf("Invalid MsgRoomProp payload (client deadline): %w", e)
+		return nil, 0, xerrors.Errorf("Invalid MsgRoomProp payload (client deadline): %w", e)
 	}
 	rpp.ClientDeadline = uint32(d.(int))
 	payload = payload[l:]
@@ -322,17 +545,20 @@ func UnmarshalRoomPropPayload(payload []byte) (*MsgRoomPropPayload, error) {
 	// public props
 	rpp.PublicProps, l, e = UnmarshalNullDict(payload)
 	if e != nil {
-		return nil, xerrors.Errorf("Invalid MsgRoomProp payload (public props): %w", e)
+		return nil, 0, xerrors.Errorf("Invalid MsgRoomProp payload (public props): %w", e)
 	}
 	payload = payload[l:]
 
 	// private props
-	rpp.PrivateProps, _, e = UnmarshalNullDict(payload)
+	rpp.PrivateProps, l, e = UnmarshalNullDict(payload)
 	if e != nil {
-		return nil, xerrors.Errorf("Invalid MsgRoomProp payload (private props): %w", e)
+		return nil, 0, xerrors.Errorf("Invalid MsgRoomProp payload (private props): %w", e)
 	}
+	payload = payload[l:]
 
-	return &rpp, nil
+	n := len(src) - len(payload)
+	rpp.EventPayload = src[:n]
+	return &rpp, n, nil
 }
 
 func GetRoomPropClientDeadline(payload []byte) (uint32, error) {
@@ -349,12 +575,19 @@ func MarshalClientPropPayload(prop Dict) []byte {
 }
 
 // UnmarshalClientPropPayload unmarshals MsgClientProp payload
-func UnmarshalClientPropPayload(payload []byte) (Dict, error) {
-	d, _, e := UnmarshalNullDict(payload)
+func UnmarshalClientPropPayload(payload []byte) (Dict, *VersionedProps, error) {
+	d, l, e := UnmarshalNullDict(payload)
+	if e != nil {
+		return nil, nil, xerrors.Errorf("Invalid MsgClientProp payload (props): %w", e)
+	}
+	if len(payload) == l {
+		return d, nil, nil
+	}
+	cond, _, e := UnmarshalVersionedProps(payload[l:])
 	if e != nil {
-		return nil, xerrors.Errorf("Invalid MsgClientProp payload (props): %w", e)
+		return nil, nil, xerrors.Errorf("Invalid MsgClientProp payload (condition): %w", e)
 	}
-	return d, nil
+	return d, cond, nil
 }
 
 // MarshalSwitchMasterPayload marshals MsgSwitchMaster payload
@@ -406,6 +639,298 @@ func UnmarshalTargetsAndData(payload []byte) ([]string, []byte, error) {
 	return targets, payload[l:], nil
 }
 
+// MarshalCachedBroadcastPayload marshals MsgCachedBroadcast payload
+func MarshalCachedBroadcastPayload(key string, data []byte) []byte {
+	return append(MarshalStr8(key), data...)
+}
+
+// UnmarshalCachedBroadcastPayload parses payload of MsgTypeCachedBroadcast
+func UnmarshalCachedBroadcastPayload(payload []byte) (string, []byte, error) {
+	k, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", nil, xerrors.Errorf("Invalid MsgCachedBroadcast payload (key): %w", e)
+	}
+	return k.(string), payload[l:], nil
+}
+
+// TimerTarget : タイマーが発火したときのEventの送り先
+type TimerTarget byte
+
+const (
+	// TimerTargetAll : 全員
+	TimerTargetAll TimerTarget = iota
+	// TimerTargetMaster : 発火時点のMaster
+	TimerTargetMaster
+)
+
+// MarshalSetTimerPayload marshals MsgSetTimer payload
+func MarshalSetTimerPayload(id string, delayMillisec int, target TimerTarget, data []byte) []byte {
+	p := append(MarshalStr8(id), MarshalUInt(delayMillisec)...)
+	p = append(p, MarshalByte(int(target))...)
+	return append(p, data...)
+}
+
+// UnmarshalSetTimerPayload parses payload of MsgTypeSetTimer
+func UnmarshalSetTimerPayload(payload []byte) (string, int, TimerTarget, []byte, error) {
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", 0, 0, nil, xerrors.Errorf("Invalid MsgSetTimer payload (timer id): %w", e)
+	}
+	id := d.(string)
+	payload = payload[l:]
+
+	d, l, e = UnmarshalAs(payload, TypeUInt)
+	if e != nil {
+		return "", 0, 0, nil, xerrors.Errorf("Invalid MsgSetTimer payload (delay): %w", e)
+	}
+	delay := d.(int)
+	payload = payload[l:]
+
+	d, l, e = UnmarshalAs(payload, TypeByte)
+	if e != nil {
+		return "", 0, 0, nil, xerrors.Errorf("Invalid MsgSetTimer payload (target): %w", e)
+	}
+	target := TimerTarget(d.(int))
+	if target > TimerTargetMaster {
+		return "", 0, 0, nil, xerrors.Errorf("Invalid MsgSetTimer payload (target): %v", target)
+	}
+
+	return id, delay, target, payload[l:], nil
+}
+
+// MarshalCancelTimerPayload marshals MsgCancelTimer payload
+func MarshalCancelTimerPayload(id string) []byte {
+	return MarshalStr8(id)
+}
+
+// UnmarshalCancelTimerPayload parses payload of MsgTypeCancelTimer
+func UnmarshalCancelTimerPayload(payload []byte) (string, error) {
+	d, _, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", xerrors.Errorf("Invalid MsgCancelTimer payload (timer id): %w", e)
+	}
+	return d.(string), nil
+}
+
+// MarshalLockstepInputPayload marshals MsgLockstepInput payload
+func MarshalLockstepInputPayload(frame uint32, input []byte) []byte {
+	return append(MarshalUInt(int(frame)), input...)
+}
+
+// UnmarshalLockstepInputPayload parses payload of MsgTypeLockstepInput
+func UnmarshalLockstepInputPayload(payload []byte) (uint32, []byte, error) {
+	d, l, e := UnmarshalAs(payload, TypeUInt)
+	if e != nil {
+		return 0, nil, xerrors.Errorf("Invalid MsgLockstepInput payload (frame): %w", e)
+	}
+	input := payload[l:]
+	if len(input) > math.MaxUint16 {
+		return 0, nil, xerrors.Errorf("Invalid MsgLockstepInput payload (input): too long (%v)", len(input))
+	}
+	return uint32(d.(int)), input, nil
+}
+
+// ObjectLeavePolicy : ネットワークオブジェクトのオーナーが退室したときの扱い
+type ObjectLeavePolicy byte
+
+const (
+	// ObjectDespawnOnLeave : 削除する
+	ObjectDespawnOnLeave ObjectLeavePolicy = iota
+	// ObjectToMasterOnLeave : Masterをオーナーにする
+	ObjectToMasterOnLeave
+)
+
+// MarshalSpawnObjectPayload marshals MsgSpawnObject payload
+func MarshalSpawnObjectPayload(id string, classId byte, policy ObjectLeavePolicy, state Dict) []byte {
+	p := append(MarshalStr8(id), MarshalByte(int(classId))...)
+	p = append(p, MarshalByte(int(policy))...)
+	return append(p, MarshalDict(state)...)
+}
+
+// UnmarshalSpawnObjectPayload parses payload of MsgTypeSpawnObject
+func UnmarshalSpawnObjectPayload(payload []byte) (string, byte, ObjectLeavePolicy, Dict, error) {
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", 0, 0, nil, xerrors.Errorf("Invalid MsgSpawnObject payload (object id): %w", e)
+	}
+	id := d.(string)
+	payload = payload[l:]
+
+	d, l, e = UnmarshalAs(payload, TypeByte)
+	if e != nil {
+		return "", 0, 0, nil, xerrors.Errorf("Invalid MsgSpawnObject payload (class id): %w", e)
+	}
+	classId := byte(d.(int))
+	payload = payload[l:]
+
+	d, l, e = UnmarshalAs(payload, TypeByte)
+	if e != nil {
+		return "", 0, 0, nil, xerrors.Errorf("Invalid MsgSpawnObject payload (leave policy): %w", e)
+	}
+	policy := ObjectLeavePolicy(d.(int))
+	if policy > ObjectToMasterOnLeave {
+		return "", 0, 0, nil, xerrors.Errorf("Invalid MsgSpawnObject payload (leave policy): %v", policy)
+	}
+	payload = payload[l:]
+
+	state, _, e := UnmarshalNullDict(payload)
+	if e != nil {
+		return "", 0, 0, nil, xerrors.Errorf("Invalid MsgSpawnObject payload (state): %w", e)
+	}
+	return id, classId, policy, state, nil
+}
+
+// MarshalUpdateObjectPayload marshals MsgUpdateObject payload
+func MarshalUpdateObjectPayload(id string, state Dict) []byte {
+	return append(MarshalStr8(id), MarshalDict(state)...)
+}
+
+// UnmarshalUpdateObjectPayload parses payload of MsgTypeUpdateObject
+func UnmarshalUpdateObjectPayload(payload []byte) (string, Dict, error) {
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", nil, xerrors.Errorf("Invalid MsgUpdateObject payload (object id): %w", e)
+	}
+	state, _, e := UnmarshalNullDict(payload[l:])
+	if e != nil {
+		return "", nil, xerrors.Errorf("Invalid MsgUpdateObject payload (state): %w", e)
+	}
+	return d.(string), state, nil
+}
+
+// MarshalTransferObjectPayload marshals MsgTransferObject payload
+func MarshalTransferObjectPayload(id, owner string) []byte {
+	return append(MarshalStr8(id), MarshalStr8(owner)...)
+}
+
+// UnmarshalTransferObjectPayload parses payload of MsgTypeTransferObject
+func UnmarshalTransferObjectPayload(payload []byte) (string, string, error) {
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", "", xerrors.Errorf("Invalid MsgTransferObject payload (object id): %w", e)
+	}
+	o, _, e := UnmarshalAs(payload[l:], TypeStr8)
+	if e != nil {
+		return "", "", xerrors.Errorf("Invalid MsgTransferObject payload (owner): %w", e)
+	}
+	return d.(string), o.(string), nil
+}
+
+// MarshalDespawnObjectPayload marshals MsgDespawnObject payload
+func MarshalDespawnObjectPayload(id string) []byte {
+	return MarshalStr8(id)
+}
+
+// UnmarshalDespawnObjectPayload parses payload of MsgTypeDespawnObject
+func UnmarshalDespawnObjectPayload(payload []byte) (string, error) {
+	d, _, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", xerrors.Errorf("Invalid MsgDespawnObject payload (object id): %w", e)
+	}
+	return d.(string), nil
+}
+
+// RandomKind : 要求する乱数の種類
+type RandomKind byte
+
+const (
+	// RandomValues : 0以上max未満の乱数をcount個
+	RandomValues RandomKind = iota
+	// RandomShuffle : 0からcount-1までを並べ替えた順列
+	RandomShuffle
+)
+
+// MarshalRandomPayload marshals MsgRandom payload
+func MarshalRandomPayload(tag string, kind RandomKind, count, max uint32) []byte {
+	p := append(MarshalStr8(tag), MarshalByte(int(kind))...)
+	p = append(p, MarshalUInt(int(count))...)
+	return append(p, MarshalUInt(int(max))...)
+}
+
+// UnmarshalRandomPayload parses payload of MsgTypeRandom
+func UnmarshalRandomPayload(payload []byte) (string, RandomKind, uint32, uint32, error) {
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", 0, 0, 0, xerrors.Errorf("Invalid MsgRandom payload (tag): %w", e)
+	}
+	tag := d.(string)
+	payload = payload[l:]
+
+	d, l, e = UnmarshalAs(payload, TypeByte)
+	if e != nil {
+		return "", 0, 0, 0, xerrors.Errorf("Invalid MsgRandom payload (kind): %w", e)
+	}
+	kind := RandomKind(d.(int))
+	if kind > RandomShuffle {
+		return "", 0, 0, 0, xerrors.Errorf("Invalid MsgRandom payload (kind): %v", kind)
+	}
+	payload = payload[l:]
+
+	d, l, e = UnmarshalAs(payload, TypeUInt)
+	if e != nil {
+		return "", 0, 0, 0, xerrors.Errorf("Invalid MsgRandom payload (count): %w", e)
+	}
+	count := uint32(d.(int))
+	payload = payload[l:]
+
+	d, _, e = UnmarshalAs(payload, TypeUInt)
+	if e != nil {
+		return "", 0, 0, 0, xerrors.Errorf("Invalid MsgRandom payload (max): %w", e)
+	}
+	return tag, kind, count, uint32(d.(int)), nil
+}
+
+// MarshalBanPayload marshals MsgBan payload
+func MarshalBanPayload(target string, durationSec int, msg string) []byte {
+	p := append(MarshalStr8(target), MarshalUInt(durationSec)...)
+	return append(p, MarshalStr8(msg)...)
+}
+
+// UnmarshalBanPayload parses payload of MsgTypeBan
+func UnmarshalBanPayload(payload []byte) (string, int, string, error) {
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", 0, "", xerrors.Errorf("Invalid MsgBan payload (client id): %w", e)
+	}
+	payload = payload[l:]
+	s, l, e := UnmarshalAs(payload, TypeUInt)
+	if e != nil {
+		return "", 0, "", xerrors.Errorf("Invalid MsgBan payload (duration): %w", e)
+	}
+	payload = payload[l:]
+	m, _, e := Unmarshal(payload)
+	if e != nil {
+		return "", 0, "", xerrors.Errorf("Invalid MsgBan payload (message): %w", e)
+	}
+	msg, ok := m.(string)
+	if !ok {
+		return "", 0, "", xerrors.Errorf("Invalid MsgBan payload (message): %T", m)
+	}
+	if msg == "" {
+		msg = "banned"
+	}
+
+	return d.(string), s.(int), msg, nil
+}
+
+// MarshalChangeRolePayload marshals MsgChangeRole payload
+func MarshalChangeRolePayload(target string, toPlayer bool) []byte {
+	return append(MarshalStr8(target), MarshalBool(toPlayer)...)
+}
+
+// UnmarshalChangeRolePayload parses payload of MsgTypeChangeRole
+func UnmarshalChangeRolePayload(payload []byte) (string, bool, error) {
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", false, xerrors.Errorf("Invalid MsgChangeRole payload (client id): %w", e)
+	}
+	p, _, e := UnmarshalAs(payload[l:], TypeTrue, TypeFalse)
+	if e != nil {
+		return "", false, xerrors.Errorf("Invalid MsgChangeRole payload (role): %w", e)
+	}
+	return d.(string), p.(bool), nil
+}
+
 // MarshalKickPayload marshals MsgKick payload
 func MarshalKickPayload(target, msg string) []byte {
 	return append(MarshalStr8(target), MarshalStr8(msg)...)
@@ -127,7 +127,7 @@ func TestClientPropPayload(t *testing.T) {
 	}
 	for k, tc := range tests {
 		p := MarshalClientPropPayload(tc.prop)
-		u, err := UnmarshalClientPropPayload(p)
+		u, _, err := UnmarshalClientPropPayload(p)
 		if err != nil {
 			t.Fatalf("%v: %v", k, err)
 		}
@@ -149,3 +149,134 @@ func TestSwitchMasterPayload(t *testing.T) {
 		t.Fatalf("new master: %v, wants %v", u, newmaster)
 	}
 }
+
+func TestBanPayload(t *testing.T) {
+	const target = "player1"
+	const sec = 300
+	const msg = "cheating"
+
+	p := MarshalBanPayload(target, sec, msg)
+	u, s, m, err := UnmarshalBanPayload(p)
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if u != target || s != sec || m != msg {
+		t.Fatalf("payload: (%v, %v, %v), wants (%v, %v, %v)", u, s, m, target, sec, msg)
+	}
+
+	_, _, m, err = UnmarshalBanPayload(MarshalBanPayload(target, 0, ""))
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if m != "banned" {
+		t.Fatalf("default message: %v, wants banned", m)
+	}
+}
+
+func TestCachedBroadcastPayload(t *testing.T) {
+	const key = "world"
+	data := MarshalInts([]int{1, 2, 3})
+
+	p := MarshalCachedBroadcastPayload(key, data)
+	k, d, err := UnmarshalCachedBroadcastPayload(p)
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if k != key {
+		t.Fatalf("key: %v, wants %v", k, key)
+	}
+	if !reflect.DeepEqual(d, data) {
+		t.Fatalf("data: %v, wants %v", d, data)
+	}
+}
+
+func TestChangeRolePayload(t *testing.T) {
+	for _, toPlayer := range []bool{true, false} {
+		p := MarshalChangeRolePayload("watcher1", toPlayer)
+		id, tp, err := UnmarshalChangeRolePayload(p)
+		if err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		if id != "watcher1" || tp != toPlayer {
+			t.Fatalf("payload: (%v, %v), wants (watcher1, %v)", id, tp, toPlayer)
+		}
+	}
+}
+
+func TestPropConditions(t *testing.T) {
+	pubc := &VersionedProps{
+		Props:    Dict{"seat1": MarshalStr8("player1"), "seat2": {}},
+		Versions: map[string]uint64{"item": 3},
+	}
+	prvc := &VersionedProps{
+		Versions: map[string]uint64{"secret": 0},
+	}
+
+	p := MarshalRoomPropPayload(true, true, false, 1, 4, 0, Dict{"seat2": MarshalStr8("player2")}, nil)
+	plen := len(p)
+	p = append(p, MarshalPropConditions(pubc, prvc)...)
+	u, err := UnmarshalRoomPropPayload(p)
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if len(u.EventPayload) != plen {
+		t.Fatalf("EventPayload includes conditions: len=%v, wants %v", len(u.EventPayload), plen)
+	}
+	if !reflect.DeepEqual(u.PublicCond, pubc) {
+		t.Fatalf("PublicCond = %#v, wants %#v", u.PublicCond, pubc)
+	}
+	if !reflect.DeepEqual(u.PrivateCond.Versions, prvc.Versions) || len(u.PrivateCond.Props) != 0 {
+		t.Fatalf("PrivateCond = %#v, wants %#v", u.PrivateCond, prvc)
+	}
+
+	props := Dict{"hp": MarshalInt(10)}
+	p = append(MarshalClientPropPayload(props), MarshalPropConditions(&VersionedProps{Versions: map[string]uint64{"hp": 5}})...)
+	d, cond, err := UnmarshalClientPropPayload(p)
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if !reflect.DeepEqual(d, props) || cond.Versions["hp"] != 5 {
+		t.Fatalf("client prop payload: (%v, %v), wants (%v, hp=5)", d, cond, props)
+	}
+}
+
+func TestSetTimerPayload(t *testing.T) {
+	data := MarshalStr8("round end")
+	p := MarshalSetTimerPayload("round", 30000, TimerTargetMaster, data)
+	id, delay, target, d, err := UnmarshalSetTimerPayload(p)
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if id != "round" || delay != 30000 || target != TimerTargetMaster || !reflect.DeepEqual(d, data) {
+		t.Fatalf("payload: (%v, %v, %v, %v), wants (round, 30000, %v, %v)", id, delay, target, d, TimerTargetMaster, data)
+	}
+
+	if _, _, _, _, err := UnmarshalSetTimerPayload(MarshalSetTimerPayload("x", 0, 2, nil)); err == nil {
+		t.Fatalf("invalid target accepted")
+	}
+}
+
+func TestRandomPayload(t *testing.T) {
+	tag, kind, count, max, err := UnmarshalRandomPayload(MarshalRandomPayload("dice", RandomValues, 2, 6))
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if tag != "dice" || kind != RandomValues || count != 2 || max != 6 {
+		t.Fatalf("payload: (%v, %v, %v, %v), wants (dice, %v, 2, 6)", tag, kind, count, max, RandomValues)
+	}
+
+	if _, _, _, _, err := UnmarshalRandomPayload(MarshalRandomPayload("x", 2, 1, 0)); err == nil {
+		t.Fatalf("invalid kind accepted")
+	}
+}
+
+func TestLockstepInputPayload(t *testing.T) {
+	input := MarshalInts([]int{1, 2})
+	frame, in, err := UnmarshalLockstepInputPayload(MarshalLockstepInputPayload(300, input))
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if frame != 300 || !reflect.DeepEqual(in, input) {
+		t.Fatalf("payload: (%v, %v), wants (300, %v)", frame, in, input)
+	}
+}
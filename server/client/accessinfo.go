@@ -14,6 +14,13 @@ type AccessInfo struct {
 	MACKey    string
 	Bearer    string
 	EncMACKey string
+
+	// EnableCompression : websocketのpermessage-deflateを有効にする. サーバが対応していなければ使わない
+	EnableCompression bool
+	// CompressionLevel : 圧縮レベル (compress/flateの-2〜9)
+	CompressionLevel int
+	// CompressionThreshold : これより小さいメッセージは圧縮しない (バイト数)
+	CompressionThreshold int
 }
 
 // GenAccessinfo : AccessInfoを生成
@@ -36,5 +43,8 @@ func GenAccessInfo(lobby, appid, appkey, userid string) (*AccessInfo, error) {
 		MACKey:    mackey,
 		Bearer:    bearer,
 		EncMACKey: encmackey,
+
+		CompressionLevel:     DefaultCompressionLevel,
+		CompressionThreshold: DefaultCompressionThreshold,
 	}, nil
 }
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"compress/flate"
 	"context"
 	"crypto/hmac"
 	"crypto/sha1"
@@ -21,7 +22,14 @@ import (
 	"wsnet2/pb"
 )
 
-const reconnectInterval = 3 * time.Second
+const (
+	reconnectInterval = 3 * time.Second
+
+	// DefaultCompressionLevel : GenAccessInfoで設定する圧縮レベル
+	DefaultCompressionLevel = 1
+	// DefaultCompressionThreshold : GenAccessInfoで設定する圧縮するメッセージの最小サイズ
+	DefaultCompressionThreshold = 256
+)
 
 var dialer = &websocket.Dialer{
 	Subprotocols:    []string{"wsnet2"},
@@ -34,11 +42,6 @@ type msgerr struct {
 	err error
 }
 
-type marshaledMsg struct {
-	seq   int
-	frame []byte
-}
-
 type unrecoverableError struct {
 	error
 }
@@ -58,11 +61,23 @@ type Connection struct {
 	url    string
 	bearer string
 
+	// dialer : AccessInfoの設定でpermessage-deflateを有効にしたdialer
+	dialer *websocket.Dialer
+	// compressionLevel, compressionThreshold : permessage-deflateが有効なときの圧縮レベルと圧縮するメッセージの最小サイズ
+	compressionLevel     int
+	compressionThreshold int
+
 	deadline atomic.Uint32
 
+	// rtt : 最後に受け取ったPongから計算した応答時間 (millisec). 次のPingで送る
+	rtt atomic.Int64
+
+	// offset : 最後に受け取ったPongから計算したサーバの時計とのずれ (millisec)
+	offset atomic.Int64
+
 	mumsg  sync.Mutex
 	msgseq int
-	msgbuf *common.RingBuf[marshaledMsg]
+	msgbuf *common.RingBuf[binary.RegularMsg]
 	hmac   hash.Hash
 
 	lastev int
@@ -73,6 +88,11 @@ type Connection struct {
 	done chan msgerr
 }
 
+// ClockOffset : サーバの時計とのずれ (サーバの時刻 - 手元の時刻)
+func (c *Connection) ClockOffset() time.Duration {
+	return time.Duration(c.offset.Load()) * time.Millisecond
+}
+
 func (c *Connection) UserId() string {
 	return c.userid
 }
@@ -82,10 +102,7 @@ func (c *Connection) Send(typ binary.MsgType, payload []byte) error {
 	c.mumsg.Lock()
 	defer c.mumsg.Unlock()
 	next := c.msgseq + 1
-	err := c.msgbuf.Write(marshaledMsg{
-		next,
-		binary.BuildRegularMsgFrame(typ, next, payload, c.hmac),
-	})
+	err := c.msgbuf.Write(binary.NewRegularMsg(typ, next, payload))
 	if err != nil {
 		return xerrors.Errorf("write to msgbuf: %w", err)
 	}
@@ -126,6 +143,12 @@ func (c *Connection) Broadcast(payload []byte) error {
 	return c.Send(binary.MsgTypeBroadcast, payload)
 }
 
+// CachedBroadcast : MsgTypeCachedBroadcastで送信
+// payloadが空ならキャッシュを削除する
+func (c *Connection) CachedBroadcast(key string, payload []byte) error {
+	return c.Send(binary.MsgTypeCachedBroadcast, binary.MarshalCachedBroadcastPayload(key, payload))
+}
+
 // ToTargets : MsgTypeTargetsで指定したPlayerに送信
 func (c *Connection) ToTargets(payload []byte, targets ...string) error {
 	list := binary.List{}
@@ -150,6 +173,67 @@ func (c *Connection) Kick(player, msg string) error {
 	return c.Send(binary.MsgTypeKick, binary.MarshalKickPayload(player, msg))
 }
 
+// Ban : 指定したクライアントの入室を禁止. secが0なら部屋が終了するまで
+func (c *Connection) Ban(player string, sec int, msg string) error {
+	return c.Send(binary.MsgTypeBan, binary.MarshalBanPayload(player, sec, msg))
+}
+
+// ChangeRole : 指定したクライアントをPlayer(toPlayer=true)またはWatcherにする
+func (c *Connection) ChangeRole(client string, toPlayer bool) error {
+	return c.Send(binary.MsgTypeChangeRole, binary.MarshalChangeRolePayload(client, toPlayer))
+}
+
+// SetTimer : サーバ側のタイマーを設定. 発火するとEvTimerが届く
+func (c *Connection) SetTimer(id string, delay time.Duration, target binary.TimerTarget, data []byte) error {
+	return c.Send(binary.MsgTypeSetTimer, binary.MarshalSetTimerPayload(id, int(delay.Milliseconds()), target, data))
+}
+
+// CancelTimer : サーバ側のタイマーを取り消す
+func (c *Connection) CancelTimer(id string) error {
+	return c.Send(binary.MsgTypeCancelTimer, binary.MarshalCancelTimerPayload(id))
+}
+
+// ListTimers : サーバ側のタイマーの一覧を要求. EvTimerListが届く
+func (c *Connection) ListTimers() error {
+	return c.Send(binary.MsgTypeListTimers, nil)
+}
+
+// LockstepInput : ロックステップのフレームの入力を送信. フレームが揃うとEvLockstepFrameが届く
+func (c *Connection) LockstepInput(frame uint32, input []byte) error {
+	return c.Send(binary.MsgTypeLockstepInput, binary.MarshalLockstepInputPayload(frame, input))
+}
+
+// SpawnObject : ネットワークオブジェクトを生成
+func (c *Connection) SpawnObject(id string, classId byte, policy binary.ObjectLeavePolicy, state binary.Dict) error {
+	return c.Send(binary.MsgTypeSpawnObject, binary.MarshalSpawnObjectPayload(id, classId, policy, state))
+}
+
+// UpdateObject : ネットワークオブジェクトの状態を更新
+func (c *Connection) UpdateObject(id string, state binary.Dict) error {
+	return c.Send(binary.MsgTypeUpdateObject, binary.MarshalUpdateObjectPayload(id, state))
+}
+
+// TransferObject : ネットワークオブジェクトのオーナーを変更
+func (c *Connection) TransferObject(id, owner string) error {
+	return c.Send(binary.MsgTypeTransferObject, binary.MarshalTransferObjectPayload(id, owner))
+}
+
+// DespawnObject : ネットワークオブジェクトを削除
+func (c *Connection) DespawnObject(id string) error {
+	return c.Send(binary.MsgTypeDespawnObject, binary.MarshalDespawnObjectPayload(id))
+}
+
+// Random : サーバの乱数を要求. 結果はEvRandomで全員に届く
+func (c *Connection) Random(tag string, kind binary.RandomKind, count, max uint32) error {
+	return c.Send(binary.MsgTypeRandom, binary.MarshalRandomPayload(tag, kind, count, max))
+}
+
+// SendVolatile : 揮発性の値を送信. 他のクライアントにEvVolatileが届く.
+// 再送しないので、接続していなければ捨てられる.
+func (c *Connection) SendVolatile(key string, value []byte) error {
+	return c.SendSystemMsg(binary.NewMsgVolatile(key, value))
+}
+
 // Leave : MsgLeaveを送信する
 func (c *Connection) Leave(msg string) error {
 	return c.Send(binary.MsgTypeLeave, binary.MarshalLeavePayload(msg))
@@ -165,13 +249,27 @@ func newConn(ctx context.Context, accinfo *AccessInfo, joined *pb.JoinedRoomRes,
 
 	mac := hmac.New(sha1.New, []byte(accinfo.MACKey))
 
+	d := dialer
+	if accinfo.EnableCompression {
+		if accinfo.CompressionLevel < flate.HuffmanOnly || accinfo.CompressionLevel > flate.BestCompression {
+			return nil, xerrors.Errorf("invalid compression level: %v", accinfo.CompressionLevel)
+		}
+		d = &websocket.Dialer{}
+		*d = *dialer
+		d.EnableCompression = true
+	}
+
 	conn := &Connection{
 		appid:  accinfo.AppId,
 		userid: accinfo.UserId,
 		url:    joined.Url,
 		bearer: "Bearer " + bearer,
 
-		msgbuf: common.NewRingBuf[marshaledMsg](32),
+		dialer:               d,
+		compressionLevel:     accinfo.CompressionLevel,
+		compressionThreshold: accinfo.CompressionThreshold,
+
+		msgbuf: common.NewRingBuf[binary.RegularMsg](32),
 		hmac:   mac,
 
 		evch:   make(chan binary.Event, 32),
@@ -216,9 +314,11 @@ func (conn *Connection) connect(ctx context.Context, warn func(error)) (string,
 		hdr.Add("Wsnet2-App", conn.appid)
 		hdr.Add("Wsnet2-User", conn.userid)
 		hdr.Add("Wsnet2-LastEventSeq", strconv.Itoa(conn.lastev))
+		hdr.Add("Wsnet2-EventTimestamp", "true")
+		hdr.Add("Wsnet2-Batch", "true")
 		hdr.Add("Authorization", conn.bearer)
 
-		ws, res, err := dialer.DialContext(ctx, conn.url, hdr)
+		ws, res, err := conn.dialer.DialContext(ctx, conn.url, hdr)
 		if err != nil {
 			if res != nil && res.StatusCode >= 400 && res.StatusCode < 500 {
 				return "websocket dial failed", xerrors.Errorf("dial: %w", err)
@@ -232,13 +332,16 @@ func (conn *Connection) connect(ctx context.Context, warn func(error)) (string,
 				continue
 			}
 		}
+		if conn.dialer.EnableCompression {
+			ws.SetCompressionLevel(conn.compressionLevel) // newConnで検証済み
+		}
 
 		conctx, cancel := context.WithCancel(ctx)
 		done := make(chan error, 4)
 		var wg sync.WaitGroup
 		wg.Add(1)
 		go func() {
-			done <- conn.receiver(conctx, ws, func(lastmsgseq int) {
+			done <- conn.receiver(conctx, ws, func(lastmsgseq int, batch bool) {
 				retrylimit = nil
 				var mu sync.Mutex
 				wg.Add(3)
@@ -247,7 +350,7 @@ func (conn *Connection) connect(ctx context.Context, warn func(error)) (string,
 					wg.Done()
 				}()
 				go func() {
-					done <- conn.sender(conctx, ws, &mu, lastmsgseq)
+					done <- conn.sender(conctx, ws, &mu, lastmsgseq, batch)
 					wg.Done()
 				}()
 				go func() {
@@ -280,7 +383,9 @@ func (conn *Connection) connect(ctx context.Context, warn func(error)) (string,
 	}
 }
 
-func (conn *Connection) receiver(ctx context.Context, ws *websocket.Conn, startsender func(int)) error {
+func (conn *Connection) receiver(ctx context.Context, ws *websocket.Conn, startsender func(int, bool)) error {
+	// timestamped : RegularEventにサーバの時刻が付いている (PeerReadyで通知される)
+	timestamped := false
 	for {
 		select {
 		case <-ctx.Done():
@@ -294,60 +399,106 @@ func (conn *Connection) receiver(ctx context.Context, ws *websocket.Conn, starts
 			return err // websocket.IsCloseError()がwrapを考慮してくれないのでこのまま返す
 		}
 
-		ev, seq, err := binary.UnmarshalEvent(data)
+		unmarshal := binary.UnmarshalEvent
+		if timestamped {
+			unmarshal = binary.UnmarshalTimestampedEvent
+		}
+		ev, seq, err := unmarshal(data)
 		if err != nil {
 			return xerrors.Errorf("receiver unmarshal: %w", err)
 		}
 
-		lastev := conn.lastev
-		if _, ok := ev.(*binary.RegularEvent); ok {
-			lastev++
-			if seq != lastev {
-				return xerrors.Errorf("invalid event sequence num: %v wants %v", seq, lastev)
+		if ev.Type() != binary.EvTypeBatch {
+			if err := conn.dispatch(ctx, ev, seq, &timestamped, startsender); err != nil {
+				return err
 			}
+			continue
 		}
 
-		switch ev.Type() {
-		case binary.EvTypePeerReady:
-			msgseq, err := binary.UnmarshalEvPeerReadyPayload(ev.Payload())
-			if err != nil {
-				return xerrors.Errorf("unmarshal peer-ready payload %v: %w", ev.Type(), err)
+		// まとめて送られてきたRegularEventを1つずつ処理する
+		first, evs, err := binary.UnmarshalEvBatchPayload(ev.Payload())
+		if err != nil {
+			return xerrors.Errorf("unmarshal batch payload: %w", err)
+		}
+		for i, ev := range evs {
+			if err := conn.dispatch(ctx, ev, first+i, &timestamped, startsender); err != nil {
+				return err
 			}
-			startsender(msgseq)
+		}
+	}
+}
 
-		case binary.EvTypeRoomProp:
-			deadline, err := binary.GetRoomPropClientDeadline(ev.Payload())
-			if err != nil {
-				return xerrors.Errorf("get client deadline: %w", err)
-			}
-			if deadline != 0 {
-				conn.deadline.Store(deadline)
-			}
+// dispatch : 受信したEventを処理してevchに流す
+func (conn *Connection) dispatch(ctx context.Context, ev binary.Event, seq int, timestamped *bool, startsender func(int, bool)) error {
+	lastev := conn.lastev
+	if _, ok := ev.(*binary.RegularEvent); ok {
+		lastev++
+		if seq != lastev {
+			return xerrors.Errorf("invalid event sequence num: %v wants %v", seq, lastev)
+		}
+	}
+
+	switch ev.Type() {
+	case binary.EvTypePeerReady:
+		msgseq, flags, err := binary.UnmarshalEvPeerReadyPayload(ev.Payload())
+		if err != nil {
+			return xerrors.Errorf("unmarshal peer-ready payload %v: %w", ev.Type(), err)
+		}
+		*timestamped = flags&binary.PeerFlagEventTimestamp != 0
+		startsender(msgseq, flags&binary.PeerFlagBatch != 0)
+
+	case binary.EvTypeMigrated:
+		// 部屋が別のgameサーバに移動したので、切断後は移動先に再接続する
+		url, err := binary.UnmarshalEvMigratedPayload(ev.Payload())
+		if err != nil {
+			return xerrors.Errorf("unmarshal migrated payload %v: %w", ev.Type(), err)
+		}
+		conn.url = url
+
+	case binary.EvTypePong:
+		p, err := binary.UnmarshalEvPongPayload(ev.Payload())
+		if err != nil {
+			return xerrors.Errorf("unmarshal pong payload %v: %w", ev.Type(), err)
+		}
+		now := time.Now()
+		conn.rtt.Store(now.UnixMilli() - int64(p.Timestamp))
+		if offset, ok := p.ClockOffset(now); ok {
+			conn.offset.Store(offset.Milliseconds())
+		}
+
+	case binary.EvTypeRoomProp:
+		deadline, err := binary.GetRoomPropClientDeadline(ev.Payload())
+		if err != nil {
+			return xerrors.Errorf("get client deadline: %w", err)
 		}
+		if deadline != 0 {
+			conn.deadline.Store(deadline)
+		}
+	}
 
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		default:
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			case conn.evch <- ev:
-				conn.lastev = lastev
-			}
+		case conn.evch <- ev:
+			conn.lastev = lastev
 		}
 	}
+	return nil
 }
 
 func (conn *Connection) pinger(ctx context.Context, ws *websocket.Conn, mu *sync.Mutex) error {
 	for {
 		conn.mumsg.Lock()
-		msg := binary.NewMsgPing(time.Now()).Marshal(conn.hmac)
+		rtt := time.Duration(conn.rtt.Load()) * time.Millisecond
+		msg := binary.NewMsgPing(time.Now(), rtt).Marshal(conn.hmac)
 		conn.mumsg.Unlock()
 
 		mu.Lock()
-		ws.SetWriteDeadline(time.Now().Add(time.Second))
-		err := ws.WriteMessage(websocket.BinaryMessage, msg)
+		err := conn.writeMessage(ws, msg)
 		mu.Unlock()
 		if err != nil {
 			return xerrors.Errorf("pinger: %w", err)
@@ -362,27 +513,40 @@ func (conn *Connection) pinger(ctx context.Context, ws *websocket.Conn, mu *sync
 	}
 }
 
-func (conn *Connection) sender(ctx context.Context, ws *websocket.Conn, mu *sync.Mutex, lastseq int) error {
+func (conn *Connection) sender(ctx context.Context, ws *websocket.Conn, mu *sync.Mutex, lastseq int, batch bool) error {
 	for {
 		msgs, err := conn.msgbuf.Read(lastseq)
 		if err != nil {
 			return unrecoverable(xerrors.Errorf("sender read: %w", err))
 		}
 
-		for _, msg := range msgs {
+		for len(msgs) > 0 {
 			select {
 			case <-ctx.Done():
 				return ctx.Err()
 			default:
 			}
+			n := 1
+			if batch {
+				n = batchLen(msgs)
+			}
+			var frame []byte
+			conn.mumsg.Lock()
+			if n == 1 {
+				frame = msgs[0].Marshal(conn.hmac)
+			} else {
+				frame = binary.BuildBatchMsgFrame(msgs[:n], conn.hmac)
+			}
+			conn.mumsg.Unlock()
+
 			mu.Lock()
-			ws.SetWriteDeadline(time.Now().Add(time.Second))
-			err := ws.WriteMessage(websocket.BinaryMessage, msg.frame)
+			err := conn.writeMessage(ws, frame)
 			mu.Unlock()
 			if err != nil {
-				return xerrors.Errorf("sender write(%v): %w", msg.seq, err)
+				return xerrors.Errorf("sender write(%v): %w", msgs[0].SequenceNum(), err)
 			}
-			lastseq = msg.seq
+			lastseq = msgs[n-1].SequenceNum()
+			msgs = msgs[n:]
 		}
 
 		select {
@@ -393,6 +557,30 @@ func (conn *Connection) sender(ctx context.Context, ws *websocket.Conn, mu *sync
 	}
 }
 
+// writeMessage : websocketに書き込む. compressionThreshold以上のメッセージだけ圧縮する.
+// muをロックしてから呼び出す.
+func (conn *Connection) writeMessage(ws *websocket.Conn, data []byte) error {
+	ws.SetWriteDeadline(time.Now().Add(time.Second))
+	ws.EnableWriteCompression(len(data) >= conn.compressionThreshold)
+	return ws.WriteMessage(websocket.BinaryMessage, data)
+}
+
+// batchLen : 先頭から1つのフレームにまとめて送るMsgの数
+func batchLen(msgs []binary.RegularMsg) int {
+	size := 0
+	for i, m := range msgs {
+		l := len(m.Payload())
+		if l > binary.MaxBatchEntrySize || size+l > binary.MaxBatchSize {
+			if i == 0 {
+				return 1
+			}
+			return i
+		}
+		size += l
+	}
+	return len(msgs)
+}
+
 func (conn *Connection) systemSender(ctx context.Context, ws *websocket.Conn, mu *sync.Mutex) error {
 	// 送信中の投げ込みも受け付けるようcap=1のチャネルを挟む
 	mc := make(chan binary.Msg, 1)
@@ -420,8 +608,7 @@ func (conn *Connection) systemSender(ctx context.Context, ws *websocket.Conn, mu
 		conn.mumsg.Unlock()
 
 		mu.Lock()
-		ws.SetWriteDeadline(time.Now().Add(time.Second))
-		err := ws.WriteMessage(websocket.BinaryMessage, frame)
+		err := conn.writeMessage(ws, frame)
 		mu.Unlock()
 		if err != nil {
 			return xerrors.Errorf("systemSender write: %w", err)
@@ -0,0 +1,44 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	"wsnet2/pb"
+)
+
+func TestNewConnCompression(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // 接続はしない
+
+	joined := &pb.JoinedRoomRes{AuthKey: "authkey", Url: "ws://localhost/"}
+	accinfo := &AccessInfo{AppId: "testapp", UserId: "user1", MACKey: "mackey"}
+
+	conn, err := newConn(ctx, accinfo, joined, nil)
+	if err != nil {
+		t.Fatalf("newConn: %+v", err)
+	}
+	if conn.dialer.EnableCompression {
+		t.Errorf("compression is enabled by default")
+	}
+
+	accinfo.EnableCompression = true
+	accinfo.CompressionLevel = 5
+	accinfo.CompressionThreshold = 1024
+	conn, err = newConn(ctx, accinfo, joined, nil)
+	if err != nil {
+		t.Fatalf("newConn: %+v", err)
+	}
+	if !conn.dialer.EnableCompression || conn.compressionLevel != 5 || conn.compressionThreshold != 1024 {
+		t.Errorf("compression: enabled=%v level=%v threshold=%v, wants true 5 1024",
+			conn.dialer.EnableCompression, conn.compressionLevel, conn.compressionThreshold)
+	}
+	if dialer.EnableCompression {
+		t.Errorf("shared dialer is modified")
+	}
+
+	accinfo.CompressionLevel = 10
+	if _, err := newConn(ctx, accinfo, joined, nil); err == nil {
+		t.Errorf("newConn with invalid compression level must fail")
+	}
+}
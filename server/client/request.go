@@ -30,6 +30,7 @@ var (
 	ErrRoomLimit   = errors.New(lobby.ResponseTypeRoomLimit.String())
 	ErrNoRoomFound = errors.New(lobby.ResponseTypeNoRoomFound.String())
 	ErrRoomFull    = errors.New(lobby.ResponseTypeRoomFull.String())
+	ErrBanned      = errors.New(lobby.ResponseTypeBanned.String())
 )
 
 // Create : Roomを作成して入室
@@ -207,13 +208,15 @@ func lobbyRequest(ctx context.Context, accinfo *AccessInfo, path string, param i
 		return &res, ErrNoRoomFound
 	case lobby.ResponseTypeRoomFull:
 		return &res, ErrRoomFull
+	case lobby.ResponseTypeBanned:
+		return &res, ErrBanned
 	default:
 		return &res, xerrors.Errorf("response type: %s: %v", res.Type, res.Msg)
 	}
 }
 
 func connectToRoom(ctx context.Context, accinfo *AccessInfo, joined *pb.JoinedRoomRes, warn func(error)) (*Room, *Connection, error) {
-	room, err := newRoom(joined, accinfo.UserId)
+	room, err := NewRoom(joined, accinfo.UserId)
 	if err != nil {
 		return nil, nil, xerrors.Errorf("new room: %w", err)
 	}
@@ -10,30 +10,43 @@ import (
 )
 
 type Room struct {
-	Id             string
-	Number         *int32
-	Visible        bool
-	Joinable       bool
-	Watchable      bool
-	SearchGroup    uint32
-	MaxPlayers     uint32
-	Watchers       uint32
-	PublicProps    binary.Dict
-	PrivateProps   binary.Dict
-	Created        time.Time
-	ClientDeadline uint32
-	Players        map[string]*Player
-	Me             *Player
-	Master         *Player
-	LastMsgTimes   binary.Dict
+	Id              string
+	Number          *int32
+	Visible         bool
+	Joinable        bool
+	Watchable       bool
+	SearchGroup     uint32
+	MaxPlayers      uint32
+	Watchers        uint32
+	PublicProps     binary.Dict
+	PrivateProps    binary.Dict
+	PublicVersions  map[string]uint64
+	PrivateVersions map[string]uint64
+	Created         time.Time
+	ClientDeadline  uint32
+	Players         map[string]*Player
+	Me              *Player
+	Master          *Player
+	LastMsgTimes    binary.Dict
 }
 
 type Player struct {
-	Id    string
-	Props binary.Dict
+	Id       string
+	Props    binary.Dict
+	Versions map[string]uint64
 }
 
-func newRoom(joined *pb.JoinedRoomRes, myid string) (*Room, error) {
+// newPlayer : 入室時のプロパティのバージョンは全て1なのでVersionsは空
+func newPlayer(id string, props binary.Dict) *Player {
+	return &Player{
+		Id:       id,
+		Props:    props,
+		Versions: make(map[string]uint64),
+	}
+}
+
+// NewRoom : JoinedRoomResから部屋の状態を作る
+func NewRoom(joined *pb.JoinedRoomRes, myid string) (*Room, error) {
 	var num *int32 = nil
 	if joined.RoomInfo.Number != nil {
 		n := joined.RoomInfo.Number.Number
@@ -56,29 +69,42 @@ func newRoom(joined *pb.JoinedRoomRes, myid string) (*Room, error) {
 		if err != nil {
 			return nil, xerrors.Errorf("player[%v] props: %w", p.Id, err)
 		}
-		players[p.Id] = &Player{
-			Id:    p.Id,
-			Props: props,
+		players[p.Id] = newPlayer(p.Id, props)
+		if v, ok := joined.PlayerPropVersions[p.Id]; ok {
+			for k, ver := range v.Versions {
+				players[p.Id].Versions[k] = ver
+			}
 		}
 	}
 
+	pubVers := make(map[string]uint64, len(joined.PublicPropVersions))
+	for k, v := range joined.PublicPropVersions {
+		pubVers[k] = v
+	}
+	privVers := make(map[string]uint64, len(joined.PrivatePropVersions))
+	for k, v := range joined.PrivatePropVersions {
+		privVers[k] = v
+	}
+
 	return &Room{
-		Id:             joined.RoomInfo.Id,
-		Number:         num,
-		Visible:        joined.RoomInfo.Visible,
-		Joinable:       joined.RoomInfo.Joinable,
-		Watchable:      joined.RoomInfo.Watchable,
-		SearchGroup:    joined.RoomInfo.SearchGroup,
-		MaxPlayers:     joined.RoomInfo.MaxPlayers,
-		Watchers:       joined.RoomInfo.Watchers,
-		PublicProps:    pubProps,
-		PrivateProps:   privProps,
-		Created:        joined.RoomInfo.Created.Time(),
-		ClientDeadline: joined.Deadline,
-		Players:        players,
-		Me:             players[myid],
-		Master:         players[joined.MasterId],
-		LastMsgTimes:   make(binary.Dict),
+		Id:              joined.RoomInfo.Id,
+		Number:          num,
+		Visible:         joined.RoomInfo.Visible,
+		Joinable:        joined.RoomInfo.Joinable,
+		Watchable:       joined.RoomInfo.Watchable,
+		SearchGroup:     joined.RoomInfo.SearchGroup,
+		MaxPlayers:      joined.RoomInfo.MaxPlayers,
+		Watchers:        joined.RoomInfo.Watchers,
+		PublicProps:     pubProps,
+		PrivateProps:    privProps,
+		PublicVersions:  pubVers,
+		PrivateVersions: privVers,
+		Created:         joined.RoomInfo.Created.Time(),
+		ClientDeadline:  joined.Deadline,
+		Players:         players,
+		Me:              players[myid],
+		Master:          players[joined.MasterId],
+		LastMsgTimes:    make(binary.Dict),
 	}, nil
 }
 
@@ -99,6 +125,8 @@ func (r *Room) Update(ev binary.Event) error {
 		return r.onEvMasterSwitched(ev)
 	case binary.EvTypeRejoined:
 		return r.onEvRejoined(ev)
+	case binary.EvTypeRoleChanged:
+		return r.onEvRoleChanged(ev)
 	case binary.EvTypePong:
 		return r.onEvPong(ev)
 	}
@@ -114,10 +142,7 @@ func (r *Room) onEvJoined(ev binary.Event) error {
 	if err != nil {
 		return xerrors.Errorf("Room.onEvJoined: player(%v) props: %w", clinfo.Id, err)
 	}
-	r.Players[clinfo.Id] = &Player{
-		Id:    clinfo.Id,
-		Props: props,
-	}
+	r.Players[clinfo.Id] = newPlayer(clinfo.Id, props)
 	return nil
 }
 
@@ -144,12 +169,8 @@ func (r *Room) onEvRoomProp(ev binary.Event) error {
 	if p.ClientDeadline != 0 {
 		r.ClientDeadline = p.ClientDeadline
 	}
-	for k, v := range p.PublicProps {
-		r.PublicProps[k] = v
-	}
-	for k, v := range p.PrivateProps {
-		r.PrivateProps[k] = v
-	}
+	applyProps(r.PublicProps, r.PublicVersions, p.PublicProps, p.Version)
+	applyProps(r.PrivateProps, r.PrivateVersions, p.PrivateProps, p.Version)
 	return nil
 }
 
@@ -158,14 +179,13 @@ func (r *Room) onEvClientProp(ev binary.Event) error {
 	if err != nil {
 		return xerrors.Errorf("Room.onEvClientProp: payload: %w", err)
 	}
-	for k, v := range p.Props {
-		r.Players[p.Id].Props[k] = v
-	}
+	player := r.Players[p.Id]
+	applyProps(player.Props, player.Versions, p.Props, p.Version)
 	return nil
 }
 
 func (r *Room) onEvMasterSwitched(ev binary.Event) error {
-	mid, err := binary.UnmarshalEvMasterSwitchedPayload(ev.Payload())
+	mid, _, err := binary.UnmarshalEvMasterSwitchedPayload(ev.Payload())
 	if err != nil {
 		return xerrors.Errorf("Room.onEvMasterSwitched: payload: %w", err)
 	}
@@ -182,10 +202,24 @@ func (r *Room) onEvRejoined(ev binary.Event) error {
 	if err != nil {
 		return xerrors.Errorf("Room.onEvRejoined: player(%v) props: %w", p.Id, err)
 	}
-	r.Players[p.Id] = &Player{
-		Id:    p.Id,
-		Props: props,
+	r.Players[p.Id] = newPlayer(p.Id, props)
+	return nil
+}
+
+func (r *Room) onEvRoleChanged(ev binary.Event) error {
+	p, isPlayer, err := binary.UnmarshalEvRoleChangedPayload(ev.Payload())
+	if err != nil {
+		return xerrors.Errorf("Room.onEvRoleChanged: payload: %w", err)
+	}
+	if !isPlayer {
+		delete(r.Players, p.Id)
+		return nil
 	}
+	props, _, err := binary.UnmarshalNullDict(p.Props)
+	if err != nil {
+		return xerrors.Errorf("Room.onEvRoleChanged: player(%v) props: %w", p.Id, err)
+	}
+	r.Players[p.Id] = newPlayer(p.Id, props)
 	return nil
 }
 
@@ -198,3 +232,17 @@ func (r *Room) onEvPong(ev binary.Event) error {
 	r.LastMsgTimes = p.LastMsgTimes
 	return nil
 }
+
+// applyProps : 変更をpropsに適用し、変更したキーのバージョンを ver にする.
+// 空の値はキーの削除を表す.
+func applyProps(props binary.Dict, versions map[string]uint64, modified binary.Dict, ver uint64) {
+	for k, v := range modified {
+		if _, ok := props[k]; ok && len(v) == 0 {
+			delete(props, k)
+			delete(versions, k)
+		} else {
+			props[k] = v
+			versions[k] = ver
+		}
+	}
+}
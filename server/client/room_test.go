@@ -3,6 +3,8 @@ package client_test
 import (
 	"reflect"
 	"testing"
+	"time"
+
 	"wsnet2/binary"
 	"wsnet2/client"
 	"wsnet2/pb"
@@ -11,29 +13,33 @@ import (
 func newRoom() *client.Room {
 	players := map[string]*client.Player{
 		"user1": {
-			Id:    "user1",
-			Props: binary.Dict{"cli1": binary.MarshalInt(100)},
+			Id:       "user1",
+			Props:    binary.Dict{"cli1": binary.MarshalInt(100)},
+			Versions: map[string]uint64{},
 		},
 		"user2": {
-			Id:    "user2",
-			Props: binary.Dict{},
+			Id:       "user2",
+			Props:    binary.Dict{},
+			Versions: map[string]uint64{},
 		},
 	}
 	return &client.Room{
-		Id:             "room1",
-		Number:         nil,
-		Visible:        false,
-		Joinable:       true,
-		Watchable:      false,
-		SearchGroup:    10,
-		MaxPlayers:     5,
-		Watchers:       20,
-		PublicProps:    binary.Dict{"pub1": binary.MarshalBool(true), "pub2": binary.MarshalNull()},
-		PrivateProps:   binary.Dict{},
-		ClientDeadline: 30,
-		Players:        players,
-		Me:             players["user2"],
-		Master:         players["user1"],
+		Id:              "room1",
+		Number:          nil,
+		Visible:         false,
+		Joinable:        true,
+		Watchable:       false,
+		SearchGroup:     10,
+		MaxPlayers:      5,
+		Watchers:        20,
+		PublicProps:     binary.Dict{"pub1": binary.MarshalBool(true), "pub2": binary.MarshalNull()},
+		PrivateProps:    binary.Dict{},
+		PublicVersions:  map[string]uint64{},
+		PrivateVersions: map[string]uint64{},
+		ClientDeadline:  30,
+		Players:         players,
+		Me:              players["user2"],
+		Master:          players["user1"],
 	}
 }
 
@@ -157,7 +163,7 @@ func TestRoom_Update_onEvClientProp(t *testing.T) {
 	user := "user1"
 	ev := binary.NewEvClientProp(user, binary.MarshalDict(binary.Dict{
 		"cli2": binary.MarshalBool(false),
-	}))
+	}), 2)
 	exp := binary.Dict{
 		"cli1": binary.MarshalInt(100),
 		"cli2": binary.MarshalBool(false),
@@ -172,11 +178,15 @@ func TestRoom_Update_onEvClientProp(t *testing.T) {
 	if !reflect.DeepEqual(room.Players[user].Props, exp) {
 		t.Fatalf("player[%v] prop: %v, wants %v", user, room.Players[user].Props, exp)
 	}
+	expVers := map[string]uint64{"cli2": 2}
+	if !reflect.DeepEqual(room.Players[user].Versions, expVers) {
+		t.Fatalf("player[%v] versions: %v, wants %v", user, room.Players[user].Versions, expVers)
+	}
 }
 
 func TestRoom_Update_onEvMasterSwitched(t *testing.T) {
 	newmaster := "user2"
-	ev := binary.NewEvMasterSwitched("user1", "user2")
+	ev := binary.NewEvMasterSwitched("user1", "user2", binary.MasterSwitchRequested)
 
 	room := newRoom()
 	err := room.Update(ev)
@@ -210,9 +220,36 @@ func TestRoom_Update_onRejoined(t *testing.T) {
 	}
 }
 
+func TestRoom_Update_onEvRoleChanged(t *testing.T) {
+	props := binary.Dict{"cli3": binary.MarshalInt(300)}
+	room := newRoom()
+
+	err := room.Update(binary.NewEvRoleChanged(&pb.ClientInfo{
+		Id:    "user3",
+		Props: binary.MarshalDict(props),
+	}, true))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if p, ok := room.Players["user3"]; !ok || !reflect.DeepEqual(p.Props, props) {
+		t.Fatalf("player user3 = %v, wants props %v", p, props)
+	}
+
+	err = room.Update(binary.NewEvRoleChanged(&pb.ClientInfo{
+		Id:    "user1",
+		Props: binary.MarshalDict(binary.Dict{}),
+	}, false))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, ok := room.Players["user1"]; ok {
+		t.Fatalf("found user1")
+	}
+}
+
 func TestRoom_Update_onEvPong(t *testing.T) {
 	const watchers = 17
-	ev := binary.NewEvPong(10000, watchers, binary.Dict{})
+	ev := binary.NewEvPong(10000, watchers, binary.Dict{}, time.Now())
 
 	room := newRoom()
 	err := room.Update(ev)
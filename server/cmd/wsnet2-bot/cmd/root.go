@@ -39,6 +39,7 @@ var (
 	proxyURL      string
 	skipTLSVerify bool
 	timeout       time.Duration
+	compression   bool
 
 	verbose bool
 
@@ -90,6 +91,7 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&proxyURL, "proxy", "", "Proxy URL")
 	rootCmd.PersistentFlags().BoolVarP(&skipTLSVerify, "skip-tls-verify", "s", false, "Skip TLS verify")
 	rootCmd.PersistentFlags().DurationVarP(&timeout, "timeout", "t", 5*time.Second, "Lobby request timeout")
+	rootCmd.PersistentFlags().BoolVar(&compression, "compression", false, "Enable websocket compression")
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Verbose log output")
 }
 
@@ -132,9 +134,19 @@ func setupClient() error {
 	return nil
 }
 
+// genAccessInfo generates AccessInfo with the command line options.
+func genAccessInfo(userId string) (*client.AccessInfo, error) {
+	accinfo, err := client.GenAccessInfo(lobbyURL, appId, appKey, userId)
+	if err != nil {
+		return nil, err
+	}
+	accinfo.EnableCompression = compression
+	return accinfo, nil
+}
+
 // searchRooms searches rooms.
 func searchRooms(ctx context.Context, cId string, param *lobby.SearchParam) ([]*pb.RoomInfo, error) {
-	accinfo, err := client.GenAccessInfo(lobbyURL, appId, appKey, cId)
+	accinfo, err := genAccessInfo(cId)
 	if err != nil {
 		return nil, err
 	}
@@ -145,7 +157,7 @@ func searchRooms(ctx context.Context, cId string, param *lobby.SearchParam) ([]*
 // createRoom creates room
 func createRoom(ctx context.Context, owner string, roomopt *pb.RoomOption) (*client.Room, *client.Connection, error) {
 
-	accinfo, err := client.GenAccessInfo(lobbyURL, appId, appKey, owner)
+	accinfo, err := genAccessInfo(owner)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -157,7 +169,7 @@ func createRoom(ctx context.Context, owner string, roomopt *pb.RoomOption) (*cli
 
 // joinRoom joins the player to the room
 func joinRoom(ctx context.Context, player, roomId string, query *client.Query) (*client.Room, *client.Connection, error) {
-	accinfo, err := client.GenAccessInfo(lobbyURL, appId, appKey, player)
+	accinfo, err := genAccessInfo(player)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -173,7 +185,7 @@ func joinRoom(ctx context.Context, player, roomId string, query *client.Query) (
 
 // joinRandom joins the player to a room randomly
 func joinRandom(ctx context.Context, player string, group uint32, query *client.Query) (*client.Room, *client.Connection, error) {
-	accinfo, err := client.GenAccessInfo(lobbyURL, appId, appKey, player)
+	accinfo, err := genAccessInfo(player)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -185,7 +197,7 @@ func joinRandom(ctx context.Context, player string, group uint32, query *client.
 
 // watchRoom joins the watcher to the room
 func watchRoom(ctx context.Context, watcher, roomId string, query *client.Query) (*client.Room, *client.Connection, error) {
-	accinfo, err := client.GenAccessInfo(lobbyURL, appId, appKey, watcher)
+	accinfo, err := genAccessInfo(watcher)
 	if err != nil {
 		return nil, nil, err
 	}
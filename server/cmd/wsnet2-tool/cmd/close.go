@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"wsnet2/pb"
+
+	"golang.org/x/xerrors"
+
+	"github.com/spf13/cobra"
+)
+
+// closeCmd represents the close command
+var closeCmd = &cobra.Command{
+	Use:   "close <room>",
+	Short: "Close the room",
+	Long:  `Close the specified room even if it is a persistent room`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return xerrors.Errorf("need room")
+		}
+
+		svrs, err := selectGrpcServers(cmd.Context(), args[0:1])
+		if err != nil {
+			return err
+		}
+		svr, ok := svrs[args[0]]
+		if !ok {
+			return xerrors.Errorf("room not found: %v", args[0])
+		}
+
+		conn, err := svr.Dial()
+		if err != nil {
+			return err
+		}
+
+		_, err = pb.NewGameClient(conn).Close(cmd.Context(), &pb.CloseReq{
+			AppId:  svr.App,
+			RoomId: svr.Room,
+		})
+		if err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(closeCmd)
+}
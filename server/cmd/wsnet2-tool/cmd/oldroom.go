@@ -36,6 +36,7 @@ type playerLog struct {
 	RoomID   string            `db:"room_id" json:"-"`
 	PlayerID string            `db:"player_id" json:"player_id"`
 	Message  game.PlayerLogMsg `db:"message" json:"message"`
+	Detail   *string           `db:"detail" json:"detail,omitempty"`
 	Datetime time.Time         `db:"datetime" json:"datetime"`
 }
 
@@ -26,24 +26,24 @@ func NewRingBuf[T any](size int) *RingBuf[T] {
 	}
 }
 
-// Write to buffer from Room.MsgLoop goroutine.
+// Write to buffer.
+// It may be called from several goroutines: Room.MsgLoop and Client.MsgLoop,
+// which sends EvInvalidPayload/EvRateLimited concurrently.
 // It returns an error when buffer is full.
 func (b *RingBuf[T]) Write(data T) error {
-	// MsgLoopは単一goroutineで、wSeqはここでしか書き換えない
-	// rSeqがwSeqを超えることは無いのでロックし続けなくてよい
-	b.mu.RLock()
+	// 複数のgoroutineから書き込まれ、Forward() も wSeq を書き換えるので、
+	// 読み書き全体を排他ロックで守る
+	b.mu.Lock()
 	r, w := b.rSeq, b.wSeq
-	b.mu.RUnlock()
 
 	s := len(b.buf)
 
 	if w-s == r {
+		b.mu.Unlock()
 		return xerrors.Errorf("RingBuf overflow: size=%v, read=%v, write=%v", s, r, w)
 	}
 
 	b.buf[w%s] = data
-
-	b.mu.Lock()
 	b.wSeq++
 	b.mu.Unlock()
 
@@ -92,3 +92,69 @@ func (b *RingBuf[T]) Read(seq int) ([]T, error) {
 
 	return buf, nil
 }
+
+// Snapshot returns all data which can be re-read and the sequence number of the first one.
+// It called from Room.MsgLoop goroutine.
+func (b *RingBuf[T]) Snapshot() (int, []T) {
+	size := len(b.buf)
+
+	b.mu.RLock()
+	w := b.wSeq
+	b.mu.RUnlock()
+
+	start := w - size + 1
+	if start < 0 {
+		start = 0
+	}
+	buf := make([]T, w-start)
+	for i := range buf {
+		buf[i] = b.buf[(start+i)%size]
+	}
+	return start, buf
+}
+
+// Restore resets the buffer with the data whose first sequence number is seq.
+// The data are treated as already read, and can be re-read by Read(seq).
+// Older data which cannot be re-read are discarded.
+func (b *RingBuf[T]) Restore(seq int, data []T) {
+	size := len(b.buf)
+	if over := len(data) - (size - 1); over > 0 {
+		seq += over
+		data = data[over:]
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, d := range data {
+		b.buf[(seq+i)%size] = d
+	}
+	b.wSeq = seq + len(data)
+	b.rSeq = b.wSeq
+}
+
+// Forward renumbers the data written after seq so that they follow newSeq,
+// and marks them as unread.
+// It is used when the reader has already read beyond the buffer (e.g. the buffer was restored from an old snapshot).
+func (b *RingBuf[T]) Forward(seq, newSeq int) {
+	size := len(b.buf)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	w := b.wSeq
+	if seq > w {
+		seq = w
+	}
+	if w-seq >= size {
+		seq = w - size + 1
+	}
+	data := make([]T, w-seq)
+	for i := range data {
+		data[i] = b.buf[(seq+i)%size]
+	}
+	for i, d := range data {
+		b.buf[(newSeq+i)%size] = d
+	}
+	b.rSeq = newSeq
+	b.wSeq = newSeq + len(data)
+}
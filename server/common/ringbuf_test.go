@@ -125,3 +125,95 @@ func TestReadWithRewind(t *testing.T) {
 		t.Fatalf("Read(2) must error")
 	}
 }
+
+func TestSnapshotRestore(t *testing.T) {
+	src := NewEvBuf(4)
+	var evs []*binary.RegularEvent
+	for i := 0; i < 6; i++ {
+		ev := binary.NewRegularEvent(binary.EvType(i), nil)
+		evs = append(evs, ev)
+		if e := src.Write(ev); e != nil {
+			t.Fatalf("Write(%v) error: %v", ev, e)
+		}
+		if _, e := src.Read(i); e != nil {
+			t.Fatalf("Read(%v) error: %v", i, e)
+		}
+	}
+
+	seq, data := src.Snapshot()
+	if seq != 3 || !reflect.DeepEqual(data, evs[3:]) {
+		t.Fatalf("Snapshot() = %v, %v, wants %v, %v", seq, data, 3, evs[3:])
+	}
+
+	dst := NewEvBuf(3)
+	dst.Restore(seq, data)
+
+	r, err := dst.Read(6)
+	if err != nil {
+		t.Fatalf("Read(6) error: %v", err)
+	}
+	if len(r) != 0 {
+		t.Fatalf("Read(6) %v, wants []", r)
+	}
+
+	r, err = dst.Read(4)
+	if err != nil {
+		t.Fatalf("Read(4) error: %v", err)
+	}
+	if !reflect.DeepEqual(r, evs[4:]) {
+		t.Fatalf("Read(4) %v, wants %v", r, evs[4:])
+	}
+
+	if _, err := dst.Read(3); err == nil {
+		t.Fatalf("Read(3) must be error")
+	}
+
+	ev := binary.NewRegularEvent(6, nil)
+	if e := dst.Write(ev); e != nil {
+		t.Fatalf("Write(%v) error: %v", ev, e)
+	}
+	r, err = dst.Read(6)
+	if err != nil {
+		t.Fatalf("Read(6) error: %v", err)
+	}
+	if !reflect.DeepEqual(r, []*binary.RegularEvent{ev}) {
+		t.Fatalf("Read(6) %v, wants %v", r, ev)
+	}
+}
+
+func TestForward(t *testing.T) {
+	buf := NewEvBuf(4)
+	buf.Restore(2, []*binary.RegularEvent{binary.NewRegularEvent(0, nil)})
+
+	evs := []*binary.RegularEvent{
+		binary.NewRegularEvent(1, nil),
+		binary.NewRegularEvent(2, nil),
+	}
+	for _, ev := range evs {
+		if e := buf.Write(ev); e != nil {
+			t.Fatalf("Write(%v) error: %v", ev, e)
+		}
+	}
+
+	buf.Forward(3, 10)
+
+	r, err := buf.Read(10)
+	if err != nil {
+		t.Fatalf("Read(10) error: %v", err)
+	}
+	if !reflect.DeepEqual(r, evs) {
+		t.Fatalf("Read(10) %v, wants %v", r, evs)
+	}
+
+	ev := binary.NewRegularEvent(3, nil)
+	if e := buf.Write(ev); e != nil {
+		t.Fatalf("Write(%v) error: %v", ev, e)
+	}
+	r, err = buf.Read(12)
+	if err != nil {
+		t.Fatalf("Read(12) error: %v", err)
+	}
+	if !reflect.DeepEqual(r, []*binary.RegularEvent{ev}) {
+		t.Fatalf("Read(12) %v, wants %v", r, ev)
+	}
+}
@@ -74,6 +74,25 @@ type GameConf struct {
 
 	DbMaxConns int `toml:"db_max_conns"`
 
+	// RecordDir : RoomOption.Recordが指定された部屋の記録ファイルの保存先. 空なら記録しない
+	RecordDir string `toml:"record_dir"`
+
+	// MigrateOnShutdown : shutdown時に部屋を稼働中の他のgameサーバに移動する
+	MigrateOnShutdown bool `toml:"migrate_on_shutdown"`
+
+	// SnapshotDir : 部屋のスナップショットの保存先. 空ならスナップショットを取らない
+	SnapshotDir string `toml:"snapshot_dir"`
+	// SnapshotInterval : スナップショットを取る間隔
+	SnapshotInterval Duration `toml:"snapshot_interval"`
+
+	// KickBanDuration : Kickされたクライアントの入室を禁止する時間. 0なら禁止しない
+	KickBanDuration Duration `toml:"kick_ban_duration"`
+
+	// PayloadLimits : メッセージとプロパティの制限
+	PayloadLimits PayloadLimits `toml:"payload_limits"`
+	// AppPayloadLimits : アプリ毎の制限. 指定したアプリはPayloadLimitsの代わりに使う
+	AppPayloadLimits map[string]PayloadLimits `toml:"app_payload_limits"`
+
 	ClientConf
 	LogConf
 }
@@ -104,6 +123,9 @@ type HubConf struct {
 
 	DbMaxConns int `toml:"db_max_conns"`
 
+	// ReplayDir : 再生する記録ファイルの置き場所 (Game.RecordDirを共有する). 空なら再生しない
+	ReplayDir string `toml:"replay_dir"`
+
 	ClientConf
 	LogConf
 }
@@ -115,6 +137,53 @@ type ClientConf struct {
 	WaitAfterClose Duration `toml:"wait_after_close"`
 
 	AuthKeyLen int `toml:"auth_key_len"`
+
+	// MsgRateLimits : メッセージ種別（"Broadcast"など）毎の送信レート制限
+	MsgRateLimits map[string]RateLimit `toml:"msg_rate_limits"`
+	// MsgRateLimitPolicy : レート制限を超えたときの処理 ("drop", "warn", "kick")
+	MsgRateLimitPolicy string `toml:"msg_rate_limit_policy"`
+
+	// PeerQueueSize : Peer毎の送信キューの長さ. 0ならEventBufSizeの2倍.
+	// 再接続時にevbufの未読Eventをまとめて積むので、EventBufSizeより大きくすること
+	PeerQueueSize int `toml:"peer_queue_size"`
+	// SlowPeerPolicy : 送信キューが溢れたときの処理 ("disconnect", "drop_volatile", "kick")
+	SlowPeerPolicy string `toml:"slow_peer_policy"`
+
+	// EnableCompression : websocketのpermessage-deflateを有効にする. クライアントが対応していなければ使わない
+	EnableCompression bool `toml:"enable_compression"`
+	// CompressionLevel : 圧縮レベル (compress/flateの-2〜9)
+	CompressionLevel int `toml:"compression_level"`
+	// CompressionThreshold : これより小さいメッセージは圧縮しない (バイト数)
+	CompressionThreshold int `toml:"compression_threshold"`
+}
+
+// RateLimit : token bucketによる送信レート制限
+type RateLimit struct {
+	// Rate : 1秒あたりに送信できるメッセージ数. 0なら制限しない
+	Rate int `toml:"rate"`
+	// Burst : 連続して送信できるメッセージ数. 0ならRateと同じ
+	Burst int `toml:"burst"`
+}
+
+// PayloadLimits : メッセージとプロパティの制限. 0なら制限しない
+type PayloadLimits struct {
+	// MaxMsgSize : クライアントから受け取るメッセージのpayloadの最大バイト数
+	MaxMsgSize int `toml:"max_msg_size"`
+	// MaxPropKeys : 各プロパティのキーの最大数
+	MaxPropKeys int `toml:"max_prop_keys"`
+	// MaxPublicPropSize : 部屋の公開プロパティの最大バイト数
+	MaxPublicPropSize int `toml:"max_public_prop_size"`
+	// MaxPrivatePropSize : 部屋の非公開プロパティの最大バイト数
+	MaxPrivatePropSize int `toml:"max_private_prop_size"`
+	// MaxClientPropSize : クライアントのプロパティの最大バイト数
+	MaxClientPropSize int `toml:"max_client_prop_size"`
+
+	// RoomPropSchema : 部屋のプロパティ（公開・非公開共通）のキーと型名 ("Int", "Str8"など)
+	RoomPropSchema map[string]string `toml:"room_prop_schema"`
+	// ClientPropSchema : クライアントのプロパティのキーと型名
+	ClientPropSchema map[string]string `toml:"client_prop_schema"`
+	// StrictSchema : スキーマにないキーを拒否する
+	StrictSchema bool `toml:"strict_schema"`
 }
 
 type LobbyConf struct {
@@ -135,6 +204,9 @@ type LobbyConf struct {
 
 	HubMaxWatchers int `toml:"hub_max_watchers"`
 
+	// WatchReplay : 終了した部屋の観戦リクエストを記録の再生としてhubに転送する
+	WatchReplay bool `toml:"watch_replay"`
+
 	DbMaxConns int `toml:"db_max_conns"`
 
 	LogConf
@@ -184,10 +256,17 @@ func Load(conffile string) (*Config, error) {
 
 			DbMaxConns: 0,
 
+			SnapshotInterval: Duration(10 * time.Second),
+
 			ClientConf: ClientConf{
-				EventBufSize:   128,
-				WaitAfterClose: Duration(30 * time.Second),
-				AuthKeyLen:     32,
+				EventBufSize:       128,
+				WaitAfterClose:     Duration(30 * time.Second),
+				AuthKeyLen:         32,
+				MsgRateLimitPolicy: "drop",
+				SlowPeerPolicy:     "disconnect",
+
+				CompressionLevel:     1,
+				CompressionThreshold: 256,
 			},
 
 			LogConf: LogConf{
@@ -215,9 +294,14 @@ func Load(conffile string) (*Config, error) {
 			DbMaxConns: 0,
 
 			ClientConf: ClientConf{
-				EventBufSize:   128,
-				WaitAfterClose: Duration(30 * time.Second),
-				AuthKeyLen:     32,
+				EventBufSize:       128,
+				WaitAfterClose:     Duration(30 * time.Second),
+				AuthKeyLen:         32,
+				MsgRateLimitPolicy: "drop",
+				SlowPeerPolicy:     "disconnect",
+
+				CompressionLevel:     1,
+				CompressionThreshold: 256,
 			},
 
 			LogConf: LogConf{
@@ -45,10 +45,36 @@ func TestLoad(t *testing.T) {
 
 		HeartBeatInterval: Duration(time.Second * 10),
 
+		RecordDir: "/tmp/wsnet2-record",
+
+		SnapshotDir:      "/tmp/wsnet2-snapshot",
+		SnapshotInterval: Duration(time.Second * 5),
+
+		PayloadLimits: PayloadLimits{
+			MaxMsgSize:        4096,
+			MaxPropKeys:       32,
+			MaxPublicPropSize: 1024,
+			RoomPropSchema:    map[string]string{"name": "Str8", "score": "Int"},
+		},
+		AppPayloadLimits: map[string]PayloadLimits{
+			"testapp": {MaxMsgSize: 1024, StrictSchema: true},
+		},
+
 		ClientConf: ClientConf{
 			EventBufSize:   512,
 			WaitAfterClose: Duration(time.Second * 60),
 			AuthKeyLen:     32,
+			MsgRateLimits: map[string]RateLimit{
+				"Broadcast": {Rate: 30, Burst: 60},
+				"RoomProp":  {Rate: 5},
+			},
+			MsgRateLimitPolicy: "warn",
+			PeerQueueSize:      1024,
+			SlowPeerPolicy:     "drop_volatile",
+
+			EnableCompression:    true,
+			CompressionLevel:     6,
+			CompressionThreshold: 128,
 		},
 
 		LogConf: LogConf{
@@ -75,6 +101,7 @@ func TestLoad(t *testing.T) {
 		AuthDataExpire: Duration(time.Second * 10),
 		ApiTimeout:     Duration(time.Second * 5),
 		HubMaxWatchers: 10000,
+		WatchReplay:    true,
 		LogConf: LogConf{
 			LogStdoutConsole: false,
 			LogStdoutLevel:   4,
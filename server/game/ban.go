@@ -0,0 +1,100 @@
+package game
+
+import (
+	"time"
+
+	"golang.org/x/xerrors"
+	"google.golang.org/grpc/codes"
+
+	"wsnet2/binary"
+	"wsnet2/pb"
+)
+
+// 入室禁止リスト
+//
+// MsgBan で指定されたクライアントや、GameConf.KickBanDuration が設定されているときに
+// Kickされたクライアントの入室と観戦を禁止する.
+// 期限はクライアント毎に設定でき、ゼロ値なら部屋が終了するまで禁止する.
+// Hub経由の観戦はgameサーバを通らないので禁止できない.
+
+// ban : 入室を禁止する. d が0なら部屋が終了するまで.
+// muClients のロックを取得してから呼び出す.
+func (r *Room) ban(id ClientID, d time.Duration, now time.Time) {
+	var expire time.Time
+	if d > 0 {
+		expire = now.Add(d)
+	}
+	r.banned[id] = expire
+	// 予約席も取り消す
+	delete(r.reservations, id)
+	r.logger.Infof("banned: %v until %v", id, expire)
+}
+
+// banKicked : Kickしたクライアントの入室を KickBanDuration の間禁止する.
+// KickBanDuration が0なら禁止しない.
+// muClients のロックを取得してから呼び出す.
+func (r *Room) banKicked(id ClientID, now time.Time) {
+	if d := time.Duration(r.conf.KickBanDuration); d > 0 {
+		r.ban(id, d, now)
+	}
+}
+
+// checkBanned : 入室禁止されていればエラーを返す.
+// 期限切れのものはここで削除する.
+func (r *Room) checkBanned(id ClientID, now time.Time) ErrorWithCode {
+	expire, ok := r.banned[id]
+	if !ok {
+		return nil
+	}
+	if !expire.IsZero() && !now.Before(expire) {
+		delete(r.banned, id)
+		return nil
+	}
+	return NormalWithCode(
+		xerrors.Errorf("Client is banned. room=%v, client=%v, expire=%v", r.ID(), id, expire),
+		codes.PermissionDenied)
+}
+
+// bansSnapshot : 部屋の移動先に送る入室禁止リスト
+func (r *Room) bansSnapshot() []*pb.Ban {
+	bans := make([]*pb.Ban, 0, len(r.banned))
+	for id, expire := range r.banned {
+		var e int64
+		if !expire.IsZero() {
+			e = expire.Unix()
+		}
+		bans = append(bans, &pb.Ban{ClientId: string(id), Expire: e})
+	}
+	return bans
+}
+
+// restoreBans : 移動元の入室禁止リストを復元する
+func (r *Room) restoreBans(bans []*pb.Ban) {
+	for _, b := range bans {
+		var expire time.Time
+		if b.Expire > 0 {
+			expire = time.Unix(b.Expire, 0)
+		}
+		r.banned[ClientID(b.ClientId)] = expire
+	}
+}
+
+func (r *Room) msgBan(msg *MsgBan) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	if msg.Sender != r.master {
+		msg.Sender.logger.Warnf("sender %q is not master %q", msg.Sender.Id, r.masterID())
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+
+	r.ban(msg.Target, msg.Duration, time.Now())
+	r.sendTo(msg.Sender, binary.NewEvSucceeded(msg))
+
+	if target, ok := r.players[msg.Target]; ok {
+		r.removeClient(target, msg.Message, PlayerLogKick)
+	} else if target, ok := r.watchers[msg.Target]; ok {
+		r.removeClient(target, msg.Message, PlayerLogKick)
+	}
+}
@@ -0,0 +1,82 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+
+	"wsnet2/binary"
+	"wsnet2/config"
+	"wsnet2/pb"
+)
+
+func TestBan(t *testing.T) {
+	r := &Room{
+		RoomInfo:     &pb.RoomInfo{Id: "room1"},
+		reservations: map[ClientID]*reservation{"b": {token: "token"}},
+		banned:       make(map[ClientID]time.Time),
+		logger:       zap.NewNop().Sugar(),
+	}
+	now := time.Now()
+
+	r.ban("a", time.Minute, now)
+	r.ban("b", 0, now)
+
+	if _, ok := r.reservations["b"]; ok {
+		t.Errorf("reservation of banned client remains")
+	}
+
+	for _, id := range []ClientID{"a", "b"} {
+		err := r.checkBanned(id, now.Add(30*time.Second))
+		if err == nil || err.Code() != codes.PermissionDenied {
+			t.Errorf("checkBanned(%v): %v, wants PermissionDenied", id, err)
+		}
+	}
+	if err := r.checkBanned("c", now); err != nil {
+		t.Errorf("checkBanned(c): %v", err)
+	}
+
+	restored := &Room{banned: make(map[ClientID]time.Time)}
+	restored.restoreBans(r.bansSnapshot())
+
+	// 期限切れ
+	if err := r.checkBanned("a", now.Add(time.Minute)); err != nil {
+		t.Errorf("checkBanned(a) after expired: %v", err)
+	}
+	if _, ok := r.banned["a"]; ok {
+		t.Errorf("expired ban remains")
+	}
+
+	if len(restored.banned) != 2 || !restored.banned["b"].IsZero() ||
+		restored.banned["a"].Unix() != now.Add(time.Minute).Unix() {
+		t.Errorf("restored bans differ: %v", restored.banned)
+	}
+}
+
+func TestKickBan(t *testing.T) {
+	r, master := newTestRoom(t, nil, nil)
+	kick := func(target ClientID) {
+		r.msgCh <- &MsgKick{
+			RegularMsg: binary.NewRegularMsg(binary.MsgTypeKick, 1, nil),
+			Sender:     master,
+			Target:     target,
+		}
+	}
+
+	// KickBanDuration が0ならKickされても入室できる
+	if _, err := joinRoom(r, "p1"); err != nil {
+		t.Fatalf("join p1: %v", err)
+	}
+	kick("p1")
+	if _, err := joinRoom(r, "p1"); err != nil {
+		t.Fatalf("join p1 after kicked: %v", err)
+	}
+
+	r.conf.KickBanDuration = config.Duration(time.Minute)
+	kick("p1")
+	if _, err := joinRoom(r, "p1"); err == nil || err.Code() != codes.PermissionDenied {
+		t.Fatalf("join p1 after kicked: %v, wants PermissionDenied", err)
+	}
+}
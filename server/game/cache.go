@@ -0,0 +1,89 @@
+package game
+
+import (
+	"sort"
+
+	"wsnet2/binary"
+	"wsnet2/pb"
+)
+
+// eventCache : MsgCachedBroadcastのキー毎に最新のEventを保持する.
+// 途中入室したクライアントに更新順に送る.
+// RoomのMsgLoopからのみ使う.
+type eventCache struct {
+	entries map[string]*cachedEvent
+	seq     uint64
+}
+
+type cachedEvent struct {
+	key    string
+	sender ClientID
+	data   []byte
+	ev     *binary.RegularEvent
+	seq    uint64
+}
+
+func newEventCache() *eventCache {
+	return &eventCache{
+		entries: make(map[string]*cachedEvent),
+	}
+}
+
+// set : キーのEventを更新する. dataが空ならキャッシュを削除する.
+func (c *eventCache) set(key string, sender ClientID, data []byte) {
+	if len(data) == 0 {
+		delete(c.entries, key)
+		return
+	}
+	c.seq++
+	c.entries[key] = &cachedEvent{
+		key:    key,
+		sender: sender,
+		data:   data,
+		ev:     binary.NewEvMessage(string(sender), data),
+		seq:    c.seq,
+	}
+}
+
+// removeSender : 退室したクライアントが送ったEventを削除し、削除したキーを返す.
+func (c *eventCache) removeSender(sender ClientID) []string {
+	var keys []string
+	for k, e := range c.entries {
+		if e.sender == sender {
+			delete(c.entries, k)
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// list : 更新順に並べたEvent
+func (c *eventCache) list() []*cachedEvent {
+	list := make([]*cachedEvent, 0, len(c.entries))
+	for _, e := range c.entries {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].seq < list[j].seq })
+	return list
+}
+
+// snapshot : 部屋の移動先に送るキャッシュ
+func (c *eventCache) snapshot() []*pb.CachedEvent {
+	list := c.list()
+	evs := make([]*pb.CachedEvent, len(list))
+	for i, e := range list {
+		evs[i] = &pb.CachedEvent{
+			Key:    e.key,
+			Sender: string(e.sender),
+			Data:   e.data,
+		}
+	}
+	return evs
+}
+
+// restore : 移動元のキャッシュを復元する
+func (c *eventCache) restore(evs []*pb.CachedEvent) {
+	for _, e := range evs {
+		c.set(e.Key, ClientID(e.Sender), e.Data)
+	}
+}
@@ -0,0 +1,103 @@
+package game
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"wsnet2/binary"
+	"wsnet2/pb"
+)
+
+func TestEventCache(t *testing.T) {
+	c := newEventCache()
+	c.set("a", "player1", []byte{1})
+	c.set("b", "player2", []byte{2})
+	c.set("c", "player1", []byte{3})
+	c.set("a", "player2", []byte{4})
+	c.set("d", "player1", nil)
+
+	want := []*pb.CachedEvent{
+		{Key: "b", Sender: "player2", Data: []byte{2}},
+		{Key: "c", Sender: "player1", Data: []byte{3}},
+		{Key: "a", Sender: "player2", Data: []byte{4}},
+	}
+	if diff := cmp.Diff(c.snapshot(), want, protocmp.Transform()); diff != "" {
+		t.Fatalf("cache differs: (-got +want)\n%s", diff)
+	}
+
+	c.set("b", "player2", nil)
+	c.removeSender("player1")
+	want = []*pb.CachedEvent{
+		{Key: "a", Sender: "player2", Data: []byte{4}},
+	}
+	if diff := cmp.Diff(c.snapshot(), want, protocmp.Transform()); diff != "" {
+		t.Fatalf("cache differs: (-got +want)\n%s", diff)
+	}
+
+	r := newEventCache()
+	r.restore(c.snapshot())
+	if diff := cmp.Diff(r.snapshot(), want, protocmp.Transform()); diff != "" {
+		t.Fatalf("restored cache differs: (-got +want)\n%s", diff)
+	}
+}
+
+func TestCachedEventsToHub(t *testing.T) {
+	r, master := newTestRoom(t, nil, nil)
+	// masterが退室しても部屋が続くように
+	if _, err := joinRoom(r, "p1"); err != nil {
+		t.Fatalf("join p1: %v", err)
+	}
+
+	cached := func(key string, data []byte) *MsgCachedBroadcast {
+		return &MsgCachedBroadcast{
+			RegularMsg: binary.NewRegularMsg(binary.MsgTypeCachedBroadcast, 1, data),
+			Sender:     master,
+			Key:        key,
+			Data:       data,
+		}
+	}
+	r.msgCh <- cached("a", []byte{1})
+
+	jch := make(chan *JoinedInfo, 1)
+	ech := make(chan ErrorWithCode, 1)
+	r.msgCh <- &MsgWatch{Info: &pb.ClientInfo{Id: "hub:1:room1", IsHub: true}, MACKey: "mackey", Joined: jch, Err: ech}
+	var hub *Client
+	select {
+	case j := <-jch:
+		hub = j.Client
+	case err := <-ech:
+		t.Fatalf("watch: %v", err)
+	}
+
+	r.msgCh <- cached("b", []byte{2})
+	r.msgCh <- cached("a", nil)
+	r.msgCh <- &MsgLeave{Sender: master, Message: "bye"}
+	getRoomInfo(r)
+
+	type entry struct {
+		Key, Sender string
+		Data        []byte
+	}
+	var got []entry
+	for _, ev := range received(t, hub) {
+		if ev.Type() != binary.EvTypeCached {
+			continue
+		}
+		key, sender, data, err := binary.UnmarshalEvCachedPayload(ev.Payload())
+		if err != nil {
+			t.Fatalf("UnmarshalEvCachedPayload: %v", err)
+		}
+		got = append(got, entry{key, sender, data})
+	}
+	want := []entry{
+		{"a", "master", []byte{1}},
+		{"b", "master", []byte{2}},
+		{"a", "master", []byte{}},
+		{"b", "master", []byte{}},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Fatalf("cached events differ: (-got +want)\n%s", diff)
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"wsnet2/binary"
 	"wsnet2/common"
 	"wsnet2/log"
+	"wsnet2/metrics"
 	"wsnet2/pb"
 )
 
@@ -27,6 +28,8 @@ type Client struct {
 	nodeCount uint32
 
 	props binary.Dict
+	// propVersions : プロパティのキー毎のバージョン
+	propVersions map[string]uint64
 
 	removed     chan struct{}
 	removeCause string
@@ -35,6 +38,9 @@ type Client struct {
 
 	evbuf *common.RingBuf[*binary.RegularEvent]
 
+	// volatile : 送信待ちの揮発性イベント. evbufと違い再送しない
+	volatile *volatileQueue
+
 	mu           sync.RWMutex
 	msgSeqNum    int
 	peer         *Peer
@@ -44,8 +50,22 @@ type Client struct {
 	received     bool
 
 	authKey string
+	macKey  string
 	hmac    hash.Hash
 
+	// migrateURL : 部屋の移動先URL. 空でなければ再接続時に移動先を通知する
+	migrateURL string
+
+	// restored : スナップショットから復元し、まだ再接続していない
+	restored    bool
+	restoredSeq int
+
+	// limiter : 送信レート制限. 制限がなければnil
+	limiter *rateLimiter
+
+	// rtt : MsgPingで通知された応答時間. 0なら未計測. RoomのMsgLoopからのみ使う
+	rtt time.Duration
+
 	logger log.Logger
 
 	evErr chan error
@@ -60,6 +80,61 @@ func NewWatcher(info *pb.ClientInfo, macKey string, room IRoom) (*Client, ErrorW
 }
 
 func newClient(info *pb.ClientInfo, macKey string, room IRoom, isPlayer bool) (*Client, ErrorWithCode) {
+	c, err := initClient(info, macKey, room, isPlayer)
+	if err != nil {
+		return nil, err
+	}
+	if err := room.PayloadLimits().checkClientProps(c.props, c.props); err != nil {
+		return nil, WithCode(xerrors.Errorf("client props: %w", err), codes.InvalidArgument)
+	}
+	c.start()
+	return c, nil
+}
+
+// restoreClient : 移動元のgameサーバでの状態を復元したClientを作る
+func restoreClient(mc *pb.MigratedClient, room IRoom, isPlayer bool) (*Client, ErrorWithCode) {
+	c, ewc := initClient(mc.Info, mc.MacKey, room, isPlayer)
+	if ewc != nil {
+		return nil, ewc
+	}
+	c.authKey = mc.AuthKey
+	c.msgSeqNum = int(mc.MsgSeqNum)
+	c.nodeCount = mc.NodeCount
+	for k, v := range mc.PropVersions {
+		c.propVersions[k] = v
+	}
+
+	if len(mc.Events) > 0 {
+		evs := make([]*binary.RegularEvent, 0, len(mc.Events))
+		seq := 0
+		for i, data := range mc.Events {
+			ev, s, err := binary.UnmarshalEvent(data)
+			if err != nil {
+				return nil, WithCode(
+					xerrors.Errorf("events[%v]: %w", i, err), codes.InvalidArgument)
+			}
+			rev, ok := ev.(*binary.RegularEvent)
+			if !ok {
+				return nil, WithCode(
+					xerrors.Errorf("events[%v]: not a regular event: %v", i, ev.Type()), codes.InvalidArgument)
+			}
+			if i == 0 {
+				seq = s - 1
+			}
+			if i < len(mc.EventTimestamps) {
+				rev = binary.NewRegularEventAt(rev.Type(), rev.Payload(), mc.EventTimestamps[i])
+			}
+			evs = append(evs, rev)
+		}
+		c.evbuf.Restore(seq, evs)
+		c.restoredSeq = seq + len(evs)
+	}
+
+	c.start()
+	return c, nil
+}
+
+func initClient(info *pb.ClientInfo, macKey string, room IRoom, isPlayer bool) (*Client, ErrorWithCode) {
 	props, iProps, err := common.InitProps(info.Props)
 	if err != nil {
 		return nil, WithCode(
@@ -73,18 +148,23 @@ func newClient(info *pb.ClientInfo, macKey string, room IRoom, isPlayer bool) (*
 		isPlayer:   isPlayer,
 		nodeCount:  1,
 
-		props: props,
+		props:        props,
+		propVersions: make(map[string]uint64),
 
 		removed:     make(chan struct{}),
 		done:        make(chan struct{}),
 		newDeadline: make(chan time.Duration, 1),
 
-		evbuf: common.NewRingBuf[*binary.RegularEvent](room.ClientConf().EventBufSize),
+		evbuf:    common.NewRingBuf[*binary.RegularEvent](room.ClientConf().EventBufSize),
+		volatile: newVolatileQueue(),
 
 		waitPeer:  make(chan *Peer, 1),
 		renewPeer: make(chan struct{}, 1),
 
+		limiter: newRateLimiter(room.MsgRateLimits(), time.Now()),
+
 		authKey: RandomHex(room.ClientConf().AuthKeyLen),
+		macKey:  macKey,
 		hmac:    hmac.New(sha1.New, []byte(macKey)),
 
 		logger: room.Logger().With(log.KeyClient, info.Id),
@@ -95,12 +175,14 @@ func newClient(info *pb.ClientInfo, macKey string, room IRoom, isPlayer bool) (*
 		c.nodeCount = 0
 	}
 
-	room.WaitGroup().Add(1)
+	return c, nil
+}
+
+func (c *Client) start() {
+	c.room.WaitGroup().Add(1)
 
-	go c.MsgLoop(room.Deadline())
+	go c.MsgLoop(c.room.Deadline())
 	go c.EventLoop()
-
-	return c, nil
 }
 
 func (c *Client) ID() ClientID {
@@ -115,6 +197,10 @@ func (c *Client) AuthKey() string {
 	return c.authKey
 }
 
+func (c *Client) IsPlayer() bool {
+	return c.isPlayer
+}
+
 func (c *Client) NodeCount() uint32 {
 	return c.nodeCount
 }
@@ -149,7 +235,13 @@ loop:
 
 		case <-c.room.Done():
 			c.logger.Debugf("client room done: %v", c.Id)
-			curPeer.Close("room closed")
+			c.mu.RLock()
+			migrated := c.migrateURL != ""
+			c.mu.RUnlock()
+			if !migrated {
+				// 移動した場合は Migrated() で移動先を通知して閉じている
+				curPeer.Close("room closed")
+			}
 			if !t.Stop() {
 				<-t.C
 			}
@@ -232,6 +324,27 @@ loop:
 					continue
 				}
 			}
+			if err := c.room.PayloadLimits().CheckMsg(m); err != nil {
+				c.logger.Warnf("client msg: %v %v: %v", c.Id, m.Type(), err)
+				if regmsg, ok := m.(binary.RegularMsg); ok {
+					if err := c.Send(binary.NewEvInvalidPayload(regmsg)); err != nil {
+						c.room.SendMessage(
+							&MsgClientError{
+								Sender: c,
+								ErrMsg: err.Error(),
+							})
+						break loop
+					}
+				}
+				continue
+			}
+			if c.limiter != nil && !c.limiter.allow(m.Type(), time.Now()) {
+				metrics.MessageThrottled.Add(1)
+				if !c.throttled(m) {
+					break loop
+				}
+				continue
+			}
 			if !t.Stop() {
 				<-t.C
 			}
@@ -259,6 +372,32 @@ loop:
 	c.room.WaitGroup().Done()
 }
 
+// throttled : 送信レート制限を超えたメッセージの処理.
+// Clientを退室させるときfalseを返す.
+func (c *Client) throttled(m binary.Msg) bool {
+	switch c.limiter.policy {
+	case RateLimitWarn:
+		c.logger.Warnf("rate limit exceeded: %v %v", c.Id, m.Type())
+		if regmsg, ok := m.(binary.RegularMsg); ok {
+			if err := c.Send(binary.NewEvRateLimited(regmsg)); err != nil {
+				c.room.SendMessage(
+					&MsgClientError{
+						Sender: c,
+						ErrMsg: err.Error(),
+					})
+				return false
+			}
+		}
+	case RateLimitKick:
+		c.logger.Warnf("rate limit exceeded: kick %v %v", c.Id, m.Type())
+		c.room.SendMessage(&MsgRateLimitExceeded{Sender: c})
+		return false
+	default:
+		c.logger.Debugf("rate limit exceeded: drop %v %v", c.Id, m.Type())
+	}
+	return true
+}
+
 func (c *Client) drainMsg(msgCh <-chan binary.Msg) {
 	if msgCh == nil {
 		return
@@ -294,9 +433,49 @@ func (c *Client) SendSystemEvent(e *binary.SystemEvent) {
 		return
 	}
 
-	// SystemEventは送信順序を問わない. 多少遅れても構わない.
-	// roomのmsgloopを止めないために新しいgoroutineで送信する.
-	go p.SendSystemEvent(e)
+	// 送信キューに積むだけなのでroomのmsgloopは止まらない.
+	p.SendSystemEvent(e)
+}
+
+// snapshot : 部屋の移動先に送るClientの状態.
+// RoomのMsgLoopから呼ばれる.
+func (c *Client) snapshot() *pb.MigratedClient {
+	c.mu.RLock()
+	msgSeq := c.msgSeqNum
+	c.mu.RUnlock()
+
+	seq, evs := c.evbuf.Snapshot()
+	events := make([][]byte, len(evs))
+	timestamps := make([]uint64, len(evs))
+	for i, ev := range evs {
+		events[i] = ev.Marshal(seq + i + 1)
+		timestamps[i] = ev.Timestamp()
+	}
+
+	return &pb.MigratedClient{
+		Info:      c.ClientInfo.Clone(),
+		MacKey:    c.macKey,
+		AuthKey:   c.authKey,
+		MsgSeqNum: uint32(msgSeq),
+		NodeCount: c.nodeCount,
+		Events:    events,
+
+		PropVersions:    c.propVersions,
+		EventTimestamps: timestamps,
+	}
+}
+
+// Migrated : 部屋の移動先を通知してpeerを閉じる.
+// 以降の再接続にも移動先を通知する.
+// RoomのMsgLoopから呼ばれる.
+func (c *Client) Migrated(url string) {
+	c.mu.Lock()
+	c.migrateURL = url
+	p := c.peer
+	c.mu.Unlock()
+	if p != nil {
+		p.SendMigrated(url)
+	}
 }
 
 func (c *Client) sendRenewPeer() {
@@ -313,6 +492,21 @@ func (c *Client) AttachPeer(p *Peer, lastEvSeq int) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if c.migrateURL != "" {
+		p.SendMigrated(c.migrateURL)
+		return xerrors.Errorf("room has been migrated: %v", c.migrateURL)
+	}
+
+	if c.restored {
+		// スナップショット以降に送信済みだったEventは失われている.
+		// 復元後のEventがクライアントの受信済み番号に続くように番号を振り直す.
+		if lastEvSeq > c.restoredSeq {
+			c.logger.Infof("forward evbuf: %v %v -> %v", c.Id, c.restoredSeq, lastEvSeq)
+			c.evbuf.Forward(c.restoredSeq, lastEvSeq)
+		}
+		c.restored = false
+	}
+
 	// 未読Eventを再送. client終了後でも送信する.
 	if err := p.SendEvents(c.evbuf); err != nil {
 		return xerrors.Errorf("SendEvents: %w", err)
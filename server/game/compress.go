@@ -0,0 +1,31 @@
+package game
+
+import (
+	"compress/flate"
+
+	"golang.org/x/xerrors"
+
+	"wsnet2/config"
+)
+
+// 圧縮
+//
+// ClientConf.EnableCompression のとき、websocketのpermessage-deflateをクライアントと交渉する.
+// クライアントが対応していなければ圧縮しない.
+//
+// 圧縮は送信するメッセージ毎に切り替えられるので、ClientConf.CompressionThreshold より
+// 小さいメッセージは圧縮せずに送る. 小さなEventは圧縮しても縮まず、CPUを使うだけなので.
+
+// ParseCompressionLevel : 設定ファイルのcompression_level
+func ParseCompressionLevel(conf *config.ClientConf) (int, error) {
+	if conf.CompressionLevel < flate.HuffmanOnly || conf.CompressionLevel > flate.BestCompression {
+		return 0, xerrors.Errorf("invalid compression_level: %v", conf.CompressionLevel)
+	}
+	return conf.CompressionLevel, nil
+}
+
+// compress : 次に書き込むメッセージを圧縮するか決める.
+// writeLoopのgoroutineから呼び出す.
+func (p *Peer) compress(size int) {
+	p.conn.EnableWriteCompression(size >= p.compressThreshold)
+}
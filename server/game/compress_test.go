@@ -0,0 +1,24 @@
+package game
+
+import (
+	"testing"
+
+	"wsnet2/config"
+)
+
+func TestParseCompressionLevel(t *testing.T) {
+	for _, level := range []int{-2, -1, 0, 1, 6, 9} {
+		got, err := ParseCompressionLevel(&config.ClientConf{CompressionLevel: level})
+		if err != nil {
+			t.Fatalf("ParseCompressionLevel(%v): %+v", level, err)
+		}
+		if got != level {
+			t.Errorf("ParseCompressionLevel(%v) = %v", level, got)
+		}
+	}
+	for _, level := range []int{-3, 10} {
+		if _, err := ParseCompressionLevel(&config.ClientConf{CompressionLevel: level}); err == nil {
+			t.Errorf("ParseCompressionLevel(%v) must fail", level)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package game
+
+import (
+	"time"
+
+	"wsnet2/binary"
+)
+
+// Watcherへの遅延配信
+//
+// RoomOption.WatcherDelay が指定された部屋では、Watcher（Hub経由の観戦を含む）への
+// RegularEventを指定時間遅らせて送る. Playerにはすぐに送る.
+// 観戦者が通話などでPlayerに情報を流す（ゴースティング）のを防ぐ.
+//
+// 遅延中のEventは受信時刻順のキューに保持し、delayFlushInterval 毎に期限が来たものを送る.
+// Watcher自身が送ったMsgへの応答（EvSucceeded等）は遅延しない.
+//
+// 入室時の部屋情報 (JoinedInfo) は現在の状態なので、遅延中のEventのうち入室前のものは送らない.
+// 続けて送るキャッシュやオブジェクト、ロックステップの入力 (HubへのEvCachedを含む) は遅延キューに入れ、
+// 入室後のEventより先に WatcherDelay 後に届ける. 観戦し直しても最新の状態を先に知ることはできない.
+// 部屋の移動では遅延中のEventを引き継がない.
+
+const (
+	// delayFlushInterval : 遅延中のEventを送るタイミングを検査する間隔
+	delayFlushInterval = 100 * time.Millisecond
+)
+
+// delayQueue : Watcher向けのEventを時刻順に保持する.
+// muClients のロックを取得してから使う.
+type delayQueue struct {
+	delay  time.Duration
+	events []*delayedEvent
+	seq    uint64
+
+	// since : このseqより前のEventを送らないWatcher (Playerだったときにすぐ受け取っている)
+	since map[ClientID]uint64
+}
+
+type delayedEvent struct {
+	at  time.Time
+	seq uint64
+	ev  *binary.RegularEvent
+	to  ClientID // 空文字列なら全Watcher
+}
+
+func newDelayQueue() *delayQueue {
+	return &delayQueue{
+		since: make(map[ClientID]uint64),
+	}
+}
+
+func (q *delayQueue) enabled() bool {
+	return q.delay > 0
+}
+
+// push : Eventをキューに追加する. to が空なら全Watcher宛て.
+func (q *delayQueue) push(ev *binary.RegularEvent, to ClientID, now time.Time) {
+	q.seq++
+	q.events = append(q.events, &delayedEvent{
+		at:  now.Add(q.delay),
+		seq: q.seq,
+		ev:  ev,
+		to:  to,
+	})
+}
+
+// pop : 送信時刻を過ぎたEventを取り出す.
+func (q *delayQueue) pop(now time.Time) []*delayedEvent {
+	// キュー内のEventが全て送られるWatcherの記録は不要
+	head := q.seq + 1
+	if len(q.events) > 0 {
+		head = q.events[0].seq
+	}
+	for id, s := range q.since {
+		if s <= head {
+			delete(q.since, id)
+		}
+	}
+
+	n := 0
+	for n < len(q.events) && !now.Before(q.events[n].at) {
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+	evs := q.events[:n:n]
+	q.events = q.events[n:]
+	return evs
+}
+
+// deliverable : e を id のWatcherに送るか
+func (q *delayQueue) deliverable(e *delayedEvent, id ClientID) bool {
+	return (e.to == "" || e.to == id) && e.seq >= q.since[id]
+}
+
+// skipPending : キュー内のEventを id に送らないようにする.
+// PlayerからWatcherになったクライアントは既にそれらを受け取っている.
+// 新しく入室したWatcherは入室時の部屋情報に反映済み.
+func (q *delayQueue) skipPending(id ClientID) {
+	if len(q.events) > 0 {
+		q.since[id] = q.seq + 1
+	}
+}
+
+// pending : キュー内の id 宛てのEvent.
+// WatcherからPlayerになったクライアントに、遅延中のEventを先に送るために使う.
+func (q *delayQueue) pending(id ClientID) []*binary.RegularEvent {
+	var evs []*binary.RegularEvent
+	for _, e := range q.events {
+		if q.deliverable(e, id) {
+			evs = append(evs, e.ev)
+		}
+	}
+	return evs
+}
+
+// watcherDelayTicker : 遅延中のEventを送るタイミング. 無効ならnil.
+func (r *Room) watcherDelayTicker() (<-chan time.Time, func()) {
+	if !r.delayed.enabled() {
+		return nil, func() {}
+	}
+	t := time.NewTicker(delayFlushInterval)
+	return t.C, t.Stop
+}
+
+// sendToWatchers : Watcherに送信. 遅延配信が有効ならキューに入れる.
+// to が空なら全Watcher宛て.
+// muClients のロックを取得してから呼び出す.
+func (r *Room) sendToWatchers(ev *binary.RegularEvent, to ClientID) {
+	if r.delayed.enabled() {
+		r.delayed.push(ev, to, time.Now())
+		return
+	}
+	if to != "" {
+		if c, ok := r.watchers[to]; ok {
+			r.sendTo(c, ev)
+		}
+		return
+	}
+	for _, c := range r.watchers {
+		r.sendTo(c, ev)
+	}
+}
+
+// sendJoinSnapshot : 入室したクライアントに入室時点の状態（キャッシュやオブジェクトなど）を送る.
+// Watcherには遅延配信が有効なら WatcherDelay 後に届く.
+// muClients のロックを取得してから呼び出す.
+func (r *Room) sendJoinSnapshot(c *Client, ev *binary.RegularEvent) {
+	if c.isPlayer {
+		r.sendTo(c, ev)
+		return
+	}
+	r.sendToWatchers(ev, c.ID())
+}
+
+// flushDelayed : 送信時刻を過ぎたEventをWatcherに送る.
+func (r *Room) flushDelayed(now time.Time) {
+	r.muClients.RLock()
+	defer r.muClients.RUnlock()
+
+	for _, e := range r.delayed.pop(now) {
+		if e.to != "" {
+			if c, ok := r.watchers[e.to]; ok && r.delayed.deliverable(e, c.ID()) {
+				r.sendTo(c, e.ev)
+			}
+			continue
+		}
+		for id, c := range r.watchers {
+			if r.delayed.deliverable(e, id) {
+				r.sendTo(c, e.ev)
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package game
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"wsnet2/binary"
+	"wsnet2/pb"
+)
+
+func TestDelayQueue(t *testing.T) {
+	q := newDelayQueue()
+	q.delay = time.Second
+	now := time.Now()
+	ev := func(i int) *binary.RegularEvent {
+		return binary.NewEvMessage("p1", binary.MarshalInt(i))
+	}
+
+	q.push(ev(1), "", now)
+	q.push(ev(2), "w1", now.Add(100*time.Millisecond))
+	q.skipPending("w2")
+	q.push(ev(3), "", now.Add(500*time.Millisecond))
+
+	if n := len(q.pending("w1")); n != 3 {
+		t.Errorf("pending(w1) = %v events, wants 3", n)
+	}
+	if n := len(q.pending("w3")); n != 2 {
+		t.Errorf("pending(w3) = %v events, wants 2", n)
+	}
+
+	if evs := q.pop(now.Add(900 * time.Millisecond)); len(evs) != 0 {
+		t.Fatalf("popped %v events before delay", len(evs))
+	}
+	evs := q.pop(now.Add(1100 * time.Millisecond))
+	if len(evs) != 2 || evs[0].seq != 1 || evs[1].seq != 2 {
+		t.Fatalf("popped events: %v, wants seq 1 and 2", evs)
+	}
+	if q.deliverable(evs[0], "w2") {
+		t.Errorf("event before skipPending is deliverable to w2")
+	}
+	if q.deliverable(evs[1], "w3") {
+		t.Errorf("event to w1 is deliverable to w3")
+	}
+
+	evs = q.pop(now.Add(2 * time.Second))
+	if len(evs) != 1 || !q.deliverable(evs[0], "w2") {
+		t.Fatalf("popped events: %v, wants seq 3 deliverable to w2", evs)
+	}
+	if len(q.events) != 0 || len(q.since) != 0 {
+		t.Errorf("queue remains: events=%v, since=%v", q.events, q.since)
+	}
+}
+
+func TestWatchDelayed(t *testing.T) {
+	r, master := newTestRoom(t, &pb.RoomOption{WatcherDelay: 1}, nil)
+	if _, err := joinRoom(r, "p1"); err != nil {
+		t.Fatalf("join p1: %v", err)
+	}
+	r.msgCh <- &MsgCachedBroadcast{
+		RegularMsg: binary.NewRegularMsg(binary.MsgTypeCachedBroadcast, 1, nil),
+		Sender:     master,
+		Key:        "k",
+		Data:       []byte("cached"),
+	}
+	r.msgCh <- broadcastMsg(master, 2, []byte("before"))
+
+	joined, err := watchRoom(r, "w1")
+	if err != nil {
+		t.Fatalf("watch w1: %v", err)
+	}
+	w1 := joined.Client
+	r.msgCh <- broadcastMsg(master, 3, []byte("after"))
+
+	messages := func() []string {
+		var msgs []string
+		for _, ev := range received(t, w1) {
+			if ev.Type() != binary.EvTypeMessage {
+				t.Fatalf("unexpected event: %v", ev.Type())
+			}
+			_, body, err := binary.UnmarshalEvMessage(ev.Payload())
+			if err != nil {
+				t.Fatalf("UnmarshalEvMessage: %v", err)
+			}
+			msgs = append(msgs, string(body))
+		}
+		return msgs
+	}
+
+	// 入室時点のキャッシュも入室後のEventと同様に遅延する
+	getRoomInfo(r)
+	if got := messages(); len(got) != 0 {
+		t.Fatalf("messages before delay = %v, wants none", got)
+	}
+
+	// キャッシュが先に届き、入室前に遅延中だったEventは届かない
+	time.Sleep(1200 * time.Millisecond)
+	getRoomInfo(r)
+	if got, want := messages(), []string{"cached", "after"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("messages = %v, wants %v", got, want)
+	}
+}
@@ -0,0 +1,128 @@
+package game
+
+import (
+	"sync"
+
+	"wsnet2/binary"
+	"wsnet2/pb"
+)
+
+// RoomHandler : サーバサイドの部屋ロジック
+//
+// AppIdごとに RegisterRoomHandler で登録する.
+// 各メソッドは部屋のMsgLoopから muClients のロックを取得した状態で呼ばれるので、
+// Roomへの操作は SendEvent/BroadcastEvent などハンドラ用のメソッドを使うこと.
+// 登録されていないAppの部屋の動作は変わらない.
+type RoomHandler interface {
+	// OnCreate : 部屋が作成され、masterが入室した直後に呼ばれる
+	OnCreate(room *Room, master *Client)
+
+	// OnJoin : 入室(観戦を含む)の前に呼ばれる.
+	// errorを返すと入室を拒否する. ErrorWithCode ならそのコードをクライアントに返す.
+	// ただしPermissionDeniedはBanによる拒否を表すので、FailedPreconditionとして返す.
+	OnJoin(room *Room, info *pb.ClientInfo, isPlayer bool) error
+
+	// OnJoined : 入室(観戦・再入室を含む)した直後に呼ばれる
+	OnJoined(room *Room, client *Client, rejoin bool)
+
+	// OnLeave : Player/Watcherが退室した直後に呼ばれる.
+	// 最後のPlayerの退室時は部屋が閉じる前に呼ばれる.
+	OnLeave(room *Room, client *Client, cause string)
+
+	// OnRoomProp : 部屋情報の変更を適用する前に呼ばれる.
+	// msgの内容を書き換えることができる. errorを返すと変更を拒否する.
+	OnRoomProp(room *Room, msg *MsgRoomProp) error
+
+	// OnClientProp : クライアントのプロパティ変更を適用する前に呼ばれる.
+	// msgの内容を書き換えることができる. errorを返すと変更を拒否する.
+	OnClientProp(room *Room, msg *MsgClientProp) error
+
+	// OnMessage : MsgBroadcast/MsgCachedBroadcast/MsgTargets/MsgToMasterを配送する前に呼ばれる.
+	// msgのData(MsgTargetsではTargetsも)を書き換えることができる.
+	OnMessage(room *Room, msg Msg) HandlerResult
+}
+
+// HandlerResult : RoomHandler.OnMessage の処理結果
+type HandlerResult int
+
+const (
+	// HandlerPass : (書き換えた)メッセージをそのまま配送する
+	HandlerPass HandlerResult = iota
+	// HandlerDrop : 配送せずに破棄する
+	HandlerDrop
+	// HandlerDeny : 配送せずに送信者にEvPermissionDeniedを返す
+	HandlerDeny
+)
+
+// BaseRoomHandler : 何もしないRoomHandler.
+// 埋め込むことで必要なメソッドだけ実装できる.
+type BaseRoomHandler struct{}
+
+var _ RoomHandler = BaseRoomHandler{}
+
+func (BaseRoomHandler) OnCreate(*Room, *Client)                  {}
+func (BaseRoomHandler) OnJoin(*Room, *pb.ClientInfo, bool) error { return nil }
+func (BaseRoomHandler) OnJoined(*Room, *Client, bool)            {}
+func (BaseRoomHandler) OnLeave(*Room, *Client, string)           {}
+func (BaseRoomHandler) OnRoomProp(*Room, *MsgRoomProp) error     { return nil }
+func (BaseRoomHandler) OnClientProp(*Room, *MsgClientProp) error { return nil }
+func (BaseRoomHandler) OnMessage(*Room, Msg) HandlerResult       { return HandlerPass }
+
+var (
+	muHandlers   sync.RWMutex
+	roomHandlers = make(map[pb.AppId]RoomHandler)
+)
+
+// RegisterRoomHandler : appIdの部屋で使うRoomHandlerを登録する.
+// NewRepos より前に呼び出すこと.
+func RegisterRoomHandler(appId pb.AppId, h RoomHandler) {
+	muHandlers.Lock()
+	defer muHandlers.Unlock()
+	if h == nil {
+		delete(roomHandlers, appId)
+		return
+	}
+	roomHandlers[appId] = h
+}
+
+func getRoomHandler(appId pb.AppId) RoomHandler {
+	muHandlers.RLock()
+	defer muHandlers.RUnlock()
+	return roomHandlers[appId]
+}
+
+// ハンドラ用のRoom操作.
+// RoomHandlerのメソッド内から呼び出すこと.
+
+// Master : 現在のMasterクライアント. 無人の部屋ではnil
+func (r *Room) Master() *Client {
+	return r.master
+}
+
+// Player : 入室中のPlayer. いなければnil.
+func (r *Room) Player(id ClientID) *Client {
+	return r.players[id]
+}
+
+// PlayerIDs : 入室中のPlayerのID（Master交代順）
+func (r *Room) PlayerIDs() []ClientID {
+	ids := make([]ClientID, len(r.masterOrder))
+	copy(ids, r.masterOrder)
+	return ids
+}
+
+// SendEvent : 特定クライアントにEventを送信する
+func (r *Room) SendEvent(c *Client, ev *binary.RegularEvent) {
+	r.sendTo(c, ev)
+}
+
+// BroadcastEvent : 全員にEventを送信する
+func (r *Room) BroadcastEvent(ev *binary.RegularEvent) {
+	r.broadcast(ev)
+}
+
+// NewServerMessage : サーバから送信するEvMessage.
+// 送信者のClientIDは空文字列になる.
+func NewServerMessage(data []byte) *binary.RegularEvent {
+	return binary.NewEvMessage(string(adminClientID), data)
+}
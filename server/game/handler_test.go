@@ -0,0 +1,141 @@
+package game
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+
+	"wsnet2/binary"
+	"wsnet2/pb"
+)
+
+// fakeHandler : 呼ばれた順番を記録するRoomHandler
+type fakeHandler struct {
+	BaseRoomHandler
+
+	mu       sync.Mutex
+	calls    []string
+	unlocked []string
+}
+
+func (h *fakeHandler) record(r *Room, call string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.calls = append(h.calls, call)
+	// ハンドラは muClients のロックを取得した状態で呼ばれる
+	if r.muClients.TryLock() {
+		r.muClients.Unlock()
+		h.unlocked = append(h.unlocked, call)
+	}
+}
+
+func (h *fakeHandler) result() ([]string, []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.calls...), append([]string(nil), h.unlocked...)
+}
+
+func (h *fakeHandler) OnCreate(r *Room, master *Client) {
+	h.record(r, "create:"+master.Id)
+}
+
+func (h *fakeHandler) OnJoin(r *Room, info *pb.ClientInfo, isPlayer bool) error {
+	h.record(r, "join:"+info.Id)
+	switch info.Id {
+	case "rejected":
+		return errors.New("rejected by handler")
+	case "denied":
+		return WithCode(errors.New("denied by handler"), codes.PermissionDenied)
+	}
+	return nil
+}
+
+func (h *fakeHandler) OnJoined(r *Room, c *Client, rejoin bool) {
+	h.record(r, "joined:"+c.Id)
+}
+
+func (h *fakeHandler) OnLeave(r *Room, c *Client, cause string) {
+	h.record(r, "leave:"+c.Id)
+}
+
+func (h *fakeHandler) OnMessage(r *Room, msg Msg) HandlerResult {
+	m := msg.(*MsgBroadcast)
+	h.record(r, "message:"+string(m.Data))
+	switch string(m.Data) {
+	case "drop":
+		return HandlerDrop
+	case "deny":
+		return HandlerDeny
+	}
+	m.Data = append([]byte("handled:"), m.Data...)
+	return HandlerPass
+}
+
+func TestRoomHandler(t *testing.T) {
+	h := &fakeHandler{}
+	r, master := newTestRoom(t, nil, h)
+
+	if _, err := joinRoom(r, "rejected"); err == nil || err.Code() != codes.FailedPrecondition {
+		t.Fatalf("join rejected: %v, wants FailedPrecondition", err)
+	}
+	// PermissionDeniedはBanと区別できるようFailedPreconditionになる
+	if _, err := joinRoom(r, "denied"); err == nil || err.Code() != codes.FailedPrecondition {
+		t.Fatalf("join denied: %v, wants FailedPrecondition", err)
+	}
+	joined, err := joinRoom(r, "p1")
+	if err != nil {
+		t.Fatalf("join p1: %v", err)
+	}
+	p1 := joined.Client
+
+	for i, data := range []string{"a", "drop", "deny", "b"} {
+		r.msgCh <- broadcastMsg(master, i+1, []byte(data))
+	}
+	r.msgCh <- &MsgLeave{Sender: p1, Message: "bye"}
+	getRoomInfo(r)
+
+	calls, unlocked := h.result()
+	wantCalls := []string{
+		"create:master",
+		"join:rejected",
+		"join:denied",
+		"join:p1", "joined:p1",
+		"message:a", "message:drop", "message:deny", "message:b",
+		"leave:p1",
+	}
+	if !reflect.DeepEqual(calls, wantCalls) {
+		t.Errorf("calls = %v, wants %v", calls, wantCalls)
+	}
+	if len(unlocked) > 0 {
+		t.Errorf("called without muClients lock: %v", unlocked)
+	}
+
+	// 書き換えたメッセージだけがDropやDenyを挟んだ順番どおりに届く
+	var msgs []string
+	for _, ev := range received(t, p1) {
+		if ev.Type() != binary.EvTypeMessage {
+			continue
+		}
+		_, body, err := binary.UnmarshalEvMessage(ev.Payload())
+		if err != nil {
+			t.Fatalf("UnmarshalEvMessage: %v", err)
+		}
+		msgs = append(msgs, string(body))
+	}
+	if want := []string{"handled:a", "handled:b"}; !reflect.DeepEqual(msgs, want) {
+		t.Errorf("messages = %v, wants %v", msgs, want)
+	}
+
+	denied := false
+	for _, ev := range received(t, master) {
+		if ev.Type() == binary.EvTypePermissionDenied {
+			denied = true
+		}
+	}
+	if !denied {
+		t.Errorf("master did not receive EvPermissionDenied")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 	"wsnet2/config"
 	"wsnet2/log"
+	"wsnet2/metrics"
 )
 
 type RoomID string
@@ -15,6 +16,9 @@ type IRoom interface {
 	Repo() IRepo
 
 	ClientConf() *config.ClientConf
+	MsgRateLimits() *MsgRateLimits
+	PayloadLimits() *PayloadLimits
+	PeerQueueStats() *metrics.PeerQueueStats
 
 	Deadline() time.Duration
 	WaitGroup() *sync.WaitGroup
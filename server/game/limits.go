@@ -0,0 +1,160 @@
+package game
+
+import (
+	"golang.org/x/xerrors"
+
+	"wsnet2/binary"
+	"wsnet2/config"
+	"wsnet2/pb"
+)
+
+// PayloadLimits : メッセージとプロパティの制限
+type PayloadLimits struct {
+	conf config.PayloadLimits
+
+	roomSchema   map[string]binary.Type
+	clientSchema map[string]binary.Type
+}
+
+// NewPayloadLimits : アプリの制限.
+// AppPayloadLimitsに指定がなければPayloadLimitsを使う.
+func NewPayloadLimits(conf *config.GameConf, appId pb.AppId) (*PayloadLimits, error) {
+	c, ok := conf.AppPayloadLimits[appId]
+	if !ok {
+		c = conf.PayloadLimits
+	}
+
+	roomSchema, err := parsePropSchema(c.RoomPropSchema)
+	if err != nil {
+		return nil, xerrors.Errorf("room_prop_schema: %w", err)
+	}
+	clientSchema, err := parsePropSchema(c.ClientPropSchema)
+	if err != nil {
+		return nil, xerrors.Errorf("client_prop_schema: %w", err)
+	}
+
+	return &PayloadLimits{
+		conf:         c,
+		roomSchema:   roomSchema,
+		clientSchema: clientSchema,
+	}, nil
+}
+
+func parsePropSchema(schema map[string]string) (map[string]binary.Type, error) {
+	if len(schema) == 0 {
+		return nil, nil
+	}
+	types := make(map[string]binary.Type, len(schema))
+	for key, name := range schema {
+		t, ok := typeByName(name)
+		if !ok {
+			return nil, xerrors.Errorf("unknown type: %v=%q", key, name)
+		}
+		types[key] = t
+	}
+	return types, nil
+}
+
+func typeByName(name string) (binary.Type, bool) {
+	for t := binary.TypeNull; t <= binary.TypeDecimals; t++ {
+		if t.String() == name {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+// CheckMsg : クライアントから受け取ったメッセージの大きさを検査する
+func (l *PayloadLimits) CheckMsg(m binary.Msg) error {
+	if l == nil || l.conf.MaxMsgSize == 0 {
+		return nil
+	}
+	if len(m.Payload()) > l.conf.MaxMsgSize {
+		return xerrors.Errorf("msg too large: %v > %v", len(m.Payload()), l.conf.MaxMsgSize)
+	}
+	return nil
+}
+
+// checkRoomProps : 部屋のプロパティを検査する.
+// mod は変更されたキーのみで、スキーマはこれだけを検査する.
+func (l *PayloadLimits) checkRoomProps(public, modPublic, private, modPrivate binary.Dict) error {
+	if l == nil {
+		return nil
+	}
+	if err := l.checkProps(public, modPublic, l.conf.MaxPublicPropSize, l.roomSchema); err != nil {
+		return xerrors.Errorf("public props: %w", err)
+	}
+	if err := l.checkProps(private, modPrivate, l.conf.MaxPrivatePropSize, l.roomSchema); err != nil {
+		return xerrors.Errorf("private props: %w", err)
+	}
+	return nil
+}
+
+// checkClientProps : クライアントのプロパティを検査する.
+func (l *PayloadLimits) checkClientProps(props, mod binary.Dict) error {
+	if l == nil {
+		return nil
+	}
+	return l.checkProps(props, mod, l.conf.MaxClientPropSize, l.clientSchema)
+}
+
+func (l *PayloadLimits) checkProps(props, mod binary.Dict, maxSize int, schema map[string]binary.Type) error {
+	if l.conf.MaxPropKeys > 0 && len(props) > l.conf.MaxPropKeys {
+		return xerrors.Errorf("too many keys: %v > %v", len(props), l.conf.MaxPropKeys)
+	}
+	if maxSize > 0 {
+		if size := len(binary.MarshalDict(props)); size > maxSize {
+			return xerrors.Errorf("too large: %v > %v", size, maxSize)
+		}
+	}
+	if schema == nil {
+		return nil
+	}
+	for k, v := range mod {
+		if len(v) == 0 {
+			// 削除
+			continue
+		}
+		t, ok := schema[k]
+		if !ok {
+			if l.conf.StrictSchema {
+				return xerrors.Errorf("unknown key: %q", k)
+			}
+			continue
+		}
+		if !sameType(t, binary.Type(v[0])) {
+			return xerrors.Errorf("type mismatch: %q is %v, wants %v", k, binary.Type(v[0]), t)
+		}
+	}
+	return nil
+}
+
+// sameType : 文字列の長さで変わる型は同じものとして扱う. nullはどの型にも一致する
+func sameType(want, got binary.Type) bool {
+	if got == binary.TypeNull {
+		return true
+	}
+	switch want {
+	case binary.TypeStr8, binary.TypeStr16:
+		return got == binary.TypeStr8 || got == binary.TypeStr16
+	case binary.TypeFalse, binary.TypeTrue:
+		return got == binary.TypeFalse || got == binary.TypeTrue
+	}
+	return want == got
+}
+
+// mergeProps : 変更を適用したプロパティ. 元のDictは変更しない.
+func mergeProps(props, mod binary.Dict) binary.Dict {
+	merged := make(binary.Dict, len(props)+len(mod))
+	for k, v := range props {
+		merged[k] = v
+	}
+	for k, v := range mod {
+		if len(v) == 0 {
+			delete(merged, k)
+		} else {
+			merged[k] = v
+		}
+	}
+	return merged
+}
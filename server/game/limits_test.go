@@ -0,0 +1,81 @@
+package game
+
+import (
+	"hash"
+	"testing"
+
+	"wsnet2/binary"
+	"wsnet2/config"
+)
+
+type testMsg struct {
+	mtype   binary.MsgType
+	payload []byte
+}
+
+func (m *testMsg) Type() binary.MsgType          { return m.mtype }
+func (m *testMsg) Payload() []byte               { return m.payload }
+func (m *testMsg) Marshal(hmac hash.Hash) []byte { return nil }
+
+func TestPayloadLimits(t *testing.T) {
+	conf := &config.GameConf{
+		PayloadLimits: config.PayloadLimits{
+			MaxPropKeys:       2,
+			MaxPublicPropSize: 32,
+			RoomPropSchema:    map[string]string{"name": "Str8", "score": "Int"},
+		},
+		AppPayloadLimits: map[string]config.PayloadLimits{
+			"strict": {
+				MaxMsgSize:     4,
+				RoomPropSchema: map[string]string{"name": "Str8"},
+				StrictSchema:   true,
+			},
+		},
+	}
+
+	l, err := NewPayloadLimits(conf, "testapp")
+	if err != nil {
+		t.Fatalf("NewPayloadLimits: %+v", err)
+	}
+
+	name := binary.MarshalStr16("alice")
+	score := binary.MarshalInt(100)
+	props := binary.Dict{"name": name, "score": score}
+	if err := l.checkRoomProps(props, props, nil, nil); err != nil {
+		t.Fatalf("checkRoomProps: %+v", err)
+	}
+
+	mod := binary.Dict{"score": binary.MarshalStr8("100")}
+	if err := l.checkRoomProps(mergeProps(props, mod), mod, nil, nil); err == nil {
+		t.Fatalf("checkRoomProps must fail for type mismatch")
+	}
+	mod = binary.Dict{"other": binary.MarshalInt(1)}
+	if err := l.checkRoomProps(mergeProps(props, mod), mod, nil, nil); err == nil {
+		t.Fatalf("checkRoomProps must fail for too many keys")
+	}
+	mod = binary.Dict{"score": {}, "other": binary.MarshalInt(1)}
+	if err := l.checkRoomProps(mergeProps(props, mod), mod, nil, nil); err != nil {
+		t.Fatalf("checkRoomProps: %+v", err)
+	}
+	mod = binary.Dict{"name": binary.MarshalStr8("a name which is too large for the limit")}
+	if err := l.checkRoomProps(mergeProps(props, mod), mod, nil, nil); err == nil {
+		t.Fatalf("checkRoomProps must fail for too large props")
+	}
+
+	l, err = NewPayloadLimits(conf, "strict")
+	if err != nil {
+		t.Fatalf("NewPayloadLimits: %+v", err)
+	}
+	mod = binary.Dict{"score": binary.MarshalInt(1)}
+	if err := l.checkRoomProps(mod, mod, nil, nil); err == nil {
+		t.Fatalf("checkRoomProps must fail for unknown key")
+	}
+	if err := l.CheckMsg(&testMsg{binary.MsgTypeBroadcast, []byte{1, 2, 3, 4, 5}}); err == nil {
+		t.Fatalf("CheckMsg must fail for too large msg")
+	}
+
+	conf.PayloadLimits.ClientPropSchema = map[string]string{"name": "String"}
+	if _, err := NewPayloadLimits(conf, "testapp"); err == nil {
+		t.Fatalf("NewPayloadLimits must fail for unknown type")
+	}
+}
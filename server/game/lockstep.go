@@ -0,0 +1,235 @@
+package game
+
+import (
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"wsnet2/binary"
+)
+
+// ロックステップ
+//
+// RoomOption.LockstepTickRate が指定された部屋では、サーバが一定間隔（tick）でフレームを進める.
+// Playerは MsgLockstepInput でフレーム番号を指定して入力を送り、サーバはフレーム毎に全Playerの入力を
+// まとめた EvLockstepFrame を全員に送る.
+//
+// フレームNは N+LockstepInputDelay+1 回目のtickで締め切る. 締め切りまでに入力が届かなかった
+// PlayerはNullで埋める. 締め切ったフレームへの入力や先のフレームへの入力は EvRejected を返す.
+// Playerがいない間はフレームを進めない.
+//
+// 締め切ったフレームは lockstepHistoryDuration 分保持し、途中入室したクライアントに
+// EvLockstepHistory でまとめて送る. それより古いフレームが必要なら、アプリ側で状態を同期すること.
+// 部屋の移動ではtick数と保持しているフレームを引き継ぐ. 締め切り前の入力は引き継がない.
+
+const (
+	// lockstepMaxTickRate : 1秒あたりのフレーム数の上限
+	lockstepMaxTickRate = 120
+
+	// lockstepMaxLead : 現在のtickからこのフレーム数先までの入力を受け付ける
+	lockstepMaxLead = 60
+
+	// lockstepHistoryDuration : 途中入室者向けに保持するフレームの時間
+	lockstepHistoryDuration = 10 * time.Second
+
+	// lockstepHistoryChunk : EvLockstepHistory 1つに入れるフレーム数
+	lockstepHistoryChunk = 64
+)
+
+// lockstep : ロックステップの状態.
+// muClients のロックを取得してから使う.
+type lockstep struct {
+	rate     uint32
+	interval time.Duration
+	delay    uint32
+
+	// tick : 経過したtick数
+	tick uint32
+
+	// inputs : 締め切り前のフレームの入力 (frame => client id => input)
+	inputs map[uint32]binary.Dict
+
+	// history : 締め切ったフレーム. 最後の要素が最後に締め切ったフレーム
+	history     []binary.Dict
+	historySize int
+}
+
+func newLockstep() *lockstep {
+	return &lockstep{
+		inputs: make(map[uint32]binary.Dict),
+	}
+}
+
+// configure : tick rateとinput delayを設定する. tickRateが0なら無効.
+func (ls *lockstep) configure(tickRate, delay uint32) error {
+	if tickRate > lockstepMaxTickRate {
+		return xerrors.Errorf("tick rate too high: %v (max=%v)", tickRate, lockstepMaxTickRate)
+	}
+	if delay > lockstepMaxLead {
+		return xerrors.Errorf("input delay too long: %v (max=%v)", delay, lockstepMaxLead)
+	}
+	if tickRate == 0 {
+		return nil
+	}
+	ls.rate = tickRate
+	ls.interval = time.Second / time.Duration(tickRate)
+	ls.delay = delay
+	ls.historySize = int(lockstepHistoryDuration / ls.interval)
+	return nil
+}
+
+func (ls *lockstep) enabled() bool {
+	return ls.interval > 0
+}
+
+// next : 次に締め切るフレーム
+func (ls *lockstep) next() uint32 {
+	if ls.tick < ls.delay {
+		return 0
+	}
+	return ls.tick - ls.delay
+}
+
+// input : フレームの入力を記録する. 受け付けられないフレームならエラー.
+func (ls *lockstep) input(frame uint32, cid ClientID, input []byte) error {
+	if frame < ls.next() {
+		return xerrors.Errorf("frame %v is already closed (next=%v)", frame, ls.next())
+	}
+	if frame > ls.tick+lockstepMaxLead {
+		return xerrors.Errorf("frame %v is too far ahead (tick=%v)", frame, ls.tick)
+	}
+	if len(input) == 0 {
+		input = binary.MarshalNull()
+	}
+	inputs, ok := ls.inputs[frame]
+	if !ok {
+		inputs = make(binary.Dict)
+		ls.inputs[frame] = inputs
+	}
+	inputs[string(cid)] = input
+	return nil
+}
+
+// step : tickを進める. フレームを締め切ったら、そのフレーム番号と入力を返す.
+// 入力が届かなかったPlayerはNullで埋める.
+func (ls *lockstep) step(players []ClientID) (uint32, binary.Dict, bool) {
+	frame := ls.next()
+	ls.tick++
+	if ls.next() == frame {
+		return 0, nil, false
+	}
+
+	received := ls.inputs[frame]
+	delete(ls.inputs, frame)
+	inputs := make(binary.Dict, len(players))
+	for _, id := range players {
+		if in, ok := received[string(id)]; ok {
+			inputs[string(id)] = in
+		} else {
+			inputs[string(id)] = binary.MarshalNull()
+		}
+	}
+
+	ls.history = append(ls.history, inputs)
+	if n := len(ls.history) - ls.historySize; n > 0 {
+		ls.history = ls.history[n:]
+	}
+	return frame, inputs, true
+}
+
+// historyEvents : 保持しているフレームを lockstepHistoryChunk 毎にまとめたEvent
+func (ls *lockstep) historyEvents() []*binary.RegularEvent {
+	first := ls.next() - uint32(len(ls.history))
+	var evs []*binary.RegularEvent
+	for i := 0; i < len(ls.history); i += lockstepHistoryChunk {
+		end := i + lockstepHistoryChunk
+		if end > len(ls.history) {
+			end = len(ls.history)
+		}
+		evs = append(evs, binary.NewEvLockstepHistory(first+uint32(i), ls.history[i:end]))
+	}
+	return evs
+}
+
+// historySnapshot : 部屋の移動先に送るフレーム
+func (ls *lockstep) historySnapshot() [][]byte {
+	frames := make([][]byte, len(ls.history))
+	for i, f := range ls.history {
+		frames[i] = binary.MarshalDict(f)
+	}
+	return frames
+}
+
+// restore : 移動元のtick数とフレームを復元する
+func (ls *lockstep) restore(tick uint32, frames [][]byte) error {
+	ls.tick = tick
+	for i, b := range frames {
+		f, _, err := binary.UnmarshalNullDict(b)
+		if err != nil {
+			return xerrors.Errorf("lockstep history[%v]: %w", i, err)
+		}
+		ls.history = append(ls.history, f)
+	}
+	if uint32(len(ls.history)) > ls.next() {
+		return xerrors.Errorf("lockstep history too long: %v (next=%v)", len(ls.history), ls.next())
+	}
+	return nil
+}
+
+// lockstepTicker : フレームを進めるタイミング. 無効ならnil.
+func (r *Room) lockstepTicker() (<-chan time.Time, func()) {
+	if !r.lockstep.enabled() {
+		return nil, func() {}
+	}
+	t := time.NewTicker(r.lockstep.interval)
+	return t.C, t.Stop
+}
+
+// stepLockstep : フレームを進め、締め切ったフレームを全員に送る.
+func (r *Room) stepLockstep() {
+	r.muClients.RLock()
+	defer r.muClients.RUnlock()
+
+	if len(r.players) == 0 {
+		return
+	}
+	players := make([]ClientID, 0, len(r.players))
+	for id := range r.players {
+		players = append(players, id)
+	}
+	if frame, inputs, ok := r.lockstep.step(players); ok {
+		r.broadcast(binary.NewEvLockstepFrame(frame, inputs))
+	}
+}
+
+// sendLockstepHistory : 途中入室したクライアントに過去のフレームを送る.
+// muClients のロックを取得してから呼び出す.
+func (r *Room) sendLockstepHistory(c *Client) {
+	if !r.lockstep.enabled() {
+		return
+	}
+	for _, ev := range r.lockstep.historyEvents() {
+		r.sendJoinSnapshot(c, ev)
+	}
+}
+
+func (r *Room) msgLockstepInput(msg *MsgLockstepInput) {
+	r.muClients.RLock()
+	defer r.muClients.RUnlock()
+
+	if !msg.Sender.isPlayer || r.players[msg.SenderID()] != msg.Sender {
+		msg.Sender.logger.Warnf("lockstep input from non-player: %v", msg.Sender.Id)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+	if !r.lockstep.enabled() {
+		msg.Sender.logger.Infof("lockstep is disabled")
+		r.sendTo(msg.Sender, binary.NewEvRejected(msg))
+		return
+	}
+
+	if err := r.lockstep.input(msg.Frame, msg.SenderID(), msg.Input); err != nil {
+		msg.Sender.logger.Debugf("lockstep input rejected: %v", err)
+		r.sendTo(msg.Sender, binary.NewEvRejected(msg))
+	}
+}
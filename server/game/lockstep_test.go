@@ -0,0 +1,65 @@
+package game
+
+import (
+	"reflect"
+	"testing"
+
+	"wsnet2/binary"
+)
+
+func TestLockstep(t *testing.T) {
+	ls := newLockstep()
+	if err := ls.configure(lockstepMaxTickRate+1, 0); err == nil {
+		t.Fatalf("tick rate over max accepted")
+	}
+	if err := ls.configure(60, 2); err != nil {
+		t.Fatalf("configure: %v", err)
+	}
+	players := []ClientID{"p1", "p2"}
+
+	if err := ls.input(0, "p1", binary.MarshalByte(1)); err != nil {
+		t.Fatalf("input: %v", err)
+	}
+	if err := ls.input(lockstepMaxLead+1, "p1", nil); err == nil {
+		t.Fatalf("input too far ahead accepted")
+	}
+
+	// フレーム0は3回目のtickで締め切る
+	for i := 0; i < 2; i++ {
+		if f, in, ok := ls.step(players); ok {
+			t.Fatalf("frame closed too early: %v %v", f, in)
+		}
+	}
+	f, inputs, ok := ls.step(players)
+	wants := binary.Dict{"p1": binary.MarshalByte(1), "p2": binary.MarshalNull()}
+	if !ok || f != 0 || !reflect.DeepEqual(inputs, wants) {
+		t.Fatalf("frame: %v %v %v, wants 0 %v", f, inputs, ok, wants)
+	}
+	if err := ls.input(0, "p2", nil); err == nil {
+		t.Fatalf("input to closed frame accepted")
+	}
+
+	for i := 0; i < ls.historySize+lockstepHistoryChunk; i++ {
+		ls.step(players)
+	}
+	if len(ls.history) != ls.historySize {
+		t.Fatalf("history size: %v, wants %v", len(ls.history), ls.historySize)
+	}
+	evs := ls.historyEvents()
+	first, frames, err := binary.UnmarshalEvLockstepHistoryPayload(evs[len(evs)-1].Payload())
+	if err != nil {
+		t.Fatalf("history event: %v", err)
+	}
+	if last := first + uint32(len(frames)); last != ls.next() {
+		t.Errorf("last frame of history: %v, wants %v", last, ls.next())
+	}
+
+	restored := newLockstep()
+	restored.configure(60, 2)
+	if err := restored.restore(ls.tick, ls.historySnapshot()); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if restored.next() != ls.next() || !reflect.DeepEqual(restored.history, ls.history) {
+		t.Errorf("restored: next=%v, wants %v", restored.next(), ls.next())
+	}
+}
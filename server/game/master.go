@@ -0,0 +1,93 @@
+package game
+
+import (
+	"time"
+
+	"wsnet2/binary"
+)
+
+// Masterの自動切替え
+//
+// RoomOption.MasterByLatency が指定された部屋では、Masterが退室したとき
+// MsgPingで通知されたRTTが最小のPlayerを次のMasterにする. RTTが未計測のPlayerは後回しにする.
+// 指定がなければ入室順に選ぶ.
+//
+// RoomOption.MasterIdleTimeout が指定された部屋では、Masterからのメッセージ（Pingを含む）が
+// その時間届かなければ、他のPlayerにMasterを切替えて EvMasterSwitched を通知する.
+// アプリがバックグラウンドに回ってMasterが止まったとき、ゲームが進まなくなるのを防ぐ.
+
+// masterIdleTicker : MasterIdleTimeoutを検査するタイミング. 無効ならnil.
+func (r *Room) masterIdleTicker() (<-chan time.Time, func()) {
+	if r.masterIdleTimeout <= 0 {
+		return nil, func() {}
+	}
+	interval := r.masterIdleTimeout / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+	t := time.NewTicker(interval)
+	return t.C, t.Stop
+}
+
+// lastMsgTime : Playerの最終Msg受信時刻
+func (r *Room) lastMsgTime(cid ClientID) (time.Time, bool) {
+	d, ok := r.lastMsg[string(cid)]
+	if !ok {
+		return time.Time{}, false
+	}
+	t, _, err := binary.UnmarshalAs(d, binary.TypeULong)
+	if err != nil {
+		r.logger.Errorf("Unmarshal LastMsg[%s]: %+v", cid, err)
+		return time.Time{}, false
+	}
+	return time.UnixMilli(int64(t.(uint64))), true
+}
+
+// nextMaster : 次のMasterを選ぶ. 候補がいなければnil.
+// idle が0より大きいとき、最終Msg受信時刻がidleより古いPlayerは選ばない.
+// muClients のロックを取得してから呼び出す.
+func (r *Room) nextMaster(exclude ClientID, idle time.Duration, now time.Time) *Client {
+	var next *Client
+	for _, id := range r.masterOrder {
+		c := r.players[id]
+		if id == exclude || c == nil {
+			continue
+		}
+		if idle > 0 {
+			if t, ok := r.lastMsgTime(id); !ok || now.Sub(t) >= idle {
+				continue
+			}
+		}
+		if !r.masterByLatency {
+			return c
+		}
+		if next == nil || (c.rtt > 0 && (next.rtt == 0 || c.rtt < next.rtt)) {
+			next = c
+		}
+	}
+	return next
+}
+
+// checkMasterIdle : Masterからのメッセージが途絶えていたら切替える.
+func (r *Room) checkMasterIdle(now time.Time) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	if r.master == nil {
+		return
+	}
+	prev := r.master
+	if t, ok := r.lastMsgTime(prev.ID()); ok && now.Sub(t) < r.masterIdleTimeout {
+		return
+	}
+
+	next := r.nextMaster(prev.ID(), r.masterIdleTimeout, now)
+	if next == nil {
+		r.logger.Debugf("master %v is idle but no other active player", prev.Id)
+		return
+	}
+
+	r.master = next
+	r.logger.Infof("master switched (idle): %v -> %v", prev.Id, next.Id)
+	r.broadcast(binary.NewEvMasterSwitched(prev.Id, next.Id, binary.MasterSwitchIdle))
+}
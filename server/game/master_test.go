@@ -0,0 +1,97 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"wsnet2/binary"
+	"wsnet2/pb"
+)
+
+func TestNextMaster(t *testing.T) {
+	now := time.Now()
+	lastMsg := func(d time.Duration) []byte {
+		return binary.MarshalULong(uint64(now.Add(-d).UnixMilli()))
+	}
+	r := &Room{
+		RoomInfo: &pb.RoomInfo{Id: "room1"},
+		players: map[ClientID]*Client{
+			"p1": {ClientInfo: &pb.ClientInfo{Id: "p1"}, rtt: 50 * time.Millisecond},
+			"p2": {ClientInfo: &pb.ClientInfo{Id: "p2"}},
+			"p3": {ClientInfo: &pb.ClientInfo{Id: "p3"}, rtt: 80 * time.Millisecond},
+			"p4": {ClientInfo: &pb.ClientInfo{Id: "p4"}, rtt: 30 * time.Millisecond},
+		},
+		masterOrder: []ClientID{"p1", "p2", "p3", "p4"},
+		lastMsg: binary.Dict{
+			"p1": lastMsg(0),
+			"p2": lastMsg(time.Second),
+			"p3": lastMsg(2 * time.Second),
+			"p4": lastMsg(20 * time.Second),
+		},
+		logger: zap.NewNop().Sugar(),
+	}
+
+	tests := map[string]struct {
+		byLatency bool
+		exclude   ClientID
+		idle      time.Duration
+		want      ClientID
+	}{
+		"order":              {false, "p1", 0, "p2"},
+		"order idle":         {false, "p1", 10 * time.Second, "p2"},
+		"latency":            {true, "p1", 0, "p4"},
+		"latency idle":       {true, "p1", 10 * time.Second, "p3"},
+		"latency unmeasured": {true, "p3", 1500 * time.Millisecond, "p1"},
+	}
+	for name, test := range tests {
+		r.masterByLatency = test.byLatency
+		next := r.nextMaster(test.exclude, test.idle, now)
+		if next == nil || next.ID() != test.want {
+			t.Errorf("%v: next master = %v, wants %v", name, next, test.want)
+		}
+	}
+
+	r.masterByLatency = false
+	if next := r.nextMaster("p1", 500*time.Millisecond, now); next != nil {
+		t.Errorf("next master = %v, wants nil", next.Id)
+	}
+
+	// 他に候補がいなければ切替えない
+	r.master = r.players["p4"]
+	r.masterIdleTimeout = 500 * time.Millisecond
+	r.checkMasterIdle(now.Add(5 * time.Second))
+	if r.master.ID() != "p4" {
+		t.Errorf("master switched to %v without active player", r.master.Id)
+	}
+}
+
+func TestSwitchMasterByNonMaster(t *testing.T) {
+	r, master := newTestRoom(t, nil, nil)
+	joined, err := joinRoom(r, "p1")
+	if err != nil {
+		t.Fatalf("join p1: %v", err)
+	}
+	p1 := joined.Client
+
+	r.msgCh <- &MsgSwitchMaster{
+		RegularMsg: binary.NewRegularMsg(binary.MsgTypeSwitchMaster, 1, nil),
+		Sender:     p1,
+		Target:     "p1",
+	}
+	getRoomInfo(r)
+
+	if r.masterID() != "master" {
+		t.Fatalf("master = %v, wants master", r.masterID())
+	}
+	types := receivedTypes(t, p1)
+	if types[len(types)-1] != binary.EvTypePermissionDenied {
+		t.Fatalf("p1 events = %v, wants EvTypePermissionDenied at last", types)
+	}
+	for _, typ := range receivedTypes(t, master) {
+		if typ == binary.EvTypeMasterSwitched {
+			t.Fatalf("master switch is broadcast")
+		}
+	}
+}
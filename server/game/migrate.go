@@ -0,0 +1,299 @@
+package game
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+	"google.golang.org/grpc/codes"
+
+	"wsnet2/binary"
+	"wsnet2/log"
+	"wsnet2/pb"
+)
+
+// 部屋の移動
+//
+// shutdown時に部屋の状態（部屋情報、Player/Watcher、各クライアントのevbufとシーケンス番号）を
+// 別のgameサーバに送り、部屋を引き継ぐ.
+// 移動先は room.host_id を更新し、移動元は各クライアントに EvMigrated で移動先のURLを通知する.
+// クライアントは移動先に再接続し、未受信のEventを受け取る.
+
+// MigrateRooms : 全ての部屋を移動する.
+// 移動できなかった部屋はそのまま残る.
+func (repo *Repository) MigrateRooms(ctx context.Context, migrate func(req *pb.MigrateReq) (string, error), logger log.Logger) {
+	repo.mu.RLock()
+	rooms := make([]*Room, 0, len(repo.rooms))
+	for _, room := range repo.rooms {
+		rooms = append(rooms, room)
+	}
+	repo.mu.RUnlock()
+
+	for _, room := range rooms {
+		if err := repo.migrateRoom(ctx, room, migrate); err != nil {
+			logger.Errorf("migrate room: room=%v %+v", room.Id, err)
+		}
+	}
+}
+
+func (repo *Repository) migrateRoom(ctx context.Context, room *Room, migrate func(req *pb.MigrateReq) (string, error)) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	ch := make(chan error, 1)
+	msg := &MsgMigrate{
+		Migrate: migrate,
+		Res:     ch,
+	}
+	select {
+	case <-ctx.Done():
+		return xerrors.Errorf("MsgMigrate write msg timeout or context done: room=%v", room.Id)
+	case <-room.Done():
+		return nil
+	case room.msgCh <- msg:
+	}
+
+	select {
+	case <-ctx.Done():
+		return xerrors.Errorf("MsgMigrate response timeout or context done: room=%v", room.Id)
+	case err := <-ch:
+		return err
+	}
+}
+
+func (r *Room) msgMigrate(msg *MsgMigrate) {
+	r.muClients.Lock()
+	// 移動先が同じ記録ファイルに追記できるよう、先に閉じておく
+	r.closeRecorder()
+	req := r.snapshot()
+	r.muClients.Unlock()
+
+	// 移動先の応答を待つ間もロックを取得する処理を止めないよう、ロックを解放してから呼び出す.
+	// 部屋の状態はMsgLoopでしか変更しないので、呼び出し中に変わることはない.
+	url, err := msg.Migrate(req)
+
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+	if err != nil {
+		r.resumeRecording()
+		msg.Res <- xerrors.Errorf("migrate: %w", err)
+		return
+	}
+
+	r.logger.Infof("room migrated: %v", url)
+	r.migrated = true
+	for _, c := range r.players {
+		c.Migrated(url)
+	}
+	for _, c := range r.watchers {
+		c.Migrated(url)
+	}
+	close(r.done)
+	msg.Res <- nil
+}
+
+// snapshot : 移動先に送る部屋の状態.
+// muClients のロックを取得してから呼び出す.
+func (r *Room) snapshot() *pb.MigrateReq {
+	players := make([]*pb.MigratedClient, 0, len(r.masterOrder))
+	for _, id := range r.masterOrder {
+		players = append(players, r.players[id].snapshot())
+	}
+	watchers := make([]*pb.MigratedClient, 0, len(r.watchers))
+	for _, c := range r.watchers {
+		watchers = append(watchers, c.snapshot())
+	}
+
+	return &pb.MigrateReq{
+		AppId:        r.AppId,
+		RoomInfo:     r.RoomInfo.Clone(),
+		Deadline:     uint32(r.deadline / time.Second),
+		MasterId:     string(r.masterID()),
+		Players:      players,
+		Watchers:     watchers,
+		LastMsgTimes: r.lastMsgTimes(),
+		RateLimits:   r.rateLimitOption,
+		CachedEvents: r.cache.snapshot(),
+		Persistent:   r.persistent,
+		EmptyTtl:     uint32(r.emptyTTL / time.Second),
+		Reservations: r.reservationsSnapshot(),
+		Bans:         r.bansSnapshot(),
+
+		MasterByLatency:   r.masterByLatency,
+		MasterIdleTimeout: uint32(r.masterIdleTimeout / time.Second),
+		RoleChange:        uint32(r.roleChange),
+		WatcherDelay:      uint32(r.delayed.delay / time.Second),
+
+		PublicPropVersions:  r.publicVersions,
+		PrivatePropVersions: r.privateVersions,
+		PropVersion:         r.propVersion,
+
+		PublicPropAcl:     r.publicAcls.aclSnapshot(),
+		PrivatePropAcl:    r.privateAcls.aclSnapshot(),
+		PublicPropOwners:  r.publicAcls.ownersSnapshot(),
+		PrivatePropOwners: r.privateAcls.ownersSnapshot(),
+		Timers:            r.timers.snapshot(),
+
+		LockstepTickRate:   r.lockstep.rate,
+		LockstepInputDelay: r.lockstep.delay,
+		LockstepTick:       r.lockstep.tick,
+		LockstepHistory:    r.lockstep.historySnapshot(),
+
+		Objects: r.objects.snapshot(),
+
+		Record: r.record,
+	}
+}
+
+// restoreRoom : 移動元から受け取った状態で部屋を復元する.
+// 復元したクライアントは再接続を待つ.
+func restoreRoom(repo *Repository, req *pb.MigrateReq, logger log.Logger) (*Room, ErrorWithCode) {
+	info := req.RoomInfo
+	info.HostId = repo.hostId
+
+	r, ewc := initRoom(repo, info, req.Deadline, req.RateLimits, repo.conf, logger)
+	if ewc != nil {
+		return nil, ewc
+	}
+
+	for _, mc := range req.Players {
+		c, ewc := restoreClient(mc, r, true)
+		if ewc != nil {
+			close(r.done)
+			return nil, WithCode(
+				xerrors.Errorf("restore player(%v): %w", mc.Info.GetId(), ewc), ewc.Code())
+		}
+		r.players[c.ID()] = c
+		r.masterOrder = append(r.masterOrder, c.ID())
+	}
+	for _, mc := range req.Watchers {
+		c, ewc := restoreClient(mc, r, false)
+		if ewc != nil {
+			close(r.done)
+			return nil, WithCode(
+				xerrors.Errorf("restore watcher(%v): %w", mc.Info.GetId(), ewc), ewc.Code())
+		}
+		r.watchers[c.ID()] = c
+	}
+
+	r.persistent = req.Persistent
+	r.emptyTTL = time.Duration(req.EmptyTtl) * time.Second
+	r.masterByLatency = req.MasterByLatency
+	r.masterIdleTimeout = time.Duration(req.MasterIdleTimeout) * time.Second
+	r.roleChange = RoleChangePolicy(req.RoleChange)
+	r.delayed.delay = time.Duration(req.WatcherDelay) * time.Second
+	r.record = req.Record && repo.conf.RecordDir != ""
+	for k, v := range req.PublicPropVersions {
+		r.publicVersions[k] = v
+	}
+	for k, v := range req.PrivatePropVersions {
+		r.privateVersions[k] = v
+	}
+	if req.PropVersion > r.propVersion {
+		r.propVersion = req.PropVersion
+	}
+	if err := r.initPropAcls(req.PublicPropAcl, req.PrivatePropAcl); err != nil {
+		close(r.done)
+		return nil, WithCode(xerrors.Errorf("prop acl: %w", err), codes.InvalidArgument)
+	}
+	r.publicAcls.restoreOwners(req.PublicPropOwners)
+	r.privateAcls.restoreOwners(req.PrivatePropOwners)
+	r.timers.restore(req.Timers, time.Now())
+	if err := r.lockstep.configure(req.LockstepTickRate, req.LockstepInputDelay); err != nil {
+		close(r.done)
+		return nil, WithCode(xerrors.Errorf("lockstep: %w", err), codes.InvalidArgument)
+	}
+	if err := r.lockstep.restore(req.LockstepTick, req.LockstepHistory); err != nil {
+		close(r.done)
+		return nil, WithCode(xerrors.Errorf("lockstep: %w", err), codes.InvalidArgument)
+	}
+	if err := r.objects.restore(req.Objects); err != nil {
+		close(r.done)
+		return nil, WithCode(xerrors.Errorf("objects: %w", err), codes.InvalidArgument)
+	}
+	if len(r.players) == 0 && r.persistent {
+		// 無人の部屋
+		r.startEmptyTimer()
+	} else {
+		master, ok := r.players[ClientID(req.MasterId)]
+		if !ok {
+			close(r.done)
+			return nil, WithCode(
+				xerrors.Errorf("master not found: %v", req.MasterId), codes.InvalidArgument)
+		}
+		r.master = master
+	}
+
+	for id, t := range req.LastMsgTimes {
+		r.lastMsg[id] = binary.MarshalULong(t)
+	}
+	r.cache.restore(req.CachedEvents)
+	r.restoreReservations(req.Reservations)
+	r.restoreBans(req.Bans)
+
+	return r, nil
+}
+
+// MigrateRoom : 別のgameサーバから部屋を受け入れる.
+func (repo *Repository) MigrateRoom(ctx context.Context, req *pb.MigrateReq) ErrorWithCode {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	if req.RoomInfo == nil {
+		return WithCode(xerrors.Errorf("no room info"), codes.InvalidArgument)
+	}
+
+	repo.mu.RLock()
+	rooms := len(repo.rooms)
+	clients := len(repo.clients)
+	_, exists := repo.rooms[RoomID(req.RoomInfo.Id)]
+	repo.mu.RUnlock()
+	if exists {
+		return WithCode(
+			xerrors.Errorf("room already exists: %v", req.RoomInfo.Id), codes.AlreadyExists)
+	}
+	if rooms >= repo.conf.MaxRooms {
+		return WithCode(
+			xerrors.Errorf("reached to the max_rooms"), codes.ResourceExhausted)
+	}
+	if clients+len(req.Players)+len(req.Watchers) > repo.conf.MaxClients {
+		return WithCode(
+			xerrors.Errorf("reached to the max_clients"), codes.ResourceExhausted)
+	}
+
+	logger := log.Get(log.CurrentLevel()).With(log.KeyApp, repo.app.Id, log.KeyRoom, req.RoomInfo.Id)
+
+	room, ewc := restoreRoom(repo, req, logger)
+	if ewc != nil {
+		return WithCode(xerrors.Errorf("restoreRoom: %w", ewc), ewc.Code())
+	}
+
+	if _, err := repo.db.ExecContext(ctx, "UPDATE room SET host_id=? WHERE id=?", repo.hostId, room.Id); err != nil {
+		close(room.done)
+		return WithCode(xerrors.Errorf("update host_id: %w", err), codes.Internal)
+	}
+
+	repo.mu.Lock()
+	repo.rooms[room.ID()] = room
+	for _, c := range room.players {
+		repo.addClient(room, c)
+	}
+	for _, c := range room.watchers {
+		repo.addClient(room, c)
+	}
+	repo.mu.Unlock()
+
+	room.resumeRecording()
+	room.start()
+
+	logger.Infof("room migrated from other host: %v, players=%v watchers=%v", room.Id, len(req.Players), len(req.Watchers))
+	return nil
+}
+
+// addClient : repo.mu のロックを取得してから呼び出す
+func (repo *Repository) addClient(room *Room, c *Client) {
+	if _, ok := repo.clients[c.ID()]; !ok {
+		repo.clients[c.ID()] = make(map[RoomID]*Client)
+	}
+	repo.clients[c.ID()][room.ID()] = c
+}
@@ -0,0 +1,41 @@
+package game
+
+import (
+	"errors"
+	"testing"
+
+	"wsnet2/pb"
+)
+
+func TestMsgMigrateUnlocked(t *testing.T) {
+	r, _ := newTestRoom(t, nil, nil)
+
+	var locked bool
+	res := make(chan error, 1)
+	r.msgCh <- &MsgMigrate{
+		Migrate: func(req *pb.MigrateReq) (string, error) {
+			// 移動先の呼び出し中は muClients を解放している
+			if r.muClients.TryLock() {
+				r.muClients.Unlock()
+			} else {
+				locked = true
+			}
+			return "", errors.New("migrate failed")
+		},
+		Res: res,
+	}
+	if err := <-res; err == nil {
+		t.Fatalf("msgMigrate must return the error")
+	}
+	if locked {
+		t.Fatalf("muClients is locked while calling Migrate")
+	}
+
+	// 移動に失敗した部屋はそのまま続く
+	select {
+	case <-r.Done():
+		t.Fatalf("room closed after failed migration")
+	default:
+	}
+	getRoomInfo(r)
+}
@@ -23,10 +23,28 @@ var _ Msg = &MsgLeave{}
 var _ Msg = &MsgRoomProp{}
 var _ Msg = &MsgClientProp{}
 var _ Msg = &MsgBroadcast{}
+var _ Msg = &MsgCachedBroadcast{}
 var _ Msg = &MsgSwitchMaster{}
 var _ Msg = &MsgKick{}
+var _ Msg = &MsgBan{}
+var _ Msg = &MsgChangeRole{}
+var _ Msg = &MsgSetTimer{}
+var _ Msg = &MsgCancelTimer{}
+var _ Msg = &MsgListTimers{}
+var _ Msg = &MsgLockstepInput{}
+var _ Msg = &MsgSpawnObject{}
+var _ Msg = &MsgUpdateObject{}
+var _ Msg = &MsgTransferObject{}
+var _ Msg = &MsgDespawnObject{}
+var _ Msg = &MsgRandom{}
+var _ Msg = &MsgVolatile{}
 var _ Msg = &MsgClientError{}
 var _ Msg = &MsgClientTimeout{}
+var _ Msg = &MsgRateLimitExceeded{}
+var _ Msg = &MsgSlowPeer{}
+var _ Msg = &MsgMigrate{}
+var _ Msg = &MsgAdminClose{}
+var _ Msg = &MsgReserve{}
 
 const adminClientID = ClientID("")
 
@@ -37,6 +55,14 @@ type JoinedInfo struct {
 	Client   *Client
 	MasterId ClientID
 	Deadline time.Duration
+
+	// InviteTokens : 部屋作成時に予約した席の招待トークン
+	InviteTokens map[string]string
+
+	// PublicPropVersions, PrivatePropVersions, PlayerPropVersions : プロパティのキー毎のバージョン
+	PublicPropVersions  map[string]uint64
+	PrivatePropVersions map[string]uint64
+	PlayerPropVersions  map[string]*pb.PropVersions
 }
 
 // MsgCreate : 部屋作成メッセージ
@@ -61,6 +87,9 @@ type MsgJoin struct {
 	MACKey string
 	Joined chan<- *JoinedInfo
 	Err    chan<- ErrorWithCode
+
+	// InviteToken : 予約席の招待トークン
+	InviteToken string
 }
 
 func (*MsgJoin) msg() {}
@@ -89,6 +118,10 @@ func (m *MsgWatch) SenderID() ClientID {
 type MsgPing struct {
 	Sender    *Client
 	Timestamp uint64
+	RTT       time.Duration
+
+	// ReceivedAt : サーバが受信した時刻 (時計合わせ用)
+	ReceivedAt time.Time
 }
 
 func (*MsgPing) msg() {}
@@ -98,13 +131,15 @@ func (m *MsgPing) SenderID() ClientID {
 }
 
 func msgPing(sender *Client, m binary.Msg) (Msg, error) {
-	ts, err := binary.UnmarshalPingPayload(m.Payload())
+	ts, rtt, err := binary.UnmarshalPingPayload(m.Payload())
 	if err != nil {
 		return nil, err
 	}
 	return &MsgPing{
-		Sender:    sender,
-		Timestamp: ts,
+		Sender:     sender,
+		Timestamp:  ts,
+		RTT:        rtt,
+		ReceivedAt: time.Now(),
 	}, nil
 }
 
@@ -131,6 +166,31 @@ func msgNodeCount(sender *Client, m binary.Msg) (Msg, error) {
 	}, nil
 }
 
+// MsgVolatile : 揮発性の値の送信
+type MsgVolatile struct {
+	Sender *Client
+	Key    string
+	Value  []byte
+}
+
+func (*MsgVolatile) msg() {}
+
+func (m *MsgVolatile) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgVolatile(sender *Client, m binary.Msg) (Msg, error) {
+	key, value, err := binary.UnmarshalVolatilePayload(m.Payload())
+	if err != nil {
+		return nil, err
+	}
+	return &MsgVolatile{
+		Sender: sender,
+		Key:    key,
+		Value:  value,
+	}, nil
+}
+
 // MsgGetRoomInfo : 部屋情報の取得
 // gRPCから実行される
 type MsgGetRoomInfo struct {
@@ -154,6 +214,45 @@ func (m *MsgAdminKick) SenderID() ClientID {
 	return adminClientID
 }
 
+// MsgReserve : 席を予約する
+// gRPCから実行される
+type MsgReserve struct {
+	Requester ClientID
+	ClientIDs []string
+	TTL       time.Duration
+	Res       chan<- map[string]string
+	Err       chan<- ErrorWithCode
+}
+
+func (*MsgReserve) msg() {}
+func (m *MsgReserve) SenderID() ClientID {
+	return m.Requester
+}
+
+// MsgAdminClose : 部屋を終了する
+// gRPCから実行される
+type MsgAdminClose struct {
+	Res chan<- error
+}
+
+func (*MsgAdminClose) msg() {}
+func (m *MsgAdminClose) SenderID() ClientID {
+	return adminClientID
+}
+
+// MsgMigrate : 部屋を別のgameサーバに移動する
+// shutdown時に実行される
+type MsgMigrate struct {
+	// Migrate : 部屋の状態を移動先に送り、移動先のwebsocket URLを返す
+	Migrate func(req *pb.MigrateReq) (string, error)
+	Res     chan<- error
+}
+
+func (*MsgMigrate) msg() {}
+func (m *MsgMigrate) SenderID() ClientID {
+	return adminClientID
+}
+
 // MsgLeave : 退室メッセージ
 // クライアントの自発的な退室リクエスト
 type MsgLeave struct {
@@ -181,7 +280,7 @@ func msgLeave(sender *Client, msg binary.RegularMsg) (Msg, error) {
 }
 
 // MsgRoomProp : 部屋情報の変更
-// MasterClientからのみ受け付ける.
+// Master以外のPlayerからは書き込みを許可されたキーのみ受け付ける.
 type MsgRoomProp struct {
 	binary.RegularMsg
 	*binary.MsgRoomPropPayload
@@ -211,6 +310,11 @@ type MsgClientProp struct {
 	binary.RegularMsg
 	Sender *Client
 	Props  binary.Dict
+
+	// Cond : 変更の条件. 指定がなければnil
+	Cond *binary.VersionedProps
+	// EventPayload : EvClientPropに載せるプロパティ (条件を除く)
+	EventPayload []byte
 }
 
 func (*MsgClientProp) msg() {}
@@ -220,14 +324,20 @@ func (m *MsgClientProp) SenderID() ClientID {
 }
 
 func msgClientProp(sender *Client, msg binary.RegularMsg) (Msg, error) {
-	props, err := binary.UnmarshalClientPropPayload(msg.Payload())
+	props, cond, err := binary.UnmarshalClientPropPayload(msg.Payload())
 	if err != nil {
 		return nil, err
 	}
+	evPayload := msg.Payload()
+	if cond != nil {
+		evPayload = binary.MarshalDict(props)
+	}
 	return &MsgClientProp{
-		RegularMsg: msg,
-		Sender:     sender,
-		Props:      props,
+		RegularMsg:   msg,
+		Sender:       sender,
+		Props:        props,
+		Cond:         cond,
+		EventPayload: evPayload,
 	}, nil
 }
 
@@ -300,6 +410,33 @@ func msgBroadcast(sender *Client, msg binary.RegularMsg) (Msg, error) {
 	}, nil
 }
 
+// MsgCachedBroadcast : 全員に送り、途中入室者のためにキー毎に最新のものを残す
+type MsgCachedBroadcast struct {
+	binary.RegularMsg
+	Sender *Client
+	Key    string
+	Data   []byte
+}
+
+func (*MsgCachedBroadcast) msg() {}
+
+func (m *MsgCachedBroadcast) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgCachedBroadcast(sender *Client, msg binary.RegularMsg) (Msg, error) {
+	key, data, err := binary.UnmarshalCachedBroadcastPayload(msg.Payload())
+	if err != nil {
+		return nil, err
+	}
+	return &MsgCachedBroadcast{
+		RegularMsg: msg,
+		Sender:     sender,
+		Key:        key,
+		Data:       data,
+	}, nil
+}
+
 // MsgSwitchMaster : MasterClientの切替え
 // MasterClientからのみ受け付ける.
 type MsgSwitchMaster struct {
@@ -354,6 +491,310 @@ func msgKick(sender *Client, msg binary.RegularMsg) (Msg, error) {
 	}, nil
 }
 
+// MsgBan : Clientの入室を禁止し、入室中ならKick
+// MasterClientからのみ受け付ける.
+type MsgBan struct {
+	binary.RegularMsg
+	Sender   *Client
+	Target   ClientID
+	Duration time.Duration
+	Message  string
+}
+
+func (*MsgBan) msg() {}
+
+func (m *MsgBan) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgBan(sender *Client, msg binary.RegularMsg) (Msg, error) {
+	target, sec, message, err := binary.UnmarshalBanPayload(msg.Payload())
+	if err != nil {
+		return nil, err
+	}
+	return &MsgBan{
+		RegularMsg: msg,
+		Sender:     sender,
+		Target:     ClientID(target),
+		Duration:   time.Duration(sec) * time.Second,
+		Message:    message,
+	}, nil
+}
+
+// MsgChangeRole : ClientをPlayerとWatcherの間で移動する
+// RoomOption.RoleChange の設定に従って、Masterまたは本人から受け付ける.
+type MsgChangeRole struct {
+	binary.RegularMsg
+	Sender   *Client
+	Target   ClientID
+	ToPlayer bool
+}
+
+func (*MsgChangeRole) msg() {}
+
+func (m *MsgChangeRole) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgChangeRole(sender *Client, msg binary.RegularMsg) (Msg, error) {
+	target, toPlayer, err := binary.UnmarshalChangeRolePayload(msg.Payload())
+	if err != nil {
+		return nil, err
+	}
+	return &MsgChangeRole{
+		RegularMsg: msg,
+		Sender:     sender,
+		Target:     ClientID(target),
+		ToPlayer:   toPlayer,
+	}, nil
+}
+
+// MsgSetTimer : サーバ側のタイマーを設定する
+// MasterClientからのみ受け付ける.
+type MsgSetTimer struct {
+	binary.RegularMsg
+	Sender *Client
+	ID     string
+	Delay  time.Duration
+	Target binary.TimerTarget
+	Data   []byte
+}
+
+func (*MsgSetTimer) msg() {}
+
+func (m *MsgSetTimer) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgSetTimer(sender *Client, msg binary.RegularMsg) (Msg, error) {
+	id, delay, target, data, err := binary.UnmarshalSetTimerPayload(msg.Payload())
+	if err != nil {
+		return nil, err
+	}
+	return &MsgSetTimer{
+		RegularMsg: msg,
+		Sender:     sender,
+		ID:         id,
+		Delay:      time.Duration(delay) * time.Millisecond,
+		Target:     target,
+		Data:       data,
+	}, nil
+}
+
+// MsgCancelTimer : サーバ側のタイマーを取り消す
+// MasterClientからのみ受け付ける.
+type MsgCancelTimer struct {
+	binary.RegularMsg
+	Sender *Client
+	ID     string
+}
+
+func (*MsgCancelTimer) msg() {}
+
+func (m *MsgCancelTimer) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgCancelTimer(sender *Client, msg binary.RegularMsg) (Msg, error) {
+	id, err := binary.UnmarshalCancelTimerPayload(msg.Payload())
+	if err != nil {
+		return nil, err
+	}
+	return &MsgCancelTimer{
+		RegularMsg: msg,
+		Sender:     sender,
+		ID:         id,
+	}, nil
+}
+
+// MsgListTimers : サーバ側のタイマーの一覧
+// MasterClientからのみ受け付ける.
+type MsgListTimers struct {
+	binary.RegularMsg
+	Sender *Client
+}
+
+func (*MsgListTimers) msg() {}
+
+func (m *MsgListTimers) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgListTimers(sender *Client, msg binary.RegularMsg) (Msg, error) {
+	return &MsgListTimers{
+		RegularMsg: msg,
+		Sender:     sender,
+	}, nil
+}
+
+// MsgLockstepInput : ロックステップのフレームの入力
+type MsgLockstepInput struct {
+	binary.RegularMsg
+	Sender *Client
+	Frame  uint32
+	Input  []byte
+}
+
+func (*MsgLockstepInput) msg() {}
+
+func (m *MsgLockstepInput) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgLockstepInput(sender *Client, msg binary.RegularMsg) (Msg, error) {
+	frame, input, err := binary.UnmarshalLockstepInputPayload(msg.Payload())
+	if err != nil {
+		return nil, err
+	}
+	return &MsgLockstepInput{
+		RegularMsg: msg,
+		Sender:     sender,
+		Frame:      frame,
+		Input:      input,
+	}, nil
+}
+
+// MsgSpawnObject : ネットワークオブジェクトの生成
+type MsgSpawnObject struct {
+	binary.RegularMsg
+	Sender  *Client
+	ID      string
+	ClassId byte
+	Policy  binary.ObjectLeavePolicy
+	State   binary.Dict
+}
+
+func (*MsgSpawnObject) msg() {}
+
+func (m *MsgSpawnObject) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgSpawnObject(sender *Client, msg binary.RegularMsg) (Msg, error) {
+	id, classId, policy, state, err := binary.UnmarshalSpawnObjectPayload(msg.Payload())
+	if err != nil {
+		return nil, err
+	}
+	return &MsgSpawnObject{
+		RegularMsg: msg,
+		Sender:     sender,
+		ID:         id,
+		ClassId:    classId,
+		Policy:     policy,
+		State:      state,
+	}, nil
+}
+
+// MsgUpdateObject : ネットワークオブジェクトの状態の更新
+type MsgUpdateObject struct {
+	binary.RegularMsg
+	Sender *Client
+	ID     string
+	State  binary.Dict
+}
+
+func (*MsgUpdateObject) msg() {}
+
+func (m *MsgUpdateObject) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgUpdateObject(sender *Client, msg binary.RegularMsg) (Msg, error) {
+	id, state, err := binary.UnmarshalUpdateObjectPayload(msg.Payload())
+	if err != nil {
+		return nil, err
+	}
+	return &MsgUpdateObject{
+		RegularMsg: msg,
+		Sender:     sender,
+		ID:         id,
+		State:      state,
+	}, nil
+}
+
+// MsgTransferObject : ネットワークオブジェクトのオーナーの変更
+type MsgTransferObject struct {
+	binary.RegularMsg
+	Sender *Client
+	ID     string
+	Owner  ClientID
+}
+
+func (*MsgTransferObject) msg() {}
+
+func (m *MsgTransferObject) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgTransferObject(sender *Client, msg binary.RegularMsg) (Msg, error) {
+	id, owner, err := binary.UnmarshalTransferObjectPayload(msg.Payload())
+	if err != nil {
+		return nil, err
+	}
+	return &MsgTransferObject{
+		RegularMsg: msg,
+		Sender:     sender,
+		ID:         id,
+		Owner:      ClientID(owner),
+	}, nil
+}
+
+// MsgDespawnObject : ネットワークオブジェクトの削除
+type MsgDespawnObject struct {
+	binary.RegularMsg
+	Sender *Client
+	ID     string
+}
+
+func (*MsgDespawnObject) msg() {}
+
+func (m *MsgDespawnObject) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgDespawnObject(sender *Client, msg binary.RegularMsg) (Msg, error) {
+	id, err := binary.UnmarshalDespawnObjectPayload(msg.Payload())
+	if err != nil {
+		return nil, err
+	}
+	return &MsgDespawnObject{
+		RegularMsg: msg,
+		Sender:     sender,
+		ID:         id,
+	}, nil
+}
+
+// MsgRandom : サーバの乱数の要求
+type MsgRandom struct {
+	binary.RegularMsg
+	Sender *Client
+	Tag    string
+	Kind   binary.RandomKind
+	Count  uint32
+	Max    uint32
+}
+
+func (*MsgRandom) msg() {}
+
+func (m *MsgRandom) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgRandom(sender *Client, msg binary.RegularMsg) (Msg, error) {
+	tag, kind, count, max, err := binary.UnmarshalRandomPayload(msg.Payload())
+	if err != nil {
+		return nil, err
+	}
+	return &MsgRandom{
+		RegularMsg: msg,
+		Sender:     sender,
+		Tag:        tag,
+		Kind:       kind,
+		Count:      count,
+		Max:        max,
+	}, nil
+}
+
 // MsgClientError : Client内部エラー（内部で発生）
 type MsgClientError struct {
 	Sender *Client
@@ -377,12 +818,36 @@ func (m *MsgClientTimeout) SenderID() ClientID {
 	return m.Sender.ID()
 }
 
+// MsgRateLimitExceeded : 送信レート制限超過によるClientの退室（内部で発生）
+type MsgRateLimitExceeded struct {
+	Sender *Client
+}
+
+func (*MsgRateLimitExceeded) msg() {}
+
+func (m *MsgRateLimitExceeded) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+// MsgSlowPeer : 送信キューが溢れたClientの退室（内部で発生）
+type MsgSlowPeer struct {
+	Sender *Client
+}
+
+func (*MsgSlowPeer) msg() {}
+
+func (m *MsgSlowPeer) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
 func ConstructMsg(cli *Client, m binary.Msg) (msg Msg, err error) {
 	switch m.Type() {
 	case binary.MsgTypePing:
 		return msgPing(cli, m)
 	case binary.MsgTypeNodeCount:
 		return msgNodeCount(cli, m)
+	case binary.MsgTypeVolatile:
+		return msgVolatile(cli, m)
 	case binary.MsgTypeLeave:
 		return msgLeave(cli, m.(binary.RegularMsg))
 	case binary.MsgTypeRoomProp:
@@ -395,10 +860,34 @@ func ConstructMsg(cli *Client, m binary.Msg) (msg Msg, err error) {
 		return msgToMaster(cli, m.(binary.RegularMsg))
 	case binary.MsgTypeBroadcast:
 		return msgBroadcast(cli, m.(binary.RegularMsg))
+	case binary.MsgTypeCachedBroadcast:
+		return msgCachedBroadcast(cli, m.(binary.RegularMsg))
 	case binary.MsgTypeSwitchMaster:
 		return msgSwitchMaster(cli, m.(binary.RegularMsg))
 	case binary.MsgTypeKick:
 		return msgKick(cli, m.(binary.RegularMsg))
+	case binary.MsgTypeBan:
+		return msgBan(cli, m.(binary.RegularMsg))
+	case binary.MsgTypeChangeRole:
+		return msgChangeRole(cli, m.(binary.RegularMsg))
+	case binary.MsgTypeSetTimer:
+		return msgSetTimer(cli, m.(binary.RegularMsg))
+	case binary.MsgTypeCancelTimer:
+		return msgCancelTimer(cli, m.(binary.RegularMsg))
+	case binary.MsgTypeListTimers:
+		return msgListTimers(cli, m.(binary.RegularMsg))
+	case binary.MsgTypeLockstepInput:
+		return msgLockstepInput(cli, m.(binary.RegularMsg))
+	case binary.MsgTypeSpawnObject:
+		return msgSpawnObject(cli, m.(binary.RegularMsg))
+	case binary.MsgTypeUpdateObject:
+		return msgUpdateObject(cli, m.(binary.RegularMsg))
+	case binary.MsgTypeTransferObject:
+		return msgTransferObject(cli, m.(binary.RegularMsg))
+	case binary.MsgTypeDespawnObject:
+		return msgDespawnObject(cli, m.(binary.RegularMsg))
+	case binary.MsgTypeRandom:
+		return msgRandom(cli, m.(binary.RegularMsg))
 	}
 	return nil, xerrors.Errorf("unknown msg type: %T %v", m, m)
 }
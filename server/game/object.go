@@ -0,0 +1,270 @@
+package game
+
+import (
+	"sort"
+
+	"golang.org/x/xerrors"
+
+	"wsnet2/binary"
+	"wsnet2/pb"
+)
+
+// ネットワークオブジェクト
+//
+// 部屋はPlayerが MsgSpawnObject で生成したオブジェクト（ID、オーナー、クラスID、状態のDict）を保持する.
+// 状態の更新（MsgUpdateObject）、オーナーの変更（MsgTransferObject）、削除（MsgDespawnObject）は
+// オーナーとMasterのみができ、それぞれ全員にEventで通知する.
+//
+// オーナーが退室したりWatcherになったときは、生成時に指定した ObjectLeavePolicy に従って削除するか
+// Masterをオーナーにする. Masterがいなければオーナーなしで残し、Masterのみが操作できる.
+// 途中入室したクライアント（Watcherを含む）には生成順に EvObjectSnapshot でまとめて送る.
+// Hubは届いたEventからオブジェクトを再現し、Hubに入室したWatcherに同様に送る.
+// 部屋の移動ではオブジェクトを引き継ぐ.
+
+const (
+	// maxRoomObjects : 部屋に生成できるオブジェクトの数
+	maxRoomObjects = 1024
+
+	// objectSnapshotChunk : EvObjectSnapshot 1つに入れるオブジェクトの数
+	objectSnapshotChunk = 32
+)
+
+type netObject struct {
+	binary.NetObject
+	policy binary.ObjectLeavePolicy
+	seq    uint64
+}
+
+// objectRegistry : 部屋のネットワークオブジェクト.
+// muClients のロックを取得してから使う.
+type objectRegistry struct {
+	objects map[string]*netObject
+	seq     uint64
+}
+
+func newObjectRegistry() *objectRegistry {
+	return &objectRegistry{
+		objects: make(map[string]*netObject),
+	}
+}
+
+// spawn : オブジェクトを生成する. 同じIDのオブジェクトがあればエラー.
+func (reg *objectRegistry) spawn(id string, owner ClientID, classId byte, policy binary.ObjectLeavePolicy, state binary.Dict) (*netObject, error) {
+	if _, ok := reg.objects[id]; ok {
+		return nil, xerrors.Errorf("object already exists: %v", id)
+	}
+	if len(reg.objects) >= maxRoomObjects {
+		return nil, xerrors.Errorf("too many objects: max=%v", maxRoomObjects)
+	}
+	reg.seq++
+	o := &netObject{
+		NetObject: binary.NetObject{
+			ID:      id,
+			Owner:   string(owner),
+			ClassId: classId,
+			State:   state,
+		},
+		policy: policy,
+		seq:    reg.seq,
+	}
+	reg.objects[id] = o
+	return o, nil
+}
+
+// list : 生成順に並べたオブジェクト
+func (reg *objectRegistry) list() []*netObject {
+	list := make([]*netObject, 0, len(reg.objects))
+	for _, o := range reg.objects {
+		list = append(list, o)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].seq < list[j].seq })
+	return list
+}
+
+// owned : ownerがオーナーのオブジェクトを生成順に返す
+func (reg *objectRegistry) owned(owner ClientID) []*netObject {
+	var objs []*netObject
+	for _, o := range reg.list() {
+		if o.Owner == string(owner) {
+			objs = append(objs, o)
+		}
+	}
+	return objs
+}
+
+// snapshotEvents : 全オブジェクトを objectSnapshotChunk 毎にまとめたEvent
+func (reg *objectRegistry) snapshotEvents() []*binary.RegularEvent {
+	list := reg.list()
+	var evs []*binary.RegularEvent
+	for i := 0; i < len(list); i += objectSnapshotChunk {
+		end := i + objectSnapshotChunk
+		if end > len(list) {
+			end = len(list)
+		}
+		objs := make([]*binary.NetObject, 0, end-i)
+		for _, o := range list[i:end] {
+			objs = append(objs, &o.NetObject)
+		}
+		evs = append(evs, binary.NewEvObjectSnapshot(objs))
+	}
+	return evs
+}
+
+// snapshot : 部屋の移動先に送るオブジェクト
+func (reg *objectRegistry) snapshot() []*pb.RoomObject {
+	list := reg.list()
+	objs := make([]*pb.RoomObject, len(list))
+	for i, o := range list {
+		objs[i] = &pb.RoomObject{
+			Id:          o.ID,
+			Owner:       o.Owner,
+			ClassId:     uint32(o.ClassId),
+			LeavePolicy: uint32(o.policy),
+			State:       binary.MarshalDict(o.State),
+		}
+	}
+	return objs
+}
+
+// restore : 移動元のオブジェクトを復元する
+func (reg *objectRegistry) restore(objs []*pb.RoomObject) error {
+	for _, o := range objs {
+		state, _, err := binary.UnmarshalNullDict(o.State)
+		if err != nil {
+			return xerrors.Errorf("object %v: %w", o.Id, err)
+		}
+		_, err = reg.spawn(o.Id, ClientID(o.Owner), byte(o.ClassId), binary.ObjectLeavePolicy(o.LeavePolicy), state)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// canModify : sender がオブジェクトを操作できるか
+func (r *Room) canModify(sender ClientID, o *netObject) bool {
+	return string(sender) == o.Owner || sender == r.masterID()
+}
+
+// releaseObjects : 退室したりWatcherになったクライアントのオブジェクトを削除するかMasterに渡す.
+// 次のMasterを決めてから呼び出す.
+// muClients のロックを取得してから呼び出す.
+func (r *Room) releaseObjects(cid ClientID) {
+	for _, o := range r.objects.owned(cid) {
+		if o.policy == binary.ObjectToMasterOnLeave {
+			o.Owner = string(r.masterID())
+			r.logger.Debugf("object %v: owner %v -> %v", o.ID, cid, o.Owner)
+			r.broadcast(binary.NewEvObjectOwnerChanged(o.ID, o.Owner))
+			continue
+		}
+		delete(r.objects.objects, o.ID)
+		r.logger.Debugf("object %v despawned: owner %v left", o.ID, cid)
+		r.broadcast(binary.NewEvObjectDespawned(o.ID))
+	}
+}
+
+// sendObjectSnapshot : 途中入室したクライアントにオブジェクトを送る.
+// muClients のロックを取得してから呼び出す.
+func (r *Room) sendObjectSnapshot(c *Client) {
+	for _, ev := range r.objects.snapshotEvents() {
+		r.sendJoinSnapshot(c, ev)
+	}
+}
+
+func (r *Room) msgSpawnObject(msg *MsgSpawnObject) {
+	r.muClients.RLock()
+	defer r.muClients.RUnlock()
+
+	if !msg.Sender.isPlayer || r.players[msg.SenderID()] != msg.Sender {
+		msg.Sender.logger.Warnf("spawn object from non-player: %v", msg.Sender.Id)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+
+	o, err := r.objects.spawn(msg.ID, msg.SenderID(), msg.ClassId, msg.Policy, msg.State)
+	if err != nil {
+		msg.Sender.logger.Infof("spawn object: %v", err)
+		r.sendTo(msg.Sender, binary.NewEvRejected(msg))
+		return
+	}
+	msg.Sender.logger.Debugf("object spawned: %v class=%v", o.ID, o.ClassId)
+	r.sendTo(msg.Sender, binary.NewEvSucceeded(msg))
+	r.broadcast(binary.NewEvObjectSpawned(&o.NetObject))
+}
+
+func (r *Room) msgUpdateObject(msg *MsgUpdateObject) {
+	r.muClients.RLock()
+	defer r.muClients.RUnlock()
+
+	o, ok := r.objects.objects[msg.ID]
+	if !ok {
+		msg.Sender.logger.Infof("object %q is not found", msg.ID)
+		r.sendTo(msg.Sender, binary.NewEvTargetNotFound(msg, []string{msg.ID}))
+		return
+	}
+	if !r.canModify(msg.SenderID(), o) {
+		msg.Sender.logger.Warnf("sender %q is neither owner %q nor master of object %q", msg.Sender.Id, o.Owner, o.ID)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+
+	o.State = mergeProps(o.State, msg.State)
+	mod := make(binary.Dict, len(msg.State))
+	for k, v := range msg.State {
+		if len(v) == 0 {
+			// 削除したキーはNullで通知する
+			v = binary.MarshalNull()
+		}
+		mod[k] = v
+	}
+	r.broadcast(binary.NewEvObjectUpdated(o.ID, mod))
+}
+
+func (r *Room) msgTransferObject(msg *MsgTransferObject) {
+	r.muClients.RLock()
+	defer r.muClients.RUnlock()
+
+	o, ok := r.objects.objects[msg.ID]
+	if !ok {
+		msg.Sender.logger.Infof("object %q is not found", msg.ID)
+		r.sendTo(msg.Sender, binary.NewEvTargetNotFound(msg, []string{msg.ID}))
+		return
+	}
+	if !r.canModify(msg.SenderID(), o) {
+		msg.Sender.logger.Warnf("sender %q is neither owner %q nor master of object %q", msg.Sender.Id, o.Owner, o.ID)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+	if _, ok := r.players[msg.Owner]; !ok {
+		msg.Sender.logger.Infof("new owner %v is not a player", msg.Owner)
+		r.sendTo(msg.Sender, binary.NewEvTargetNotFound(msg, []string{string(msg.Owner)}))
+		return
+	}
+
+	msg.Sender.logger.Debugf("object %v: owner %v -> %v", o.ID, o.Owner, msg.Owner)
+	o.Owner = string(msg.Owner)
+	r.sendTo(msg.Sender, binary.NewEvSucceeded(msg))
+	r.broadcast(binary.NewEvObjectOwnerChanged(o.ID, o.Owner))
+}
+
+func (r *Room) msgDespawnObject(msg *MsgDespawnObject) {
+	r.muClients.RLock()
+	defer r.muClients.RUnlock()
+
+	o, ok := r.objects.objects[msg.ID]
+	if !ok {
+		msg.Sender.logger.Infof("object %q is not found", msg.ID)
+		r.sendTo(msg.Sender, binary.NewEvTargetNotFound(msg, []string{msg.ID}))
+		return
+	}
+	if !r.canModify(msg.SenderID(), o) {
+		msg.Sender.logger.Warnf("sender %q is neither owner %q nor master of object %q", msg.Sender.Id, o.Owner, o.ID)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+
+	delete(r.objects.objects, o.ID)
+	msg.Sender.logger.Debugf("object despawned: %v", o.ID)
+	r.sendTo(msg.Sender, binary.NewEvSucceeded(msg))
+	r.broadcast(binary.NewEvObjectDespawned(o.ID))
+}
@@ -0,0 +1,68 @@
+package game
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+
+	"wsnet2/binary"
+	"wsnet2/pb"
+)
+
+func TestObjectRegistry(t *testing.T) {
+	reg := newObjectRegistry()
+	for i, id := range []string{"b", "a", "c"} {
+		if _, err := reg.spawn(id, "p1", byte(i), binary.ObjectDespawnOnLeave, binary.Dict{"hp": binary.MarshalInt(10)}); err != nil {
+			t.Fatalf("spawn %v: %v", id, err)
+		}
+	}
+	if _, err := reg.spawn("a", "p2", 0, binary.ObjectDespawnOnLeave, nil); err == nil {
+		t.Fatalf("spawned duplicated id")
+	}
+
+	evs := reg.snapshotEvents()
+	if len(evs) != 1 {
+		t.Fatalf("snapshot events: %v, wants 1", len(evs))
+	}
+	objs, err := binary.UnmarshalEvObjectSnapshotPayload(evs[0].Payload())
+	if err != nil {
+		t.Fatalf("snapshot payload: %v", err)
+	}
+	if len(objs) != 3 || objs[0].ID != "b" || objs[1].ID != "a" || objs[2].ID != "c" || objs[2].ClassId != 2 {
+		t.Fatalf("snapshot objects: %v, wants b, a, c", objs)
+	}
+
+	restored := newObjectRegistry()
+	if err := restored.restore(reg.snapshot()); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if list := restored.list(); len(list) != 3 || list[0].ID != "b" || list[2].Owner != "p1" {
+		t.Errorf("restored objects: %v", list)
+	}
+}
+
+func TestReleaseObjects(t *testing.T) {
+	master := &Client{ClientInfo: &pb.ClientInfo{Id: "master"}, isPlayer: true}
+	r := &Room{
+		RoomInfo: &pb.RoomInfo{Id: "room1"},
+		master:   master,
+		objects:  newObjectRegistry(),
+		delayed:  newDelayQueue(),
+		logger:   zap.NewNop().Sugar(),
+	}
+	r.objects.spawn("bullet", "p1", 1, binary.ObjectDespawnOnLeave, nil)
+	r.objects.spawn("flag", "p1", 2, binary.ObjectToMasterOnLeave, nil)
+	r.objects.spawn("unit", "p2", 3, binary.ObjectDespawnOnLeave, nil)
+
+	r.releaseObjects("p1")
+
+	if _, ok := r.objects.objects["bullet"]; ok {
+		t.Errorf("bullet is not despawned")
+	}
+	if o := r.objects.objects["flag"]; o == nil || o.Owner != "master" {
+		t.Errorf("flag: %v, wants owned by master", o)
+	}
+	if o := r.objects.objects["unit"]; o == nil || o.Owner != "p2" {
+		t.Errorf("unit: %v, wants owned by p2", o)
+	}
+}
@@ -21,6 +21,46 @@ const (
 	waitCloseTimeout = 3 * time.Second
 )
 
+// outFrame : 送信キューに積むwebsocketのメッセージ.
+// headとbodyを続けて1つのメッセージとして書き込む.
+// bodyは同じEventを送る全ての宛先で共有するので変更しないこと.
+type outFrame struct {
+	typ  int
+	head [binary.TimestampedEventHeaderSize]byte
+	hlen int
+	body []byte
+
+	// ev, seq, timestamped : RegularEventのとき. EvTypeBatchにまとめるのに使う
+	ev          *binary.RegularEvent
+	seq         int
+	timestamped bool
+}
+
+// regularFrame : RegularEventのメッセージ. 宛先毎のヘッダだけを作り、payloadは共有する
+func regularFrame(ev *binary.RegularEvent, seqNum int, timestamped bool) outFrame {
+	f := outFrame{
+		typ:         websocket.BinaryMessage,
+		body:        ev.Payload(),
+		ev:          ev,
+		seq:         seqNum,
+		timestamped: timestamped,
+	}
+	f.hlen = len(ev.AppendHeader(f.head[:0], seqNum, timestamped))
+	return f
+}
+
+// batchable : EvTypeBatchにまとめられるか
+func (f *outFrame) batchable() bool {
+	return f.ev != nil && len(f.body) <= binary.MaxBatchEntrySize
+}
+
+// systemFrame : SystemEventのメッセージ
+func systemFrame(ev *binary.SystemEvent) outFrame {
+	f := outFrame{typ: websocket.BinaryMessage, body: ev.Payload()}
+	f.hlen = len(ev.AppendHeader(f.head[:0]))
+	return f
+}
+
 // Peer : websocketの接続
 //
 // CloseCodeが次の場合はクライアントは再接続を試行しない
@@ -34,13 +74,36 @@ type Peer struct {
 	done     chan struct{}
 	detached chan struct{}
 
+	// outq : 送信キュー. websocketへの書き込みはwriteLoopだけが行う
+	outq chan outFrame
+	// connClosed : websocketを閉じたらcloseする
+	connClosed chan struct{}
+	closeOnce  sync.Once
+
+	// policy : 送信キューが溢れたときの処理
+	policy SlowPeerPolicy
+
+	// muWrite : 送信キューに積む順番とclosed, slowedを守る
 	muWrite sync.Mutex
 	closed  bool
+	// slowed : 送信キューが溢れた
+	slowed bool
 
 	evSeqNum int
+
+	// timestamped : RegularEventにサーバの時刻を付ける (クライアントが Wsnet2-EventTimestamp ヘッダで要求する)
+	timestamped bool
+	// ready : EvPeerReadyを送信済み. クライアントはEvPeerReadyで時刻の有無を知るので、それより前に送るEventには付けない
+	ready bool
+	// batched : 小さなMsgやEventをまとめて送受信する (クライアントが Wsnet2-Batch ヘッダで要求する)
+	batched bool
+	// compressThreshold : これより小さいメッセージは圧縮しない
+	compressThreshold int
 }
 
-func NewPeer(ctx context.Context, cli *Client, conn *websocket.Conn, lastEvSeq int) (*Peer, error) {
+func NewPeer(ctx context.Context, cli *Client, conn *websocket.Conn, lastEvSeq int, timestamped, batched bool) (*Peer, error) {
+	conf := cli.room.ClientConf()
+	policy, _ := ParseSlowPeerPolicy(conf) // Repositoryの作成時に検証済み
 	p := &Peer{
 		client: cli,
 		conn:   conn,
@@ -49,9 +112,22 @@ func NewPeer(ctx context.Context, cli *Client, conn *websocket.Conn, lastEvSeq i
 		done:     make(chan struct{}),
 		detached: make(chan struct{}),
 
-		evSeqNum: lastEvSeq,
+		outq:       make(chan outFrame, peerQueueSize(conf)),
+		connClosed: make(chan struct{}),
+		policy:     policy,
+
+		evSeqNum:    lastEvSeq,
+		timestamped: timestamped,
+		batched:     batched,
+
+		compressThreshold: conf.CompressionThreshold,
 	}
 	conn.SetCloseHandler(func(code int, text string) error { return nil }) // CloseMessageの返送はこちらで制御する
+	if conf.EnableCompression {
+		level, _ := ParseCompressionLevel(conf) // Repositoryの作成時に検証済み
+		conn.SetCompressionLevel(level)
+	}
+	go p.writeLoop()
 	err := cli.AttachPeer(p, lastEvSeq)
 	if err != nil {
 		p.closeWithMessage(websocket.CloseGoingAway, err.Error())
@@ -81,29 +157,36 @@ func (p *Peer) SendReady(lastMsgSeq int) error {
 	if p.closed {
 		return xerrors.New("peer closed")
 	}
-	p.client.logger.Infof("peer ready (%v, peer=%p): lastMsg=%v", p.client.Id, p, lastMsgSeq)
-	ev := binary.NewEvPeerReady(lastMsgSeq)
-	return writeMessage(p.conn, websocket.BinaryMessage, ev.Marshal())
+	p.client.logger.Infof("peer ready (%v, peer=%p): lastMsg=%v, timestamped=%v, batched=%v",
+		p.client.Id, p, lastMsgSeq, p.timestamped, p.batched)
+	var flags byte
+	if p.timestamped {
+		flags |= binary.PeerFlagEventTimestamp
+	}
+	if p.batched {
+		flags |= binary.PeerFlagBatch
+	}
+	ev := binary.NewEvPeerReady(lastMsgSeq, flags)
+	p.ready = true
+	if !p.enqueue(systemFrame(ev)) {
+		return xerrors.New("peer send queue is full")
+	}
+	return nil
 }
 
-// SendSystemEvent : SystemEventを送信する.
-// 送信失敗時はPeerを閉じて再接続できるようにする.
-// 個別のgoroutineで呼ばれるのでerrorは返さない. see: (*Client).SendSystemEvent()
+// SendSystemEvent : SystemEventを送信キューに積む.
+// 送信順序は問わないのでerrorは返さない. see: (*Client).SendSystemEvent()
 func (p *Peer) SendSystemEvent(ev *binary.SystemEvent) {
 	p.muWrite.Lock()
 	defer p.muWrite.Unlock()
 	if p.closed {
 		return
 	}
-	metrics.MessageSent.Add(1)
-	err := writeMessage(p.conn, websocket.BinaryMessage, ev.Marshal())
-	if err != nil {
-		p.client.logger.Warnf("peer send %v (%v, peer=%p): %+v", ev.Type(), p.client.Id, p, err)
-		p.sendCloseAndCloseConn(websocket.CloseInternalServerErr, err.Error())
-	}
+	p.enqueue(systemFrame(ev))
 }
 
-// SendEvents : evbufに蓄積されてるイベントを送信
+// SendEvents : evbufに蓄積されてるイベントを送信キューに積む.
+// payloadは他の宛先と共有し、宛先毎にはシーケンス番号を含むヘッダだけを作る.
 // 送信失敗時はPeerを閉じて再接続できるようにする. errorは返さない.
 // 再接続しても復帰不能な場合はerrorを返す（Client.EventLoopを止める）.
 func (p *Peer) SendEvents(evbuf *common.RingBuf[*binary.RegularEvent]) error {
@@ -124,22 +207,30 @@ func (p *Peer) SendEvents(evbuf *common.RingBuf[*binary.RegularEvent]) error {
 		return err
 	}
 
-	seqNum := p.evSeqNum
 	for _, ev := range evs {
-		seqNum++
-		buf := ev.Marshal(seqNum)
-		err := writeMessage(p.conn, websocket.BinaryMessage, buf)
-		if err != nil {
+		seqNum := p.evSeqNum + 1
+		if !p.enqueue(regularFrame(ev, seqNum, p.timestamped && p.ready)) {
 			// 新しいpeerで復帰できるかもしれない
-			p.client.logger.Warnf("peer send %v (%v, %p): %+v", ev.Type(), p.client.Id, p, err)
-			p.sendCloseAndCloseConn(websocket.CloseInternalServerErr, err.Error())
 			return nil
 		}
+		p.evSeqNum = seqNum
 	}
-	p.evSeqNum = seqNum
 	return nil
 }
 
+// SendMigrated : 部屋の移動先を通知してwebsocketを閉じる.
+// クライアントは移動先に再接続する.
+func (p *Peer) SendMigrated(url string) {
+	p.muWrite.Lock()
+	defer p.muWrite.Unlock()
+	if p.closed {
+		return
+	}
+	ev := binary.NewEvMigrated(url)
+	p.enqueue(systemFrame(ev))
+	p.sendCloseAndCloseConn(websocket.CloseServiceRestart, "room migrated")
+}
+
 func (p *Peer) Close(msg string) {
 	if p == nil {
 		return
@@ -176,9 +267,103 @@ func (p *Peer) sendCloseAndCloseConn(code int, msg string) {
 		return
 	}
 	p.closed = true
-	writeMessage(p.conn, websocket.CloseMessage, formatCloseMessage(code, msg))
+	data := formatCloseMessage(code, msg)
+	select {
+	case p.outq <- outFrame{typ: websocket.CloseMessage, body: data}:
+	default:
+		// 送信キューが溢れているので待たずに送る (WriteControlは並行して呼び出せる)
+		go p.conn.WriteControl(websocket.CloseMessage, data, time.Now().Add(writeTimeout))
+	}
 	// wait close message from client
-	time.AfterFunc(waitCloseTimeout, func() { p.conn.Close() })
+	time.AfterFunc(waitCloseTimeout, p.closeConn)
+}
+
+// closeConn : websocketを閉じてwriteLoopを止める
+func (p *Peer) closeConn() {
+	p.closeOnce.Do(func() {
+		p.conn.Close()
+		close(p.connClosed)
+	})
+}
+
+// enqueue : 送信キューに積む. 溢れたら遅いPeerとして処理してfalseを返す.
+// p.muWrite をロックしてから呼び出す.
+func (p *Peer) enqueue(f outFrame) bool {
+	if p.slowed {
+		return false
+	}
+	select {
+	case p.outq <- f:
+		return true
+	default:
+		p.slow()
+		return false
+	}
+}
+
+// writeLoop : 送信キューのメッセージをwebsocketに書き込む.
+// 送信キューが空のときは送信待ちの揮発性イベントを書き込む.
+// CloseMessageを書き込むか、websocketを閉じたら終わる.
+func (p *Peer) writeLoop() {
+	failed := false
+	var batch []outFrame
+	var next *outFrame
+	for {
+		var f outFrame
+		if next != nil {
+			f, next = *next, nil
+		} else {
+			select {
+			case <-p.connClosed:
+				return
+			case f = <-p.outq:
+			default:
+				select {
+				case <-p.connClosed:
+					return
+				case f = <-p.outq:
+				case <-p.client.volatile.ready:
+					if !failed {
+						if err := p.writeVolatile(); err != nil {
+							failed = true
+							p.writeFailed(err)
+						}
+					}
+					continue
+				}
+			}
+		}
+		if failed {
+			continue // 切断するので捨てる
+		}
+		var err error
+		if p.batched && f.batchable() {
+			batch, next = p.collectBatch(append(batch[:0], f))
+			size := 0
+			for i := range batch {
+				size += batch[i].hlen + len(batch[i].body)
+			}
+			p.compress(size)
+			err = writeBatch(p.conn, batch)
+		} else {
+			p.compress(f.hlen + len(f.body))
+			err = writeFrame(p.conn, &f)
+		}
+		if f.typ == websocket.CloseMessage {
+			return
+		}
+		if err != nil {
+			failed = true
+			p.writeFailed(err)
+		}
+	}
+}
+
+// writeFailed : 書き込みに失敗したwebsocketを閉じる.
+// 新しいpeerで復帰できるかもしれない.
+func (p *Peer) writeFailed(err error) {
+	p.client.logger.Warnf("peer write (%v, peer=%p): %+v", p.client.Id, p, err)
+	p.closeWithMessage(websocket.CloseInternalServerErr, err.Error())
 }
 
 func (p *Peer) MsgLoop(ctx context.Context) {
@@ -203,8 +388,10 @@ loop:
 						// 切断していないならclose messageを送ってから切断
 						p.closeWithMessage(websocket.CloseInternalServerErr, err.Error())
 					} else {
+						p.muWrite.Lock()
 						p.closed = true
-						p.conn.Close()
+						p.muWrite.Unlock()
+						p.closeConn()
 					}
 				}
 			} else if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
@@ -224,15 +411,23 @@ loop:
 			p.closeWithMessage(websocket.CloseInvalidFramePayloadData, err.Error())
 			break loop
 		}
-
-		select {
-		case <-ctx.Done():
-			break loop
-		case <-p.detached:
-			break loop
-		case <-p.client.done:
+		msgs, err := p.unbatch(msg)
+		if err != nil {
+			p.client.logger.Errorf("peer UnmarshalBatchMsgPayload (%v, %p): %+v", p.client.Id, p, err)
+			p.closeWithMessage(websocket.CloseInvalidFramePayloadData, err.Error())
 			break loop
-		case p.msgCh <- msg:
+		}
+
+		for _, msg := range msgs {
+			select {
+			case <-ctx.Done():
+				break loop
+			case <-p.detached:
+				break loop
+			case <-p.client.done:
+				break loop
+			case p.msgCh <- msg:
+			}
 		}
 	}
 
@@ -241,10 +436,80 @@ loop:
 	close(p.done)
 }
 
-func writeMessage(conn *websocket.Conn, messageType int, data []byte) error {
+// writeFrame : headとbodyを1つのメッセージとして書き込む.
+// 連結したバッファは作らず、websocketの書き込みバッファに直接コピーする.
+func writeFrame(conn *websocket.Conn, f *outFrame) error {
+	metrics.MessageSent.Add(1)
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if f.hlen == 0 {
+		return conn.WriteMessage(f.typ, f.body)
+	}
+	w, err := conn.NextWriter(f.typ)
+	if err != nil {
+		return err
+	}
+	// 書き込みエラーはwriterが保持してCloseで返す
+	w.Write(f.head[:f.hlen])
+	w.Write(f.body)
+	return w.Close()
+}
+
+// collectBatch : 送信キューに続けて積まれているRegularEventをbatchに加える.
+// まとめられないものを取り出したときはそれも返す.
+func (p *Peer) collectBatch(batch []outFrame) ([]outFrame, *outFrame) {
+	size := len(batch[0].body)
+	for size < binary.MaxBatchSize {
+		select {
+		case f := <-p.outq:
+			last := &batch[len(batch)-1]
+			if !f.batchable() || f.timestamped != last.timestamped || f.seq != last.seq+1 {
+				return batch, &f
+			}
+			batch = append(batch, f)
+			size += len(f.body)
+		default:
+			return batch, nil
+		}
+	}
+	return batch, nil
+}
+
+// writeBatch : 連続したRegularEventをEvTypeBatchにまとめて1つのメッセージとして書き込む
+func writeBatch(conn *websocket.Conn, batch []outFrame) error {
+	if len(batch) == 1 {
+		return writeFrame(conn, &batch[0])
+	}
 	metrics.MessageSent.Add(1)
 	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
-	return conn.WriteMessage(messageType, data)
+	w, err := conn.NextWriter(websocket.BinaryMessage)
+	if err != nil {
+		return err
+	}
+	// 書き込みエラーはwriterが保持してCloseで返す
+	var head [binary.TimestampedEventHeaderSize]byte
+	w.Write(binary.AppendEvBatchHeader(head[:0], batch[0].seq, batch[0].timestamped))
+	for i := range batch {
+		f := &batch[i]
+		w.Write(f.ev.AppendBatchEntryHeader(head[:0], f.timestamped))
+		w.Write(f.body)
+	}
+	return w.Close()
+}
+
+// unbatch : MsgTypeBatchを1つずつのMsgに分ける. それ以外はそのまま返す
+func (p *Peer) unbatch(msg binary.Msg) ([]binary.Msg, error) {
+	if !p.batched || msg.Type() != binary.MsgTypeBatch {
+		return []binary.Msg{msg}, nil
+	}
+	rmsgs, err := binary.UnmarshalBatchMsgPayload(msg.Payload())
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]binary.Msg, len(rmsgs))
+	for i, m := range rmsgs {
+		msgs[i] = m
+	}
+	return msgs, nil
 }
 
 func formatCloseMessage(closeCode int, text string) []byte {
@@ -0,0 +1,266 @@
+package game
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/shiguredo/websocket"
+	"go.uber.org/zap"
+
+	"wsnet2/binary"
+	"wsnet2/common"
+	"wsnet2/metrics"
+	"wsnet2/pb"
+)
+
+// benchmarkRecipients : broadcastの宛先の数
+const benchmarkRecipients = 100
+
+// newWebsocketPair : ループバックで接続したwebsocketのサーバ側とクライアント側
+func newWebsocketPair(tb testing.TB) (*websocket.Conn, *websocket.Conn) {
+	ch := make(chan *websocket.Conn, 1)
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			tb.Errorf("upgrade: %v", err)
+			return
+		}
+		ch <- conn
+	}))
+	tb.Cleanup(svr.Close)
+
+	cli, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(svr.URL, "http"), nil)
+	if err != nil {
+		tb.Fatalf("dial: %v", err)
+	}
+	conn := <-ch
+	tb.Cleanup(func() {
+		cli.Close()
+		conn.Close()
+	})
+	return conn, cli
+}
+
+func TestWriteFrame(t *testing.T) {
+	conn, cli := newWebsocketPair(t)
+
+	ev := binary.NewRegularEventAt(binary.EvTypeMessage, binary.MarshalStr8("hello"), 1700000000123)
+	sev := binary.NewEvVolatile("p1", "pos", binary.MarshalInt(1))
+	tests := []struct {
+		frame outFrame
+		want  []byte
+	}{
+		{regularFrame(ev, 1, false), ev.Marshal(1)},
+		{regularFrame(ev, 2, true), ev.MarshalWithTimestamp(2)},
+		{systemFrame(sev), sev.Marshal()},
+	}
+	for i, tc := range tests {
+		if err := writeFrame(conn, &tc.frame); err != nil {
+			t.Fatalf("writeFrame[%v]: %v", i, err)
+		}
+		_, data, err := cli.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage[%v]: %v", i, err)
+		}
+		if !reflect.DeepEqual(data, tc.want) {
+			t.Errorf("message[%v] = %v, wants %v", i, data, tc.want)
+		}
+	}
+}
+
+func TestWriteBatch(t *testing.T) {
+	conn, cli := newWebsocketPair(t)
+
+	evs := []*binary.RegularEvent{
+		binary.NewRegularEventAt(binary.EvTypeMessage, binary.MarshalStr8("a"), 1700000000001),
+		binary.NewRegularEventAt(binary.EvTypeMessage, binary.MarshalStr8("b"), 1700000000002),
+		binary.NewRegularEventAt(binary.EvTypeMessage, binary.MarshalStr8("c"), 1700000000003),
+	}
+	large := binary.NewRegularEvent(binary.EvTypeMessage, make([]byte, binary.MaxBatchEntrySize+1))
+	p := &Peer{outq: make(chan outFrame, 8)}
+	p.outq <- regularFrame(evs[1], 11, true)
+	p.outq <- regularFrame(evs[2], 12, true)
+	p.outq <- regularFrame(large, 13, true)
+
+	batch, next := p.collectBatch([]outFrame{regularFrame(evs[0], 10, true)})
+	if len(batch) != len(evs) {
+		t.Fatalf("len(batch) = %v, wants %v", len(batch), len(evs))
+	}
+	if next == nil || next.ev != large {
+		t.Fatalf("next = %v, wants large event", next)
+	}
+	if err := writeBatch(conn, batch); err != nil {
+		t.Fatalf("writeBatch: %v", err)
+	}
+
+	_, data, err := cli.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	ev, _, err := binary.UnmarshalEvent(data)
+	if err != nil {
+		t.Fatalf("UnmarshalEvent: %v", err)
+	}
+	if ev.Type() != binary.EvTypeBatch {
+		t.Fatalf("event type = %v, wants %v", ev.Type(), binary.EvTypeBatch)
+	}
+	first, got, err := binary.UnmarshalEvBatchPayload(ev.Payload())
+	if err != nil {
+		t.Fatalf("UnmarshalEvBatchPayload: %v", err)
+	}
+	if first != 10 {
+		t.Errorf("first seq = %v, wants 10", first)
+	}
+	if !reflect.DeepEqual(got, evs) {
+		t.Errorf("events = %v, wants %v", got, evs)
+	}
+}
+
+// TestResendBeforeReady : 再接続時にEvPeerReadyより前に再送するEventには時刻を付けない
+func TestResendBeforeReady(t *testing.T) {
+	r, master := newTestRoom(t, nil, nil)
+	joined, ewc := joinRoom(r, "p1")
+	if ewc != nil {
+		t.Fatalf("join p1: %v", ewc)
+	}
+	p1 := joined.Client
+	r.msgCh <- broadcastMsg(master, 1, []byte("a"))
+	r.msgCh <- broadcastMsg(master, 2, []byte("b"))
+	getRoomInfo(r)
+	resent := received(t, p1)
+
+	conn, cli := newWebsocketPair(t)
+	if _, err := NewPeer(context.Background(), p1, conn, 0, true, false); err != nil {
+		t.Fatalf("NewPeer: %+v", err)
+	}
+	r.msgCh <- broadcastMsg(master, 3, []byte("c"))
+
+	for i, ev := range resent {
+		_, data, err := cli.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage: %v", err)
+		}
+		if want := ev.Marshal(i + 1); !reflect.DeepEqual(data, want) {
+			t.Fatalf("resent event[%v] = %v, wants %v", i, data, want)
+		}
+	}
+
+	_, data, err := cli.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if ev, _, err := binary.UnmarshalEvent(data); err != nil || ev.Type() != binary.EvTypePeerReady {
+		t.Fatalf("event after resent = %v (%v), wants EvPeerReady", ev, err)
+	}
+
+	_, data, err = cli.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	ev, seq, err := binary.UnmarshalTimestampedEvent(data)
+	if err != nil {
+		t.Fatalf("UnmarshalTimestampedEvent: %v", err)
+	}
+	if seq != len(resent)+1 || ev.(*binary.RegularEvent).Timestamp() == 0 {
+		t.Fatalf("event after ready: seq=%v timestamp=%v, wants seq=%v with timestamp", seq, ev.(*binary.RegularEvent).Timestamp(), len(resent)+1)
+	}
+	if _, body, err := binary.UnmarshalEvMessage(ev.Payload()); err != nil || string(body) != "c" {
+		t.Fatalf("event after ready: %q (%v), wants \"c\"", body, err)
+	}
+}
+
+// newBenchmarkPeers : 宛先毎のevbufとPeer
+func newBenchmarkPeers(n int) ([]*common.RingBuf[*binary.RegularEvent], []*Peer) {
+	r := &Room{
+		peerStats: &metrics.PeerQueueStats{},
+		logger:    zap.NewNop().Sugar(),
+	}
+	evbufs := make([]*common.RingBuf[*binary.RegularEvent], n)
+	peers := make([]*Peer, n)
+	for i := range peers {
+		c := &Client{ClientInfo: &pb.ClientInfo{Id: "c"}, room: r, logger: r.logger}
+		evbufs[i] = common.NewRingBuf[*binary.RegularEvent](16)
+		peers[i] = &Peer{
+			client:      c,
+			outq:        make(chan outFrame, 16),
+			timestamped: true,
+			ready:       true,
+		}
+	}
+	return evbufs, peers
+}
+
+// BenchmarkSendEvents : 1つのEventを全宛先の送信キューに積む
+func BenchmarkSendEvents(b *testing.B) {
+	evbufs, peers := newBenchmarkPeers(benchmarkRecipients)
+	ev := binary.NewRegularEvent(binary.EvTypeMessage, make([]byte, 256))
+	b.ReportAllocs()
+	b.SetBytes(int64(benchmarkRecipients * len(ev.Payload())))
+	for i := 0; i < b.N; i++ {
+		for j, p := range peers {
+			evbufs[j].Write(ev)
+			p.SendEvents(evbufs[j])
+			<-p.outq
+		}
+	}
+}
+
+// BenchmarkSendEventsMarshal : 比較用. 宛先毎にEventをmarshalして送信キューに積む
+func BenchmarkSendEventsMarshal(b *testing.B) {
+	evbufs, peers := newBenchmarkPeers(benchmarkRecipients)
+	ev := binary.NewRegularEvent(binary.EvTypeMessage, make([]byte, 256))
+	b.ReportAllocs()
+	b.SetBytes(int64(benchmarkRecipients * len(ev.Payload())))
+	for i := 0; i < b.N; i++ {
+		for j, p := range peers {
+			evbufs[j].Write(ev)
+			evs, _ := evbufs[j].Read(p.evSeqNum)
+			for _, ev := range evs {
+				p.evSeqNum++
+				p.outq <- outFrame{typ: websocket.BinaryMessage, body: ev.MarshalWithTimestamp(p.evSeqNum)}
+			}
+			<-p.outq
+		}
+	}
+}
+
+func benchmarkWrite(b *testing.B, frame func(ev *binary.RegularEvent, seq int) outFrame) {
+	conn, cli := newWebsocketPair(b)
+	go func() {
+		for {
+			if _, _, err := cli.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ev := binary.NewRegularEvent(binary.EvTypeMessage, make([]byte, 256))
+	b.ReportAllocs()
+	b.SetBytes(int64(benchmarkRecipients * len(ev.Payload())))
+	for i := 0; i < b.N; i++ {
+		for seq := 0; seq < benchmarkRecipients; seq++ {
+			f := frame(ev, seq)
+			if err := writeFrame(conn, &f); err != nil {
+				b.Fatalf("writeFrame: %v", err)
+			}
+		}
+	}
+}
+
+// BenchmarkWriteFrame : 宛先毎のヘッダと共有のpayloadを書き込む
+func BenchmarkWriteFrame(b *testing.B) {
+	benchmarkWrite(b, func(ev *binary.RegularEvent, seq int) outFrame {
+		return regularFrame(ev, seq, true)
+	})
+}
+
+// BenchmarkWriteFrameMarshal : 比較用. 宛先毎にmarshalしたEventを書き込む
+func BenchmarkWriteFrameMarshal(b *testing.B) {
+	benchmarkWrite(b, func(ev *binary.RegularEvent, seq int) outFrame {
+		return outFrame{typ: websocket.BinaryMessage, body: ev.MarshalWithTimestamp(seq)}
+	})
+}
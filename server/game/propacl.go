@@ -0,0 +1,160 @@
+package game
+
+import (
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"wsnet2/binary"
+)
+
+// 部屋プロパティのキー毎の書き込み権限
+//
+// RoomOption.PublicPropAcl/PrivatePropAcl でキー毎に MsgRoomProp で書き込めるクライアントを指定する.
+// 指定のないキーはMasterだけが書き込める.
+// Master以外のPlayerは、書き込めるキーだけを含み、部屋の設定（visible等）を変えない MsgRoomProp を送れる.
+// 書き込めないキーが一つでも含まれていれば、メッセージ全体を EvPermissionDenied で拒否する.
+//
+// PropAclOwner のキーは、最初に書き込んだPlayerが所有者になり、以降は所有者だけが書き込める.
+// キーを削除するか所有者が退室すると、所有者はいなくなる.
+
+// PropAcl : 部屋プロパティのキーの書き込み権限
+type PropAcl uint32
+
+const (
+	// PropAclMaster : Masterのみ (デフォルト)
+	PropAclMaster PropAcl = iota
+	// PropAclOwner : 最初に書き込んだPlayer (所有者) のみ
+	PropAclOwner
+	// PropAclPlayer : 全てのPlayer
+	PropAclPlayer
+	// PropAclReadOnly : 書き込み不可 (部屋作成時の値のまま)
+	PropAclReadOnly
+)
+
+// propAcls : キー毎の書き込み権限と所有者
+type propAcls struct {
+	acls   map[string]PropAcl
+	owners map[string]ClientID
+}
+
+// newDefaultPropAcls : 全てのキーがPropAclMaster
+func newDefaultPropAcls() *propAcls {
+	return &propAcls{
+		acls:   make(map[string]PropAcl),
+		owners: make(map[string]ClientID),
+	}
+}
+
+func newPropAcls(acls map[string]uint32) (*propAcls, error) {
+	pa := newDefaultPropAcls()
+	for k, v := range acls {
+		if PropAcl(v) > PropAclReadOnly {
+			return nil, xerrors.Errorf("invalid acl of %q: %v", k, v)
+		}
+		pa.acls[k] = PropAcl(v)
+	}
+	return pa, nil
+}
+
+// writable : sender が modified の全てのキーを書き込めるか
+func (pa *propAcls) writable(modified binary.Dict, sender, master ClientID) bool {
+	for k := range modified {
+		switch pa.acls[k] {
+		case PropAclMaster:
+			if sender != master {
+				return false
+			}
+		case PropAclOwner:
+			if o, ok := pa.owners[k]; ok && o != sender {
+				return false
+			}
+		case PropAclPlayer:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// updateOwners : 書き込まれたPropAclOwnerのキーの所有者を更新する
+func (pa *propAcls) updateOwners(modified binary.Dict, sender ClientID) {
+	for k, v := range modified {
+		if pa.acls[k] != PropAclOwner {
+			continue
+		}
+		if len(v) == 0 {
+			delete(pa.owners, k)
+		} else if _, ok := pa.owners[k]; !ok {
+			pa.owners[k] = sender
+		}
+	}
+}
+
+// release : id が所有するキーを手放す
+func (pa *propAcls) release(id ClientID) {
+	for k, o := range pa.owners {
+		if o == id {
+			delete(pa.owners, k)
+		}
+	}
+}
+
+func (pa *propAcls) aclSnapshot() map[string]uint32 {
+	acls := make(map[string]uint32, len(pa.acls))
+	for k, v := range pa.acls {
+		acls[k] = uint32(v)
+	}
+	return acls
+}
+
+func (pa *propAcls) ownersSnapshot() map[string]string {
+	owners := make(map[string]string, len(pa.owners))
+	for k, v := range pa.owners {
+		owners[k] = string(v)
+	}
+	return owners
+}
+
+func (pa *propAcls) restoreOwners(owners map[string]string) {
+	for k, v := range owners {
+		pa.owners[k] = ClientID(v)
+	}
+}
+
+// initPropAcls : RoomOptionの書き込み権限を設定する
+func (r *Room) initPropAcls(public, private map[string]uint32) error {
+	var err error
+	if r.publicAcls, err = newPropAcls(public); err != nil {
+		return xerrors.Errorf("public: %w", err)
+	}
+	if r.privateAcls, err = newPropAcls(private); err != nil {
+		return xerrors.Errorf("private: %w", err)
+	}
+	return nil
+}
+
+// releasePropOwners : 退室したPlayerが所有するキーを手放す
+func (r *Room) releasePropOwners(id ClientID) {
+	r.publicAcls.release(id)
+	r.privateAcls.release(id)
+}
+
+// checkRoomPropAcl : 部屋プロパティの変更を sender が行えるか
+func (r *Room) checkRoomPropAcl(msg *MsgRoomProp) bool {
+	sender := msg.SenderID()
+	master := r.masterID()
+	if sender != master {
+		// Master以外は部屋の設定を変えられない
+		if !msg.Sender.isPlayer || (len(msg.PublicProps) == 0 && len(msg.PrivateProps) == 0) {
+			return false
+		}
+		if msg.Visible != r.Visible || msg.Joinable != r.Joinable || msg.Watchable != r.Watchable ||
+			msg.SearchGroup != r.SearchGroup || msg.MaxPlayer != r.MaxPlayers ||
+			(msg.ClientDeadline != 0 && msg.ClientDeadline != uint32(r.deadline/time.Second)) {
+			return false
+		}
+	}
+	return r.publicAcls.writable(msg.PublicProps, sender, master) &&
+		r.privateAcls.writable(msg.PrivateProps, sender, master)
+}
@@ -0,0 +1,65 @@
+package game
+
+import (
+	"testing"
+
+	"wsnet2/binary"
+)
+
+func TestPropAcls(t *testing.T) {
+	if _, err := newPropAcls(map[string]uint32{"bad": 4}); err == nil {
+		t.Fatalf("invalid acl accepted")
+	}
+	pa, err := newPropAcls(map[string]uint32{
+		"seat":  uint32(PropAclOwner),
+		"chat":  uint32(PropAclPlayer),
+		"rule":  uint32(PropAclReadOnly),
+		"score": uint32(PropAclMaster),
+	})
+	if err != nil {
+		t.Fatalf("newPropAcls: %v", err)
+	}
+	val := binary.MarshalInt(1)
+
+	tests := map[string]struct {
+		keys   []string
+		sender ClientID
+		want   bool
+	}{
+		"player":             {[]string{"chat"}, "p1", true},
+		"master":             {[]string{"score", "chat"}, "master", true},
+		"master by player":   {[]string{"score"}, "p1", false},
+		"no acl":             {[]string{"other"}, "p1", false},
+		"no acl by master":   {[]string{"other"}, "master", true},
+		"read only":          {[]string{"rule"}, "master", false},
+		"unowned":            {[]string{"seat"}, "p1", true},
+		"partially writable": {[]string{"chat", "score"}, "p1", false},
+	}
+	for name, test := range tests {
+		modified := binary.Dict{}
+		for _, k := range test.keys {
+			modified[k] = val
+		}
+		if got := pa.writable(modified, test.sender, "master"); got != test.want {
+			t.Errorf("%v: writable = %v, wants %v", name, got, test.want)
+		}
+	}
+
+	seat := binary.Dict{"seat": val}
+	pa.updateOwners(seat, "p1")
+	if pa.writable(seat, "p2", "master") || pa.writable(seat, "master", "master") {
+		t.Errorf("seat owned by p1 is writable by others")
+	}
+	if !pa.writable(seat, "p1", "master") {
+		t.Errorf("seat is not writable by the owner")
+	}
+	pa.release("p1")
+	if !pa.writable(seat, "p2", "master") {
+		t.Errorf("seat released by p1 is not writable")
+	}
+	pa.updateOwners(seat, "p2")
+	pa.updateOwners(binary.Dict{"seat": {}}, "p2")
+	if _, ok := pa.owners["seat"]; ok {
+		t.Errorf("owner remains after delete: %v", pa.owners)
+	}
+}
@@ -0,0 +1,97 @@
+package game
+
+import (
+	"bytes"
+	"maps"
+
+	"wsnet2/binary"
+	"wsnet2/pb"
+)
+
+// プロパティのバージョン
+//
+// 部屋のpublic/privateプロパティとクライアントのプロパティは、キー毎にバージョンを持つ.
+// バージョンは部屋全体で単調増加する値で、キーを変更したときのものになる.
+// 部屋作成時や入室時に設定されたキーのバージョンは1、存在しないキーは0とする.
+//
+// 入室時には JoinedInfo でその時点のバージョンを返し、途中から入室したクライアントも条件を指定できるようにする.
+//
+// MsgRoomProp/MsgClientProp に条件（期待する現在の値かバージョン）が指定されていれば、
+// 全て一致したときだけ変更を適用する. 一致しなければ EvPropConflict で現在の値とバージョンを返す.
+
+const (
+	// initialPropVersion : 部屋作成時や入室時に設定されたキーのバージョン
+	initialPropVersion = 1
+)
+
+// propVersion : キーの現在のバージョン
+func propVersion(props binary.Dict, versions map[string]uint64, key string) uint64 {
+	if v, ok := versions[key]; ok {
+		return v
+	}
+	if _, ok := props[key]; ok {
+		return initialPropVersion
+	}
+	return 0
+}
+
+// checkPropCondition : 条件に合わないキーの現在の値とバージョンを返す. 全て合えばnil.
+func checkPropCondition(props binary.Dict, versions map[string]uint64, cond *binary.VersionedProps) *binary.VersionedProps {
+	if cond.IsEmpty() {
+		return nil
+	}
+	conflict := &binary.VersionedProps{
+		Props:    make(binary.Dict),
+		Versions: make(map[string]uint64),
+	}
+	add := func(k string) {
+		conflict.Props[k] = props[k]
+		conflict.Versions[k] = propVersion(props, versions, k)
+	}
+	for k, v := range cond.Props {
+		if !bytes.Equal(props[k], v) {
+			add(k)
+		}
+	}
+	for k, v := range cond.Versions {
+		if propVersion(props, versions, k) != v {
+			add(k)
+		}
+	}
+	if len(conflict.Versions) == 0 {
+		return nil
+	}
+	return conflict
+}
+
+// applyProps : 変更をpropsに適用し、変更したキーのバージョンを ver にする.
+// 空の値はキーの削除を表す.
+func applyProps(props binary.Dict, versions map[string]uint64, modified binary.Dict, ver uint64) {
+	for k, v := range modified {
+		if _, ok := props[k]; ok && len(v) == 0 {
+			delete(props, k)
+			delete(versions, k)
+		} else {
+			props[k] = v
+			versions[k] = ver
+		}
+	}
+}
+
+// withPropVersions : 入室時に返す情報に現在のバージョンを設定する.
+// JoinedInfo は別のgoroutineで使われるのでコピーを渡す.
+func (r *Room) withPropVersions(joined *JoinedInfo) *JoinedInfo {
+	joined.PublicPropVersions = maps.Clone(r.publicVersions)
+	joined.PrivatePropVersions = maps.Clone(r.privateVersions)
+	joined.PlayerPropVersions = make(map[string]*pb.PropVersions, len(r.players))
+	for id, c := range r.players {
+		joined.PlayerPropVersions[string(id)] = &pb.PropVersions{Versions: maps.Clone(c.propVersions)}
+	}
+	return joined
+}
+
+// nextPropVersion : 変更したキーに付けるバージョン
+func (r *Room) nextPropVersion() uint64 {
+	r.propVersion++
+	return r.propVersion
+}
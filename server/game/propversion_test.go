@@ -0,0 +1,96 @@
+package game
+
+import (
+	"reflect"
+	"testing"
+
+	"wsnet2/binary"
+	"wsnet2/pb"
+)
+
+func TestPropCondition(t *testing.T) {
+	props := binary.Dict{
+		"seat1": binary.MarshalStr8("player1"),
+		"item":  binary.MarshalInt(3),
+		"init":  binary.MarshalBool(true),
+	}
+	versions := map[string]uint64{"seat1": 4, "item": 7}
+
+	tests := map[string]struct {
+		cond *binary.VersionedProps
+		keys []string
+	}{
+		"none": {nil, nil},
+		"match": {&binary.VersionedProps{
+			Props:    binary.Dict{"seat1": binary.MarshalStr8("player1"), "seat2": {}},
+			Versions: map[string]uint64{"item": 7, "init": initialPropVersion, "absent": 0},
+		}, nil},
+		"value": {&binary.VersionedProps{
+			Props: binary.Dict{"seat1": {}, "seat2": {}},
+		}, []string{"seat1"}},
+		"version": {&binary.VersionedProps{
+			Versions: map[string]uint64{"item": 6, "absent": 1},
+		}, []string{"item", "absent"}},
+	}
+	for name, test := range tests {
+		conflict := checkPropCondition(props, versions, test.cond)
+		if test.keys == nil {
+			if conflict != nil {
+				t.Errorf("%v: conflict %v, wants nil", name, conflict)
+			}
+			continue
+		}
+		if conflict == nil || len(conflict.Versions) != len(test.keys) {
+			t.Fatalf("%v: conflict %v, wants %v", name, conflict, test.keys)
+		}
+		for _, k := range test.keys {
+			if conflict.Versions[k] != propVersion(props, versions, k) || !reflect.DeepEqual(conflict.Props[k], props[k]) {
+				t.Errorf("%v: conflict[%v] = (%v, %v), wants current value", name, k, conflict.Props[k], conflict.Versions[k])
+			}
+		}
+	}
+
+	applyProps(props, versions, binary.Dict{"seat1": {}, "seat2": binary.MarshalStr8("player2")}, 8)
+	if _, ok := props["seat1"]; ok || propVersion(props, versions, "seat1") != 0 {
+		t.Errorf("seat1 is not deleted: %v", versions)
+	}
+	if propVersion(props, versions, "seat2") != 8 {
+		t.Errorf("seat2 version = %v, wants 8", versions["seat2"])
+	}
+}
+
+func TestJoinedPropVersions(t *testing.T) {
+	r, master := newTestRoom(t, &pb.RoomOption{MaxPlayers: 4}, nil)
+
+	payload := binary.MarshalRoomPropPayload(true, true, true, 0, 4, 0,
+		binary.Dict{"pub": binary.MarshalInt(1)}, binary.Dict{"priv": binary.MarshalInt(2)})
+	msg, err := msgRoomProp(master, binary.NewRegularMsg(binary.MsgTypeRoomProp, 1, payload))
+	if err != nil {
+		t.Fatalf("msgRoomProp: %v", err)
+	}
+	r.msgCh <- msg
+	payload = binary.MarshalDict(binary.Dict{"seat": binary.MarshalInt(3)})
+	msg, err = msgClientProp(master, binary.NewRegularMsg(binary.MsgTypeClientProp, 2, payload))
+	if err != nil {
+		t.Fatalf("msgClientProp: %v", err)
+	}
+	r.msgCh <- msg
+
+	// 後から入室したクライアントも変更済みのキーのバージョンを知ることができる
+	joined, ewc := joinRoom(r, "p1")
+	if ewc != nil {
+		t.Fatalf("join p1: %v", ewc)
+	}
+	if want := map[string]uint64{"pub": 2}; !reflect.DeepEqual(joined.PublicPropVersions, want) {
+		t.Errorf("public versions = %v, wants %v", joined.PublicPropVersions, want)
+	}
+	if want := map[string]uint64{"priv": 2}; !reflect.DeepEqual(joined.PrivatePropVersions, want) {
+		t.Errorf("private versions = %v, wants %v", joined.PrivatePropVersions, want)
+	}
+	if want := map[string]uint64{"seat": 3}; !reflect.DeepEqual(joined.PlayerPropVersions["master"].GetVersions(), want) {
+		t.Errorf("master versions = %v, wants %v", joined.PlayerPropVersions["master"], want)
+	}
+	if vers, ok := joined.PlayerPropVersions["p1"]; !ok || len(vers.Versions) != 0 {
+		t.Errorf("p1 versions = %v, wants empty", vers)
+	}
+}
@@ -0,0 +1,95 @@
+package game
+
+import (
+	crand "crypto/rand"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/xerrors"
+
+	"wsnet2/binary"
+)
+
+// サーバの乱数
+//
+// Playerが MsgRandom で要求した乱数（0以上max未満の値をcount個、または0からcount-1の順列）を
+// サーバの crypto/rand で生成し、EvRandom で要求者を含む全員に送る. 全員が同じ結果を受け取るので、
+// Masterを含むどのクライアントも結果を操作できない.
+// 生成した乱数は後から調べられるよう player_log に記録する（記録が有効な部屋では記録ファイルにも残る）.
+
+const (
+	// randomMaxCount : 1回の要求で生成できる乱数の数
+	randomMaxCount = 256
+)
+
+// generateRandom : 乱数を生成する
+func generateRandom(kind binary.RandomKind, count, max uint32) ([]int, error) {
+	if count == 0 || count > randomMaxCount {
+		return nil, xerrors.Errorf("invalid count: %v (max=%v)", count, randomMaxCount)
+	}
+
+	switch kind {
+	case binary.RandomValues:
+		if max == 0 {
+			return nil, xerrors.Errorf("invalid max: %v", max)
+		}
+		vals := make([]int, count)
+		for i := range vals {
+			n, err := randInt(int64(max))
+			if err != nil {
+				return nil, err
+			}
+			vals[i] = n
+		}
+		return vals, nil
+
+	case binary.RandomShuffle:
+		vals := make([]int, count)
+		for i := range vals {
+			vals[i] = i
+		}
+		// Fisher-Yates
+		for i := len(vals) - 1; i > 0; i-- {
+			j, err := randInt(int64(i + 1))
+			if err != nil {
+				return nil, err
+			}
+			vals[i], vals[j] = vals[j], vals[i]
+		}
+		return vals, nil
+	}
+
+	return nil, xerrors.Errorf("unknown kind: %v", kind)
+}
+
+// randInt : 0以上n未満の乱数
+func randInt(n int64) (int, error) {
+	v, err := crand.Int(crand.Reader, big.NewInt(n))
+	if err != nil {
+		return 0, xerrors.Errorf("crypto/rand: %w", err)
+	}
+	return int(v.Int64()), nil
+}
+
+func (r *Room) msgRandom(msg *MsgRandom) {
+	r.muClients.RLock()
+	defer r.muClients.RUnlock()
+
+	if !msg.Sender.isPlayer || r.players[msg.SenderID()] != msg.Sender {
+		msg.Sender.logger.Warnf("random from non-player: %v", msg.Sender.Id)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+
+	vals, err := generateRandom(msg.Kind, msg.Count, msg.Max)
+	if err != nil {
+		msg.Sender.logger.Infof("random rejected: %v", err)
+		r.sendTo(msg.Sender, binary.NewEvRejected(msg))
+		return
+	}
+
+	detail := fmt.Sprintf("tag=%q kind=%v count=%v max=%v values=%v", msg.Tag, msg.Kind, msg.Count, msg.Max, vals)
+	msg.Sender.logger.Infof("random: %v", detail)
+	r.repo.PlayerLogWithDetail(msg.Sender, PlayerLogRandom, detail)
+	r.broadcast(binary.NewEvRandom(string(msg.SenderID()), msg.Tag, msg.Kind, vals))
+}
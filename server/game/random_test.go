@@ -0,0 +1,50 @@
+package game
+
+import (
+	"sort"
+	"testing"
+
+	"wsnet2/binary"
+)
+
+func TestGenerateRandom(t *testing.T) {
+	vals, err := generateRandom(binary.RandomValues, 100, 6)
+	if err != nil {
+		t.Fatalf("values: %v", err)
+	}
+	if len(vals) != 100 {
+		t.Fatalf("values: len=%v, wants 100", len(vals))
+	}
+	for _, v := range vals {
+		if v < 0 || v >= 6 {
+			t.Fatalf("value out of range: %v", v)
+		}
+	}
+
+	vals, err = generateRandom(binary.RandomShuffle, 52, 0)
+	if err != nil {
+		t.Fatalf("shuffle: %v", err)
+	}
+	sort.Ints(vals)
+	for i, v := range vals {
+		if v != i {
+			t.Fatalf("not a permutation: %v", vals)
+		}
+	}
+
+	tests := map[string]struct {
+		kind  binary.RandomKind
+		count uint32
+		max   uint32
+	}{
+		"no count": {binary.RandomValues, 0, 6},
+		"too many": {binary.RandomShuffle, randomMaxCount + 1, 0},
+		"no max":   {binary.RandomValues, 1, 0},
+		"bad kind": {binary.RandomKind(9), 1, 6},
+	}
+	for k, tc := range tests {
+		if _, err := generateRandom(tc.kind, tc.count, tc.max); err == nil {
+			t.Errorf("%v: no error", k)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package game
+
+import (
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"wsnet2/binary"
+	"wsnet2/config"
+	"wsnet2/pb"
+)
+
+// RateLimitPolicy : 送信レート制限を超えたメッセージの処理
+type RateLimitPolicy int
+
+const (
+	// RateLimitDrop : メッセージを破棄する
+	RateLimitDrop RateLimitPolicy = iota
+	// RateLimitWarn : メッセージを破棄し、送信者にEvRateLimitedを返す
+	RateLimitWarn
+	// RateLimitKick : 送信者を退室させる
+	RateLimitKick
+)
+
+var rateLimitPolicies = map[string]RateLimitPolicy{
+	"":     RateLimitDrop,
+	"drop": RateLimitDrop,
+	"warn": RateLimitWarn,
+	"kick": RateLimitKick,
+}
+
+// MsgRateLimits : クライアントからのメッセージの送信レート制限
+type MsgRateLimits struct {
+	Limits map[binary.MsgType]config.RateLimit
+	Policy RateLimitPolicy
+}
+
+// NewMsgRateLimits : 設定ファイルのレート制限.
+// MsgTypeはprefixを除いた名前 ("Broadcast"など) で指定する.
+func NewMsgRateLimits(conf *config.ClientConf) (*MsgRateLimits, error) {
+	policy, ok := rateLimitPolicies[conf.MsgRateLimitPolicy]
+	if !ok {
+		return nil, xerrors.Errorf("invalid msg_rate_limit_policy: %q", conf.MsgRateLimitPolicy)
+	}
+
+	limits := make(map[binary.MsgType]config.RateLimit, len(conf.MsgRateLimits))
+	for name, l := range conf.MsgRateLimits {
+		t, ok := msgTypeByName(name)
+		if !ok {
+			return nil, xerrors.Errorf("invalid msg_rate_limits: unknown msg type %q", name)
+		}
+		if l.Rate < 0 || l.Burst < 0 {
+			return nil, xerrors.Errorf("invalid msg_rate_limits: %v: %+v", name, l)
+		}
+		limits[t] = l
+	}
+
+	return &MsgRateLimits{
+		Limits: limits,
+		Policy: policy,
+	}, nil
+}
+
+// With : RoomOption.RateLimits で上書きしたレート制限
+func (l *MsgRateLimits) With(op map[uint32]*pb.RateLimit) (*MsgRateLimits, error) {
+	if len(op) == 0 {
+		return l, nil
+	}
+	if l == nil {
+		l = &MsgRateLimits{}
+	}
+
+	limits := make(map[binary.MsgType]config.RateLimit, len(l.Limits)+len(op))
+	for t, rl := range l.Limits {
+		limits[t] = rl
+	}
+	for t, rl := range op {
+		if t > 0xff || strings.HasPrefix(binary.MsgType(t).String(), "MsgType(") {
+			return nil, xerrors.Errorf("unknown msg type: %v", t)
+		}
+		limits[binary.MsgType(t)] = config.RateLimit{
+			Rate:  int(rl.GetRate()),
+			Burst: int(rl.GetBurst()),
+		}
+	}
+
+	return &MsgRateLimits{
+		Limits: limits,
+		Policy: l.Policy,
+	}, nil
+}
+
+func msgTypeByName(name string) (binary.MsgType, bool) {
+	for t := 0; t <= 0xff; t++ {
+		if binary.MsgType(t).String() == "MsgType"+name {
+			return binary.MsgType(t), true
+		}
+	}
+	return 0, false
+}
+
+// tokenBucket : rate個/秒でトークンを補充し、最大burst個まで貯める
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// rateLimiter : クライアント毎のレート制限.
+// Client.MsgLoopからのみ使う.
+type rateLimiter struct {
+	buckets map[binary.MsgType]*tokenBucket
+	policy  RateLimitPolicy
+}
+
+// newRateLimiter : 制限がなければnilを返す
+func newRateLimiter(l *MsgRateLimits, now time.Time) *rateLimiter {
+	if l == nil {
+		return nil
+	}
+	buckets := make(map[binary.MsgType]*tokenBucket)
+	for t, rl := range l.Limits {
+		if rl.Rate <= 0 {
+			continue
+		}
+		burst := rl.Burst
+		if burst <= 0 {
+			burst = rl.Rate
+		}
+		buckets[t] = &tokenBucket{
+			rate:   float64(rl.Rate),
+			burst:  float64(burst),
+			tokens: float64(burst),
+			last:   now,
+		}
+	}
+	if len(buckets) == 0 {
+		return nil
+	}
+	return &rateLimiter{
+		buckets: buckets,
+		policy:  l.Policy,
+	}
+}
+
+func (l *rateLimiter) allow(t binary.MsgType, now time.Time) bool {
+	b, ok := l.buckets[t]
+	if !ok {
+		return true
+	}
+	return b.allow(now)
+}
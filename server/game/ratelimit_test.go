@@ -0,0 +1,91 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"wsnet2/binary"
+	"wsnet2/config"
+	"wsnet2/pb"
+)
+
+func TestNewMsgRateLimits(t *testing.T) {
+	conf := &config.ClientConf{
+		MsgRateLimits: map[string]config.RateLimit{
+			"Broadcast": {Rate: 10, Burst: 20},
+			"Targets":   {Rate: 5},
+		},
+		MsgRateLimitPolicy: "kick",
+	}
+	l, err := NewMsgRateLimits(conf)
+	if err != nil {
+		t.Fatalf("NewMsgRateLimits: %+v", err)
+	}
+	if l.Policy != RateLimitKick {
+		t.Fatalf("Policy = %v, wants %v", l.Policy, RateLimitKick)
+	}
+	if got := l.Limits[binary.MsgTypeBroadcast]; got != (config.RateLimit{Rate: 10, Burst: 20}) {
+		t.Fatalf("Limits[Broadcast] = %+v", got)
+	}
+
+	l2, err := l.With(map[uint32]*pb.RateLimit{
+		uint32(binary.MsgTypeBroadcast): {Rate: 1},
+	})
+	if err != nil {
+		t.Fatalf("With: %+v", err)
+	}
+	if got := l2.Limits[binary.MsgTypeBroadcast]; got != (config.RateLimit{Rate: 1}) {
+		t.Fatalf("Limits[Broadcast] = %+v", got)
+	}
+	if got := l.Limits[binary.MsgTypeBroadcast]; got != (config.RateLimit{Rate: 10, Burst: 20}) {
+		t.Fatalf("original Limits[Broadcast] modified: %+v", got)
+	}
+
+	if _, err := l.With(map[uint32]*pb.RateLimit{250: {Rate: 1}}); err == nil {
+		t.Fatalf("With must fail for unknown msg type")
+	}
+
+	conf.MsgRateLimits["Unknown"] = config.RateLimit{Rate: 1}
+	if _, err := NewMsgRateLimits(conf); err == nil {
+		t.Fatalf("NewMsgRateLimits must fail for unknown msg type")
+	}
+	delete(conf.MsgRateLimits, "Unknown")
+	conf.MsgRateLimitPolicy = "ban"
+	if _, err := NewMsgRateLimits(conf); err == nil {
+		t.Fatalf("NewMsgRateLimits must fail for unknown policy")
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	l := newRateLimiter(&MsgRateLimits{
+		Limits: map[binary.MsgType]config.RateLimit{
+			binary.MsgTypeBroadcast: {Rate: 2, Burst: 3},
+			binary.MsgTypeTargets:   {Rate: 0},
+		},
+	}, now)
+
+	for i := 0; i < 3; i++ {
+		if !l.allow(binary.MsgTypeBroadcast, now) {
+			t.Fatalf("allow[%v] = false, wants true", i)
+		}
+	}
+	if l.allow(binary.MsgTypeBroadcast, now) {
+		t.Fatalf("allow after burst = true, wants false")
+	}
+	if !l.allow(binary.MsgTypeTargets, now) {
+		t.Fatalf("unlimited msg type must be allowed")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if !l.allow(binary.MsgTypeBroadcast, now) {
+		t.Fatalf("allow after refill = false, wants true")
+	}
+	if l.allow(binary.MsgTypeBroadcast, now) {
+		t.Fatalf("allow = true, wants false")
+	}
+
+	if newRateLimiter(&MsgRateLimits{}, now) != nil {
+		t.Fatalf("newRateLimiter without limits must be nil")
+	}
+}
@@ -0,0 +1,242 @@
+package game
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/xerrors"
+	"google.golang.org/protobuf/proto"
+
+	wsbinary "wsnet2/binary"
+	"wsnet2/pb"
+)
+
+// 部屋の記録ファイル
+//
+// file format:
+// | "WSR1" | record ... |
+//
+// record format:
+// | 64bit-be unixtime(nanosec) | 8bit type | 32bit-be length | body |
+//
+// 最初のrecordは type=0 で、bodyは入室時点の部屋情報 (pb.JoinedRoomRes).
+// 以降のrecordは broadcast された RegularEvent で、typeはEvType、bodyはpayload.
+//
+// 部屋の移動やスナップショットからの復元では、同じファイルに続けて記録する (AppendRecorder).
+// 移動先は記録ディレクトリを共有している必要がある.
+const (
+	recordMagic = "WSR1"
+
+	recordTypeRoom = 0
+
+	recordHeaderLen = 8 + 1 + 4
+
+	// RecordFileExt : 記録ファイルの拡張子
+	RecordFileExt = ".wsrec"
+)
+
+// RecordPath : 記録ファイルのパス
+func RecordPath(dir string, appId pb.AppId, roomId RoomID) string {
+	return filepath.Join(dir, appId, string(roomId)+RecordFileExt)
+}
+
+// Recorder : broadcastされたEventを記録ファイルに追記する.
+// RoomのMsgLoopから呼ばれる.
+type Recorder struct {
+	file *os.File
+	w    *bufio.Writer
+	buf  [recordHeaderLen]byte
+}
+
+// NewRecorder : 記録ファイルを作成し、部屋情報を書き込む.
+func NewRecorder(path string, room *pb.JoinedRoomRes) (*Recorder, error) {
+	body, err := proto.Marshal(room)
+	if err != nil {
+		return nil, xerrors.Errorf("marshal room: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, xerrors.Errorf("mkdir: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, xerrors.Errorf("open: %w", err)
+	}
+
+	rec := &Recorder{
+		file: f,
+		w:    bufio.NewWriter(f),
+	}
+	if _, err := rec.w.WriteString(recordMagic); err != nil {
+		f.Close()
+		return nil, xerrors.Errorf("write magic: %w", err)
+	}
+	if err := rec.write(time.Now(), recordTypeRoom, body); err != nil {
+		f.Close()
+		return nil, xerrors.Errorf("write room: %w", err)
+	}
+	return rec, nil
+}
+
+// AppendRecorder : 既存の記録ファイルの末尾に追記する.
+// ファイルが無ければ NewRecorder と同じく作成する.
+// 書き込み途中で終了したファイルは、最後の完全なrecordの後ろで切り詰める.
+func AppendRecorder(path string, room *pb.JoinedRoomRes) (*Recorder, error) {
+	rr, _, _, err := OpenRecord(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return NewRecorder(path, room)
+		}
+		return nil, xerrors.Errorf("OpenRecord: %w", err)
+	}
+	for {
+		_, _, _, err := rr.read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			rr.Close()
+			return nil, xerrors.Errorf("read: %w", err)
+		}
+	}
+	size := rr.off
+	rr.Close()
+
+	f, err := os.OpenFile(path, os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, xerrors.Errorf("open: %w", err)
+	}
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return nil, xerrors.Errorf("truncate: %w", err)
+	}
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		f.Close()
+		return nil, xerrors.Errorf("seek: %w", err)
+	}
+	return &Recorder{
+		file: f,
+		w:    bufio.NewWriter(f),
+	}, nil
+}
+
+// Record : Eventを記録する
+func (rec *Recorder) Record(ev *wsbinary.RegularEvent) error {
+	return rec.write(time.Now(), byte(ev.Type()), ev.Payload())
+}
+
+func (rec *Recorder) write(t time.Time, typ byte, body []byte) error {
+	binary.BigEndian.PutUint64(rec.buf[0:], uint64(t.UnixNano()))
+	rec.buf[8] = typ
+	binary.BigEndian.PutUint32(rec.buf[9:], uint32(len(body)))
+	if _, err := rec.w.Write(rec.buf[:]); err != nil {
+		return err
+	}
+	_, err := rec.w.Write(body)
+	return err
+}
+
+// Flush : バッファをファイルに書き出す
+func (rec *Recorder) Flush() error {
+	return rec.w.Flush()
+}
+
+// Close : バッファを書き出してファイルを閉じる
+func (rec *Recorder) Close() error {
+	err := rec.w.Flush()
+	if e := rec.file.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// RecordReader : 記録ファイルを先頭から読み出す
+type RecordReader struct {
+	file *os.File
+	r    *bufio.Reader
+	buf  [recordHeaderLen]byte
+
+	// off : 読み終えた完全なrecordの終端の位置
+	off int64
+}
+
+// OpenRecord : 記録ファイルを開き、記録開始時の部屋情報とその時刻を返す.
+func OpenRecord(path string) (*RecordReader, *pb.JoinedRoomRes, time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, time.Time{}, xerrors.Errorf("open: %w", err)
+	}
+	rr := &RecordReader{
+		file: f,
+		r:    bufio.NewReader(f),
+	}
+
+	var magic [len(recordMagic)]byte
+	if _, err := io.ReadFull(rr.r, magic[:]); err != nil {
+		f.Close()
+		return nil, nil, time.Time{}, xerrors.Errorf("read magic: %w", err)
+	}
+	if string(magic[:]) != recordMagic {
+		f.Close()
+		return nil, nil, time.Time{}, xerrors.Errorf("invalid record file: magic=%q", magic)
+	}
+	rr.off = int64(len(magic))
+
+	t, typ, body, err := rr.read()
+	if err != nil {
+		f.Close()
+		return nil, nil, time.Time{}, xerrors.Errorf("read room: %w", err)
+	}
+	if typ != recordTypeRoom {
+		f.Close()
+		return nil, nil, time.Time{}, xerrors.Errorf("invalid record file: first record type=%v", typ)
+	}
+	var room pb.JoinedRoomRes
+	if err := proto.Unmarshal(body, &room); err != nil {
+		f.Close()
+		return nil, nil, time.Time{}, xerrors.Errorf("unmarshal room: %w", err)
+	}
+
+	return rr, &room, t, nil
+}
+
+// Next : 次のEventとその記録時刻を返す. 終端ではio.EOFを返す.
+func (rr *RecordReader) Next() (time.Time, *wsbinary.RegularEvent, error) {
+	t, typ, body, err := rr.read()
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+	return t, wsbinary.NewRegularEventAt(wsbinary.EvType(typ), body, uint64(t.UnixMilli())), nil
+}
+
+func (rr *RecordReader) read() (time.Time, byte, []byte, error) {
+	if _, err := io.ReadFull(rr.r, rr.buf[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			// 書き込み途中で終了したファイル
+			err = io.EOF
+		}
+		return time.Time{}, 0, nil, err
+	}
+	t := time.Unix(0, int64(binary.BigEndian.Uint64(rr.buf[0:])))
+	typ := rr.buf[8]
+	body := make([]byte, binary.BigEndian.Uint32(rr.buf[9:]))
+	if _, err := io.ReadFull(rr.r, body); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return time.Time{}, 0, nil, err
+	}
+	rr.off += int64(recordHeaderLen + len(body))
+	return t, typ, body, nil
+}
+
+// Close : ファイルを閉じる
+func (rr *RecordReader) Close() error {
+	return rr.file.Close()
+}
@@ -0,0 +1,168 @@
+package game
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"wsnet2/binary"
+	"wsnet2/pb"
+)
+
+func TestRecord(t *testing.T) {
+	path := RecordPath(t.TempDir(), "testapp", "room1")
+
+	room := &pb.JoinedRoomRes{
+		RoomInfo: &pb.RoomInfo{Id: "room1", AppId: "testapp", MaxPlayers: 4},
+		Players:  []*pb.ClientInfo{{Id: "player1"}},
+		MasterId: "player1",
+		Deadline: 5,
+	}
+	evs := []*binary.RegularEvent{
+		binary.NewEvJoined(&pb.ClientInfo{Id: "player2"}),
+		binary.NewEvMessage("player1", []byte{1, 2, 3}),
+		binary.NewEvLeft("player2", "player1", "leave"),
+	}
+
+	rec, err := NewRecorder(path, room)
+	if err != nil {
+		t.Fatalf("NewRecorder: %+v", err)
+	}
+	for _, ev := range evs {
+		if err := rec.Record(ev); err != nil {
+			t.Fatalf("Record: %+v", err)
+		}
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("Close: %+v", err)
+	}
+
+	if _, err := NewRecorder(path, room); err == nil {
+		t.Fatalf("NewRecorder must not overwrite an existing file")
+	}
+
+	rr, got, start, err := OpenRecord(path)
+	if err != nil {
+		t.Fatalf("OpenRecord: %+v", err)
+	}
+	defer rr.Close()
+	if diff := cmp.Diff(got, room, protocmp.Transform()); diff != "" {
+		t.Fatalf("room differs: (-got +want)\n%s", diff)
+	}
+
+	prev := start
+	for i, want := range evs {
+		ts, ev, err := rr.Next()
+		if err != nil {
+			t.Fatalf("Next[%v]: %+v", i, err)
+		}
+		if ts.Before(prev) {
+			t.Fatalf("Next[%v]: time %v before %v", i, ts, prev)
+		}
+		prev = ts
+		if ev.Type() != want.Type() || !cmp.Equal(ev.Payload(), want.Payload()) {
+			t.Fatalf("Next[%v]: got %v %v, wants %v %v", i, ev.Type(), ev.Payload(), want.Type(), want.Payload())
+		}
+	}
+	if _, _, err := rr.Next(); err != io.EOF {
+		t.Fatalf("Next: %v, wants io.EOF", err)
+	}
+}
+
+func TestRecordTruncated(t *testing.T) {
+	path := RecordPath(t.TempDir(), "testapp", "room1")
+
+	rec, err := NewRecorder(path, &pb.JoinedRoomRes{RoomInfo: &pb.RoomInfo{Id: "room1"}})
+	if err != nil {
+		t.Fatalf("NewRecorder: %+v", err)
+	}
+	if err := rec.Record(binary.NewEvMessage("player1", []byte{1, 2, 3})); err != nil {
+		t.Fatalf("Record: %+v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("Close: %+v", err)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if err := os.Truncate(path, fi.Size()-1); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+
+	rr, _, _, err := OpenRecord(path)
+	if err != nil {
+		t.Fatalf("OpenRecord: %+v", err)
+	}
+	defer rr.Close()
+	if _, _, err := rr.Next(); err != io.EOF {
+		t.Fatalf("Next: %v, wants io.EOF", err)
+	}
+}
+
+func TestAppendRecorder(t *testing.T) {
+	path := RecordPath(t.TempDir(), "testapp", "room1")
+	room := &pb.JoinedRoomRes{RoomInfo: &pb.RoomInfo{Id: "room1"}}
+
+	// ファイルが無ければ作成する
+	rec, err := AppendRecorder(path, room)
+	if err != nil {
+		t.Fatalf("AppendRecorder: %+v", err)
+	}
+	if err := rec.Record(binary.NewEvMessage("player1", []byte{1})); err != nil {
+		t.Fatalf("Record: %+v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("Close: %+v", err)
+	}
+
+	// 書き込み途中のrecordを切り詰めてから追記する
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	if _, err := f.Write([]byte{0, 1, 2}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	f.Close()
+
+	rec, err = AppendRecorder(path, &pb.JoinedRoomRes{RoomInfo: &pb.RoomInfo{Id: "other"}})
+	if err != nil {
+		t.Fatalf("AppendRecorder: %+v", err)
+	}
+	if err := rec.Record(binary.NewEvMessage("player1", []byte{2})); err != nil {
+		t.Fatalf("Record: %+v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("Close: %+v", err)
+	}
+
+	rr, got, _, err := OpenRecord(path)
+	if err != nil {
+		t.Fatalf("OpenRecord: %+v", err)
+	}
+	defer rr.Close()
+	if diff := cmp.Diff(got, room, protocmp.Transform()); diff != "" {
+		t.Fatalf("room differs: (-got +want)\n%s", diff)
+	}
+	for i, want := range []byte{1, 2} {
+		_, ev, err := rr.Next()
+		if err != nil {
+			t.Fatalf("Next[%v]: %+v", i, err)
+		}
+		_, body, err := binary.UnmarshalEvMessage(ev.Payload())
+		if err != nil {
+			t.Fatalf("UnmarshalEvMessage[%v]: %v", i, err)
+		}
+		if !cmp.Equal(body, []byte{want}) {
+			t.Fatalf("Next[%v]: body=%v, wants %v", i, body, []byte{want})
+		}
+	}
+	if _, _, err := rr.Next(); err != io.EOF {
+		t.Fatalf("Next: %v, wants io.EOF", err)
+	}
+}
@@ -61,7 +61,8 @@ func initQueries() {
 
 		var sets []string
 		for _, c := range cols {
-			if c != "id" {
+			// host_idは部屋の移動時のみ更新する (see MigrateRoom)
+			if c != "id" && c != "host_id" {
 				sets = append(sets, c+"=:"+c)
 			}
 		}
@@ -89,19 +90,17 @@ type Repository struct {
 	conf *config.GameConf
 	db   *sqlx.DB
 
+	handler RoomHandler
+
+	rateLimits *MsgRateLimits
+	limits     *PayloadLimits
+
 	mu      sync.RWMutex
 	rooms   map[RoomID]*Room
 	clients map[ClientID]map[RoomID]*Client
 }
 
 func NewRepos(db *sqlx.DB, conf *config.GameConf, hostId uint32) (map[pb.AppId]*Repository, error) {
-	if _, err := db.Exec("INSERT INTO room_history (room_id, app_id, host_id, number, search_group, max_players, public_props, created, closed) "+
-		"SELECT id, app_id, host_id, number, search_group, max_players, props, created, now() FROM room WHERE host_id=?", hostId); err != nil {
-		return nil, xerrors.Errorf("room to history: %w", err)
-	}
-	if _, err := db.Exec("DELETE FROM `room` WHERE host_id=?", hostId); err != nil {
-		return nil, xerrors.Errorf("delete rooms: %w", err)
-	}
 	query := "SELECT id, `key` FROM app"
 	var apps []*pb.App
 	err := db.Select(&apps, query)
@@ -109,18 +108,64 @@ func NewRepos(db *sqlx.DB, conf *config.GameConf, hostId uint32) (map[pb.AppId]*
 		return nil, xerrors.Errorf("select apps: %w", err)
 	}
 	log.Debugf("new repos: apps=%v", apps)
+	rateLimits, err := NewMsgRateLimits(&conf.ClientConf)
+	if err != nil {
+		return nil, xerrors.Errorf("rate limits: %w", err)
+	}
+	if _, err := ParseSlowPeerPolicy(&conf.ClientConf); err != nil {
+		return nil, xerrors.Errorf("slow peer policy: %w", err)
+	}
+	if _, err := ParseCompressionLevel(&conf.ClientConf); err != nil {
+		return nil, xerrors.Errorf("compression level: %w", err)
+	}
 	repos := make(map[pb.AppId]*Repository, len(apps))
 	for _, app := range apps {
+		limits, err := NewPayloadLimits(conf, app.Id)
+		if err != nil {
+			return nil, xerrors.Errorf("payload limits: app=%v: %w", app.Id, err)
+		}
 		repos[app.Id] = &Repository{
 			hostId: hostId,
 			app:    app,
 			conf:   conf,
 			db:     db,
 
+			handler: getRoomHandler(app.Id),
+
+			rateLimits: rateLimits,
+			limits:     limits,
+
 			rooms:   make(map[RoomID]*Room),
 			clients: make(map[ClientID]map[RoomID]*Client),
 		}
 	}
+
+	restored, err := restoreSnapshots(db, repos, conf, hostId)
+	if err != nil {
+		return nil, xerrors.Errorf("restore snapshots: %w", err)
+	}
+
+	// 復元できなかった部屋は終了したものとして履歴に移す
+	histQuery := "INSERT INTO room_history (room_id, app_id, host_id, number, search_group, max_players, public_props, created, closed) " +
+		"SELECT id, app_id, host_id, number, search_group, max_players, props, created, now() FROM room WHERE host_id=?"
+	delQuery := "DELETE FROM `room` WHERE host_id=?"
+	args := []interface{}{hostId}
+	if len(restored) > 0 {
+		histQuery, args, err = sqlx.In(histQuery+" AND id NOT IN (?)", hostId, restored)
+		if err != nil {
+			return nil, xerrors.Errorf("sqlx.In: %w", err)
+		}
+		delQuery, _, err = sqlx.In(delQuery+" AND id NOT IN (?)", hostId, restored)
+		if err != nil {
+			return nil, xerrors.Errorf("sqlx.In: %w", err)
+		}
+	}
+	if _, err := db.Exec(histQuery, args...); err != nil {
+		return nil, xerrors.Errorf("room to history: %w", err)
+	}
+	if _, err := db.Exec(delQuery, args...); err != nil {
+		return nil, xerrors.Errorf("delete rooms: %w", err)
+	}
 	return repos, nil
 }
 
@@ -159,7 +204,7 @@ func (repo *Repository) CreateRoom(ctx context.Context, op *pb.RoomOption, maste
 	logger := log.Get(loglevel).With(log.KeyApp, repo.app.Id, log.KeyRoom, info.Id)
 	logger.Infof("new room: %v, num=%v, master=%v", info.Id, info.Number.Number, master.Id)
 
-	room, joined, ewc := NewRoom(ctx, repo, info, master, macKey, op.ClientDeadline, repo.conf, logger)
+	room, joined, ewc := NewRoom(ctx, repo, info, op, master, macKey, repo.conf, logger)
 	if ewc != nil {
 		tx.Rollback()
 		return nil, WithCode(xerrors.Errorf("NewRoom: %w", ewc), ewc.Code())
@@ -198,18 +243,24 @@ func (repo *Repository) CreateRoom(ctx context.Context, op *pb.RoomOption, maste
 		AuthKey:  cli.authKey,
 		MasterId: string(joined.MasterId),
 		Deadline: uint32(joined.Deadline / time.Second),
+
+		InviteTokens: joined.InviteTokens,
+
+		PublicPropVersions:  joined.PublicPropVersions,
+		PrivatePropVersions: joined.PrivatePropVersions,
+		PlayerPropVersions:  joined.PlayerPropVersions,
 	}, nil
 }
 
-func (repo *Repository) JoinRoom(ctx context.Context, id string, client *pb.ClientInfo, macKey string) (*pb.JoinedRoomRes, ErrorWithCode) {
-	return repo.joinRoom(ctx, id, client, macKey, true)
+func (repo *Repository) JoinRoom(ctx context.Context, id string, client *pb.ClientInfo, macKey, inviteToken string) (*pb.JoinedRoomRes, ErrorWithCode) {
+	return repo.joinRoom(ctx, id, client, macKey, inviteToken, true)
 }
 
 func (repo *Repository) WatchRoom(ctx context.Context, id string, client *pb.ClientInfo, macKey string) (*pb.JoinedRoomRes, ErrorWithCode) {
-	return repo.joinRoom(ctx, id, client, macKey, false)
+	return repo.joinRoom(ctx, id, client, macKey, "", false)
 }
 
-func (repo *Repository) joinRoom(ctx context.Context, id string, client *pb.ClientInfo, macKey string, isPlayer bool) (*pb.JoinedRoomRes, ErrorWithCode) {
+func (repo *Repository) joinRoom(ctx context.Context, id string, client *pb.ClientInfo, macKey, inviteToken string, isPlayer bool) (*pb.JoinedRoomRes, ErrorWithCode) {
 	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
 	defer cancel()
 
@@ -230,7 +281,7 @@ func (repo *Repository) joinRoom(ctx context.Context, id string, client *pb.Clie
 	errch := make(chan ErrorWithCode, 1)
 	var msg Msg
 	if isPlayer {
-		msg = &MsgJoin{client, macKey, jch, errch}
+		msg = &MsgJoin{client, macKey, jch, errch, inviteToken}
 	} else {
 		msg = &MsgWatch{client, macKey, jch, errch}
 	}
@@ -269,6 +320,10 @@ func (repo *Repository) joinRoom(ctx context.Context, id string, client *pb.Clie
 		AuthKey:  cli.authKey,
 		MasterId: string(joined.MasterId),
 		Deadline: uint32(joined.Deadline / time.Second),
+
+		PublicPropVersions:  joined.PublicPropVersions,
+		PrivatePropVersions: joined.PrivatePropVersions,
+		PlayerPropVersions:  joined.PlayerPropVersions,
 	}, nil
 }
 
@@ -378,6 +433,11 @@ func (repo *Repository) RemoveRoom(room *Room) {
 	rid := room.ID()
 	delete(repo.rooms, rid)
 
+	if room.migrated {
+		// DBのレコードは移動先の部屋のもの
+		room.logger.Debugf("room migrated: %v", rid)
+		return
+	}
 	repo.deleteRoom(room)
 	room.logger.Debugf("room removed from repository: %v", rid)
 }
@@ -512,6 +572,40 @@ func (repo *Repository) adminKickRoom(room *Room, userID string) error {
 	}
 }
 
+// AdminClose : 部屋を終了する
+func (repo *Repository) AdminClose(ctx context.Context, roomID string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	room, err := repo.GetRoom(roomID)
+	if err != nil {
+		return NormalWithCode(xerrors.Errorf("AdminClose: can not find room %q; %w", roomID, err), codes.NotFound)
+	}
+
+	ch := make(chan error, 1)
+	msg := &MsgAdminClose{
+		Res: ch,
+	}
+	select {
+	case <-ctx.Done():
+		return WithCode(
+			xerrors.Errorf("AdminClose write msg timeout or context done: room=%q", room.Id),
+			codes.DeadlineExceeded)
+	case <-room.Done():
+		return nil
+	case room.msgCh <- msg:
+	}
+
+	select {
+	case <-ctx.Done():
+		return WithCode(
+			xerrors.Errorf("AdminClose response timeout or context done: room=%q", room.Id),
+			codes.DeadlineExceeded)
+	case err := <-ch:
+		return err
+	}
+}
+
 type PlayerLogMsg string
 
 const (
@@ -524,18 +618,35 @@ const (
 	PlayerLogError   PlayerLogMsg = "Error"
 	PlayerLogAttach  PlayerLogMsg = "Attach"
 	PlayerLogDetach  PlayerLogMsg = "Detach"
+	PlayerLogRandom  PlayerLogMsg = "Random"
 )
 
 func (repo *Repository) PlayerLog(c *Client, msg PlayerLogMsg) {
 	const q = "INSERT INTO player_log (`room_id`, `player_id`, `message`, `datetime`) VALUES (:room_id, :player_id, :message, :datetime)"
 
-	param := map[string]any{
+	repo.playerLog(c, msg, q, map[string]any{
 		"room_id":   c.RoomID(),
 		"player_id": c.ID(),
 		"message":   msg,
 		"datetime":  time.Now(),
-	}
+	})
+}
+
+// PlayerLogWithDetail : 詳細（生成した乱数など）付きでplayer_logに記録する.
+// detail列は後から追加したので、それ以外の記録はdetail列の無いDBにも書き込めるよう PlayerLog を使う.
+func (repo *Repository) PlayerLogWithDetail(c *Client, msg PlayerLogMsg, detail string) {
+	const q = "INSERT INTO player_log (`room_id`, `player_id`, `message`, `detail`, `datetime`) VALUES (:room_id, :player_id, :message, :detail, :datetime)"
+
+	repo.playerLog(c, msg, q, map[string]any{
+		"room_id":   c.RoomID(),
+		"player_id": c.ID(),
+		"message":   msg,
+		"detail":    detail,
+		"datetime":  time.Now(),
+	})
+}
 
+func (repo *Repository) playerLog(c *Client, msg PlayerLogMsg, q string, param map[string]any) {
 	go func() {
 		_, err := repo.db.NamedExec(q, param)
 		if err != nil {
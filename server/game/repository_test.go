@@ -35,6 +35,9 @@ func TestQueries(t *testing.T) {
 	if !ok {
 		t.Fatalf("roomUpdateQuery not match: %v, %v", ok, roomUpdateQuery)
 	}
+	if regexp.MustCompile(`host_id=`).MatchString(roomUpdateQuery) {
+		t.Fatalf("roomUpdateQuery must not update host_id: %v", roomUpdateQuery)
+	}
 }
 
 func newDbMock(t *testing.T) (*sqlx.DB, sqlmock.Sqlmock) {
@@ -116,3 +119,36 @@ func TestNewRoomInfo(t *testing.T) {
 		t.Errorf("there were unfulfilled expectations: %s", err)
 	}
 }
+
+func TestPlayerLog(t *testing.T) {
+	_, master := newTestRoom(t, nil, nil)
+	db, mock := newDbMock(t)
+	repo := &Repository{db: db}
+
+	// detail列はPlayerLogWithDetailのときだけ書き込む
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO player_log (`room_id`, `player_id`, `message`, `datetime`)")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	repo.PlayerLog(master, PlayerLogJoin)
+	waitExpectations(t, mock)
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO player_log (`room_id`, `player_id`, `message`, `detail`, `datetime`)")).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	repo.PlayerLogWithDetail(master, PlayerLogRandom, "values=[1]")
+	waitExpectations(t, mock)
+}
+
+// waitExpectations : goroutineで実行するクエリを待つ
+func waitExpectations(t *testing.T, mock sqlmock.Sqlmock) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		err := mock.ExpectationsWereMet()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expectations: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
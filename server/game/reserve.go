@@ -0,0 +1,204 @@
+package game
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+	"google.golang.org/grpc/codes"
+
+	"wsnet2/pb"
+)
+
+// 予約席
+//
+// 特定のクライアントのためにPlayerの席を確保する.
+// 予約席はMaxPlayersに数えられ、予約されたクライアント以外の入室では空席として扱わない.
+// 予約されたクライアントは招待トークンを使うと、Joinableでない部屋にも入室できる.
+// 予約は入室すると消費され、期限が過ぎると無効になる.
+
+const (
+	// inviteTokenLen : 招待トークンのバイト数
+	inviteTokenLen = 16
+)
+
+type reservation struct {
+	token  string
+	expire time.Time // ゼロ値なら期限なし
+}
+
+func (rs *reservation) expired(now time.Time) bool {
+	return !rs.expire.IsZero() && !now.Before(rs.expire)
+}
+
+// checkReservedIds : 部屋作成時の予約が席数に収まるか検査する.
+func checkReservedIds(ids []string, masterId string, maxPlayers uint32) error {
+	reserved := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if id == "" {
+			return xerrors.Errorf("empty client id")
+		}
+		if id != masterId {
+			reserved[id] = true
+		}
+	}
+	if len(reserved) > 0 && uint32(1+len(reserved)) > maxPlayers {
+		return xerrors.Errorf("not enough seats: max=%v, reserved=%v", maxPlayers, len(reserved))
+	}
+	return nil
+}
+
+// purgeReservations : 期限切れの予約を削除する.
+func (r *Room) purgeReservations(now time.Time) {
+	for id, rs := range r.reservations {
+		if rs.expired(now) {
+			r.logger.Debugf("reservation expired: %v", id)
+			delete(r.reservations, id)
+		}
+	}
+}
+
+// reservedSeats : id 以外のクライアントのために確保している席の数.
+func (r *Room) reservedSeats(id ClientID) int {
+	n := len(r.reservations)
+	if _, ok := r.reservations[id]; ok {
+		n--
+	}
+	return n
+}
+
+// reserve : 席を予約して招待トークンを返す.
+// 予約済みのクライアントは期限を延長し、同じトークンを返す. 入室済みのPlayerは予約しない.
+// muClients のロックを取得してから呼び出す.
+func (r *Room) reserve(ids []string, ttl time.Duration, now time.Time) (map[string]string, ErrorWithCode) {
+	r.purgeReservations(now)
+
+	var expire time.Time
+	if ttl > 0 {
+		expire = now.Add(ttl)
+	}
+
+	added := make(map[ClientID]bool)
+	for _, id := range ids {
+		if id == "" {
+			return nil, WithCode(xerrors.Errorf("empty client id"), codes.InvalidArgument)
+		}
+		if _, ok := r.players[ClientID(id)]; ok {
+			continue
+		}
+		if _, ok := r.reservations[ClientID(id)]; !ok {
+			added[ClientID(id)] = true
+		}
+	}
+	if seats := len(r.players) + len(r.reservations) + len(added); uint32(seats) > r.MaxPlayers {
+		return nil, NormalWithCode(
+			xerrors.Errorf("not enough seats: room=%v max=%v, players=%v, reserved=%v, requested=%v",
+				r.ID(), r.MaxPlayers, len(r.players), len(r.reservations), len(added)),
+			codes.ResourceExhausted)
+	}
+
+	tokens := make(map[string]string, len(ids))
+	for _, id := range ids {
+		if _, ok := r.players[ClientID(id)]; ok {
+			continue
+		}
+		rs, ok := r.reservations[ClientID(id)]
+		if !ok {
+			rs = &reservation{token: RandomHex(inviteTokenLen)}
+			r.reservations[ClientID(id)] = rs
+		}
+		rs.expire = expire
+		tokens[id] = rs.token
+	}
+	r.logger.Infof("seats reserved: %v", ids)
+	return tokens, nil
+}
+
+// reservationsSnapshot : 部屋の移動先に送る予約
+func (r *Room) reservationsSnapshot() []*pb.Reservation {
+	rsvs := make([]*pb.Reservation, 0, len(r.reservations))
+	for id, rs := range r.reservations {
+		var expire int64
+		if !rs.expire.IsZero() {
+			expire = rs.expire.Unix()
+		}
+		rsvs = append(rsvs, &pb.Reservation{
+			ClientId: string(id),
+			Token:    rs.token,
+			Expire:   expire,
+		})
+	}
+	return rsvs
+}
+
+// restoreReservations : 移動元の予約を復元する
+func (r *Room) restoreReservations(rsvs []*pb.Reservation) {
+	for _, rsv := range rsvs {
+		rs := &reservation{token: rsv.Token}
+		if rsv.Expire > 0 {
+			rs.expire = time.Unix(rsv.Expire, 0)
+		}
+		r.reservations[ClientID(rsv.ClientId)] = rs
+	}
+}
+
+func (r *Room) msgReserve(msg *MsgReserve) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	if msg.Requester != adminClientID && msg.Requester != r.masterID() {
+		msg.Err <- NormalWithCode(
+			xerrors.Errorf("requester %q is not master %q", msg.Requester, r.masterID()),
+			codes.PermissionDenied)
+		return
+	}
+
+	tokens, ewc := r.reserve(msg.ClientIDs, msg.TTL, time.Now())
+	if ewc != nil {
+		msg.Err <- ewc
+		return
+	}
+	msg.Res <- tokens
+}
+
+// Reserve : 部屋の席を予約して招待トークンを返す.
+// requester は部屋のMasterでなければならない. 空文字列ならadminとして扱う.
+func (repo *Repository) Reserve(ctx context.Context, roomID, requester string, clientIDs []string, ttl time.Duration) (map[string]string, ErrorWithCode) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	room, err := repo.GetRoom(roomID)
+	if err != nil {
+		return nil, NormalWithCode(xerrors.Errorf("Reserve: can not find room %q; %w", roomID, err), codes.NotFound)
+	}
+
+	resch := make(chan map[string]string, 1)
+	errch := make(chan ErrorWithCode, 1)
+	msg := &MsgReserve{
+		Requester: ClientID(requester),
+		ClientIDs: clientIDs,
+		TTL:       ttl,
+		Res:       resch,
+		Err:       errch,
+	}
+	select {
+	case <-ctx.Done():
+		return nil, WithCode(
+			xerrors.Errorf("Reserve write msg timeout or context done: room=%q", room.Id),
+			codes.DeadlineExceeded)
+	case <-room.Done():
+		return nil, NormalWithCode(xerrors.Errorf("Reserve: room closed: %q", room.Id), codes.NotFound)
+	case room.msgCh <- msg:
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, WithCode(
+			xerrors.Errorf("Reserve response timeout or context done: room=%q", room.Id),
+			codes.DeadlineExceeded)
+	case ewc := <-errch:
+		return nil, ewc
+	case tokens := <-resch:
+		return tokens, nil
+	}
+}
@@ -0,0 +1,84 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+
+	"wsnet2/pb"
+)
+
+func TestCheckReservedIds(t *testing.T) {
+	tests := map[string]struct {
+		ids   []string
+		max   uint32
+		valid bool
+	}{
+		"none":         {nil, 0, true},
+		"fit":          {[]string{"a", "b", "c"}, 4, true},
+		"over":         {[]string{"a", "b", "c"}, 3, false},
+		"dup":          {[]string{"a", "a", "b"}, 3, true},
+		"master":       {[]string{"master", "a"}, 2, true},
+		"empty client": {[]string{"a", ""}, 4, false},
+	}
+	for name, test := range tests {
+		err := checkReservedIds(test.ids, "master", test.max)
+		if (err == nil) != test.valid {
+			t.Errorf("%v: err=%v, wants valid=%v", name, err, test.valid)
+		}
+	}
+}
+
+func TestReserve(t *testing.T) {
+	r := &Room{
+		RoomInfo:     &pb.RoomInfo{Id: "room1", MaxPlayers: 4},
+		players:      map[ClientID]*Client{"master": {}},
+		reservations: make(map[ClientID]*reservation),
+		logger:       zap.NewNop().Sugar(),
+	}
+	now := time.Now()
+
+	tokens, err := r.reserve([]string{"master", "a", "b"}, time.Minute, now)
+	if err != nil {
+		t.Fatalf("reserve: %v", err)
+	}
+	if len(tokens) != 2 || tokens["a"] == "" || tokens["b"] == "" || tokens["a"] == tokens["b"] {
+		t.Fatalf("invalid tokens: %v", tokens)
+	}
+	if n := r.reservedSeats("a"); n != 1 {
+		t.Errorf("reservedSeats(a) = %v, wants 1", n)
+	}
+	if n := r.reservedSeats("c"); n != 2 {
+		t.Errorf("reservedSeats(c) = %v, wants 2", n)
+	}
+
+	_, err = r.reserve([]string{"c", "d"}, 0, now)
+	if err == nil || err.Code() != codes.ResourceExhausted {
+		t.Fatalf("reserve over max: err=%v, wants ResourceExhausted", err)
+	}
+
+	// 予約済みのクライアントは同じトークンで期限を延長する
+	again, err := r.reserve([]string{"a", "c"}, 0, now.Add(30*time.Second))
+	if err != nil {
+		t.Fatalf("reserve again: %v", err)
+	}
+	if again["a"] != tokens["a"] {
+		t.Errorf("token changed: %v, wants %v", again["a"], tokens["a"])
+	}
+
+	r.purgeReservations(now.Add(time.Minute))
+	if _, ok := r.reservations["b"]; ok {
+		t.Errorf("reservation b is not expired")
+	}
+	if len(r.reservations) != 2 {
+		t.Errorf("reservations: %v, wants a and c", r.reservations)
+	}
+
+	restored := &Room{reservations: make(map[ClientID]*reservation)}
+	restored.restoreReservations(r.reservationsSnapshot())
+	if restored.reservations["a"].token != tokens["a"] || !restored.reservations["a"].expire.IsZero() {
+		t.Errorf("restored reservation differs: %+v", restored.reservations["a"])
+	}
+}
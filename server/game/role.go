@@ -0,0 +1,178 @@
+package game
+
+import (
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"wsnet2/binary"
+)
+
+// PlayerとWatcherの間の移動
+//
+// RoomOption.RoleChange が指定された部屋では、MsgChangeRole で入室中のクライアントを
+// 再接続させずにPlayerとWatcherの間で移動できる. Peerとevbufはそのまま引き継ぐ.
+// 移動すると EvRoleChanged を全員に通知する. 部屋の状態により移動できないときは EvRejected を返す.
+// MasterがWatcherになるときは、クライアントが元のMasterをPlayerとして参照できるよう
+// EvRoleChanged の前に EvMasterSwitched を通知する.
+// Hub経由の観戦者はPlayerにできない.
+// Joinableでない部屋では、Masterが移動させるか予約席のあるWatcherだけがPlayerになれる.
+
+// RoleChangePolicy : PlayerとWatcherの間の移動を誰に許可するか
+type RoleChangePolicy uint32
+
+const (
+	// RoleChangeDisabled : 移動できない
+	RoleChangeDisabled RoleChangePolicy = iota
+	// RoleChangeMaster : Masterのみが移動させられる
+	RoleChangeMaster
+	// RoleChangeSelf : Masterに加えて本人も移動できる
+	RoleChangeSelf
+)
+
+// allows : sender が target を移動させてよいか
+func (p RoleChangePolicy) allows(sender, target, master ClientID) bool {
+	switch p {
+	case RoleChangeMaster:
+		return sender == master
+	case RoleChangeSelf:
+		return sender == master || sender == target
+	}
+	return false
+}
+
+// promote : sender が WatcherをPlayerにする.
+// muClients のロックを取得してから呼び出す.
+func (r *Room) promote(c *Client, sender ClientID, now time.Time) error {
+	cid := c.ID()
+	if c.IsHub || c.nodeCount != 1 {
+		return xerrors.Errorf("hub client can not be a player: %v", cid)
+	}
+	r.purgeReservations(now)
+	if _, reserved := r.reservations[cid]; !r.Joinable && !reserved && sender != r.masterID() {
+		return xerrors.Errorf("room is not joinable: %v", cid)
+	}
+	if r.MaxPlayers <= uint32(len(r.players)+r.reservedSeats(cid)) {
+		return xerrors.Errorf("room full: max=%v, reserved=%v", r.MaxPlayers, r.reservedSeats(cid))
+	}
+	if err := r.handlerOnJoin(c.ClientInfo, true); err != nil {
+		return err
+	}
+
+	// 遅延中のEventを先に送ってから、Playerとしてすぐに受け取るようにする
+	for _, ev := range r.delayed.pending(cid) {
+		r.sendTo(c, ev)
+	}
+	delete(r.watchers, cid)
+	r.players[cid] = c
+	r.masterOrder = append(r.masterOrder, cid)
+	delete(r.reservations, cid)
+	c.mu.Lock()
+	c.isPlayer = true
+	c.mu.Unlock()
+
+	r.RoomInfo.Watchers -= c.nodeCount
+	r.RoomInfo.Players = uint32(len(r.players))
+	r.writeLastMsg(cid)
+	r.repo.PlayerLog(c, PlayerLogJoin)
+	c.logger.Infof("watcher became a player: %v", cid)
+	return nil
+}
+
+// demote : PlayerをWatcherにする.
+// muClients のロックを取得してから呼び出す.
+func (r *Room) demote(c *Client) error {
+	cid := c.ID()
+	if !r.Watchable {
+		return xerrors.Errorf("room is not watchable")
+	}
+	if len(r.players) == 1 && !r.persistent {
+		return xerrors.Errorf("last player can not be a watcher: %v", cid)
+	}
+	if err := r.handlerOnJoin(c.ClientInfo, false); err != nil {
+		return err
+	}
+
+	delete(r.players, cid)
+	for i, id := range r.masterOrder {
+		if id == cid {
+			r.masterOrder = append(r.masterOrder[:i], r.masterOrder[i+1:]...)
+			break
+		}
+	}
+	r.watchers[cid] = c
+	r.delayed.skipPending(cid)
+	c.mu.Lock()
+	c.isPlayer = false
+	c.mu.Unlock()
+
+	r.RoomInfo.Watchers += c.nodeCount
+	r.RoomInfo.Players = uint32(len(r.players))
+	r.removeLastMsg(cid)
+	r.releasePropOwners(cid)
+	r.repo.PlayerLog(c, PlayerLogLeave)
+	c.logger.Infof("player became a watcher: %v", cid)
+	return nil
+}
+
+func (r *Room) msgChangeRole(msg *MsgChangeRole) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	if !r.roleChange.allows(msg.SenderID(), msg.Target, r.masterID()) {
+		msg.Sender.logger.Warnf("role change is not allowed: sender=%v, target=%v, policy=%v", msg.Sender.Id, msg.Target, r.roleChange)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+
+	var target *Client
+	var found bool
+	if msg.ToPlayer {
+		target, found = r.watchers[msg.Target]
+	} else {
+		target, found = r.players[msg.Target]
+	}
+	if !found {
+		msg.Sender.logger.Infof("target %v is absent (toPlayer=%v)", msg.Target, msg.ToPlayer)
+		r.sendTo(msg.Sender, binary.NewEvTargetNotFound(msg, []string{string(msg.Target)}))
+		return
+	}
+
+	var err error
+	if msg.ToPlayer {
+		err = r.promote(target, msg.SenderID(), time.Now())
+	} else {
+		err = r.demote(target)
+	}
+	if err != nil {
+		msg.Sender.logger.Infof("role change rejected: %+v", err)
+		r.sendTo(msg.Sender, binary.NewEvRejected(msg))
+		return
+	}
+	r.updateRoomInfo()
+
+	r.sendTo(msg.Sender, binary.NewEvSucceeded(msg))
+
+	if !msg.ToPlayer && r.master == target {
+		r.master = r.nextMaster(target.ID(), 0, time.Now())
+		if r.master == nil {
+			r.startEmptyTimer()
+			r.logger.Infof("room is empty: %v", r.Id)
+		}
+		r.logger.Infof("master switched (demoted): %v -> %v", target.Id, r.masterID())
+		r.broadcast(binary.NewEvMasterSwitched(target.Id, string(r.masterID()), binary.MasterSwitchDemoted))
+	}
+
+	r.broadcast(binary.NewEvRoleChanged(target.ClientInfo, msg.ToPlayer))
+
+	if msg.ToPlayer && r.master == nil {
+		// 無人の部屋でPlayerになったクライアントがMasterになる
+		r.master = target
+		r.stopEmptyTimer()
+		target.logger.Infof("master assigned: %v", target.Id)
+		r.broadcast(binary.NewEvMasterSwitched("", target.Id, binary.MasterSwitchAssigned))
+	}
+	if !msg.ToPlayer {
+		r.releaseObjects(target.ID())
+	}
+}
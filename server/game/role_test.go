@@ -0,0 +1,149 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"wsnet2/binary"
+	"wsnet2/pb"
+)
+
+func TestRoleChangePolicy(t *testing.T) {
+	tests := map[string]struct {
+		policy RoleChangePolicy
+		sender ClientID
+		target ClientID
+		want   bool
+	}{
+		"disabled master":  {RoleChangeDisabled, "master", "w1", false},
+		"master by master": {RoleChangeMaster, "master", "w1", true},
+		"master by self":   {RoleChangeMaster, "w1", "w1", false},
+		"self by master":   {RoleChangeSelf, "master", "w1", true},
+		"self by self":     {RoleChangeSelf, "w1", "w1", true},
+		"self by other":    {RoleChangeSelf, "w2", "w1", false},
+	}
+	for name, test := range tests {
+		if got := test.policy.allows(test.sender, test.target, "master"); got != test.want {
+			t.Errorf("%v: allows = %v, wants %v", name, got, test.want)
+		}
+	}
+}
+
+func TestChangeRoleRejected(t *testing.T) {
+	master := &Client{ClientInfo: &pb.ClientInfo{Id: "master"}, isPlayer: true, nodeCount: 1}
+	watcher := &Client{ClientInfo: &pb.ClientInfo{Id: "w1"}, nodeCount: 1}
+	hub := &Client{ClientInfo: &pb.ClientInfo{Id: "hub", IsHub: true}}
+	r := &Room{
+		RoomInfo:     &pb.RoomInfo{Id: "room1", MaxPlayers: 2, Joinable: true, Watchable: true},
+		players:      map[ClientID]*Client{"master": master},
+		watchers:     map[ClientID]*Client{"w1": watcher, "hub": hub},
+		reservations: map[ClientID]*reservation{"r1": {token: "token"}},
+		logger:       zap.NewNop().Sugar(),
+	}
+	now := time.Now()
+
+	if err := r.promote(hub, "master", now); err == nil {
+		t.Errorf("hub client promoted")
+	}
+	if err := r.promote(watcher, "master", now); err == nil {
+		t.Errorf("promoted into the reserved seat")
+	}
+	if err := r.demote(master); err == nil {
+		t.Errorf("last player demoted")
+	}
+	if len(r.players) != 1 || len(r.watchers) != 2 || !master.isPlayer || watcher.isPlayer {
+		t.Errorf("clients changed: players=%v, watchers=%v", r.players, r.watchers)
+	}
+}
+
+func TestDemoteMaster(t *testing.T) {
+	r, master := newTestRoom(t, &pb.RoomOption{RoleChange: uint32(RoleChangeSelf)}, nil)
+	joined, err := joinRoom(r, "p1")
+	if err != nil {
+		t.Fatalf("join p1: %v", err)
+	}
+	p1 := joined.Client
+
+	r.msgCh <- &MsgChangeRole{
+		RegularMsg: binary.NewRegularMsg(binary.MsgTypeChangeRole, 1, nil),
+		Sender:     master,
+		Target:     "master",
+	}
+	getRoomInfo(r)
+
+	// 元のMasterがPlayerにいるうちにMasterの切替えを通知する
+	evs := received(t, p1)
+	if len(evs) < 2 {
+		t.Fatalf("events = %v", receivedTypes(t, p1))
+	}
+	evs = evs[len(evs)-2:]
+	if evs[0].Type() != binary.EvTypeMasterSwitched || evs[1].Type() != binary.EvTypeRoleChanged {
+		t.Fatalf("events = %v, %v, wants EvTypeMasterSwitched, EvTypeRoleChanged", evs[0].Type(), evs[1].Type())
+	}
+	id, reason, e := binary.UnmarshalEvMasterSwitchedPayload(evs[0].Payload())
+	if e != nil {
+		t.Fatalf("UnmarshalEvMasterSwitchedPayload: %v", e)
+	}
+	if id != "p1" || reason != binary.MasterSwitchDemoted {
+		t.Fatalf("EvMasterSwitched = %v %v, wants p1 %v", id, reason, binary.MasterSwitchDemoted)
+	}
+	if r.masterID() != "p1" || master.isPlayer {
+		t.Fatalf("master = %v, isPlayer = %v", r.masterID(), master.isPlayer)
+	}
+}
+
+func TestPromoteNotJoinable(t *testing.T) {
+	r, master := newTestRoom(t, &pb.RoomOption{RoleChange: uint32(RoleChangeSelf)}, nil)
+	var watchers []*Client
+	for _, id := range []string{"w1", "w2", "w3"} {
+		joined, err := watchRoom(r, id)
+		if err != nil {
+			t.Fatalf("watch %v: %v", id, err)
+		}
+		watchers = append(watchers, joined.Client)
+	}
+	w1, w2, w3 := watchers[0], watchers[1], watchers[2]
+
+	payload := binary.MarshalRoomPropPayload(true, false, true, 0, 4, 0, nil, nil)
+	msg, err := msgRoomProp(master, binary.NewRegularMsg(binary.MsgTypeRoomProp, 1, payload))
+	if err != nil {
+		t.Fatalf("msgRoomProp: %v", err)
+	}
+	r.msgCh <- msg
+	getRoomInfo(r)
+	r.muClients.Lock()
+	if _, err := r.reserve([]string{"w3"}, 0, time.Now()); err != nil {
+		t.Fatalf("reserve: %v", err)
+	}
+	r.muClients.Unlock()
+
+	changeRole := func(sender *Client, target ClientID) {
+		r.msgCh <- &MsgChangeRole{
+			RegularMsg: binary.NewRegularMsg(binary.MsgTypeChangeRole, 1, nil),
+			Sender:     sender,
+			Target:     target,
+			ToPlayer:   true,
+		}
+		getRoomInfo(r)
+	}
+
+	// Joinableでない部屋では自分からPlayerになれない
+	changeRole(w1, "w1")
+	if types := receivedTypes(t, w1); types[len(types)-1] != binary.EvTypeRejected {
+		t.Errorf("w1 events = %v, wants EvTypeRejected", types)
+	}
+	// Masterによる移動と予約席のあるWatcherは許可する
+	changeRole(master, "w2")
+	changeRole(w3, "w3")
+
+	r.muClients.RLock()
+	defer r.muClients.RUnlock()
+	if _, ok := r.players["w1"]; ok {
+		t.Errorf("w1 became a player in the closed room")
+	}
+	if !w2.isPlayer || !w3.isPlayer {
+		t.Errorf("w2.isPlayer = %v, w3.isPlayer = %v, wants true", w2.isPlayer, w3.isPlayer)
+	}
+}
@@ -2,6 +2,7 @@ package game
 
 import (
 	"context"
+	"errors"
 	"sync"
 	"time"
 
@@ -27,11 +28,58 @@ type Room struct {
 
 	conf *config.GameConf
 
+	handler RoomHandler
+
 	deadline time.Duration
 
+	record   bool
+	recorder *Recorder
+
+	// rateLimitOption : RoomOption.RateLimits (部屋の移動先に引き継ぐ)
+	rateLimitOption map[uint32]*pb.RateLimit
+	rateLimits      *MsgRateLimits
+
+	// peerStats : 部屋のPeerの送信キューの統計. MsgLoopの間 metrics.PeerQueues に登録する
+	peerStats *metrics.PeerQueueStats
+
+	// migrated : 別のgameサーバに移動済み
+	migrated bool
+
+	// persistent : 最後のPlayerが退室しても部屋を残す
+	persistent bool
+	// emptyTTL : 無人の部屋を残す時間. 0ならadminが閉じるまで残す
+	emptyTTL   time.Duration
+	emptyTimer *time.Timer
+
+	// reservations : 予約席. 部屋作成時は RoomOption.ReservedIds の予約を msgCreate で行う
+	reservations   map[ClientID]*reservation
+	reservedIds    []string
+	reservationTTL time.Duration
+
+	// masterByLatency : Masterの退室時などに、RTTが最小のPlayerを次のMasterにする
+	masterByLatency bool
+	// masterIdleTimeout : Masterからのメッセージがこの時間届かなければMasterを切替える. 0なら切替えない
+	masterIdleTimeout time.Duration
+
+	// roleChange : PlayerとWatcherの間の移動を誰に許可するか
+	roleChange RoleChangePolicy
+
+	// banned : 入室禁止リスト (value: 期限. ゼロ値なら部屋が終了するまで)
+	banned map[ClientID]time.Time
+
 	publicProps  binary.Dict
 	privateProps binary.Dict
 
+	// publicVersions, privateVersions : プロパティのキー毎のバージョン
+	publicVersions  map[string]uint64
+	privateVersions map[string]uint64
+	// propVersion : 最後に変更したプロパティのバージョン (部屋内のクライアントのプロパティを含む)
+	propVersion uint64
+
+	// publicAcls, privateAcls : プロパティのキー毎の書き込み権限
+	publicAcls  *propAcls
+	privateAcls *propAcls
+
 	msgCh    chan Msg
 	done     chan struct{}
 	wgClient sync.WaitGroup
@@ -44,34 +92,121 @@ type Room struct {
 
 	lastMsg binary.Dict // map[clientID]unixtime_millisec
 
+	// cache : MsgCachedBroadcastで送られた途中入室者向けのEvent
+	cache *eventCache
+
+	// delayed : Watcherに遅延配信するEvent (RoomOption.WatcherDelay)
+	delayed *delayQueue
+
+	// timers : サーバ側のタイマー
+	timers *roomTimers
+
+	// lockstep : ロックステップのフレーム (RoomOption.LockstepTickRate)
+	lockstep *lockstep
+
+	// objects : ネットワークオブジェクト
+	objects *objectRegistry
+
 	logger log.Logger
 
 	chRoomInfo   chan struct{}
 	mRoomInfo    sync.Mutex // used by updateRoomInfo
 	lastRoomInfo *pb.RoomInfo
+
+	chSnapshot   chan struct{}
+	mSnapshot    sync.Mutex // used by takeSnapshot
+	lastSnapshot []byte
+}
+
+func NewRoom(ctx context.Context, repo *Repository, info *pb.RoomInfo, op *pb.RoomOption, masterInfo *pb.ClientInfo, macKey string, conf *config.GameConf, logger log.Logger) (*Room, *JoinedInfo, ErrorWithCode) {
+	r, ewc := initRoom(repo, info, op.ClientDeadline, op.RateLimits, conf, logger)
+	if ewc != nil {
+		return nil, nil, ewc
+	}
+	r.record = op.Record && conf.RecordDir != ""
+	r.persistent = op.Persistent
+	r.emptyTTL = time.Duration(op.EmptyTtl) * time.Second
+	r.masterByLatency = op.MasterByLatency
+	r.masterIdleTimeout = time.Duration(op.MasterIdleTimeout) * time.Second
+	r.roleChange = RoleChangePolicy(op.RoleChange)
+	r.delayed.delay = time.Duration(op.WatcherDelay) * time.Second
+	if err := r.initPropAcls(op.PublicPropAcl, op.PrivatePropAcl); err != nil {
+		return nil, nil, WithCode(xerrors.Errorf("prop acl: %w", err), codes.InvalidArgument)
+	}
+	if err := r.lockstep.configure(op.LockstepTickRate, op.LockstepInputDelay); err != nil {
+		return nil, nil, WithCode(xerrors.Errorf("lockstep: %w", err), codes.InvalidArgument)
+	}
+	r.reservedIds = op.ReservedIds
+	r.reservationTTL = time.Duration(op.ReservationTtl) * time.Second
+	if err := checkReservedIds(op.ReservedIds, masterInfo.Id, info.MaxPlayers); err != nil {
+		return nil, nil, WithCode(xerrors.Errorf("reserved ids: %w", err), codes.InvalidArgument)
+	}
+	if err := repo.limits.checkRoomProps(r.publicProps, r.publicProps, r.privateProps, r.privateProps); err != nil {
+		return nil, nil, WithCode(xerrors.Errorf("room props: %w", err), codes.InvalidArgument)
+	}
+
+	r.start()
+
+	jch := make(chan *JoinedInfo, 1)
+	ech := make(chan ErrorWithCode, 1)
+
+	select {
+	case <-ctx.Done():
+		return nil, nil, WithCode(
+			xerrors.Errorf("write msg timeout or context done: room=%v client=%v", r.Id, masterInfo.Id),
+			codes.DeadlineExceeded)
+	case r.msgCh <- &MsgCreate{masterInfo, macKey, jch, ech}:
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, nil, WithCode(
+			xerrors.Errorf("msgCreate timeout or context done: room=%v client=%v", r.Id, masterInfo.Id),
+			codes.DeadlineExceeded)
+	case ewc := <-ech:
+		return nil, nil, WithCode(
+			xerrors.Errorf("msgCreate: %w", ewc), ewc.Code())
+	case joined := <-jch:
+		return r, joined, nil
+	}
 }
 
-func NewRoom(ctx context.Context, repo *Repository, info *pb.RoomInfo, masterInfo *pb.ClientInfo, macKey string, deadlineSec uint32, conf *config.GameConf, logger log.Logger) (*Room, *JoinedInfo, ErrorWithCode) {
+func initRoom(repo *Repository, info *pb.RoomInfo, deadlineSec uint32, rateLimits map[uint32]*pb.RateLimit, conf *config.GameConf, logger log.Logger) (*Room, ErrorWithCode) {
 	pubProps, iProps, err := common.InitProps(info.PublicProps)
 	if err != nil {
-		return nil, nil, WithCode(xerrors.Errorf("PublicProps unmarshal error: %w", err), codes.InvalidArgument)
+		return nil, WithCode(xerrors.Errorf("PublicProps unmarshal error: %w", err), codes.InvalidArgument)
 	}
 	info.PublicProps = iProps
 	privProps, iProps, err := common.InitProps(info.PrivateProps)
 	if err != nil {
-		return nil, nil, WithCode(xerrors.Errorf("PrivateProps unmarshal error: %w", err), codes.InvalidArgument)
+		return nil, WithCode(xerrors.Errorf("PrivateProps unmarshal error: %w", err), codes.InvalidArgument)
 	}
 	info.PrivateProps = iProps
+	limits, err := repo.rateLimits.With(rateLimits)
+	if err != nil {
+		return nil, WithCode(xerrors.Errorf("RateLimits error: %w", err), codes.InvalidArgument)
+	}
 
 	r := &Room{
 		RoomInfo: info,
 		repo:     repo,
 		conf:     conf,
+		handler:  repo.handler,
 		deadline: time.Duration(deadlineSec) * time.Second,
 
+		rateLimitOption: rateLimits,
+		rateLimits:      limits,
+		peerStats:       &metrics.PeerQueueStats{},
+
 		publicProps:  pubProps,
 		privateProps: privProps,
 
+		publicVersions:  make(map[string]uint64),
+		privateVersions: make(map[string]uint64),
+		propVersion:     initialPropVersion,
+		publicAcls:      newDefaultPropAcls(),
+		privateAcls:     newDefaultPropAcls(),
+
 		msgCh: make(chan Msg, RoomMsgChSize),
 		done:  make(chan struct{}),
 
@@ -79,37 +214,31 @@ func NewRoom(ctx context.Context, repo *Repository, info *pb.RoomInfo, masterInf
 		masterOrder: []ClientID{},
 		watchers:    make(map[ClientID]*Client),
 		lastMsg:     make(binary.Dict),
+		cache:       newEventCache(),
+		delayed:     newDelayQueue(),
+		timers:      newRoomTimers(),
+		lockstep:    newLockstep(),
+		objects:     newObjectRegistry(),
+
+		reservations: make(map[ClientID]*reservation),
+		banned:       make(map[ClientID]time.Time),
 
 		logger: logger,
 
 		chRoomInfo:   make(chan struct{}, 1),
 		lastRoomInfo: info.Clone(),
+
+		chSnapshot: make(chan struct{}, 1),
 	}
+	return r, nil
+}
 
+// start : 部屋のgoroutineを開始する.
+func (r *Room) start() {
 	go r.MsgLoop()
 	go r.roomInfoUpdater()
-
-	jch := make(chan *JoinedInfo, 1)
-	ech := make(chan ErrorWithCode, 1)
-
-	select {
-	case <-ctx.Done():
-		return nil, nil, WithCode(
-			xerrors.Errorf("write msg timeout or context done: room=%v client=%v", r.Id, masterInfo.Id),
-			codes.DeadlineExceeded)
-	case r.msgCh <- &MsgCreate{masterInfo, macKey, jch, ech}:
-	}
-
-	select {
-	case <-ctx.Done():
-		return nil, nil, WithCode(
-			xerrors.Errorf("msgCreate timeout or context done: room=%v client=%v", r.Id, masterInfo.Id),
-			codes.DeadlineExceeded)
-	case ewc := <-ech:
-		return nil, nil, WithCode(
-			xerrors.Errorf("msgCreate: %w", ewc), ewc.Code())
-	case joined := <-jch:
-		return r, joined, nil
+	if r.conf.SnapshotDir != "" {
+		go r.snapshotWriter()
 	}
 }
 
@@ -121,22 +250,61 @@ func (r *Room) ClientConf() *config.ClientConf {
 	return &r.conf.ClientConf
 }
 
+func (r *Room) MsgRateLimits() *MsgRateLimits {
+	return r.rateLimits
+}
+
+func (r *Room) PayloadLimits() *PayloadLimits {
+	return r.repo.limits
+}
+
+func (r *Room) PeerQueueStats() *metrics.PeerQueueStats {
+	return r.peerStats
+}
+
 // MsgLoop goroutine dispatch messages.
 func (r *Room) MsgLoop() {
 	metrics.Rooms.Add(1)
 	defer metrics.Rooms.Add(-1)
+	metrics.PeerQueues.Set(r.Id, r.peerStats)
+	defer metrics.PeerQueues.Delete(r.Id)
+	snapshotCh, stopSnapshot := r.snapshotTicker()
+	defer stopSnapshot()
+	masterIdleCh, stopMasterIdle := r.masterIdleTicker()
+	defer stopMasterIdle()
+	delayCh, stopDelay := r.watcherDelayTicker()
+	defer stopDelay()
+	defer r.timers.stop()
+	lockstepCh, stopLockstep := r.lockstepTicker()
+	defer stopLockstep()
 Loop:
 	for {
 		select {
 		case <-r.Done():
 			r.logger.Infof("room closed: %v", r.Id)
 			break Loop
+		case <-snapshotCh:
+			r.takeSnapshot()
+		case <-masterIdleCh:
+			r.checkMasterIdle(time.Now())
+		case <-delayCh:
+			r.flushDelayed(time.Now())
+		case <-r.timers.C():
+			r.fireTimers(time.Now())
+		case <-lockstepCh:
+			r.stepLockstep()
+		case <-r.emptyTimeout():
+			r.logger.Infof("empty room timeout: %v", r.Id)
+			close(r.done)
+			break Loop
 		case msg := <-r.msgCh:
 			r.updateLastMsg(msg.SenderID())
 			r.dispatch(msg)
 		}
 	}
 	r.repo.RemoveRoom(r)
+	r.stopRecording()
+	r.removeSnapshot()
 	r.drainMsg()
 }
 
@@ -210,26 +378,37 @@ func (r *Room) removePlayer(c *Client, cause string, logmsg PlayerLogMsg) {
 	}
 
 	r.repo.PlayerLog(c, logmsg)
+	r.releasePropOwners(cid)
 
 	c.logger.Infof("player left: %v: %v", cid, cause)
 	c.Removed(cause)
 
-	if len(r.players) == 0 {
-		close(r.done)
-		return
+	if r.handler != nil {
+		r.handler.OnLeave(r, c, cause)
 	}
 
-	if r.master.ID() == cid {
-		r.master = r.players[r.masterOrder[0]]
+	if len(r.players) == 0 {
+		if !r.persistent {
+			close(r.done)
+			return
+		}
+		// 次に入室したPlayerをMasterにする
+		r.master = nil
+		r.startEmptyTimer()
+		r.logger.Infof("room is empty: %v", r.Id)
+	} else if r.master.ID() == cid {
+		r.master = r.nextMaster(cid, 0, time.Now())
 		r.logger.Infof("master switched: %v -> %v", cid, r.master.ID())
 	}
 
 	r.RoomInfo.Players = uint32(len(r.players))
 	r.updateRoomInfo()
 
-	r.broadcast(binary.NewEvLeft(string(cid), r.master.Id, cause))
+	r.broadcast(binary.NewEvLeft(string(cid), string(r.masterID()), cause))
+	r.releaseObjects(cid)
 
 	r.removeLastMsg(cid)
+	r.removeCachedEvents(cid)
 }
 
 func (r *Room) roomInfoUpdater() {
@@ -292,6 +471,11 @@ func (r *Room) removeWatcher(c *Client, cause string) {
 	r.RoomInfo.Watchers -= c.nodeCount
 	r.updateRoomInfo()
 	c.Removed(cause)
+	r.removeCachedEvents(cid)
+
+	if r.handler != nil {
+		r.handler.OnLeave(r, c, cause)
+	}
 }
 
 func (r *Room) dispatch(msg Msg) {
@@ -306,6 +490,8 @@ func (r *Room) dispatch(msg Msg) {
 		r.msgPing(m)
 	case *MsgNodeCount:
 		r.msgNodeCount(m)
+	case *MsgVolatile:
+		r.msgVolatile(m)
 	case *MsgLeave:
 		r.msgLeave(m)
 	case *MsgRoomProp:
@@ -318,18 +504,52 @@ func (r *Room) dispatch(msg Msg) {
 		r.msgToMaster(m)
 	case *MsgBroadcast:
 		r.msgBroadcast(m)
+	case *MsgCachedBroadcast:
+		r.msgCachedBroadcast(m)
 	case *MsgSwitchMaster:
 		r.msgSwitchMaster(m)
 	case *MsgKick:
 		r.msgKick(m)
+	case *MsgBan:
+		r.msgBan(m)
+	case *MsgChangeRole:
+		r.msgChangeRole(m)
+	case *MsgSetTimer:
+		r.msgSetTimer(m)
+	case *MsgCancelTimer:
+		r.msgCancelTimer(m)
+	case *MsgListTimers:
+		r.msgListTimers(m)
+	case *MsgLockstepInput:
+		r.msgLockstepInput(m)
+	case *MsgSpawnObject:
+		r.msgSpawnObject(m)
+	case *MsgUpdateObject:
+		r.msgUpdateObject(m)
+	case *MsgTransferObject:
+		r.msgTransferObject(m)
+	case *MsgDespawnObject:
+		r.msgDespawnObject(m)
+	case *MsgRandom:
+		r.msgRandom(m)
 	case *MsgAdminKick:
 		r.msgAdminKick(m)
+	case *MsgAdminClose:
+		r.msgAdminClose(m)
+	case *MsgReserve:
+		r.msgReserve(m)
 	case *MsgGetRoomInfo:
 		r.msgGetRoomInfo(m)
+	case *MsgMigrate:
+		r.msgMigrate(m)
 	case *MsgClientError:
 		r.msgClientError(m)
 	case *MsgClientTimeout:
 		r.msgClientTimeout(m)
+	case *MsgRateLimitExceeded:
+		r.msgRateLimitExceeded(m)
+	case *MsgSlowPeer:
+		r.msgSlowPeer(m)
 	default:
 		r.logger.Errorf("unknown msg type (%T): %v", m, m)
 	}
@@ -354,12 +574,11 @@ func (r *Room) sendTo(c *Client, ev *binary.RegularEvent) {
 // broadcast : 全員に送信.
 // muClients のロックを取得してから呼び出すこと
 func (r *Room) broadcast(ev *binary.RegularEvent) {
+	r.recordEvent(ev)
 	for _, c := range r.players {
 		r.sendTo(c, ev)
 	}
-	for _, c := range r.watchers {
-		r.sendTo(c, ev)
-	}
+	r.sendToWatchers(ev, "")
 }
 
 func (r *Room) msgCreate(msg *MsgCreate) {
@@ -379,19 +598,54 @@ func (r *Room) msgCreate(msg *MsgCreate) {
 	r.master = master
 	r.players[master.ID()] = master
 	r.masterOrder = append(r.masterOrder, master.ID())
+
+	var tokens map[string]string
+	if len(r.reservedIds) > 0 {
+		// 席数は NewRoom で検査済み
+		tokens, err = r.reserve(r.reservedIds, r.reservationTTL, time.Now())
+		if err != nil {
+			r.logger.Errorf("reserve: %+v", err)
+		}
+	}
 	r.repo.PlayerLog(master, PlayerLogCreate)
 
 	rinfo := r.RoomInfo.Clone()
 	cinfo := r.master.ClientInfo.Clone()
 	players := []*pb.ClientInfo{cinfo}
-	msg.Joined <- &JoinedInfo{rinfo, players, master, master.ID(), r.deadline}
+	msg.Joined <- r.withPropVersions(&JoinedInfo{Room: rinfo, Players: players, Client: master, MasterId: master.ID(), Deadline: r.deadline, InviteTokens: tokens})
 	r.broadcast(binary.NewEvJoined(cinfo))
 
+	if r.record {
+		r.startRecording(rinfo, players)
+	}
+
 	r.writeLastMsg(master.ID())
+
+	if r.handler != nil {
+		r.handler.OnCreate(r, master)
+	}
 }
 
 func (r *Room) msgJoin(msg *MsgJoin) {
-	if !r.Joinable {
+	now := time.Now()
+	if err := r.checkBanned(msg.SenderID(), now); err != nil {
+		msg.Err <- err
+		return
+	}
+
+	r.purgeReservations(now)
+	invited := false
+	if msg.InviteToken != "" {
+		rs, ok := r.reservations[msg.SenderID()]
+		if !ok || rs.token != msg.InviteToken {
+			err := xerrors.Errorf("Invalid invite token. room=%v, client=%v", r.ID(), msg.Info.Id)
+			msg.Err <- NormalWithCode(err, codes.Unauthenticated)
+			return
+		}
+		invited = true
+	}
+
+	if !r.Joinable && !invited {
 		err := xerrors.Errorf("Room is not joinable. room=%v, client=%v", r.ID(), msg.Info.Id)
 		msg.Err <- NormalWithCode(err, codes.FailedPrecondition)
 		return
@@ -409,12 +663,18 @@ func (r *Room) msgJoin(msg *MsgJoin) {
 		return
 	}
 
-	if !rejoin && r.MaxPlayers <= uint32(len(r.players)) {
-		err := xerrors.Errorf("Room full. room=%v max=%v, client=%v", r.ID(), r.MaxPlayers, msg.Info.Id)
+	// 他のクライアントの予約席は空席に数えない
+	if !rejoin && r.MaxPlayers <= uint32(len(r.players)+r.reservedSeats(msg.SenderID())) {
+		err := xerrors.Errorf("Room full. room=%v max=%v, reserved=%v, client=%v", r.ID(), r.MaxPlayers, r.reservedSeats(msg.SenderID()), msg.Info.Id)
 		msg.Err <- NormalWithCode(err, codes.ResourceExhausted)
 		return
 	}
 
+	if err := r.handlerOnJoin(msg.Info, true); err != nil {
+		msg.Err <- err
+		return
+	}
+
 	client, err := NewPlayer(msg.Info, msg.MACKey, r)
 	if err != nil {
 		err = WithCode(
@@ -432,12 +692,20 @@ func (r *Room) msgJoin(msg *MsgJoin) {
 		r.repo.PlayerLog(client, PlayerLogRejoin)
 		client.logger.Infof("rejoin player: %v", client.Id)
 	} else {
+		delete(r.reservations, client.ID())
 		r.masterOrder = append(r.masterOrder, client.ID())
 		r.repo.PlayerLog(client, PlayerLogJoin)
 		r.RoomInfo.Players = uint32(len(r.players))
 		r.updateRoomInfo()
 		client.logger.Infof("new player: %v", client.Id)
 	}
+	newMaster := r.master == nil
+	if newMaster {
+		// 無人の部屋に入室したPlayerがMasterになる
+		r.master = client
+		r.stopEmptyTimer()
+		client.logger.Infof("master assigned: %v", client.Id)
+	}
 
 	rinfo := r.RoomInfo.Clone()
 	cinfo := client.ClientInfo.Clone()
@@ -445,17 +713,51 @@ func (r *Room) msgJoin(msg *MsgJoin) {
 	for _, c := range r.players {
 		players = append(players, c.ClientInfo.Clone())
 	}
-	msg.Joined <- &JoinedInfo{rinfo, players, client, r.master.ID(), r.deadline}
+	msg.Joined <- r.withPropVersions(&JoinedInfo{Room: rinfo, Players: players, Client: client, MasterId: r.master.ID(), Deadline: r.deadline})
 	if rejoin {
 		r.broadcast(binary.NewEvRejoined(cinfo))
 	} else {
 		r.broadcast(binary.NewEvJoined(cinfo))
 	}
+	if newMaster {
+		r.broadcast(binary.NewEvMasterSwitched("", client.Id, binary.MasterSwitchAssigned))
+	}
+	r.sendCachedEvents(client)
+	r.sendObjectSnapshot(client)
+	r.sendLockstepHistory(client)
 
 	r.writeLastMsg(client.ID())
+
+	if r.handler != nil {
+		r.handler.OnJoined(r, client, rejoin)
+	}
+}
+
+// handlerOnJoin : RoomHandler.OnJoinで入室の可否を確認する.
+// ハンドラが返したerrorにgRPCのコードがなければFailedPreconditionとする.
+// PermissionDeniedはBanされたクライアントの拒否に使うので、FailedPreconditionに置き換える.
+func (r *Room) handlerOnJoin(info *pb.ClientInfo, isPlayer bool) ErrorWithCode {
+	if r.handler == nil {
+		return nil
+	}
+	err := r.handler.OnJoin(r, info, isPlayer)
+	if err == nil {
+		return nil
+	}
+	err = xerrors.Errorf("RoomHandler rejected. room=%v, client=%v: %w", r.ID(), info.Id, err)
+	var ewc ErrorWithCode
+	if errors.As(err, &ewc) && ewc.Code() != codes.PermissionDenied {
+		return WithCode(err, ewc.Code())
+	}
+	return NormalWithCode(err, codes.FailedPrecondition)
 }
 
 func (r *Room) msgWatch(msg *MsgWatch) {
+	if err := r.checkBanned(msg.SenderID(), time.Now()); err != nil {
+		msg.Err <- err
+		return
+	}
+
 	if !r.Watchable {
 		err := xerrors.Errorf("Room is not watchable. room=%v, client=%v", r.ID(), msg.Info.Id)
 		msg.Err <- NormalWithCode(err, codes.FailedPrecondition)
@@ -472,6 +774,11 @@ func (r *Room) msgWatch(msg *MsgWatch) {
 		return
 	}
 
+	if err := r.handlerOnJoin(msg.Info, false); err != nil {
+		msg.Err <- err
+		return
+	}
+
 	client, err := NewWatcher(msg.Info, msg.MACKey, r)
 	if err != nil {
 		err = WithCode(
@@ -499,7 +806,82 @@ func (r *Room) msgWatch(msg *MsgWatch) {
 		players = append(players, c.ClientInfo.Clone())
 	}
 
-	msg.Joined <- &JoinedInfo{rinfo, players, client, r.master.ID(), r.deadline}
+	// 遅延中のEventは入室時の部屋情報に反映済み
+	r.delayed.skipPending(client.ID())
+	msg.Joined <- r.withPropVersions(&JoinedInfo{Room: rinfo, Players: players, Client: client, MasterId: r.masterID(), Deadline: r.deadline})
+	r.sendCachedEvents(client)
+	r.sendObjectSnapshot(client)
+	r.sendLockstepHistory(client)
+
+	if r.handler != nil {
+		r.handler.OnJoined(r, client, rejoin)
+	}
+}
+
+// masterID : 無人の部屋では空になる
+func (r *Room) masterID() ClientID {
+	if r.master == nil {
+		return ""
+	}
+	return r.master.ID()
+}
+
+// startEmptyTimer : 無人になった部屋の終了タイマーを開始する
+func (r *Room) startEmptyTimer() {
+	if r.emptyTTL > 0 {
+		r.emptyTimer = time.NewTimer(r.emptyTTL)
+	}
+}
+
+func (r *Room) stopEmptyTimer() {
+	if r.emptyTimer != nil {
+		r.emptyTimer.Stop()
+		r.emptyTimer = nil
+	}
+}
+
+// emptyTimeout : 無人の部屋の終了タイミング. タイマーがなければnil.
+func (r *Room) emptyTimeout() <-chan time.Time {
+	if r.emptyTimer == nil {
+		return nil
+	}
+	return r.emptyTimer.C
+}
+
+// sendCachedEvents : 途中入室したクライアントにキャッシュしたEventを送る.
+// muClients のロックを取得してから呼び出す.
+func (r *Room) sendCachedEvents(c *Client) {
+	for _, e := range r.cache.list() {
+		if c.IsHub {
+			// Hubはキャッシュを保持して、Hubの観戦者に送る
+			r.sendJoinSnapshot(c, binary.NewEvCached(e.key, string(e.sender), e.data))
+		} else {
+			r.sendJoinSnapshot(c, e.ev)
+		}
+	}
+}
+
+// sendCacheToHubs : キャッシュの更新をHubに送る. dataが空ならキャッシュの削除.
+// muClients のロックを取得してから呼び出す.
+func (r *Room) sendCacheToHubs(key string, sender ClientID, data []byte) {
+	var ev *binary.RegularEvent
+	for id, c := range r.watchers {
+		if !c.IsHub {
+			continue
+		}
+		if ev == nil {
+			ev = binary.NewEvCached(key, string(sender), data)
+		}
+		r.sendToWatchers(ev, id)
+	}
+}
+
+// removeCachedEvents : 退室したクライアントが送ったキャッシュを削除する.
+// muClients のロックを取得してから呼び出す.
+func (r *Room) removeCachedEvents(cid ClientID) {
+	for _, key := range r.cache.removeSender(cid) {
+		r.sendCacheToHubs(key, cid, nil)
+	}
 }
 
 func (r *Room) msgPing(msg *MsgPing) {
@@ -514,8 +896,11 @@ func (r *Room) msgPing(msg *MsgPing) {
 			return
 		}
 	}
-	msg.Sender.logger.Debugf("ping %v: %v", msg.Sender.Id, msg.Timestamp)
-	ev := binary.NewEvPong(msg.Timestamp, r.RoomInfo.Watchers, r.lastMsg)
+	msg.Sender.logger.Debugf("ping %v: %v rtt=%v", msg.Sender.Id, msg.Timestamp, msg.RTT)
+	if msg.RTT > 0 {
+		msg.Sender.rtt = msg.RTT
+	}
+	ev := binary.NewEvPong(msg.Timestamp, r.RoomInfo.Watchers, r.lastMsg, msg.ReceivedAt)
 	msg.Sender.SendSystemEvent(ev)
 }
 
@@ -546,12 +931,40 @@ func (r *Room) msgRoomProp(msg *MsgRoomProp) {
 	r.muClients.RLock()
 	defer r.muClients.RUnlock()
 
-	if msg.Sender != r.master {
-		r.logger.Warnf("msgRoomProp: sender %q is not master %q", msg.Sender.Id, r.master.Id)
+	if !r.checkRoomPropAcl(msg) {
+		r.logger.Warnf("msgRoomProp: sender %q is not permitted (master=%q)", msg.Sender.Id, r.masterID())
 		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
 		return
 	}
 
+	pubConflict := checkPropCondition(r.publicProps, r.publicVersions, msg.PublicCond)
+	privConflict := checkPropCondition(r.privateProps, r.privateVersions, msg.PrivateCond)
+	if pubConflict != nil || privConflict != nil {
+		msg.Sender.logger.Infof("msgRoomProp: condition mismatch: public=%v, private=%v", pubConflict, privConflict)
+		r.sendTo(msg.Sender, binary.NewEvPropConflict(msg, pubConflict, privConflict))
+		return
+	}
+
+	if r.handler != nil {
+		if err := r.handler.OnRoomProp(r, msg); err != nil {
+			msg.Sender.logger.Infof("msgRoomProp: rejected by handler: %v", err)
+			r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+			return
+		}
+		// ハンドラによる書き換えをEventに反映する
+		msg.EventPayload = binary.MarshalRoomPropPayload(
+			msg.Visible, msg.Joinable, msg.Watchable, msg.SearchGroup, msg.MaxPlayer, msg.ClientDeadline,
+			msg.PublicProps, msg.PrivateProps)
+	}
+
+	if err := r.repo.limits.checkRoomProps(
+		mergeProps(r.publicProps, msg.PublicProps), msg.PublicProps,
+		mergeProps(r.privateProps, msg.PrivateProps), msg.PrivateProps); err != nil {
+		msg.Sender.logger.Warnf("msgRoomProp: invalid props: %v", err)
+		r.sendTo(msg.Sender, binary.NewEvInvalidPayload(msg))
+		return
+	}
+
 	msg.Sender.logger.Debugf("update room props: v=%v j=%v w=%v group=%v maxp=%v deadline=%v public=%v private=%v",
 		msg.Visible, msg.Joinable, msg.Watchable, msg.SearchGroup, msg.MaxPlayer, msg.ClientDeadline, msg.PublicProps, msg.PrivateProps)
 
@@ -567,25 +980,17 @@ func (r *Room) msgRoomProp(msg *MsgRoomProp) {
 	r.RoomInfo.SearchGroup = msg.SearchGroup
 	r.RoomInfo.MaxPlayers = msg.MaxPlayer
 
+	ver := r.nextPropVersion()
+
 	if len(msg.PublicProps) > 0 {
-		for k, v := range msg.PublicProps {
-			if _, ok := r.publicProps[k]; ok && len(v) == 0 {
-				delete(r.publicProps, k)
-			} else {
-				r.publicProps[k] = v
-			}
-		}
+		applyProps(r.publicProps, r.publicVersions, msg.PublicProps, ver)
+		r.publicAcls.updateOwners(msg.PublicProps, msg.SenderID())
 		r.RoomInfo.PublicProps = binary.MarshalDict(r.publicProps)
 	}
 
 	if len(msg.PrivateProps) > 0 {
-		for k, v := range msg.PrivateProps {
-			if _, ok := r.privateProps[k]; ok && len(v) == 0 {
-				delete(r.privateProps, k)
-			} else {
-				r.privateProps[k] = v
-			}
-		}
+		applyProps(r.privateProps, r.privateVersions, msg.PrivateProps, ver)
+		r.privateAcls.updateOwners(msg.PrivateProps, msg.SenderID())
 		r.RoomInfo.PrivateProps = binary.MarshalDict(r.privateProps)
 	}
 
@@ -608,7 +1013,7 @@ func (r *Room) msgRoomProp(msg *MsgRoomProp) {
 	}
 
 	r.sendTo(msg.Sender, binary.NewEvSucceeded(msg))
-	r.broadcast(binary.NewEvRoomProp(msg.Sender.Id, msg.MsgRoomPropPayload))
+	r.broadcast(binary.NewEvRoomProp(msg.Sender.Id, msg.MsgRoomPropPayload, ver))
 }
 
 func (r *Room) msgClientProp(msg *MsgClientProp) {
@@ -624,22 +1029,41 @@ func (r *Room) msgClientProp(msg *MsgClientProp) {
 		return
 	}
 
+	if conflict := checkPropCondition(msg.Sender.props, msg.Sender.propVersions, msg.Cond); conflict != nil {
+		msg.Sender.logger.Infof("msgClientProp: condition mismatch: %v", conflict)
+		r.sendTo(msg.Sender, binary.NewEvPropConflict(msg, conflict))
+		return
+	}
+
+	props := msg.EventPayload
+	if r.handler != nil {
+		if err := r.handler.OnClientProp(r, msg); err != nil {
+			msg.Sender.logger.Infof("msgClientProp: rejected by handler: %v", err)
+			r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+			return
+		}
+		// ハンドラによる書き換えをEventに反映する
+		props = binary.MarshalDict(msg.Props)
+	}
+
+	if err := r.repo.limits.checkClientProps(mergeProps(msg.Sender.props, msg.Props), msg.Props); err != nil {
+		msg.Sender.logger.Warnf("msgClientProp: invalid props: %v", err)
+		r.sendTo(msg.Sender, binary.NewEvInvalidPayload(msg))
+		return
+	}
+
 	msg.Sender.logger.Debugf("update client prop: %v", msg.Props)
 
+	ver := r.nextPropVersion()
+
 	if len(msg.Props) > 0 {
 		c := msg.Sender
-		for k, v := range msg.Props {
-			if _, ok := c.props[k]; ok && len(v) == 0 {
-				delete(c.props, k)
-			} else {
-				c.props[k] = v
-			}
-		}
+		applyProps(c.props, c.propVersions, msg.Props, ver)
 		c.ClientInfo.Props = binary.MarshalDict(c.props)
 	}
 
 	r.sendTo(msg.Sender, binary.NewEvSucceeded(msg))
-	r.broadcast(binary.NewEvClientProp(msg.Sender.Id, msg.Payload()))
+	r.broadcast(binary.NewEvClientProp(msg.Sender.Id, props, ver))
 }
 
 func (r *Room) msgTargets(msg *MsgTargets) {
@@ -655,6 +1079,10 @@ func (r *Room) msgTargets(msg *MsgTargets) {
 		}
 	}
 
+	if !r.handlerOnMessage(msg.Sender, msg) {
+		return
+	}
+
 	msg.Sender.logger.Debugf("message to targets: %v, %v", msg.Targets, msg.Data)
 
 	ev := binary.NewEvMessage(msg.Sender.Id, msg.Data)
@@ -690,6 +1118,16 @@ func (r *Room) msgToMaster(msg *MsgToMaster) {
 		}
 	}
 
+	if !r.handlerOnMessage(msg.Sender, msg) {
+		return
+	}
+
+	if r.master == nil {
+		msg.Sender.logger.Infof("master is absent")
+		r.sendTo(msg.Sender, binary.NewEvTargetNotFound(msg, []string{}))
+		return
+	}
+
 	msg.Sender.logger.Debugf("message to master: %v", msg.Data)
 
 	r.sendTo(r.master, binary.NewEvMessage(msg.Sender.Id, msg.Data))
@@ -708,18 +1146,70 @@ func (r *Room) msgBroadcast(msg *MsgBroadcast) {
 		}
 	}
 
+	if !r.handlerOnMessage(msg.Sender, msg) {
+		return
+	}
+
 	msg.Sender.logger.Debugf("message to all: %v", msg.Data)
 
 	r.broadcast(binary.NewEvMessage(msg.Sender.Id, msg.Data))
 }
 
+func (r *Room) msgCachedBroadcast(msg *MsgCachedBroadcast) {
+	r.muClients.RLock()
+	defer r.muClients.RUnlock()
+	if msg.Sender.isPlayer {
+		if r.players[msg.SenderID()] != msg.Sender {
+			return
+		}
+	} else {
+		if r.watchers[msg.SenderID()] != msg.Sender {
+			return
+		}
+	}
+
+	if !r.handlerOnMessage(msg.Sender, msg) {
+		return
+	}
+
+	msg.Sender.logger.Debugf("cached message to all: %v %v", msg.Key, msg.Data)
+
+	r.cache.set(msg.Key, msg.SenderID(), msg.Data)
+	if len(msg.Data) > 0 {
+		r.broadcast(binary.NewEvMessage(msg.Sender.Id, msg.Data))
+	}
+	r.sendCacheToHubs(msg.Key, msg.SenderID(), msg.Data)
+}
+
+// handlerOnMessage : RoomHandler.OnMessageを呼び、配送を続けるかを返す.
+// muClients のロックを取得してから呼び出す.
+func (r *Room) handlerOnMessage(sender *Client, msg interface {
+	Msg
+	binary.RegularMsg
+}) bool {
+	if r.handler == nil {
+		return true
+	}
+	switch r.handler.OnMessage(r, msg) {
+	case HandlerDrop:
+		sender.logger.Debugf("message dropped by handler: %v", msg.Type())
+		return false
+	case HandlerDeny:
+		sender.logger.Infof("message denied by handler: %v", msg.Type())
+		r.sendTo(sender, binary.NewEvPermissionDenied(msg))
+		return false
+	}
+	return true
+}
+
 func (r *Room) msgSwitchMaster(msg *MsgSwitchMaster) {
 	r.muClients.RLock()
 	defer r.muClients.RUnlock()
 
 	if msg.Sender != r.master {
-		msg.Sender.logger.Warnf("sender %q is not master %q", msg.Sender.Id, r.master.Id)
+		msg.Sender.logger.Warnf("sender %q is not master %q", msg.Sender.Id, r.masterID())
 		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
 	}
 
 	target, found := r.players[msg.Target]
@@ -734,7 +1224,7 @@ func (r *Room) msgSwitchMaster(msg *MsgSwitchMaster) {
 	msg.Sender.logger.Infof("master switched: %v -> %v", msg.Sender.ID(), r.master.Id)
 
 	r.sendTo(msg.Sender, binary.NewEvSucceeded(msg))
-	r.broadcast(binary.NewEvMasterSwitched(msg.Sender.Id, r.master.Id))
+	r.broadcast(binary.NewEvMasterSwitched(msg.Sender.Id, r.master.Id, binary.MasterSwitchRequested))
 }
 
 func (r *Room) msgKick(msg *MsgKick) {
@@ -742,7 +1232,7 @@ func (r *Room) msgKick(msg *MsgKick) {
 	defer r.muClients.Unlock()
 
 	if msg.Sender != r.master {
-		msg.Sender.logger.Warnf("sender %q is not master %q", msg.Sender.Id, r.master.Id)
+		msg.Sender.logger.Warnf("sender %q is not master %q", msg.Sender.Id, r.masterID())
 		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
 		return
 	}
@@ -757,6 +1247,7 @@ func (r *Room) msgKick(msg *MsgKick) {
 	r.logger.Infof("kick: %v", target.Id)
 	r.sendTo(msg.Sender, binary.NewEvSucceeded(msg))
 
+	r.banKicked(target.ID(), time.Now())
 	r.removeClient(target, msg.Message, PlayerLogKick)
 }
 
@@ -769,10 +1260,17 @@ func (r *Room) msgAdminKick(msg *MsgAdminKick) {
 		return
 	}
 
+	r.banKicked(target.ID(), time.Now())
 	r.removeClient(target, "kicked by admin", PlayerLogKick)
 	msg.Res <- nil
 }
 
+func (r *Room) msgAdminClose(msg *MsgAdminClose) {
+	r.logger.Infof("room closed by admin: %v", r.Id)
+	close(r.done)
+	msg.Res <- nil
+}
+
 func (r *Room) msgGetRoomInfo(msg *MsgGetRoomInfo) {
 	ri := r.RoomInfo.Clone()
 
@@ -782,6 +1280,17 @@ func (r *Room) msgGetRoomInfo(msg *MsgGetRoomInfo) {
 	for _, id := range r.masterOrder {
 		cis = append(cis, r.players[id].ClientInfo.Clone())
 	}
+
+	msg.Res <- &pb.GetRoomInfoRes{
+		RoomInfo:     ri,
+		ClientInfos:  cis,
+		MasterId:     string(r.masterID()),
+		LastMsgTimes: r.lastMsgTimes(),
+	}
+}
+
+// lastMsgTimes : 各Playerの最終Msg受信時刻 (unixtime millisec)
+func (r *Room) lastMsgTimes() map[string]uint64 {
 	lmt := make(map[string]uint64)
 	for p, d := range r.lastMsg {
 		t, _, err := binary.UnmarshalAs(d, binary.TypeULong)
@@ -790,13 +1299,7 @@ func (r *Room) msgGetRoomInfo(msg *MsgGetRoomInfo) {
 		}
 		lmt[p] = t.(uint64)
 	}
-
-	msg.Res <- &pb.GetRoomInfoRes{
-		RoomInfo:     ri,
-		ClientInfos:  cis,
-		MasterId:     r.master.Id,
-		LastMsgTimes: lmt,
-	}
+	return lmt
 }
 
 func (r *Room) msgClientError(msg *MsgClientError) {
@@ -811,6 +1314,12 @@ func (r *Room) msgClientTimeout(msg *MsgClientTimeout) {
 	r.removeClient(msg.Sender, "timeout", PlayerLogTimeout)
 }
 
+func (r *Room) msgRateLimitExceeded(msg *MsgRateLimitExceeded) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+	r.removeClient(msg.Sender, "rate limit exceeded", PlayerLogKick)
+}
+
 // IRoom実装
 
 func (r *Room) Deadline() time.Duration {
@@ -835,3 +1344,77 @@ func (r *Room) SendMessage(msg Msg) {
 func (r *Room) Repo() IRepo {
 	return r.repo
 }
+
+// startRecording : 記録ファイルを作成して記録を開始する.
+// 記録できなくても部屋は継続する.
+func (r *Room) startRecording(rinfo *pb.RoomInfo, players []*pb.ClientInfo) {
+	path := RecordPath(r.conf.RecordDir, r.AppId, r.ID())
+	rec, err := NewRecorder(path, &pb.JoinedRoomRes{
+		RoomInfo: rinfo,
+		Players:  players,
+		MasterId: string(r.masterID()),
+		Deadline: uint32(r.deadline / time.Second),
+	})
+	if err != nil {
+		r.logger.Errorf("start recording: %+v", err)
+		return
+	}
+	r.logger.Infof("start recording: %v", path)
+	r.recorder = rec
+}
+
+// resumeRecording : 部屋の移動や復元の後、既存の記録ファイルに続けて記録する.
+// 記録ファイルが無いときは現在の部屋情報から記録を開始する.
+func (r *Room) resumeRecording() {
+	if !r.record {
+		return
+	}
+	players := make([]*pb.ClientInfo, 0, len(r.masterOrder))
+	for _, id := range r.masterOrder {
+		players = append(players, r.players[id].ClientInfo.Clone())
+	}
+	path := RecordPath(r.conf.RecordDir, r.AppId, r.ID())
+	rec, err := AppendRecorder(path, &pb.JoinedRoomRes{
+		RoomInfo: r.RoomInfo.Clone(),
+		Players:  players,
+		MasterId: string(r.masterID()),
+		Deadline: uint32(r.deadline / time.Second),
+	})
+	if err != nil {
+		r.logger.Errorf("resume recording: %+v", err)
+		return
+	}
+	r.logger.Infof("resume recording: %v", path)
+	r.recorder = rec
+}
+
+// recordEvent : broadcastするEventを記録する.
+// muClients のロックを取得してから呼び出す.
+func (r *Room) recordEvent(ev *binary.RegularEvent) {
+	if r.recorder == nil {
+		return
+	}
+	if err := r.recorder.Record(ev); err != nil {
+		r.logger.Errorf("record event: %+v", err)
+		r.recorder.Close()
+		r.recorder = nil
+	}
+}
+
+func (r *Room) stopRecording() {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+	r.closeRecorder()
+}
+
+// closeRecorder : 記録ファイルを閉じる.
+// muClients のロックを取得してから呼び出す.
+func (r *Room) closeRecorder() {
+	if r.recorder == nil {
+		return
+	}
+	if err := r.recorder.Close(); err != nil {
+		r.logger.Errorf("stop recording: %+v", err)
+	}
+	r.recorder = nil
+}
@@ -0,0 +1,233 @@
+package game
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"wsnet2/binary"
+	"wsnet2/config"
+	"wsnet2/pb"
+)
+
+// newTestRoom : MsgLoopを動かしたテスト用の部屋. masterが入室済み.
+// DBはsqlmockなので、player_logなどの書き込みはエラーをログに出すだけで無視される.
+func newTestRoom(t *testing.T, op *pb.RoomOption, handler RoomHandler) (*Room, *Client) {
+	t.Helper()
+	db, _ := newDbMock(t)
+	conf := &config.GameConf{
+		ClientConf: config.ClientConf{
+			EventBufSize: 128,
+			AuthKeyLen:   8,
+		},
+	}
+	rateLimits, err := NewMsgRateLimits(&conf.ClientConf)
+	if err != nil {
+		t.Fatalf("NewMsgRateLimits: %v", err)
+	}
+	limits, err := NewPayloadLimits(conf, "testapp")
+	if err != nil {
+		t.Fatalf("NewPayloadLimits: %v", err)
+	}
+	repo := &Repository{
+		app:        &pb.App{Id: "testapp"},
+		conf:       conf,
+		db:         db,
+		handler:    handler,
+		rateLimits: rateLimits,
+		limits:     limits,
+		rooms:      make(map[RoomID]*Room),
+		clients:    make(map[ClientID]map[RoomID]*Client),
+	}
+
+	if op == nil {
+		op = &pb.RoomOption{}
+	}
+	if op.ClientDeadline == 0 {
+		op.ClientDeadline = 30
+	}
+	if op.MaxPlayers == 0 {
+		op.MaxPlayers = 4
+	}
+	info := &pb.RoomInfo{
+		Id:         "room1",
+		AppId:      "testapp",
+		Joinable:   true,
+		Watchable:  true,
+		MaxPlayers: op.MaxPlayers,
+		Number:     &pb.RoomNumber{},
+	}
+	info.SetCreated(time.Now())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	r, joined, ewc := NewRoom(ctx, repo, info, op, &pb.ClientInfo{Id: "master"}, "mackey", conf, zap.NewNop().Sugar())
+	if ewc != nil {
+		t.Fatalf("NewRoom: %v", ewc)
+	}
+	t.Cleanup(func() {
+		select {
+		case <-r.Done():
+		default:
+			adminClose(r)
+		}
+	})
+	return r, joined.Client
+}
+
+// joinRoom : Playerとして入室する
+func joinRoom(r *Room, id string) (*JoinedInfo, ErrorWithCode) {
+	jch := make(chan *JoinedInfo, 1)
+	ech := make(chan ErrorWithCode, 1)
+	r.msgCh <- &MsgJoin{Info: &pb.ClientInfo{Id: id}, MACKey: "mackey", Joined: jch, Err: ech}
+	select {
+	case j := <-jch:
+		return j, nil
+	case err := <-ech:
+		return nil, err
+	}
+}
+
+// watchRoom : Watcherとして入室する
+func watchRoom(r *Room, id string) (*JoinedInfo, ErrorWithCode) {
+	jch := make(chan *JoinedInfo, 1)
+	ech := make(chan ErrorWithCode, 1)
+	r.msgCh <- &MsgWatch{Info: &pb.ClientInfo{Id: id}, MACKey: "mackey", Joined: jch, Err: ech}
+	select {
+	case j := <-jch:
+		return j, nil
+	case err := <-ech:
+		return nil, err
+	}
+}
+
+// getRoomInfo : 部屋の情報. 先に送ったMsgの処理を待つのにも使う
+func getRoomInfo(r *Room) *pb.GetRoomInfoRes {
+	ch := make(chan *pb.GetRoomInfoRes, 1)
+	r.msgCh <- &MsgGetRoomInfo{Res: ch}
+	return <-ch
+}
+
+func adminClose(r *Room) {
+	ch := make(chan error, 1)
+	r.msgCh <- &MsgAdminClose{Res: ch}
+	<-ch
+}
+
+func broadcastMsg(c *Client, seq int, data []byte) *MsgBroadcast {
+	return &MsgBroadcast{
+		RegularMsg: binary.NewRegularMsg(binary.MsgTypeBroadcast, seq, data),
+		Sender:     c,
+		Data:       data,
+	}
+}
+
+// received : クライアントのevbufに書き込まれたEvent
+func received(t *testing.T, c *Client) []*binary.RegularEvent {
+	t.Helper()
+	evs, err := c.evbuf.Read(0)
+	if err != nil {
+		t.Fatalf("evbuf.Read: %v", err)
+	}
+	return evs
+}
+
+// receivedTypes : クライアントのevbufに書き込まれたEventの種類
+func receivedTypes(t *testing.T, c *Client) []binary.EvType {
+	t.Helper()
+	var types []binary.EvType
+	for _, ev := range received(t, c) {
+		types = append(types, ev.Type())
+	}
+	return types
+}
+
+func TestPersistentRoom(t *testing.T) {
+	r, master := newTestRoom(t, &pb.RoomOption{Persistent: true}, nil)
+	joined, err := watchRoom(r, "w1")
+	if err != nil {
+		t.Fatalf("watch w1: %v", err)
+	}
+	w1 := joined.Client
+
+	// 最後のPlayerが退室しても部屋は残り、Masterがいなくなる
+	r.msgCh <- &MsgLeave{Sender: master, Message: "bye"}
+	if res := getRoomInfo(r); res.RoomInfo.Players != 0 {
+		t.Fatalf("players = %v, wants 0", res.RoomInfo.Players)
+	}
+	select {
+	case <-r.Done():
+		t.Fatalf("persistent room closed after the last player left")
+	default:
+	}
+	evs := received(t, w1)
+	left, e := binary.UnmarshalEvLeftPayload(evs[len(evs)-1].Payload())
+	if e != nil {
+		t.Fatalf("UnmarshalEvLeftPayload: %v", e)
+	}
+	if left.ClientId != "master" || left.MasterId != "" {
+		t.Fatalf("EvLeft = %+v, wants client=master master=\"\"", left)
+	}
+
+	// 無人の部屋を観戦するとMasterは空
+	joined, err = watchRoom(r, "w2")
+	if err != nil {
+		t.Fatalf("watch w2: %v", err)
+	}
+	if joined.MasterId != "" {
+		t.Fatalf("master of the empty room = %q, wants empty", joined.MasterId)
+	}
+
+	// 次に入室したPlayerがMasterになる
+	joined, err = joinRoom(r, "master")
+	if err != nil {
+		t.Fatalf("rejoin master: %v", err)
+	}
+	if joined.MasterId != "master" {
+		t.Fatalf("master = %q, wants master", joined.MasterId)
+	}
+	getRoomInfo(r)
+	evs = received(t, w1)
+	ev := evs[len(evs)-1]
+	if ev.Type() != binary.EvTypeMasterSwitched {
+		t.Fatalf("last event = %v, wants EvTypeMasterSwitched", ev.Type())
+	}
+	id, reason, e := binary.UnmarshalEvMasterSwitchedPayload(ev.Payload())
+	if e != nil {
+		t.Fatalf("UnmarshalEvMasterSwitchedPayload: %v", e)
+	}
+	if id != "master" || reason != binary.MasterSwitchAssigned {
+		t.Fatalf("EvMasterSwitched = %v %v, wants master %v", id, reason, binary.MasterSwitchAssigned)
+	}
+}
+
+func TestEmptyRoomTimeout(t *testing.T) {
+	r, master := newTestRoom(t, &pb.RoomOption{Persistent: true, EmptyTtl: 1}, nil)
+
+	r.msgCh <- &MsgLeave{Sender: master, Message: "bye"}
+	select {
+	case <-r.Done():
+		t.Fatalf("room closed before empty_ttl")
+	case <-time.After(500 * time.Millisecond):
+	}
+	select {
+	case <-r.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatalf("room is not closed after empty_ttl")
+	}
+}
+
+func TestAdminClose(t *testing.T) {
+	r, master := newTestRoom(t, &pb.RoomOption{Persistent: true}, nil)
+	r.msgCh <- &MsgLeave{Sender: master, Message: "bye"}
+
+	// 無人のpersistentな部屋も管理者は閉じられる
+	adminClose(r)
+	select {
+	case <-r.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("room is not closed by admin")
+	}
+}
@@ -108,7 +108,7 @@ func (sv *GameService) Join(ctx context.Context, in *pb.JoinRoomReq) (*pb.Joined
 		return nil, status.Errorf(codes.Internal, "Invalid app_id: %v", in.AppId)
 	}
 
-	res, err := repo.JoinRoom(ctx, in.RoomId, in.ClientInfo, in.MacKey)
+	res, err := repo.JoinRoom(ctx, in.RoomId, in.ClientInfo, in.MacKey, in.InviteToken)
 	if err != nil {
 		logEWC(logger, "repo.JoinRoom", err)
 		return nil, status.Errorf(err.Code(), "JoinRoom failed: %s", err)
@@ -199,6 +199,91 @@ func (sv *GameService) Kick(ctx context.Context, in *pb.KickReq) (*pb.Empty, err
 	return &pb.Empty{}, nil
 }
 
+func (sv *GameService) Close(ctx context.Context, in *pb.CloseReq) (*pb.Empty, error) {
+	logger := log.GetLoggerWith(
+		log.KeyHandler, "grpc:Close",
+		log.KeyApp, in.AppId,
+		log.KeyRoom, in.RoomId,
+		log.KeyRequestedAt, float64(time.Now().UnixMilli())/1000,
+	)
+	logger.Debugf("gRPC Close: %v", in.RoomId)
+	repo, ok := sv.repos[in.AppId]
+	if !ok {
+		logger.Errorf("invalid app_id: %v", in.AppId)
+		return nil, status.Errorf(codes.Internal, "Invalid app_id: %v", in.AppId)
+	}
+	err := repo.AdminClose(ctx, in.RoomId)
+	if err != nil {
+		logger.Errorf("repo.AdminClose: %+v", err)
+		return nil, err
+	}
+
+	logger.Infof("gRPC Close OK: room=%q", in.RoomId)
+
+	return &pb.Empty{}, nil
+}
+
+func (sv *GameService) Reserve(ctx context.Context, in *pb.ReserveReq) (*pb.ReserveRes, error) {
+	logger := log.GetLoggerWith(
+		log.KeyHandler, "grpc:Reserve",
+		log.KeyApp, in.AppId,
+		log.KeyClient, in.RequesterId,
+		log.KeyRoom, in.RoomId,
+		log.KeyRequestedAt, float64(time.Now().UnixMilli())/1000,
+	)
+	logger.Debugf("gRPC Reserve: %v %v", in.RoomId, in.ClientIds)
+
+	repo, ok := sv.repos[in.AppId]
+	if !ok {
+		logger.Errorf("invalid app_id: %v", in.AppId)
+		return nil, status.Errorf(codes.Internal, "Invalid app_id: %v", in.AppId)
+	}
+
+	tokens, err := repo.Reserve(ctx, in.RoomId, in.RequesterId, in.ClientIds, time.Duration(in.Ttl)*time.Second)
+	if err != nil {
+		logEWC(logger, "repo.Reserve", err)
+		return nil, status.Errorf(err.Code(), "Reserve failed: %s", err)
+	}
+
+	logger.Infof("gRPC Reserve OK: room=%v clients=%v", in.RoomId, in.ClientIds)
+
+	return &pb.ReserveRes{InviteTokens: tokens}, nil
+}
+
+func (sv *GameService) Migrate(ctx context.Context, in *pb.MigrateReq) (*pb.MigrateRes, error) {
+	logger := log.GetLoggerWith(
+		log.KeyHandler, "grpc:Migrate",
+		log.KeyApp, in.AppId,
+		log.KeyRoom, in.RoomInfo.GetId(),
+		log.KeyRequestedAt, float64(time.Now().UnixMilli())/1000,
+	)
+	logger.Debugf("gRPC Migrate: %v", in.RoomInfo.GetId())
+
+	if sv.shutdownRequested() {
+		logger.Infof("gRPC Migrate: the host is shutting down")
+		return nil, status.Errorf(codes.Unavailable, "the host is shutting down")
+	}
+
+	repo, ok := sv.repos[in.AppId]
+	if !ok {
+		logger.Errorf("invalid app_id: %v", in.AppId)
+		return nil, status.Errorf(codes.NotFound, "Invalid app_id: %v", in.AppId)
+	}
+
+	if err := repo.MigrateRoom(ctx, in); err != nil {
+		logEWC(logger, "repo.MigrateRoom", err)
+		return nil, status.Errorf(err.Code(), "MigrateRoom failed: %s", err)
+	}
+
+	res := &pb.MigrateRes{
+		Url: fmt.Sprintf(sv.wsURLFormat, in.RoomInfo.Id),
+	}
+
+	logger.Infof("gRPC Migrate OK: room=%v", in.RoomInfo.Id)
+
+	return res, nil
+}
+
 func logEWC(logger log.Logger, msg string, err game.ErrorWithCode) {
 	if err.IsNormal() {
 		logger.Infof("%s: %v", msg, err)
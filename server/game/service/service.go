@@ -2,10 +2,15 @@ package service
 
 import (
 	"context"
+	"fmt"
+	"math/rand"
 	"sync"
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"golang.org/x/xerrors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
 
 	"wsnet2/common"
 	"wsnet2/config"
@@ -166,6 +171,10 @@ func (s *GameService) Shutdown(ctx context.Context) {
 		return
 	}
 
+	if s.conf.MigrateOnShutdown {
+		s.migrateRooms(ctx)
+	}
+
 	// Wait for all the rooms to be closed
 	ticker := time.NewTicker(500 * time.Millisecond)
 	defer ticker.Stop()
@@ -185,6 +194,58 @@ func (s *GameService) Shutdown(ctx context.Context) {
 	}
 }
 
+type migrateHost struct {
+	Id       uint32 `db:"id"`
+	Hostname string `db:"hostname"`
+	GRPCPort int    `db:"grpc_port"`
+}
+
+// migrateRooms : 稼働中の他のgameサーバに部屋を移動する
+func (s *GameService) migrateRooms(ctx context.Context) {
+	var hosts []*migrateHost
+	validHeartbeat := time.Now().Add(-3 * time.Duration(s.conf.HeartBeatInterval)).Unix()
+	err := s.db.Select(&hosts,
+		"SELECT id, hostname, grpc_port FROM game_server WHERE id <> ? AND status = ? AND heartbeat >= ?",
+		s.HostId, common.HostStatusRunning, validHeartbeat)
+	if err != nil {
+		log.Errorf("select game_server: %+v", err)
+		return
+	}
+	if len(hosts) == 0 {
+		log.Infof("no host to migrate rooms")
+		return
+	}
+
+	log.Infof("migrating %v rooms to %v hosts", s.numRooms(), len(hosts))
+
+	pool := common.NewGrpcPool(grpc.WithTransportCredentials(insecure.NewCredentials()))
+	migrate := func(req *pb.MigrateReq) (string, error) {
+		var lasterr error
+		for _, n := range rand.Perm(len(hosts)) {
+			h := hosts[n]
+			conn, err := pool.Get(fmt.Sprintf("%s:%d", h.Hostname, h.GRPCPort))
+			if err != nil {
+				lasterr = xerrors.Errorf("grpcPool get (host=%v): %w", h.Id, err)
+				continue
+			}
+			ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			res, err := pb.NewGameClient(conn).Migrate(ctx, req)
+			cancel()
+			if err != nil {
+				lasterr = xerrors.Errorf("gRPC Migrate (host=%v): %w", h.Id, err)
+				continue
+			}
+			return res.Url, nil
+		}
+		return "", lasterr
+	}
+
+	logger := log.GetLoggerWith(log.KeyHandler, "migrate")
+	for _, repo := range s.repos {
+		repo.MigrateRooms(ctx, migrate, logger)
+	}
+}
+
 func (s *GameService) numRooms() int {
 	numRooms := 0
 	for _, repo := range s.repos {
@@ -0,0 +1,85 @@
+package game
+
+import (
+	"github.com/shiguredo/websocket"
+	"golang.org/x/xerrors"
+
+	"wsnet2/config"
+)
+
+// 遅いPeer
+//
+// Peerへの送信はPeer毎の送信キューに積み、writeLoopのgoroutineだけがwebsocketに書き込む.
+// RoomやHubのMsgLoopはキューに積むだけなので、書き込みが遅いクライアントがいても止まらない.
+//
+// キューが溢れたPeerは遅いとみなし、ClientConf.SlowPeerPolicy に従って処理する.
+//   - disconnect: Peerを切断する. クライアントは再接続して未受信のEventを受け取る
+//   - drop_volatile: キューが半分以上埋まっている間に届いた揮発性イベントを捨てる. 溢れたらdisconnectと同じ
+//   - kick: クライアントを退室させる
+//
+// 遅いPeerと捨てた揮発性イベントの数は metrics.PeerQueues に部屋毎に記録する.
+
+// SlowPeerPolicy : 送信キューが溢れたPeerの処理
+type SlowPeerPolicy int
+
+const (
+	// SlowPeerDisconnect : Peerを切断する
+	SlowPeerDisconnect SlowPeerPolicy = iota
+	// SlowPeerDropVolatile : キューが混んでいる間は揮発性イベントを捨て、溢れたら切断する
+	SlowPeerDropVolatile
+	// SlowPeerKick : クライアントを退室させる
+	SlowPeerKick
+)
+
+var slowPeerPolicies = map[string]SlowPeerPolicy{
+	"":              SlowPeerDisconnect,
+	"disconnect":    SlowPeerDisconnect,
+	"drop_volatile": SlowPeerDropVolatile,
+	"kick":          SlowPeerKick,
+}
+
+// ParseSlowPeerPolicy : 設定ファイルのslow_peer_policy
+func ParseSlowPeerPolicy(conf *config.ClientConf) (SlowPeerPolicy, error) {
+	policy, ok := slowPeerPolicies[conf.SlowPeerPolicy]
+	if !ok {
+		return 0, xerrors.Errorf("invalid slow_peer_policy: %q", conf.SlowPeerPolicy)
+	}
+	return policy, nil
+}
+
+// peerQueueSize : Peer毎の送信キューの長さ
+func peerQueueSize(conf *config.ClientConf) int {
+	if conf.PeerQueueSize > 0 {
+		return conf.PeerQueueSize
+	}
+	return conf.EventBufSize * 2
+}
+
+// dropVolatile : 揮発性イベントを捨てるか
+func (p *Peer) dropVolatile() bool {
+	return p.policy == SlowPeerDropVolatile && len(p.outq) >= cap(p.outq)/2
+}
+
+// slow : 送信キューが溢れたときの処理.
+// p.muWrite をロックしてから呼び出す.
+func (p *Peer) slow() {
+	if p.slowed {
+		return // 処理済み. 以降のデータは捨てる
+	}
+	p.slowed = true
+	p.client.logger.Warnf("slow peer (%v, peer=%p): queue=%v", p.client.Id, p, cap(p.outq))
+	p.client.room.PeerQueueStats().AddSlowPeer()
+
+	if p.policy == SlowPeerKick {
+		// 退室処理でPeerも閉じる
+		go p.client.room.SendMessage(&MsgSlowPeer{Sender: p.client})
+		return
+	}
+	p.sendCloseAndCloseConn(websocket.CloseInternalServerErr, "slow peer")
+}
+
+func (r *Room) msgSlowPeer(msg *MsgSlowPeer) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+	r.removeClient(msg.Sender, "slow peer", PlayerLogKick)
+}
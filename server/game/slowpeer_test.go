@@ -0,0 +1,108 @@
+package game
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+
+	"wsnet2/binary"
+	"wsnet2/config"
+	"wsnet2/metrics"
+	"wsnet2/pb"
+)
+
+func TestParseSlowPeerPolicy(t *testing.T) {
+	tests := map[string]SlowPeerPolicy{
+		"":              SlowPeerDisconnect,
+		"disconnect":    SlowPeerDisconnect,
+		"drop_volatile": SlowPeerDropVolatile,
+		"kick":          SlowPeerKick,
+	}
+	for name, want := range tests {
+		policy, err := ParseSlowPeerPolicy(&config.ClientConf{SlowPeerPolicy: name})
+		if err != nil {
+			t.Fatalf("ParseSlowPeerPolicy(%q): %+v", name, err)
+		}
+		if policy != want {
+			t.Errorf("ParseSlowPeerPolicy(%q) = %v, wants %v", name, policy, want)
+		}
+	}
+	if _, err := ParseSlowPeerPolicy(&config.ClientConf{SlowPeerPolicy: "close"}); err == nil {
+		t.Errorf("ParseSlowPeerPolicy must fail for unknown policy")
+	}
+
+	if n := peerQueueSize(&config.ClientConf{EventBufSize: 128}); n != 256 {
+		t.Errorf("peerQueueSize = %v, wants 256", n)
+	}
+	if n := peerQueueSize(&config.ClientConf{EventBufSize: 128, PeerQueueSize: 1000}); n != 1000 {
+		t.Errorf("peerQueueSize = %v, wants 1000", n)
+	}
+}
+
+func TestPeerQueueOverflow(t *testing.T) {
+	r := &Room{
+		msgCh:     make(chan Msg, 1),
+		done:      make(chan struct{}),
+		peerStats: &metrics.PeerQueueStats{},
+		logger:    zap.NewNop().Sugar(),
+	}
+	c := &Client{ClientInfo: &pb.ClientInfo{Id: "c1"}, room: r, logger: r.logger, volatile: newVolatileQueue()}
+	p := &Peer{
+		client: c,
+		outq:   make(chan outFrame, 4),
+		policy: SlowPeerKick,
+	}
+	c.peer = p
+	ev := binary.NewEvPeerReady(0, 0)
+
+	for i := 0; i < 4; i++ {
+		p.SendSystemEvent(ev)
+	}
+	if p.slowed {
+		t.Fatalf("peer slowed before overflow")
+	}
+
+	// 揮発性イベントは送信キューに積まないので溢れていても遅いPeerにならない
+	c.SendVolatile("p1", "pos", nil)
+	if p.slowed || len(p.outq) != 4 {
+		t.Fatalf("volatile event queued: slowed=%v queued=%v", p.slowed, len(p.outq))
+	}
+	if vals := c.volatile.take(); len(vals) != 1 {
+		t.Fatalf("pending volatile: %v, wants 1", vals)
+	}
+
+	p.SendSystemEvent(ev)
+	if !p.slowed {
+		t.Fatalf("peer must be slowed")
+	}
+	msg := <-r.msgCh
+	if m, ok := msg.(*MsgSlowPeer); !ok || m.Sender != c {
+		t.Fatalf("room msg: %#v, wants MsgSlowPeer", msg)
+	}
+	if got := r.peerStats.String(); got != `{"slow_peers": 1, "volatile_dropped": 0}` {
+		t.Errorf("peerStats: %v", got)
+	}
+
+	// drop_volatile: キューが半分埋まったら揮発性イベントを捨てる
+	r.peerStats = &metrics.PeerQueueStats{}
+	p = &Peer{
+		client: c,
+		outq:   make(chan outFrame, 4),
+		policy: SlowPeerDropVolatile,
+	}
+	c.peer = p
+	c.SendVolatile("p1", "pos", nil)
+	p.SendSystemEvent(ev)
+	p.SendSystemEvent(ev)
+	c.SendVolatile("p1", "pos", nil)
+	c.SendVolatile("p1", "rot", nil)
+	if vals := c.volatile.take(); len(vals) != 1 {
+		t.Fatalf("pending volatile: %v, wants 1", vals)
+	}
+	if p.slowed {
+		t.Fatalf("peer slowed by volatile events")
+	}
+	if got := r.peerStats.String(); got != `{"slow_peers": 0, "volatile_dropped": 2}` {
+		t.Errorf("peerStats: %v", got)
+	}
+}
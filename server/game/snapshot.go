@@ -0,0 +1,225 @@
+package game
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/xerrors"
+	"google.golang.org/protobuf/proto"
+
+	"wsnet2/config"
+	"wsnet2/log"
+	"wsnet2/pb"
+)
+
+// 部屋のスナップショット
+//
+// GameConf.SnapshotDir が設定されているとき、部屋の状態（部屋の移動と同じ pb.MigrateReq）を
+// SnapshotInterval 毎にファイルに保存する.
+// gameサーバが異常終了して再起動したとき、NewRepos はスナップショットから部屋を復元する.
+// クライアントは既存の認証キーで再接続できる. スナップショット以降の変更は失われる.
+//
+// スナップショットにはクライアントの認証キーが含まれるので、他のユーザから読めないようにする.
+
+const (
+	// SnapshotFileExt : スナップショットファイルの拡張子
+	SnapshotFileExt = ".snap"
+)
+
+func snapshotPath(dir string, appId pb.AppId, roomId RoomID) string {
+	return filepath.Join(dir, appId, string(roomId)+SnapshotFileExt)
+}
+
+// snapshotTicker : スナップショットを取るタイミング. 無効ならnil.
+func (r *Room) snapshotTicker() (<-chan time.Time, func()) {
+	if r.conf.SnapshotDir == "" || r.conf.SnapshotInterval <= 0 {
+		return nil, func() {}
+	}
+	t := time.NewTicker(time.Duration(r.conf.SnapshotInterval))
+	return t.C, t.Stop
+}
+
+// takeSnapshot : 部屋の状態を保存する.
+// MsgLoopから呼ばれる. ファイルへの書き込みは snapshotWriter で行う.
+// 復元後に記録を続けられるよう、記録ファイルのバッファもここで書き出す.
+func (r *Room) takeSnapshot() {
+	r.muClients.RLock()
+	data, err := proto.Marshal(r.snapshot())
+	if r.recorder != nil {
+		if err := r.recorder.Flush(); err != nil {
+			r.logger.Errorf("flush record: %+v", err)
+		}
+	}
+	r.muClients.RUnlock()
+	if err != nil {
+		r.logger.Errorf("marshal snapshot: %+v", err)
+		return
+	}
+
+	r.mSnapshot.Lock()
+	r.lastSnapshot = data
+	r.mSnapshot.Unlock()
+
+	select {
+	case r.chSnapshot <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Room) snapshotWriter() {
+	path := snapshotPath(r.conf.SnapshotDir, r.AppId, r.ID())
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-r.chSnapshot:
+		}
+
+		r.mSnapshot.Lock()
+		if r.lastSnapshot != nil {
+			if err := writeSnapshot(path, r.lastSnapshot); err != nil {
+				r.logger.Errorf("write snapshot: %+v", err)
+			}
+			r.lastSnapshot = nil
+		}
+		r.mSnapshot.Unlock()
+	}
+}
+
+// removeSnapshot : 部屋の終了時にスナップショットを削除する.
+func (r *Room) removeSnapshot() {
+	if r.conf.SnapshotDir == "" {
+		return
+	}
+	r.mSnapshot.Lock()
+	defer r.mSnapshot.Unlock()
+	r.lastSnapshot = nil
+	path := snapshotPath(r.conf.SnapshotDir, r.AppId, r.ID())
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		r.logger.Errorf("remove snapshot: %+v", err)
+	}
+}
+
+func writeSnapshot(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return xerrors.Errorf("mkdir: %w", err)
+	}
+	// 書き込み途中で終了しても前回のスナップショットが残るよう、一時ファイルに書いてrenameする
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return xerrors.Errorf("write: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return xerrors.Errorf("rename: %w", err)
+	}
+	return nil
+}
+
+// restoreSnapshots : このホストの部屋をスナップショットから復元する.
+// 復元できた部屋のIDを返す.
+func restoreSnapshots(db *sqlx.DB, repos map[pb.AppId]*Repository, conf *config.GameConf, hostId uint32) ([]string, error) {
+	if conf.SnapshotDir == "" {
+		return nil, nil
+	}
+
+	var rooms []struct {
+		Id    string `db:"id"`
+		AppId string `db:"app_id"`
+	}
+	if err := db.Select(&rooms, "SELECT id, app_id FROM room WHERE host_id=?", hostId); err != nil {
+		return nil, xerrors.Errorf("select rooms: %w", err)
+	}
+	ids := make(map[pb.AppId]map[string]bool)
+	for _, r := range rooms {
+		if ids[r.AppId] == nil {
+			ids[r.AppId] = make(map[string]bool)
+		}
+		ids[r.AppId][r.Id] = true
+	}
+
+	var restored []string
+	for appId, repo := range repos {
+		restored = append(restored, repo.restoreRooms(ids[appId])...)
+	}
+	return restored, nil
+}
+
+// restoreRooms : スナップショットから部屋を復元する.
+// ids はこのホストの部屋としてDBに残っている部屋ID. 復元できた部屋IDを返す.
+// 復元しなかったスナップショットは削除する.
+func (repo *Repository) restoreRooms(ids map[string]bool) []string {
+	var restored []string
+	dir := filepath.Join(repo.conf.SnapshotDir, repo.app.Id)
+	files, err := filepath.Glob(filepath.Join(dir, "*"+SnapshotFileExt))
+	if err != nil {
+		log.Errorf("snapshot glob: %+v", err)
+		return nil
+	}
+
+	for _, file := range files {
+		roomId := strings.TrimSuffix(filepath.Base(file), SnapshotFileExt)
+		if ids[roomId] {
+			if err := repo.restoreRoom(file); err != nil {
+				log.Errorf("restore room %v: %+v", roomId, err)
+			} else {
+				restored = append(restored, roomId)
+				continue
+			}
+		}
+		if err := os.Remove(file); err != nil {
+			log.Errorf("remove snapshot: %+v", err)
+		}
+	}
+	return restored
+}
+
+func (repo *Repository) restoreRoom(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return xerrors.Errorf("read: %w", err)
+	}
+	var req pb.MigrateReq
+	if err := proto.Unmarshal(data, &req); err != nil {
+		return xerrors.Errorf("unmarshal: %w", err)
+	}
+	if req.RoomInfo == nil {
+		return xerrors.Errorf("no room info")
+	}
+
+	logger := log.Get(log.CurrentLevel()).With(log.KeyApp, repo.app.Id, log.KeyRoom, req.RoomInfo.Id)
+
+	room, ewc := restoreRoom(repo, &req, logger)
+	if ewc != nil {
+		return xerrors.Errorf("restoreRoom: %w", ewc)
+	}
+
+	for _, c := range room.players {
+		c.mu.Lock()
+		c.restored = true
+		c.mu.Unlock()
+	}
+	for _, c := range room.watchers {
+		c.mu.Lock()
+		c.restored = true
+		c.mu.Unlock()
+	}
+
+	repo.mu.Lock()
+	repo.rooms[room.ID()] = room
+	for _, c := range room.players {
+		repo.addClient(room, c)
+	}
+	for _, c := range room.watchers {
+		repo.addClient(room, c)
+	}
+	repo.mu.Unlock()
+
+	room.resumeRecording()
+	room.start()
+
+	logger.Infof("room restored from snapshot: %v, players=%v watchers=%v", room.Id, len(req.Players), len(req.Watchers))
+	return nil
+}
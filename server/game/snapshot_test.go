@@ -0,0 +1,122 @@
+package game
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	"wsnet2/binary"
+	"wsnet2/config"
+	"wsnet2/log"
+)
+
+func TestWriteSnapshot(t *testing.T) {
+	path := snapshotPath(t.TempDir(), "testapp", "room1")
+
+	for _, data := range [][]byte{{1, 2, 3}, {4, 5}} {
+		if err := writeSnapshot(path, data); err != nil {
+			t.Fatalf("writeSnapshot: %+v", err)
+		}
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+		if string(got) != string(data) {
+			t.Fatalf("snapshot = %v, wants %v", got, data)
+		}
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0o600 {
+		t.Fatalf("snapshot perm = %o, wants 600", perm)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("temporary file remains: %v", err)
+	}
+}
+
+func TestRestoreSnapshot(t *testing.T) {
+	// restoreRoom は log パッケージのloggerを使う
+	log.InitLogger(&config.LogConf{LogStdoutLevel: uint32(log.NOLOG)})
+
+	r, master := newTestRoom(t, nil, nil)
+	repo := r.repo
+	joined, ewc := joinRoom(r, "p1")
+	if ewc != nil {
+		t.Fatalf("join p1: %v", ewc)
+	}
+	p1 := joined.Client
+
+	r.msgCh <- broadcastMsg(master, 1, []byte("a"))
+	getRoomInfo(r)
+
+	r.muClients.RLock()
+	data, err := proto.Marshal(r.snapshot())
+	r.muClients.RUnlock()
+	if err != nil {
+		t.Fatalf("marshal snapshot: %v", err)
+	}
+	path := snapshotPath(t.TempDir(), "testapp", "room1")
+	if err := writeSnapshot(path, data); err != nil {
+		t.Fatalf("writeSnapshot: %+v", err)
+	}
+
+	// スナップショット以降にp1が受信したEventは復元後の部屋には無い
+	r.msgCh <- broadcastMsg(master, 2, []byte("b"))
+	getRoomInfo(r)
+	lastEvSeq := len(received(t, p1))
+	adminClose(r)
+
+	if err := repo.restoreRoom(path); err != nil {
+		t.Fatalf("restoreRoom: %+v", err)
+	}
+	repo.mu.RLock()
+	restored := repo.rooms["room1"]
+	repo.mu.RUnlock()
+	t.Cleanup(func() { adminClose(restored) })
+
+	restored.muClients.RLock()
+	rmaster := restored.players["master"]
+	rp1 := restored.players["p1"]
+	restored.muClients.RUnlock()
+
+	// 再接続前のEventは受信済みの番号に続けて届く
+	restored.msgCh <- broadcastMsg(rmaster, 3, []byte("c"))
+	getRoomInfo(restored)
+
+	conn, cli := newWebsocketPair(t)
+	if _, err := NewPeer(context.Background(), rp1, conn, lastEvSeq, false, false); err != nil {
+		t.Fatalf("NewPeer: %+v", err)
+	}
+	restored.msgCh <- broadcastMsg(rmaster, 4, []byte("d"))
+
+	for i, want := range []string{"c", "d"} {
+		var ev binary.Event
+		var seq int
+		for {
+			_, data, err := cli.ReadMessage()
+			if err != nil {
+				t.Fatalf("ReadMessage: %v", err)
+			}
+			ev, seq, err = binary.UnmarshalEvent(data)
+			if err != nil {
+				t.Fatalf("UnmarshalEvent: %v", err)
+			}
+			if ev.Type() == binary.EvTypeMessage {
+				break
+			}
+		}
+		_, body, err := binary.UnmarshalEvMessage(ev.Payload())
+		if err != nil {
+			t.Fatalf("UnmarshalEvMessage: %v", err)
+		}
+		if string(body) != want || seq != lastEvSeq+i+1 {
+			t.Fatalf("event[%v] = %q seq=%v, wants %q seq=%v", i, body, seq, want, lastEvSeq+i+1)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package game
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"wsnet2/binary"
+	"wsnet2/pb"
+)
+
+// サーバ側のタイマー
+//
+// Master（MsgSetTimer）やRoomHandler（Room.SetTimer）が設定したタイマーを部屋のMsgLoopで発火し、
+// EvTimer を全員または発火時点のMasterに送る. Masterの時計に頼らないので、Masterが遅延したり
+// 交代してもカウントダウンやターンの制限時間がずれない.
+// 同じIDのタイマーは置き換える. 部屋の移動では残り時間を引き継ぐ.
+
+const (
+	// maxRoomTimers : 部屋に設定できるタイマーの数
+	maxRoomTimers = 64
+)
+
+type roomTimer struct {
+	id     string
+	at     time.Time
+	seq    uint64
+	target binary.TimerTarget
+	data   []byte
+}
+
+// roomTimers : 部屋のタイマー.
+// RoomHandlerからも設定できるよう、muで保護する.
+type roomTimers struct {
+	mu      sync.Mutex
+	entries map[string]*roomTimer
+	seq     uint64
+
+	// timer : 次に発火するタイマーの時刻に合わせる. MsgLoopが待ち受ける.
+	timer *time.Timer
+}
+
+func newRoomTimers() *roomTimers {
+	t := time.NewTimer(time.Hour)
+	t.Stop()
+	return &roomTimers{
+		entries: make(map[string]*roomTimer),
+		timer:   t,
+	}
+}
+
+// C : 次のタイマーの時刻に値が届く
+func (ts *roomTimers) C() <-chan time.Time {
+	return ts.timer.C
+}
+
+// stop : 部屋の終了時に止める
+func (ts *roomTimers) stop() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.timer.Stop()
+}
+
+// set : タイマーを設定する. 同じIDのタイマーは置き換える.
+func (ts *roomTimers) set(id string, d time.Duration, target binary.TimerTarget, data []byte, now time.Time) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if _, ok := ts.entries[id]; !ok && len(ts.entries) >= maxRoomTimers {
+		return xerrors.Errorf("too many timers: max=%v", maxRoomTimers)
+	}
+	ts.seq++
+	ts.entries[id] = &roomTimer{
+		id:     id,
+		at:     now.Add(d),
+		seq:    ts.seq,
+		target: target,
+		data:   data,
+	}
+	ts.rearm(now)
+	return nil
+}
+
+// cancel : タイマーを取り消す. 見つからなければfalse.
+func (ts *roomTimers) cancel(id string, now time.Time) bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if _, ok := ts.entries[id]; !ok {
+		return false
+	}
+	delete(ts.entries, id)
+	ts.rearm(now)
+	return true
+}
+
+// list : タイマーIDと残り時間
+func (ts *roomTimers) list(now time.Time) map[string]time.Duration {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	remains := make(map[string]time.Duration, len(ts.entries))
+	for id, t := range ts.entries {
+		d := t.at.Sub(now)
+		if d < 0 {
+			d = 0
+		}
+		remains[id] = d
+	}
+	return remains
+}
+
+// popDue : 時刻を過ぎたタイマーを設定順に取り出す.
+func (ts *roomTimers) popDue(now time.Time) []*roomTimer {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	var due []*roomTimer
+	for id, t := range ts.entries {
+		if !now.Before(t.at) {
+			due = append(due, t)
+			delete(ts.entries, id)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].at.Equal(due[j].at) {
+			return due[i].at.Before(due[j].at)
+		}
+		return due[i].seq < due[j].seq
+	})
+	ts.rearm(now)
+	return due
+}
+
+// rearm : 次のタイマーの時刻に合わせる. mu のロックを取得してから呼び出す.
+// 止める前に発火していた値がチャネルに残っていても、popDueが空振りするだけなので問題ない.
+func (ts *roomTimers) rearm(now time.Time) {
+	ts.timer.Stop()
+	var next *roomTimer
+	for _, t := range ts.entries {
+		if next == nil || t.at.Before(next.at) {
+			next = t
+		}
+	}
+	if next != nil {
+		ts.timer.Reset(next.at.Sub(now))
+	}
+}
+
+// snapshot : 部屋の移動先に送るタイマー
+func (ts *roomTimers) snapshot() []*pb.RoomTimer {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	timers := make([]*pb.RoomTimer, 0, len(ts.entries))
+	for _, t := range ts.entries {
+		timers = append(timers, &pb.RoomTimer{
+			Id:     t.id,
+			FireAt: t.at.UnixMilli(),
+			Target: uint32(t.target),
+			Data:   t.data,
+		})
+	}
+	return timers
+}
+
+// restore : 移動元のタイマーを復元する
+func (ts *roomTimers) restore(timers []*pb.RoomTimer, now time.Time) {
+	for _, t := range timers {
+		d := time.UnixMilli(t.FireAt).Sub(now)
+		if d < 0 {
+			d = 0
+		}
+		_ = ts.set(t.Id, d, binary.TimerTarget(t.Target), t.Data, now)
+	}
+}
+
+// SetTimer : サーバ側のタイマーを設定する. RoomHandlerから使う.
+// 発火するとEvTimerをtargetに送る. 同じIDのタイマーは置き換える.
+func (r *Room) SetTimer(id string, d time.Duration, target binary.TimerTarget, data []byte) error {
+	return r.timers.set(id, d, target, data, time.Now())
+}
+
+// CancelTimer : サーバ側のタイマーを取り消す. 見つからなければfalse.
+func (r *Room) CancelTimer(id string) bool {
+	return r.timers.cancel(id, time.Now())
+}
+
+// fireTimers : 時刻を過ぎたタイマーのEventを送る.
+func (r *Room) fireTimers(now time.Time) {
+	due := r.timers.popDue(now)
+	if len(due) == 0 {
+		return
+	}
+
+	r.muClients.RLock()
+	defer r.muClients.RUnlock()
+
+	for _, t := range due {
+		r.logger.Debugf("timer fired: %v", t.id)
+		ev := binary.NewEvTimer(t.id, t.data)
+		switch t.target {
+		case binary.TimerTargetMaster:
+			if r.master != nil {
+				r.sendTo(r.master, ev)
+			}
+		default:
+			r.broadcast(ev)
+		}
+	}
+}
+
+func (r *Room) msgSetTimer(msg *MsgSetTimer) {
+	r.muClients.RLock()
+	defer r.muClients.RUnlock()
+
+	if msg.Sender != r.master {
+		msg.Sender.logger.Warnf("sender %q is not master %q", msg.Sender.Id, r.masterID())
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+
+	if err := r.timers.set(msg.ID, msg.Delay, msg.Target, msg.Data, time.Now()); err != nil {
+		msg.Sender.logger.Infof("set timer %q: %v", msg.ID, err)
+		r.sendTo(msg.Sender, binary.NewEvRejected(msg))
+		return
+	}
+	msg.Sender.logger.Debugf("timer set: %v, %v", msg.ID, msg.Delay)
+	r.sendTo(msg.Sender, binary.NewEvSucceeded(msg))
+}
+
+func (r *Room) msgCancelTimer(msg *MsgCancelTimer) {
+	r.muClients.RLock()
+	defer r.muClients.RUnlock()
+
+	if msg.Sender != r.master {
+		msg.Sender.logger.Warnf("sender %q is not master %q", msg.Sender.Id, r.masterID())
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+
+	if !r.timers.cancel(msg.ID, time.Now()) {
+		msg.Sender.logger.Infof("timer %q is not found", msg.ID)
+		r.sendTo(msg.Sender, binary.NewEvTargetNotFound(msg, []string{msg.ID}))
+		return
+	}
+	r.sendTo(msg.Sender, binary.NewEvSucceeded(msg))
+}
+
+func (r *Room) msgListTimers(msg *MsgListTimers) {
+	r.muClients.RLock()
+	defer r.muClients.RUnlock()
+
+	if msg.Sender != r.master {
+		msg.Sender.logger.Warnf("sender %q is not master %q", msg.Sender.Id, r.masterID())
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+
+	r.sendTo(msg.Sender, binary.NewEvTimerList(msg, r.timers.list(time.Now())))
+}
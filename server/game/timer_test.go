@@ -0,0 +1,52 @@
+package game
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"wsnet2/binary"
+)
+
+func TestRoomTimers(t *testing.T) {
+	ts := newRoomTimers()
+	defer ts.stop()
+	now := time.Now()
+
+	ts.set("turn", 3*time.Second, binary.TimerTargetMaster, []byte{1}, now)
+	ts.set("round", time.Second, binary.TimerTargetAll, []byte{2}, now)
+	ts.set("countdown", time.Second, binary.TimerTargetAll, []byte{3}, now)
+	ts.set("turn", 2*time.Second, binary.TimerTargetMaster, []byte{4}, now)
+
+	remains := ts.list(now.Add(500 * time.Millisecond))
+	if len(remains) != 3 || remains["turn"] != 1500*time.Millisecond {
+		t.Fatalf("timers: %v, wants 3 timers and turn=1.5s", remains)
+	}
+
+	if due := ts.popDue(now.Add(900 * time.Millisecond)); len(due) != 0 {
+		t.Fatalf("fired before the time: %v", due)
+	}
+	due := ts.popDue(now.Add(time.Second))
+	if len(due) != 2 || due[0].id != "round" || due[1].id != "countdown" {
+		t.Fatalf("fired timers: %v, wants round and countdown", due)
+	}
+
+	if ts.cancel("round", now) {
+		t.Errorf("fired timer is cancelled")
+	}
+	restored := newRoomTimers()
+	defer restored.stop()
+	restored.restore(ts.snapshot(), now.Add(time.Second))
+	if !restored.cancel("turn", now) || len(restored.entries) != 0 {
+		t.Errorf("restored timers: %v, wants turn", restored.entries)
+	}
+
+	for i := len(ts.entries); i < maxRoomTimers; i++ {
+		if err := ts.set(fmt.Sprintf("timer%v", i), time.Minute, binary.TimerTargetAll, nil, now); err != nil {
+			t.Fatalf("set timer%v: %v", i, err)
+		}
+	}
+	if err := ts.set("over", time.Minute, binary.TimerTargetAll, nil, now); err == nil {
+		t.Errorf("set timers over max")
+	}
+}
@@ -0,0 +1,168 @@
+package game
+
+import (
+	"sync"
+
+	"wsnet2/binary"
+)
+
+// 揮発性イベント
+//
+// Playerが MsgVolatile で送った値（位置など高頻度に更新される値）は、RegularEvent と違い evbuf に入れず
+// 再接続時の再送もしない. 各クライアントは送信者とキーの組毎に最新の値だけを保持し、Peerへの書き込みが
+// 追いつかない間に届いた古い値は捨てる. Peerが無い（切断中の）クライアントには送らない.
+// Watcherには WatcherDelay が無い部屋でのみ送る.
+//
+// 揮発性イベントはPeerの送信キューには積まず、writeLoopが送信キューが空のときだけ書き込む.
+// そのため揮発性イベントで送信キューが溢れて遅いPeerになることはない.
+// SlowPeerDropVolatile では送信キューが混んでいる間に届いた値は保持せずに捨てる.
+
+const (
+	// maxVolatileKeys : クライアント毎に保持する送信待ちの値の数. 超えた分は捨てる
+	maxVolatileKeys = 1024
+)
+
+type volatileKey struct {
+	sender ClientID
+	key    string
+}
+
+// volatileQueue : 送信待ちの揮発性イベント. 送信者とキー毎に最新の値のみ保持する.
+type volatileQueue struct {
+	mu      sync.Mutex
+	pending map[volatileKey][]byte
+	order   []volatileKey
+	ready   chan struct{}
+}
+
+func newVolatileQueue() *volatileQueue {
+	return &volatileQueue{
+		pending: make(map[volatileKey][]byte),
+		ready:   make(chan struct{}, 1),
+	}
+}
+
+// put : 値を送信待ちにする. 同じ送信者とキーの値があれば置き換える.
+// 送信待ちが多すぎて捨てたときはfalse.
+func (q *volatileQueue) put(sender ClientID, key string, value []byte) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	k := volatileKey{sender, key}
+	if _, ok := q.pending[k]; !ok {
+		if len(q.order) >= maxVolatileKeys {
+			return false
+		}
+		q.order = append(q.order, k)
+	}
+	q.pending[k] = value
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+type volatileValue struct {
+	volatileKey
+	value []byte
+}
+
+func (v *volatileValue) event() *binary.SystemEvent {
+	return binary.NewEvVolatile(string(v.sender), v.key, v.value)
+}
+
+// take : 送信待ちの値を届いた順に取り出す
+func (q *volatileQueue) take() []volatileValue {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	vals := make([]volatileValue, 0, len(q.order))
+	for _, k := range q.order {
+		vals = append(vals, volatileValue{k, q.pending[k]})
+	}
+	q.pending = make(map[volatileKey][]byte, len(q.order))
+	q.order = q.order[:0]
+	return vals
+}
+
+// requeue : 送信できなかった値を送信待ちの先頭に戻す.
+// 同じ送信者とキーの新しい値が届いていればそちらを残す.
+func (q *volatileQueue) requeue(vals []volatileValue) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	order := make([]volatileKey, 0, len(vals)+len(q.order))
+	for _, v := range vals {
+		if _, ok := q.pending[v.volatileKey]; !ok {
+			q.pending[v.volatileKey] = v.value
+			order = append(order, v.volatileKey)
+		}
+	}
+	q.order = append(order, q.order...)
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// SendVolatile : 揮発性の値を送信待ちにする. Peerが無ければ捨てる.
+// RoomやHubのMsgLoopから呼ばれる.
+func (c *Client) SendVolatile(sender ClientID, key string, value []byte) {
+	c.mu.RLock()
+	p := c.peer
+	c.mu.RUnlock()
+	if p == nil {
+		return
+	}
+	if p.dropVolatile() {
+		c.room.PeerQueueStats().AddVolatileDropped()
+		return
+	}
+	if !c.volatile.put(sender, key, value) {
+		c.logger.Debugf("volatile dropped: %v %v", sender, key)
+	}
+}
+
+// writeVolatile : 送信待ちの揮発性イベントを書き込む.
+// writeLoopから送信キューが空のときに呼ばれる. 途中で送信キューに積まれたら残りは戻して次の機会に送る.
+func (p *Peer) writeVolatile() error {
+	q := p.client.volatile
+	vals := q.take()
+	for i := range vals {
+		if len(p.outq) > 0 {
+			q.requeue(vals[i:])
+			return nil
+		}
+		f := systemFrame(vals[i].event())
+		p.compress(f.hlen + len(f.body))
+		if err := writeFrame(p.conn, &f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Room) msgVolatile(msg *MsgVolatile) {
+	r.muClients.RLock()
+	defer r.muClients.RUnlock()
+
+	if !msg.Sender.isPlayer || r.players[msg.SenderID()] != msg.Sender {
+		// 応答のEventは無いので捨てるだけ
+		msg.Sender.logger.Debugf("volatile from non-player: %v", msg.Sender.Id)
+		return
+	}
+
+	for id, c := range r.players {
+		if id != msg.SenderID() {
+			c.SendVolatile(msg.SenderID(), msg.Key, msg.Value)
+		}
+	}
+	if !r.delayed.enabled() {
+		for _, c := range r.watchers {
+			c.SendVolatile(msg.SenderID(), msg.Key, msg.Value)
+		}
+	}
+}
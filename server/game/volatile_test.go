@@ -0,0 +1,100 @@
+package game
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"wsnet2/binary"
+	"wsnet2/pb"
+)
+
+func TestVolatileQueue(t *testing.T) {
+	q := newVolatileQueue()
+	q.put("p1", "pos", binary.MarshalInt(1))
+	q.put("p2", "pos", binary.MarshalInt(2))
+	q.put("p1", "rot", binary.MarshalInt(3))
+	q.put("p1", "pos", binary.MarshalInt(4))
+
+	select {
+	case <-q.ready:
+	default:
+		t.Fatalf("queue is not ready")
+	}
+
+	vals := q.take()
+	wants := []struct {
+		sender, key string
+		value       int
+	}{
+		{"p1", "pos", 4},
+		{"p2", "pos", 2},
+		{"p1", "rot", 3},
+	}
+	if len(vals) != len(wants) {
+		t.Fatalf("events: %v, wants %v", len(vals), len(wants))
+	}
+	for i, w := range wants {
+		sender, key, value, err := binary.UnmarshalEvVolatilePayload(vals[i].event().Payload())
+		if err != nil {
+			t.Fatalf("events[%v]: %v", i, err)
+		}
+		v, _, _ := binary.Unmarshal(value)
+		if sender != w.sender || key != w.key || v != w.value {
+			t.Errorf("events[%v]: (%v, %v, %v), wants (%v, %v, %v)", i, sender, key, v, w.sender, w.key, w.value)
+		}
+	}
+	if vals := q.take(); len(vals) != 0 {
+		t.Errorf("taken twice: %v", vals)
+	}
+
+	// 戻した値は先頭に入り、戻す前に届いた新しい値は残る
+	q.put("p1", "rot", binary.MarshalInt(5))
+	q.requeue(vals[1:])
+	if got := q.take(); len(got) != 2 || got[0].sender != "p2" || got[1].key != "rot" || !reflect.DeepEqual(got[1].value, binary.MarshalInt(5)) {
+		t.Errorf("requeued: %v", got)
+	}
+
+	for i := 0; i < maxVolatileKeys; i++ {
+		if !q.put("p1", fmt.Sprint(i), nil) {
+			t.Fatalf("put %v dropped", i)
+		}
+	}
+	if q.put("p2", "pos", nil) {
+		t.Errorf("put over the limit")
+	}
+	if !q.put("p1", "0", nil) {
+		t.Errorf("overwriting the pending key dropped")
+	}
+}
+
+func TestWriteVolatile(t *testing.T) {
+	conn, cli := newWebsocketPair(t)
+	c := &Client{ClientInfo: &pb.ClientInfo{Id: "c1"}, logger: zap.NewNop().Sugar(), volatile: newVolatileQueue()}
+	p := &Peer{
+		client:     c,
+		conn:       conn,
+		outq:       make(chan outFrame, 4),
+		connClosed: make(chan struct{}),
+	}
+	t.Cleanup(p.closeConn)
+
+	// 送信キューに積まれたメッセージを先に書き込み、空になってから揮発性イベントを書き込む
+	sev := binary.NewEvPeerReady(1, 0)
+	p.outq <- systemFrame(sev)
+	c.volatile.put("p1", "pos", binary.MarshalInt(1))
+	c.volatile.put("p1", "pos", binary.MarshalInt(2))
+	go p.writeLoop()
+
+	for i, want := range [][]byte{sev.Marshal(), binary.NewEvVolatile("p1", "pos", binary.MarshalInt(2)).Marshal()} {
+		_, data, err := cli.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage[%v]: %v", i, err)
+		}
+		if !reflect.DeepEqual(data, want) {
+			t.Errorf("message[%v] = %v, wants %v", i, data, want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package hub
+
+import (
+	"sort"
+
+	"wsnet2/binary"
+)
+
+// eventCache : gameから EvCached で通知されたキャッシュ.
+// Hubに途中から入室したWatcherに更新順に送る.
+// HubのProcessLoopからのみ使う.
+type eventCache struct {
+	entries map[string]*cachedEvent
+	seq     uint64
+}
+
+type cachedEvent struct {
+	ev  *binary.RegularEvent
+	seq uint64
+}
+
+func newEventCache() *eventCache {
+	return &eventCache{
+		entries: make(map[string]*cachedEvent),
+	}
+}
+
+// update : EvCachedでキャッシュを更新する. dataが空ならキャッシュを削除する.
+func (c *eventCache) update(ev binary.Event) error {
+	key, sender, data, err := binary.UnmarshalEvCachedPayload(ev.Payload())
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		delete(c.entries, key)
+		return nil
+	}
+	c.seq++
+	c.entries[key] = &cachedEvent{
+		ev:  binary.NewEvMessage(sender, data),
+		seq: c.seq,
+	}
+	return nil
+}
+
+// list : 更新順に並べたEvent
+func (c *eventCache) list() []*binary.RegularEvent {
+	list := make([]*cachedEvent, 0, len(c.entries))
+	for _, e := range c.entries {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].seq < list[j].seq })
+
+	evs := make([]*binary.RegularEvent, len(list))
+	for i, e := range list {
+		evs[i] = e.ev
+	}
+	return evs
+}
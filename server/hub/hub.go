@@ -3,6 +3,7 @@ package hub
 import (
 	"context"
 	"fmt"
+	"maps"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -17,6 +18,7 @@ import (
 	"wsnet2/config"
 	"wsnet2/game"
 	"wsnet2/log"
+	"wsnet2/metrics"
 	"wsnet2/pb"
 )
 
@@ -28,11 +30,23 @@ type Hub struct {
 	clientId string
 
 	room *client.Room
-	conn *client.Connection
+	conn upstream
+
+	// replay : 記録の再生中はnil以外
+	replay *replayer
+
+	// cache : 途中入室したWatcherに送るキャッシュ
+	cache *eventCache
+
+	// objects : 途中入室したWatcherに送るネットワークオブジェクト
+	objects *objectRegistry
 
 	msgCh chan game.Msg
 	done  <-chan struct{}
 
+	// peerStats : Watcherの送信キューの統計. ProcessLoopの間 metrics.PeerQueues に登録する
+	peerStats *metrics.PeerQueueStats
+
 	watchers map[ClientID]*game.Client
 	wgClient sync.WaitGroup
 
@@ -46,6 +60,15 @@ type Hub struct {
 
 var _ game.IRoom = &Hub{}
 
+// upstream : Hubが配信するEventの取得元とMsgの送信先
+type upstream interface {
+	Events() <-chan binary.Event
+	Send(typ binary.MsgType, payload []byte) error
+	SendSystemMsg(msg binary.Msg) error
+}
+
+var _ upstream = &client.Connection{}
+
 func NewHub(repo *Repository, pk int64, appid AppID, roomid RoomID, grpc *grpc.ClientConn, wsHost string, logger log.Logger) (*Hub, error) {
 	// hub->game 接続に使うclientId. このhubを作成するトリガーになったclientIdは使わない
 	// roomIdもhostIdもユニークなので hostId:roomId はユニークになるはず。
@@ -76,16 +99,28 @@ func NewHub(repo *Repository, pk int64, appid AppID, roomid RoomID, grpc *grpc.C
 		}
 	}()
 
+	hub := newHub(repo, pk, appid, roomid, clientid, room, conn, done, logger)
+	go hub.nodeCountUpdater()
+
+	return hub, nil
+}
+
+func newHub(repo *Repository, pk int64, appid AppID, roomid RoomID, clientid string, room *client.Room, conn upstream, done <-chan struct{}, logger log.Logger) *Hub {
 	hub := &Hub{
 		repo:     repo,
 		hubPK:    pk,
 		roomId:   roomid,
+		appId:    appid,
 		clientId: clientid,
 		room:     room,
 		conn:     conn,
 		msgCh:    make(chan game.Msg, game.RoomMsgChSize),
 		done:     done,
 		watchers: make(map[ClientID]*game.Client),
+		cache:    newEventCache(),
+		objects:  newObjectRegistry(),
+
+		peerStats: &metrics.PeerQueueStats{},
 
 		nodeCountUpdated: make(chan struct{}, 1),
 
@@ -93,9 +128,8 @@ func NewHub(repo *Repository, pk int64, appid AppID, roomid RoomID, grpc *grpc.C
 	}
 
 	go hub.ProcessLoop()
-	go hub.nodeCountUpdater()
 
-	return hub, nil
+	return hub
 }
 
 func (h *Hub) ID() RoomID {
@@ -106,6 +140,19 @@ func (h *Hub) ClientConf() *config.ClientConf {
 	return &h.repo.conf.ClientConf
 }
 
+func (h *Hub) MsgRateLimits() *game.MsgRateLimits {
+	return h.repo.rateLimits
+}
+
+// PayloadLimits : hubでは検査せず、転送先のgameサーバで検査する
+func (h *Hub) PayloadLimits() *game.PayloadLimits {
+	return nil
+}
+
+func (h *Hub) PeerQueueStats() *metrics.PeerQueueStats {
+	return h.peerStats
+}
+
 func (h *Hub) Repo() game.IRepo {
 	return h.repo
 }
@@ -145,6 +192,11 @@ func (h *Hub) removeWatcher(cid game.ClientID, cause string) {
 	h.storeNodeCount()
 
 	c.Removed(cause)
+
+	if h.replay != nil && len(h.watchers) == 0 {
+		// 再生は観戦者ごとなので誰もいなくなったら終了
+		h.replay.stop()
+	}
 }
 
 func (h *Hub) storeNodeCount() {
@@ -197,6 +249,8 @@ func (h *Hub) nodeCountUpdater() {
 
 // ProcessLoop goroutine dispatch messages and events.
 func (h *Hub) ProcessLoop() {
+	metrics.PeerQueues.Set(h.clientId, h.peerStats)
+	defer metrics.PeerQueues.Delete(h.clientId)
 Loop:
 	for {
 		select {
@@ -210,9 +264,19 @@ Loop:
 			if err := h.room.Update(ev); err != nil {
 				h.logger.Errorf("room update: %+v", err)
 			}
-			if binary.IsRegularEvent(ev) {
+			if err := h.objects.update(ev); err != nil {
+				h.logger.Errorf("objects update: %+v", err)
+			}
+			if ev.Type() == binary.EvTypeCached {
+				// Hub宛てのEventなのでWatcherには送らない
+				if err := h.cache.update(ev); err != nil {
+					h.logger.Errorf("cache update: %+v", err)
+				}
+			} else if binary.IsRegularEvent(ev) {
 				h.logger.Debugf("broadcast: %v", ev.Type())
 				h.broadcast(ev.(*binary.RegularEvent))
+			} else if ev.Type() == binary.EvTypeVolatile {
+				h.broadcastVolatile(ev)
 			}
 		}
 	}
@@ -251,6 +315,13 @@ func (h *Hub) dispatchMsg(msg game.Msg) {
 		h.msgClientError(m)
 	case *game.MsgClientTimeout:
 		h.msgClientTimeout(m)
+	case *game.MsgRateLimitExceeded:
+		h.msgRateLimitExceeded(m)
+	case *game.MsgSlowPeer:
+		h.msgSlowPeer(m)
+	case *game.MsgVolatile:
+		// Watcherからは送れない
+		m.Sender.Logger().Debugf("volatile from watcher: %v", m.Key)
 
 	// clientから来たメッセージをgameに伝える.
 	case *game.MsgTargets:
@@ -262,12 +333,27 @@ func (h *Hub) dispatchMsg(msg game.Msg) {
 	case *game.MsgBroadcast:
 		m.Sender.Logger().Debugf("message to all: %v", m.Data)
 		h.proxyMessage(m.RegularMsg)
+	case *game.MsgCachedBroadcast:
+		m.Sender.Logger().Debugf("cached message to all: %v %v", m.Key, m.Data)
+		h.proxyMessage(m.RegularMsg)
 
 	default:
 		h.logger.Errorf("unknown msg type: %T %v", m, m)
 	}
 }
 
+// broadcastVolatile : 揮発性イベントを全員に送信.
+func (h *Hub) broadcastVolatile(ev binary.Event) {
+	sender, key, value, err := binary.UnmarshalEvVolatilePayload(ev.Payload())
+	if err != nil {
+		h.logger.Errorf("volatile payload: %+v", err)
+		return
+	}
+	for _, c := range h.watchers {
+		c.SendVolatile(game.ClientID(sender), key, value)
+	}
+}
+
 // broadcast : 全員に送信.
 func (h *Hub) broadcast(ev *binary.RegularEvent) {
 	errs := map[game.ClientID]string{}
@@ -314,6 +400,11 @@ func (h *Hub) msgWatch(msg *game.MsgWatch) {
 	}
 	h.storeNodeCount()
 
+	if h.replay != nil {
+		// 観戦者の入室前に再生したEventは誰にも届かないので、ここで再生を開始する
+		defer h.replay.begin()
+	}
+
 	rinfo := &pb.RoomInfo{
 		Id:           h.room.Id,
 		AppId:        h.appId,
@@ -332,19 +423,44 @@ func (h *Hub) msgWatch(msg *game.MsgWatch) {
 	rinfo.SetCreated(h.room.Created)
 
 	players := make([]*pb.ClientInfo, 0, len(h.room.Players))
+	playerVers := make(map[string]*pb.PropVersions, len(h.room.Players))
 	for _, p := range h.room.Players {
 		players = append(players, &pb.ClientInfo{
 			Id:    p.Id,
 			Props: binary.MarshalDict(p.Props),
 		})
+		playerVers[p.Id] = &pb.PropVersions{Versions: maps.Clone(p.Versions)}
+	}
+
+	// 無人の部屋ではMasterがいない
+	var masterId game.ClientID
+	if h.room.Master != nil {
+		masterId = game.ClientID(h.room.Master.Id)
 	}
 
 	msg.Joined <- &game.JoinedInfo{
 		Room:     rinfo,
 		Players:  players,
 		Client:   client,
-		MasterId: game.ClientID(h.room.Master.Id),
+		MasterId: masterId,
 		Deadline: h.Deadline(),
+
+		PublicPropVersions:  maps.Clone(h.room.PublicVersions),
+		PrivatePropVersions: maps.Clone(h.room.PrivateVersions),
+		PlayerPropVersions:  playerVers,
+	}
+
+	for _, ev := range h.cache.list() {
+		if err := client.Send(ev); err != nil {
+			h.removeWatcher(client.ID(), err.Error())
+			return
+		}
+	}
+	for _, ev := range h.objects.snapshotEvents() {
+		if err := client.Send(ev); err != nil {
+			h.removeWatcher(client.ID(), err.Error())
+			return
+		}
 	}
 }
 
@@ -357,7 +473,7 @@ func (h *Hub) msgPing(msg *game.MsgPing) {
 		return
 	}
 	msg.Sender.Logger().Debugf("ping %v: %v", msg.Sender.Id, msg.Timestamp)
-	ev := binary.NewEvPong(msg.Timestamp, h.room.Watchers, h.room.LastMsgTimes)
+	ev := binary.NewEvPong(msg.Timestamp, h.room.Watchers, h.room.LastMsgTimes, msg.ReceivedAt)
 	msg.Sender.SendSystemEvent(ev)
 }
 
@@ -369,6 +485,14 @@ func (h *Hub) msgClientTimeout(msg *game.MsgClientTimeout) {
 	h.removeWatcher(msg.Sender.ID(), "timeout")
 }
 
+func (h *Hub) msgRateLimitExceeded(msg *game.MsgRateLimitExceeded) {
+	h.removeWatcher(msg.Sender.ID(), "rate limit exceeded")
+}
+
+func (h *Hub) msgSlowPeer(msg *game.MsgSlowPeer) {
+	h.removeWatcher(msg.Sender.ID(), "slow peer")
+}
+
 // clientから受け取った RegularMsg を gameサーバーに転送する
 func (h *Hub) proxyMessage(msg binary.RegularMsg) {
 	err := h.conn.Send(msg.Type(), msg.Payload())
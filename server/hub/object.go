@@ -0,0 +1,123 @@
+package hub
+
+import (
+	"sort"
+
+	"golang.org/x/xerrors"
+
+	"wsnet2/binary"
+)
+
+// objectSnapshotChunk : EvObjectSnapshot 1つに入れるオブジェクトの数
+const objectSnapshotChunk = 32
+
+// objectRegistry : gameから届いたオブジェクトのEventで再現した部屋のネットワークオブジェクト.
+// Hubに途中から入室したWatcherに EvObjectSnapshot で生成順に送る.
+// HubのProcessLoopからのみ使う.
+type objectRegistry struct {
+	objects map[string]*netObject
+	seq     uint64
+}
+
+type netObject struct {
+	binary.NetObject
+	seq uint64
+}
+
+func newObjectRegistry() *objectRegistry {
+	return &objectRegistry{
+		objects: make(map[string]*netObject),
+	}
+}
+
+// update : オブジェクトのEventを適用する. それ以外のEventは無視する.
+func (reg *objectRegistry) update(ev binary.Event) error {
+	switch ev.Type() {
+	case binary.EvTypeObjectSpawned:
+		o, err := binary.UnmarshalEvObjectSpawnedPayload(ev.Payload())
+		if err != nil {
+			return err
+		}
+		reg.add(o)
+
+	case binary.EvTypeObjectSnapshot:
+		objs, err := binary.UnmarshalEvObjectSnapshotPayload(ev.Payload())
+		if err != nil {
+			return err
+		}
+		for _, o := range objs {
+			reg.add(o)
+		}
+
+	case binary.EvTypeObjectUpdated:
+		id, state, err := binary.UnmarshalEvObjectUpdatedPayload(ev.Payload())
+		if err != nil {
+			return err
+		}
+		o, ok := reg.objects[id]
+		if !ok {
+			return xerrors.Errorf("object not found: %v", id)
+		}
+		for k, v := range state {
+			if len(v) == 1 && v[0] == byte(binary.TypeNull) {
+				// 削除したキーはNullで通知される
+				delete(o.State, k)
+				continue
+			}
+			o.State[k] = v
+		}
+
+	case binary.EvTypeObjectOwnerChanged:
+		id, owner, err := binary.UnmarshalEvObjectOwnerChangedPayload(ev.Payload())
+		if err != nil {
+			return err
+		}
+		o, ok := reg.objects[id]
+		if !ok {
+			return xerrors.Errorf("object not found: %v", id)
+		}
+		o.Owner = owner
+
+	case binary.EvTypeObjectDespawned:
+		id, err := binary.UnmarshalEvObjectDespawnedPayload(ev.Payload())
+		if err != nil {
+			return err
+		}
+		delete(reg.objects, id)
+	}
+	return nil
+}
+
+func (reg *objectRegistry) add(o *binary.NetObject) {
+	if o.State == nil {
+		o.State = make(binary.Dict)
+	}
+	reg.seq++
+	reg.objects[o.ID] = &netObject{
+		NetObject: *o,
+		seq:       reg.seq,
+	}
+}
+
+// snapshotEvents : 全オブジェクトを生成順に objectSnapshotChunk 毎にまとめたEvent
+func (reg *objectRegistry) snapshotEvents() []*binary.RegularEvent {
+	list := make([]*netObject, 0, len(reg.objects))
+	for _, o := range reg.objects {
+		list = append(list, o)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].seq < list[j].seq })
+
+	var evs []*binary.RegularEvent
+	for i := 0; i < len(list); i += objectSnapshotChunk {
+		end := i + objectSnapshotChunk
+		if end > len(list) {
+			end = len(list)
+		}
+		objs := make([]*binary.NetObject, 0, end-i)
+		for _, o := range list[i:end] {
+			objs = append(objs, &o.NetObject)
+		}
+		evs = append(evs, binary.NewEvObjectSnapshot(objs))
+	}
+	return evs
+}
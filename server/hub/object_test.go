@@ -0,0 +1,51 @@
+package hub
+
+import (
+	"reflect"
+	"testing"
+
+	"wsnet2/binary"
+)
+
+func TestObjectRegistry(t *testing.T) {
+	reg := newObjectRegistry()
+	evs := []*binary.RegularEvent{
+		binary.NewEvObjectSnapshot([]*binary.NetObject{
+			{ID: "b", Owner: "p1", ClassId: 1, State: binary.Dict{"hp": binary.MarshalInt(10)}},
+			{ID: "a", Owner: "p1", ClassId: 2, State: binary.Dict{}},
+		}),
+		binary.NewEvObjectSpawned(&binary.NetObject{ID: "c", Owner: "p2", ClassId: 3, State: binary.Dict{}}),
+		binary.NewEvObjectUpdated("b", binary.Dict{"hp": binary.MarshalNull(), "mp": binary.MarshalInt(5)}),
+		binary.NewEvObjectOwnerChanged("a", "p2"),
+		binary.NewEvObjectDespawned("c"),
+		binary.NewEvObjectSpawned(&binary.NetObject{ID: "d", Owner: "p1", ClassId: 4, State: binary.Dict{}}),
+	}
+	for _, ev := range evs {
+		if err := reg.update(ev); err != nil {
+			t.Fatalf("update %v: %v", ev.Type(), err)
+		}
+	}
+	if err := reg.update(binary.NewEvObjectDespawned("c")); err != nil {
+		t.Errorf("despawn twice: %v", err)
+	}
+	if err := reg.update(binary.NewEvObjectOwnerChanged("c", "p1")); err == nil {
+		t.Errorf("owner of the despawned object changed")
+	}
+
+	snapshot := reg.snapshotEvents()
+	if len(snapshot) != 1 {
+		t.Fatalf("snapshot events: %v, wants 1", len(snapshot))
+	}
+	objs, err := binary.UnmarshalEvObjectSnapshotPayload(snapshot[0].Payload())
+	if err != nil {
+		t.Fatalf("snapshot payload: %v", err)
+	}
+	want := []*binary.NetObject{
+		{ID: "b", Owner: "p1", ClassId: 1, State: binary.Dict{"mp": binary.MarshalInt(5)}},
+		{ID: "a", Owner: "p2", ClassId: 2, State: binary.Dict{}},
+		{ID: "d", Owner: "p1", ClassId: 4, State: binary.Dict{}},
+	}
+	if !reflect.DeepEqual(objs, want) {
+		t.Errorf("snapshot objects: %v, wants %v", objs, want)
+	}
+}
@@ -0,0 +1,120 @@
+package hub
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"wsnet2/binary"
+	"wsnet2/client"
+	"wsnet2/game"
+	"wsnet2/log"
+)
+
+// replayer : 記録ファイルのEventを記録時と同じ間隔で流す.
+// 再生中の部屋にはMsgを送れないので、送信は全て破棄する.
+type replayer struct {
+	rec   *game.RecordReader
+	start time.Time
+
+	events    chan binary.Event
+	done      chan struct{}
+	stopCh    chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
+
+	logger log.Logger
+}
+
+var _ upstream = &replayer{}
+
+// NewReplayHub : 記録ファイルを再生するHubを作成する.
+// 再生は観戦者ごとに行い、最初の観戦者が入室したときに開始する.
+func NewReplayHub(repo *Repository, appid AppID, roomid RoomID, path string, logger log.Logger) (*Hub, error) {
+	rec, joined, start, err := game.OpenRecord(path)
+	if err != nil {
+		return nil, xerrors.Errorf("game.OpenRecord: %w", err)
+	}
+
+	room, err := client.NewRoom(joined, "")
+	if err != nil {
+		rec.Close()
+		return nil, xerrors.Errorf("client.NewRoom: %w", err)
+	}
+
+	rp := &replayer{
+		rec:    rec,
+		start:  start,
+		events: make(chan binary.Event),
+		done:   make(chan struct{}),
+		stopCh: make(chan struct{}),
+		logger: logger,
+	}
+
+	clientid := fmt.Sprintf("replay:%d:%s", repo.hostId, roomid)
+	hub := newHub(repo, 0, appid, roomid, clientid, room, rp, rp.done, logger)
+	hub.replay = rp
+
+	return hub, nil
+}
+
+func (p *replayer) Events() <-chan binary.Event {
+	return p.events
+}
+
+func (p *replayer) Send(typ binary.MsgType, payload []byte) error {
+	p.logger.Debugf("replay: discard msg: %v", typ)
+	return nil
+}
+
+func (p *replayer) SendSystemMsg(msg binary.Msg) error {
+	return nil
+}
+
+// begin : 再生を開始する. 2回目以降の呼び出しは何もしない.
+func (p *replayer) begin() {
+	p.startOnce.Do(func() { go p.play() })
+}
+
+// stop : 再生を終了する. 開始前なら開始せずにHubを終了させる.
+func (p *replayer) stop() {
+	p.stopOnce.Do(func() { close(p.stopCh) })
+	p.begin()
+}
+
+func (p *replayer) play() {
+	defer close(p.done)
+	defer close(p.events)
+	defer p.rec.Close()
+
+	begin := time.Now()
+	for {
+		t, ev, err := p.rec.Next()
+		if err != nil {
+			if err != io.EOF {
+				p.logger.Errorf("replay: read record: %+v", err)
+			}
+			p.logger.Infof("replay finished")
+			return
+		}
+
+		if d := t.Sub(p.start) - time.Since(begin); d > 0 {
+			select {
+			case <-p.stopCh:
+				p.logger.Infof("replay stopped")
+				return
+			case <-time.After(d):
+			}
+		}
+
+		select {
+		case <-p.stopCh:
+			p.logger.Infof("replay stopped")
+			return
+		case p.events <- ev:
+		}
+	}
+}
@@ -2,6 +2,7 @@ package hub
 
 import (
 	"context"
+	"os"
 	"sync"
 	"time"
 
@@ -30,8 +31,11 @@ type Repository struct {
 	db       *sqlx.DB
 	grpcPool *common.GrpcPool
 
-	muhubs sync.RWMutex
-	hubs   map[RoomID]*Hub
+	rateLimits *game.MsgRateLimits
+
+	muhubs  sync.RWMutex
+	hubs    map[RoomID]*Hub
+	replays map[*Hub]struct{}
 
 	muclients sync.RWMutex
 	clients   map[ClientID]map[RoomID]*game.Client
@@ -43,13 +47,27 @@ func NewRepository(db *sqlx.DB, conf *config.HubConf, hostId uint32) (*Repositor
 		return nil, xerrors.Errorf("delete rooms: %w", err)
 	}
 
+	rateLimits, err := game.NewMsgRateLimits(&conf.ClientConf)
+	if err != nil {
+		return nil, xerrors.Errorf("rate limits: %w", err)
+	}
+	if _, err := game.ParseSlowPeerPolicy(&conf.ClientConf); err != nil {
+		return nil, xerrors.Errorf("slow peer policy: %w", err)
+	}
+	if _, err := game.ParseCompressionLevel(&conf.ClientConf); err != nil {
+		return nil, xerrors.Errorf("compression level: %w", err)
+	}
+
 	repo := &Repository{
 		hostId:   hostId,
 		conf:     conf,
 		db:       db,
 		grpcPool: common.NewGrpcPool(grpc.WithTransportCredentials(insecure.NewCredentials())),
 
+		rateLimits: rateLimits,
+
 		hubs:    make(map[RoomID]*Hub),
+		replays: make(map[*Hub]struct{}),
 		clients: make(map[ClientID]map[RoomID]*game.Client),
 	}
 	return repo, nil
@@ -145,6 +163,65 @@ func (r *Repository) WatchRoom(ctx context.Context, appId AppID, roomId RoomID,
 		return nil, game.WithCode(xerrors.Errorf("getOrCreateHub: %w", err), codes.NotFound)
 	}
 
+	return r.watch(ctx, hub, roomId, client, macKey)
+}
+
+// WatchReplay : 記録された部屋を観戦する.
+// 観戦者ごとに再生用のHubを作成する.
+func (r *Repository) WatchReplay(ctx context.Context, appId AppID, roomId RoomID, client *pb.ClientInfo, macKey string) (*pb.JoinedRoomRes, game.ErrorWithCode) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	if r.conf.ReplayDir == "" {
+		return nil, game.NormalWithCode(
+			xerrors.Errorf("replay is disabled"), codes.FailedPrecondition)
+	}
+
+	r.muclients.RLock()
+	clients := len(r.clients)
+	r.muclients.RUnlock()
+	if clients >= r.conf.MaxClients {
+		return nil, game.WithCode(
+			xerrors.Errorf("reached to the max_clients"), codes.ResourceExhausted)
+	}
+
+	path := game.RecordPath(r.conf.ReplayDir, appId, roomId)
+	if _, err := os.Stat(path); err != nil {
+		return nil, game.NormalWithCode(
+			xerrors.Errorf("record file: %w", err), codes.NotFound)
+	}
+
+	logger := log.Get(log.CurrentLevel()).With(log.KeyApp, appId, log.KeyRoom, roomId)
+	logger.Infof("create replay hub: app=%v room=%v client=%v", appId, roomId, client.Id)
+
+	hub, err := NewReplayHub(r, appId, roomId, path, logger)
+	if err != nil {
+		return nil, game.WithCode(xerrors.Errorf("NewReplayHub: %w", err), codes.Internal)
+	}
+
+	r.muhubs.Lock()
+	r.replays[hub] = struct{}{}
+	r.muhubs.Unlock()
+	metrics.Hubs.Add(1)
+
+	go func() {
+		<-hub.Done()
+		r.muhubs.Lock()
+		delete(r.replays, hub)
+		r.muhubs.Unlock()
+		logger.Infof("replay hub removed: room=%v", roomId)
+		metrics.Hubs.Add(-1)
+	}()
+
+	res, ewc := r.watch(ctx, hub, roomId, client, macKey)
+	if ewc != nil {
+		// 観戦者がいないまま残らないようにする
+		hub.replay.stop()
+	}
+	return res, ewc
+}
+
+func (r *Repository) watch(ctx context.Context, hub *Hub, roomId RoomID, client *pb.ClientInfo, macKey string) (*pb.JoinedRoomRes, game.ErrorWithCode) {
 	jch := make(chan *game.JoinedInfo, 1)
 	errch := make(chan game.ErrorWithCode, 1)
 	msg := &game.MsgWatch{
@@ -195,6 +272,10 @@ func (r *Repository) WatchRoom(ctx context.Context, appId AppID, roomId RoomID,
 		AuthKey:  cli.AuthKey(),
 		MasterId: string(joined.MasterId),
 		Deadline: uint32(joined.Deadline / time.Second),
+
+		PublicPropVersions:  joined.PublicPropVersions,
+		PrivatePropVersions: joined.PrivatePropVersions,
+		PlayerPropVersions:  joined.PlayerPropVersions,
 	}, nil
 }
 
@@ -230,7 +311,7 @@ func (r *Repository) GetClient(roomId, userId string) (*game.Client, error) {
 func (r *Repository) GetHubCount() int {
 	r.muhubs.RLock()
 	defer r.muhubs.RUnlock()
-	return len(r.hubs)
+	return len(r.hubs) + len(r.replays)
 }
 
 func (r *Repository) PlayerLog(c *game.Client, msg game.PlayerLogMsg) {}
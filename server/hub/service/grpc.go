@@ -61,6 +61,10 @@ func (sv *HubService) Watch(ctx context.Context, in *pb.JoinRoomReq) (*pb.Joined
 	)
 	logger.Debugf("gRPC Watch: %v %v", in.RoomId, in.ClientInfo)
 
+	if in.Replay {
+		return sv.watchReplay(ctx, in, logger)
+	}
+
 	res, err := sv.repo.WatchRoom(ctx, in.AppId, hub.RoomID(in.RoomId), in.ClientInfo, in.GrpcHost, in.WsHost, in.MacKey)
 	if err != nil {
 		logEWC(logger, "repo.WatchRoom", err)
@@ -74,6 +78,20 @@ func (sv *HubService) Watch(ctx context.Context, in *pb.JoinRoomReq) (*pb.Joined
 	return res, nil
 }
 
+func (sv *HubService) watchReplay(ctx context.Context, in *pb.JoinRoomReq, logger log.Logger) (*pb.JoinedRoomRes, error) {
+	res, err := sv.repo.WatchReplay(ctx, in.AppId, hub.RoomID(in.RoomId), in.ClientInfo, in.MacKey)
+	if err != nil {
+		logEWC(logger, "repo.WatchReplay", err)
+		return nil, status.Errorf(err.Code(), "WatchReplay failed: %s", err)
+	}
+
+	res.Url = fmt.Sprintf(sv.wsURLFormat, res.RoomInfo.Id)
+
+	logger.Infof("gRPC Watch (replay) OK: room=%v user=%v", res.RoomInfo.Id, in.ClientInfo.Id)
+
+	return res, nil
+}
+
 func logEWC(logger log.Logger, msg string, err game.ErrorWithCode) {
 	if err.IsNormal() {
 		logger.Infof("%s: %v", msg, err)
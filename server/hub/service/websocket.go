@@ -35,6 +35,7 @@ var (
 
 type WSHandler struct {
 	*HubService
+	upgrader *websocket.Upgrader
 }
 
 func (sv *HubService) serveWebSocket(ctx context.Context) <-chan error {
@@ -67,7 +68,9 @@ func (sv *HubService) serveWebSocket(ctx context.Context) <-chan error {
 			listener = tls.NewListener(listener, tlsConf)
 		}
 
-		ws := &WSHandler{sv}
+		up := upgrader
+		up.EnableCompression = sv.conf.EnableCompression
+		ws := &WSHandler{sv, &up}
 		r := chi.NewMux()
 		r.Get("/room/{id:[0-9a-f]+}", ws.HandleRoom)
 
@@ -103,6 +106,10 @@ func (s *WSHandler) HandleRoom(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	}
+	// 古いクライアントは送ってこないので、無ければ時刻を付けない
+	timestamped, _ := strconv.ParseBool(r.Header.Get("Wsnet2-EventTimestamp"))
+	// まとめ送りも同様
+	batched, _ := strconv.ParseBool(r.Header.Get("Wsnet2-Batch"))
 
 	cli, err := s.repo.GetClient(roomId, clientId)
 	if err != nil {
@@ -125,7 +132,7 @@ func (s *WSHandler) HandleRoom(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithCancel(r.Context())
 	defer cancel()
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		breq, _ := httputil.DumpRequest(r, false)
 		logger.Errorf("websocket: upgrade: %+v\nrequest: %v", err, string(breq))
@@ -134,7 +141,7 @@ func (s *WSHandler) HandleRoom(w http.ResponseWriter, r *http.Request) {
 	metrics.Conns.Add(1)
 	defer metrics.Conns.Add(-1)
 
-	peer, err := game.NewPeer(ctx, cli, conn, lastEvSeq)
+	peer, err := game.NewPeer(ctx, cli, conn, lastEvSeq, timestamped, batched)
 	if err != nil {
 		logger.Warnf("websocket: new peer: %+v", err)
 		return
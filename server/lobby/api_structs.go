@@ -18,6 +18,17 @@ type JoinParam struct {
 	EncMACKey  string         `json:"emk"`
 }
 
+type JoinByInviteParam struct {
+	InviteToken string         `json:"token"`
+	ClientInfo  *pb.ClientInfo `json:"client"`
+	EncMACKey   string         `json:"emk"`
+}
+
+type ReserveParam struct {
+	ClientIDs []string `json:"ids"`
+	TTL       uint32   `json:"ttl"`
+}
+
 type SearchParam struct {
 	SearchGroup    uint32        `json:"group"`
 	Queries        []PropQueries `json:"query"`
@@ -45,6 +56,9 @@ type Response struct {
 	Type  ResponseType      `json:"type"`
 	Room  *pb.JoinedRoomRes `json:"room,omitempty"`
 	Rooms []*pb.RoomInfo    `json:"rooms,omitempty"`
+
+	// Tokens : 予約席の招待トークン (key: client id)
+	Tokens map[string]string `json:"tokens,omitempty"`
 }
 
 type ResponseType byte
@@ -54,6 +68,7 @@ const (
 	ResponseTypeRoomLimit
 	ResponseTypeNoRoomFound
 	ResponseTypeRoomFull
+	ResponseTypeBanned
 )
 
 func (r ResponseType) String() string {
@@ -66,6 +81,8 @@ func (r ResponseType) String() string {
 		return "NoRoomFound"
 	case ResponseTypeRoomFull:
 		return "RoomFull"
+	case ResponseTypeBanned:
+		return "Banned"
 	default:
 		return fmt.Sprintf("UnknownType(%v)", byte(r))
 	}
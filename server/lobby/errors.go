@@ -15,6 +15,8 @@ const (
 	ErrRoomFull
 	ErrAlreadyJoined
 	ErrNoWatchableRoom
+	ErrPermission
+	ErrBanned
 )
 
 // ErrorWithErrType : ErrTypeとerrorの組
@@ -2,6 +2,8 @@ package lobby
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"math/rand"
 	"time"
@@ -136,7 +138,7 @@ func filter(rooms []*pb.RoomInfo, props []binary.Dict, queries []PropQueries, li
 	return filtered
 }
 
-func (rs *RoomService) join(ctx context.Context, appId, roomId string, clientInfo *pb.ClientInfo, macKey string, hostId uint32) (*pb.JoinedRoomRes, error) {
+func (rs *RoomService) join(ctx context.Context, appId, roomId string, clientInfo *pb.ClientInfo, macKey, inviteToken string, hostId uint32) (*pb.JoinedRoomRes, error) {
 	game, err := rs.gameCache.Get(hostId)
 	if err != nil {
 		return nil, xerrors.Errorf("get game server(%v): %w", hostId, err)
@@ -151,10 +153,11 @@ func (rs *RoomService) join(ctx context.Context, appId, roomId string, clientInf
 	client := pb.NewGameClient(conn)
 
 	req := &pb.JoinRoomReq{
-		AppId:      appId,
-		RoomId:     roomId,
-		ClientInfo: clientInfo,
-		MacKey:     macKey,
+		AppId:       appId,
+		RoomId:      roomId,
+		ClientInfo:  clientInfo,
+		MacKey:      macKey,
+		InviteToken: inviteToken,
 	}
 
 	res, err := client.Join(ctx, req)
@@ -171,6 +174,10 @@ func (rs *RoomService) join(ctx context.Context, appId, roomId string, clientInf
 				err = withType(err, ErrRoomFull)
 			case codes.AlreadyExists: // 既に入室している
 				err = withType(err, ErrAlreadyJoined)
+			case codes.Unauthenticated: // 招待トークンが無効
+				err = withType(err, ErrPermission)
+			case codes.PermissionDenied: // 入室禁止されている
+				err = withType(err, ErrBanned)
 			case codes.InvalidArgument:
 				err = withType(err, ErrArgument)
 			}
@@ -181,6 +188,89 @@ func (rs *RoomService) join(ctx context.Context, appId, roomId string, clientInf
 	return res, nil
 }
 
+// JoinByInvite : 招待トークンで予約席に入室する.
+// 部屋がJoinableやVisibleでなくても入室できる.
+func (rs *RoomService) JoinByInvite(ctx context.Context, appId, roomId, inviteToken string, clientInfo *pb.ClientInfo, macKey string, logger log.Logger) (*pb.JoinedRoomRes, error) {
+	if _, found := rs.apps[appId]; !found {
+		return nil, xerrors.Errorf("Unknown appId: %v", appId)
+	}
+	if inviteToken == "" {
+		return nil, withType(xerrors.Errorf("no invite token"), ErrArgument)
+	}
+
+	var room pb.RoomInfo
+	err := rs.db.Get(&room, "SELECT * FROM room WHERE app_id = ? AND id = ?", appId, roomId)
+	if err != nil {
+		return nil, withType(
+			xerrors.Errorf("select room (id=%v): %w", roomId, err),
+			ErrNoJoinableRoom)
+	}
+
+	return rs.join(ctx, appId, room.Id, clientInfo, macKey, inviteToken, room.HostId)
+}
+
+// Reserve : 部屋の席を予約して招待トークンを返す.
+// requesterId は部屋のMasterでなければならない.
+func (rs *RoomService) Reserve(ctx context.Context, appId, roomId, requesterId string, clientIds []string, ttl uint32, logger log.Logger) (map[string]string, error) {
+	if _, found := rs.apps[appId]; !found {
+		return nil, xerrors.Errorf("Unknown appId: %v", appId)
+	}
+	if requesterId == "" {
+		return nil, withType(xerrors.Errorf("no requester id"), ErrArgument)
+	}
+	if len(clientIds) == 0 {
+		return nil, withType(xerrors.Errorf("no client ids"), ErrArgument)
+	}
+
+	var room pb.RoomInfo
+	err := rs.db.Get(&room, "SELECT * FROM room WHERE app_id = ? AND id = ?", appId, roomId)
+	if err != nil {
+		return nil, withType(
+			xerrors.Errorf("select room (id=%v): %w", roomId, err),
+			ErrNoJoinableRoom)
+	}
+
+	game, err := rs.gameCache.Get(room.HostId)
+	if err != nil {
+		return nil, xerrors.Errorf("get game server(%v): %w", room.HostId, err)
+	}
+
+	grpcAddr := fmt.Sprintf("%s:%d", game.Hostname, game.GRPCPort)
+	conn, err := rs.grpcPool.Get(grpcAddr)
+	if err != nil {
+		return nil, xerrors.Errorf("grpcPool.Get(%s): %w", grpcAddr, err)
+	}
+
+	req := &pb.ReserveReq{
+		AppId:       appId,
+		RoomId:      room.Id,
+		RequesterId: requesterId,
+		ClientIds:   clientIds,
+		Ttl:         ttl,
+	}
+
+	res, err := pb.NewGameClient(conn).Reserve(ctx, req)
+	if err != nil {
+		st, ok := status.FromError(err)
+		err = xerrors.Errorf("gRPC Reserve: %w", err)
+		if ok {
+			switch st.Code() {
+			case codes.NotFound: // roomが既に消えた
+				err = withType(err, ErrNoJoinableRoom)
+			case codes.ResourceExhausted: // 席が足りない
+				err = withType(err, ErrRoomFull)
+			case codes.PermissionDenied: // Masterでない
+				err = withType(err, ErrPermission)
+			case codes.InvalidArgument:
+				err = withType(err, ErrArgument)
+			}
+		}
+		return nil, err
+	}
+
+	return res.InviteTokens, nil
+}
+
 func (rs *RoomService) JoinById(ctx context.Context, appId, roomId string, queries []PropQueries, clientInfo *pb.ClientInfo, macKey string, logger log.Logger) (*pb.JoinedRoomRes, error) {
 	if _, found := rs.apps[appId]; !found {
 		return nil, xerrors.Errorf("Unknown appId: %v", appId)
@@ -206,7 +296,7 @@ func (rs *RoomService) JoinById(ctx context.Context, appId, roomId string, queri
 			ErrNoJoinableRoom)
 	}
 
-	return rs.join(ctx, appId, filtered[0].Id, clientInfo, macKey, filtered[0].HostId)
+	return rs.join(ctx, appId, filtered[0].Id, clientInfo, macKey, "", filtered[0].HostId)
 }
 
 func (rs *RoomService) JoinByNumber(ctx context.Context, appId string, roomNumber int32, queries []PropQueries, clientInfo *pb.ClientInfo, macKey string, logger log.Logger) (*pb.JoinedRoomRes, error) {
@@ -234,7 +324,7 @@ func (rs *RoomService) JoinByNumber(ctx context.Context, appId string, roomNumbe
 			ErrNoJoinableRoom)
 	}
 
-	return rs.join(ctx, appId, filtered[0].Id, clientInfo, macKey, filtered[0].HostId)
+	return rs.join(ctx, appId, filtered[0].Id, clientInfo, macKey, "", filtered[0].HostId)
 }
 
 func (rs *RoomService) JoinAtRandom(ctx context.Context, appId string, searchGroup uint32, queries []PropQueries, clientInfo *pb.ClientInfo, macKey string, logger log.Logger) (*pb.JoinedRoomRes, error) {
@@ -253,7 +343,7 @@ func (rs *RoomService) JoinAtRandom(ctx context.Context, appId string, searchGro
 		default:
 		}
 
-		res, err := rs.join(ctx, appId, room.Id, clientInfo, macKey, room.HostId)
+		res, err := rs.join(ctx, appId, room.Id, clientInfo, macKey, "", room.HostId)
 		if err == nil {
 			return res, nil
 		}
@@ -366,26 +456,81 @@ func (rs *RoomService) watch(ctx context.Context, room *pb.RoomInfo, clientInfo
 
 	res, err := client.Watch(ctx, req)
 	if err != nil {
-		st, ok := status.FromError(err)
-		err = xerrors.Errorf("gRPC Watch: %w", err)
-		if ok {
-			switch st.Code() {
-			case codes.NotFound: // roomが既に消えた
-				err = withType(err, ErrNoWatchableRoom)
-			case codes.FailedPrecondition: // watchableでなくなっていた
-				err = withType(err, ErrNoWatchableRoom)
-			case codes.AlreadyExists: // 既に入室している
-				err = withType(err, ErrAlreadyJoined)
-			case codes.InvalidArgument:
-				err = withType(err, ErrArgument)
-			}
-		}
-		return nil, err
+		return nil, watchError(err)
+	}
+
+	return res, nil
+}
+
+// watchReplay : 終了した部屋の記録をhubで再生して観戦する
+func (rs *RoomService) watchReplay(ctx context.Context, appId, roomId string, queries []PropQueries, clientInfo *pb.ClientInfo, macKey string, logger log.Logger) (*pb.JoinedRoomRes, error) {
+	var pubProps []byte
+	err := rs.db.Get(&pubProps, "SELECT public_props FROM room_history WHERE app_id = ? AND room_id = ? LIMIT 1", appId, roomId)
+	if err != nil {
+		return nil, withType(
+			xerrors.Errorf("select room_history (id=%v): %w", roomId, err),
+			ErrNoWatchableRoom)
+	}
+
+	props, err := unmarshalProps(pubProps)
+	if err != nil {
+		return nil, xerrors.Errorf("unmarshalProps: %w", err)
+	}
+
+	filtered := filter([]*pb.RoomInfo{{Id: roomId}}, []binary.Dict{props}, queries, 1, false, false, logger)
+	if len(filtered) == 0 {
+		return nil, withType(
+			xerrors.Errorf("filter result is empty: room=%v", roomId),
+			ErrNoWatchableRoom)
+	}
+
+	hub, err := rs.hubCache.Rand()
+	if err != nil {
+		return nil, xerrors.Errorf("get hub server: %w", err)
+	}
+
+	grpcAddr := fmt.Sprintf("%s:%d", hub.Hostname, hub.GRPCPort)
+	conn, err := rs.grpcPool.Get(grpcAddr)
+	if err != nil {
+		return nil, xerrors.Errorf("get gRPC client: %w", err)
+	}
+
+	req := &pb.JoinRoomReq{
+		AppId:      appId,
+		RoomId:     roomId,
+		ClientInfo: clientInfo,
+		MacKey:     macKey,
+		Replay:     true,
+	}
+
+	res, err := pb.NewGameClient(conn).Watch(ctx, req)
+	if err != nil {
+		return nil, watchError(err)
 	}
 
 	return res, nil
 }
 
+func watchError(err error) error {
+	st, ok := status.FromError(err)
+	err = xerrors.Errorf("gRPC Watch: %w", err)
+	if ok {
+		switch st.Code() {
+		case codes.NotFound: // roomが既に消えた. 記録が無い
+			err = withType(err, ErrNoWatchableRoom)
+		case codes.FailedPrecondition: // watchableでなくなっていた
+			err = withType(err, ErrNoWatchableRoom)
+		case codes.AlreadyExists: // 既に入室している
+			err = withType(err, ErrAlreadyJoined)
+		case codes.PermissionDenied: // 入室禁止されている
+			err = withType(err, ErrBanned)
+		case codes.InvalidArgument:
+			err = withType(err, ErrArgument)
+		}
+	}
+	return err
+}
+
 func (rs *RoomService) WatchById(ctx context.Context, appId, roomId string, queries []PropQueries, clientInfo *pb.ClientInfo, macKey string, logger log.Logger) (*pb.JoinedRoomRes, error) {
 	if _, found := rs.apps[appId]; !found {
 		return nil, xerrors.Errorf("Unknown appId: %v", appId)
@@ -394,6 +539,10 @@ func (rs *RoomService) WatchById(ctx context.Context, appId, roomId string, quer
 	var room pb.RoomInfo
 	err := rs.db.Get(&room, "SELECT * FROM room WHERE app_id = ? AND id = ? AND watchable = 1", appId, roomId)
 	if err != nil {
+		if rs.conf.WatchReplay && errors.Is(err, sql.ErrNoRows) {
+			// 終了した部屋は記録を再生する
+			return rs.watchReplay(ctx, appId, roomId, queries, clientInfo, macKey, logger)
+		}
 		return nil, withType(
 			xerrors.Errorf("select room (id=%v): %w", roomId, err),
 			ErrNoWatchableRoom)
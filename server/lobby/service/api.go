@@ -83,6 +83,8 @@ func (sv *LobbyService) registerRoutes(r chi.Router) {
 	r.Post("/rooms/join/id/{roomId}", sv.handleJoinRoom)
 	r.Post("/rooms/join/number/{roomNumber:[0-9]+}", sv.handleJoinRoomByNumber)
 	r.Post("/rooms/join/random/{searchGroup:[0-9]+}", sv.handleJoinRoomAtRandom)
+	r.Post("/rooms/join/invite/{roomId}", sv.handleJoinRoomByInvite)
+	r.Post("/rooms/reserve/id/{roomId}", sv.handleReserve)
 	r.Post("/rooms/search", sv.handleSearchRooms)
 	r.Post("/rooms/search/ids", sv.handleSearchByIds)
 	r.Post("/rooms/search/numbers", sv.handleSearchByNumbers)
@@ -179,10 +181,16 @@ func renderErrorResponse(w http.ResponseWriter, msg string, status int, err erro
 			return
 		case lobby.ErrAlreadyJoined:
 			status = http.StatusConflict
+		case lobby.ErrPermission:
+			status = http.StatusForbidden
 		case lobby.ErrRoomFull:
 			logger.Infof("Failed with status OK: %+v", err)
 			renderResponse(w, &lobby.Response{Msg: msg, Type: lobby.ResponseTypeRoomFull}, logger)
 			return
+		case lobby.ErrBanned:
+			logger.Infof("Failed with status OK: %+v", err)
+			renderResponse(w, &lobby.Response{Msg: msg, Type: lobby.ResponseTypeBanned}, logger)
+			return
 		case lobby.ErrNoJoinableRoom, lobby.ErrNoWatchableRoom:
 			logger.Infof("Failed with status OK: %+v", err)
 			renderResponse(w, &lobby.Response{Msg: msg, Type: lobby.ResponseTypeNoRoomFound}, logger)
@@ -407,6 +415,91 @@ func (sv *LobbyService) handleJoinRoomAtRandom(w http.ResponseWriter, r *http.Re
 	renderJoinedRoomResponse(w, room, logger)
 }
 
+func (sv *LobbyService) handleJoinRoomByInvite(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(sv.conf.ApiTimeout))
+	defer cancel()
+
+	h := parseSpecificHeader(r)
+	logger := prepareLogger("lobby:join/invite", h, r)
+	logger.Debugf("handleJoinRoomByInvite")
+
+	appKey, err := sv.authUser(h)
+	if err != nil {
+		renderErrorResponse(w, "Failed to user auth", http.StatusUnauthorized, err, logger)
+		return
+	}
+
+	var param lobby.JoinByInviteParam
+	err = msgpackDecode(r.Body, &param)
+	if err != nil {
+		renderErrorResponse(w, "Failed to read request body", http.StatusBadRequest, err, logger)
+		return
+	}
+
+	macKey, err := auth.DecryptMACKey(appKey, param.EncMACKey)
+	if err != nil {
+		renderErrorResponse(w, "Failed to read MAC Key", http.StatusBadRequest, err, logger)
+		return
+	}
+
+	vars := NewJoinVars(r)
+	roomId := vars.roomId()
+	if roomId == "" {
+		renderErrorResponse(
+			w, "Invalid room id", http.StatusBadRequest, xerrors.Errorf("Invalid room id"), logger)
+		return
+	}
+	logger = logger.With(log.KeyRoom, roomId)
+
+	room, err := sv.roomService.JoinByInvite(ctx, h.appId, roomId, param.InviteToken, param.ClientInfo, macKey, logger)
+	if err != nil {
+		renderErrorResponse(w, "Failed to join room", http.StatusInternalServerError, err, logger)
+		return
+	}
+
+	renderJoinedRoomResponse(w, room, logger)
+}
+
+func (sv *LobbyService) handleReserve(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(sv.conf.ApiTimeout))
+	defer cancel()
+
+	h := parseSpecificHeader(r)
+	logger := prepareLogger("lobby:reserve/id", h, r)
+	logger.Debugf("handleReserve")
+
+	_, err := sv.authUser(h)
+	if err != nil {
+		renderErrorResponse(w, "Failed to user auth", http.StatusUnauthorized, err, logger)
+		return
+	}
+
+	var param lobby.ReserveParam
+	err = msgpackDecode(r.Body, &param)
+	if err != nil {
+		renderErrorResponse(w, "Failed to read request body", http.StatusBadRequest, err, logger)
+		return
+	}
+
+	vars := NewJoinVars(r)
+	roomId := vars.roomId()
+	if roomId == "" {
+		renderErrorResponse(
+			w, "Invalid room id", http.StatusBadRequest, xerrors.Errorf("Invalid room id"), logger)
+		return
+	}
+	logger = logger.With(log.KeyRoom, roomId)
+
+	tokens, err := sv.roomService.Reserve(ctx, h.appId, roomId, h.userId, param.ClientIDs, param.TTL, logger)
+	if err != nil {
+		renderErrorResponse(w, "Failed to reserve", http.StatusInternalServerError, err, logger)
+		return
+	}
+
+	logger.Debugf("reserved: %v", param.ClientIDs)
+	renderResponse(w, &lobby.Response{Msg: "OK", Tokens: tokens}, logger)
+}
+
 func (sv *LobbyService) handleSearchRooms(w http.ResponseWriter, r *http.Request) {
 	h := parseSpecificHeader(r)
 	logger := prepareLogger("lobby:search", h, r)
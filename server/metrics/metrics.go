@@ -11,6 +11,14 @@ var (
 	Hubs        = new(expvar.Int)
 	MessageSent = new(expvar.Int)
 	MessageRecv = new(expvar.Int)
+
+	MessageThrottled = new(expvar.Int)
+
+	SlowPeers       = new(expvar.Int)
+	VolatileDropped = new(expvar.Int)
+
+	// PeerQueues : 部屋毎の *PeerQueueStats
+	PeerQueues = new(expvar.Map)
 )
 
 func init() {
@@ -19,4 +27,8 @@ func init() {
 	expmap.Set("hubs", Hubs)
 	expmap.Set("message_sent", MessageSent)
 	expmap.Set("message_recv", MessageRecv)
+	expmap.Set("message_throttled", MessageThrottled)
+	expmap.Set("slow_peers", SlowPeers)
+	expmap.Set("volatile_dropped", VolatileDropped)
+	expmap.Set("peer_queues", PeerQueues)
 }
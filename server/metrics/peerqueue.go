@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"expvar"
+	"fmt"
+)
+
+// PeerQueueStats : 部屋（またはHub）毎のPeer送信キューの統計.
+// PeerQueues に部屋IDで登録する. nilなら部屋毎には数えない.
+type PeerQueueStats struct {
+	slowPeers       expvar.Int
+	volatileDropped expvar.Int
+}
+
+// AddSlowPeer : 送信キューが溢れたPeerを数える
+func (s *PeerQueueStats) AddSlowPeer() {
+	SlowPeers.Add(1)
+	if s != nil {
+		s.slowPeers.Add(1)
+	}
+}
+
+// AddVolatileDropped : 送信キューが混んでいて捨てた揮発性イベントを数える
+func (s *PeerQueueStats) AddVolatileDropped() {
+	VolatileDropped.Add(1)
+	if s != nil {
+		s.volatileDropped.Add(1)
+	}
+}
+
+// String : expvar.Var の実装
+func (s *PeerQueueStats) String() string {
+	return fmt.Sprintf(`{"slow_peers": %d, "volatile_dropped": %d}`,
+		s.slowPeers.Value(), s.volatileDropped.Value())
+}
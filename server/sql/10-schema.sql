@@ -72,6 +72,7 @@ CREATE TABLE player_log (
   `room_id`   VARCHAR(32) NOT NULL,
   `player_id` VARCHAR(32) NOT NULL,
   `message`   VARCHAR(32) NOT NULL,
+  `detail`    TEXT,
   `datetime`  DATETIME,
   KEY `room_id` (`room_id`),
   KEY `player_id` (`player_id`)
@@ -25,6 +25,40 @@ namespace WSNet2.Core.Test
             Assert.AreEqual(0x0102030405060708, ev.PingTimestamp);
             Assert.AreEqual(9, ev.WatcherCount);
             Assert.AreEqual(explmts, lmts);
+            Assert.IsFalse(ev.HasClockOffset);
+        }
+
+        [Test]
+        public void TestEvPongClockOffset()
+        {
+            var now = (ulong)System.DateTimeOffset.Now.ToUnixTimeMilliseconds();
+            var ping = now - 100;
+            // サーバの時計が1時間進んでいる
+            var server = ping + 3600_000 + 50;
+
+            var payload = new List<byte>();
+            payload.Add((byte)Type.ULong);
+            payload.AddRange(be64(ping));
+            payload.AddRange(new byte[] { (byte)Type.UInt, 0, 0, 0, 0, (byte)Type.Dict, 0 });
+            payload.Add((byte)Type.ULong);
+            payload.AddRange(be64(server));
+            payload.Add((byte)Type.ULong);
+            payload.AddRange(be64(server));
+
+            var ev = new EvPong(WSNet2Serializer.NewReader(payload.ToArray()));
+
+            Assert.IsTrue(ev.HasClockOffset);
+            Assert.That(ev.ClockOffset, Is.InRange(3600_000 - 10, 3600_000 + 10));
+        }
+
+        static byte[] be64(ulong v)
+        {
+            var b = new byte[8];
+            for (var i = 0; i < 8; i++)
+            {
+                b[i] = (byte)(v >> (56 - i * 8));
+            }
+            return b;
         }
     }
 }
@@ -57,7 +57,7 @@ namespace Sample.Logic
 
         void RPCSyncGameState(string sender, GameState state)
         {
-            if (sender == room.Master.Id)
+            if (sender == room.Master?.Id)
             {
                 client?.OnSyncGameState(state);
             }
@@ -73,7 +73,7 @@ namespace Sample.Logic
 
         void RPCSyncServerTick(string sender, long tick)
         {
-            if (sender == room.Master.Id)
+            if (sender == room.Master?.Id)
             {
                 client?.OnSyncServerTick(tick);
             }
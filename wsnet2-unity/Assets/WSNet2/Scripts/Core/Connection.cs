@@ -41,6 +41,7 @@ namespace WSNet2
 
         BlockingCollection<byte[]> evBufPool;
         uint evSeqNum;
+        volatile bool eventTimestamp;
 
         Logger logger;
 
@@ -208,8 +209,10 @@ namespace WSNet2
             ws.Options.SetRequestHeader("Wsnet2-App", appId);
             ws.Options.SetRequestHeader("Wsnet2-User", clientId);
             ws.Options.SetRequestHeader("Wsnet2-LastEventSeq", evSeqNum.ToString());
+            ws.Options.SetRequestHeader("Wsnet2-EventTimestamp", "true");
             ws.Options.AddSubProtocol("wsnet2");
 
+            eventTimestamp = false; // EvPeerReadyで通知される
             logger?.Info("connecting to {0}", uri);
             var cts = CancellationTokenSource.CreateLinkedTokenSource(ct);
             cts.CancelAfter(WSNet2Settings.ConnectTimeoutMilliSec);
@@ -253,12 +256,20 @@ namespace WSNet2
 
                         case EvType.PeerReady:
                             var evpr = ev as EvPeerReady;
-                            logger?.Info("receive peer-ready: lastMsgSeqNum={0}", evpr.LastMsgSeqNum);
+                            logger?.Info("receive peer-ready: lastMsgSeqNum={0}, eventTimestamp={1}", evpr.LastMsgSeqNum, evpr.EventTimestamp);
+                            eventTimestamp = evpr.EventTimestamp;
                             var sender = Task.Run(async () => await Sender(ws, evpr.LastMsgSeqNum + 1, ct));
                             var pinger = Task.Run(async () => await Pinger(ws, ct));
                             senderTaskSource.TrySetResult(sender);
                             pingerTaskSource.TrySetResult(pinger);
                             break;
+                        case EvType.Migrated:
+                            // 部屋が別のGameサーバに移動したので、切断後は移動先に再接続する
+                            var evm = ev as EvMigrated;
+                            logger?.Info("room migrated: {0}", evm.Url);
+                            uri = new Uri(evm.Url);
+                            ReturnEventBuffer(ev);
+                            break;
                         case EvType.Pong:
                             onPong(ev as EvPong);
                             room.handleEvent(ev);
@@ -319,7 +330,7 @@ namespace WSNet2
                     }
                 }
 
-                var ev = Event.Parse(new ArraySegment<byte>(buf, 0, pos));
+                var ev = Event.Parse(new ArraySegment<byte>(buf, 0, pos), eventTimestamp);
                 NetworkInformer.OnRoomReceive(room, pos, ev);
                 return ev;
             }
@@ -370,7 +381,7 @@ namespace WSNet2
                 }
 
                 var interval = Task.Delay(pingInterval, pingerDelayCanceller.Token);
-                var time = (uint)msg.SetTimestamp();
+                var time = (uint)msg.SetTimestamp(room.RttMillisec);
                 lastPingTime = time;
                 await Send(ws, msg.Value, ct);
                 try
@@ -0,0 +1,25 @@
+﻿using System.Collections.Generic;
+
+namespace WSNet2
+{
+    /// <summary>
+    ///   ロックステップのフレームの入力が揃いました
+    /// </summary>
+    public class EvLockstepFrame : Event
+    {
+        /// <summary>フレーム番号</summary>
+        public uint Frame { get; private set; }
+
+        /// <summary>PlayerのID => 入力 (届かなかったPlayerはnull)</summary>
+        public Dictionary<string, object> Inputs { get; private set; }
+
+        /// <summary>
+        ///   コンストラクタ
+        /// </summary>
+        public EvLockstepFrame(SerialReader reader) : base(EvType.LockstepFrame, reader)
+        {
+            Frame = reader.ReadUInt();
+            Inputs = reader.ReadDict();
+        }
+    }
+}
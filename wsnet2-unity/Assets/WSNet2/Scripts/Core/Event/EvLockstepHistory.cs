@@ -0,0 +1,30 @@
+﻿using System.Collections.Generic;
+
+namespace WSNet2
+{
+    /// <summary>
+    ///   途中入室時に過去のロックステップのフレームが届きました
+    /// </summary>
+    public class EvLockstepHistory : Event
+    {
+        /// <summary>最初のフレーム番号</summary>
+        public uint FirstFrame { get; private set; }
+
+        /// <summary>各フレームの入力 (PlayerのID => 入力)</summary>
+        public List<Dictionary<string, object>> Frames { get; private set; }
+
+        /// <summary>
+        ///   コンストラクタ
+        /// </summary>
+        public EvLockstepHistory(SerialReader reader) : base(EvType.LockstepHistory, reader)
+        {
+            FirstFrame = reader.ReadUInt();
+            var list = reader.ReadList();
+            Frames = new List<Dictionary<string, object>>(list.Count);
+            foreach (var f in list)
+            {
+                Frames.Add((Dictionary<string, object>)f);
+            }
+        }
+    }
+}
@@ -5,7 +5,23 @@
     /// </summary>
     public class EvMasterSwitched : Event
     {
+        /// <summary>
+        ///   交代した理由
+        /// </summary>
+        public enum SwitchReason : byte
+        {
+            /// <summary>マスターが交代させた</summary>
+            Requested = 0,
+            /// <summary>無人の部屋に入室したプレイヤーがマスターになった</summary>
+            Assigned,
+            /// <summary>マスターからの通信が途絶えたのでサーバが交代させた</summary>
+            Idle,
+            /// <summary>マスターが観戦者になった</summary>
+            Demoted,
+        }
+
         public string NewMasterId { get; private set; }
+        public SwitchReason Reason { get; private set; }
 
         /// <summary>
         ///   コンストラクタ
@@ -13,6 +29,10 @@
         public EvMasterSwitched(SerialReader reader) : base(EvType.MasterSwitched, reader)
         {
             NewMasterId = reader.ReadString();
+            if (reader.GetRest().Count > 0)
+            {
+                Reason = (SwitchReason)reader.ReadByte();
+            }
         }
     }
 }
@@ -0,0 +1,24 @@
+﻿namespace WSNet2
+{
+    /// <summary>
+    ///   部屋が別のGameサーバに移動しました
+    /// </summary>
+    /// <remarks>
+    ///   <para>
+    ///     この後サーバから切断されるので、移動先のURLに再接続します。
+    ///   </para>
+    /// </remarks>
+    public class EvMigrated : Event
+    {
+        /// <summary>移動先のwebsocketのURL</summary>
+        public string Url { get; private set; }
+
+        /// <summary>
+        ///   コンストラクタ
+        /// </summary>
+        public EvMigrated(SerialReader reader) : base(EvType.Migrated, reader)
+        {
+            Url = reader.ReadString();
+        }
+    }
+}
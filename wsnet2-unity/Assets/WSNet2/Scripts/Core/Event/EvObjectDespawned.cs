@@ -0,0 +1,19 @@
+﻿namespace WSNet2
+{
+    /// <summary>
+    ///   ネットワークオブジェクトが削除されました
+    /// </summary>
+    public class EvObjectDespawned : Event
+    {
+        /// <summary>オブジェクトのID</summary>
+        public string ObjectID { get; private set; }
+
+        /// <summary>
+        ///   コンストラクタ
+        /// </summary>
+        public EvObjectDespawned(SerialReader reader) : base(EvType.ObjectDespawned, reader)
+        {
+            ObjectID = reader.ReadString();
+        }
+    }
+}
@@ -0,0 +1,23 @@
+﻿namespace WSNet2
+{
+    /// <summary>
+    ///   ネットワークオブジェクトのオーナーが変わりました
+    /// </summary>
+    public class EvObjectOwnerChanged : Event
+    {
+        /// <summary>オブジェクトのID</summary>
+        public string ObjectID { get; private set; }
+
+        /// <summary>新しいオーナーのID (空ならオーナーなし)</summary>
+        public string Owner { get; private set; }
+
+        /// <summary>
+        ///   コンストラクタ
+        /// </summary>
+        public EvObjectOwnerChanged(SerialReader reader) : base(EvType.ObjectOwnerChanged, reader)
+        {
+            ObjectID = reader.ReadString();
+            Owner = reader.ReadString();
+        }
+    }
+}
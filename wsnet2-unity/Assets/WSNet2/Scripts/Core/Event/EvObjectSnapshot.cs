@@ -0,0 +1,26 @@
+﻿using System.Collections.Generic;
+
+namespace WSNet2
+{
+    /// <summary>
+    ///   入室時に既存のネットワークオブジェクトが届きました
+    /// </summary>
+    public class EvObjectSnapshot : Event
+    {
+        /// <summary>生成順のオブジェクト</summary>
+        public List<NetObject> Objects { get; private set; }
+
+        /// <summary>
+        ///   コンストラクタ
+        /// </summary>
+        public EvObjectSnapshot(SerialReader reader) : base(EvType.ObjectSnapshot, reader)
+        {
+            var count = reader.ReadByte();
+            Objects = new List<NetObject>(count);
+            for (var i = 0; i < count; i++)
+            {
+                Objects.Add(new NetObject(reader));
+            }
+        }
+    }
+}
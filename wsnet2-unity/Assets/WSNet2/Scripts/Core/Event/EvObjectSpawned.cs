@@ -0,0 +1,19 @@
+﻿namespace WSNet2
+{
+    /// <summary>
+    ///   ネットワークオブジェクトが生成されました
+    /// </summary>
+    public class EvObjectSpawned : Event
+    {
+        /// <summary>生成されたオブジェクト</summary>
+        public NetObject Object { get; private set; }
+
+        /// <summary>
+        ///   コンストラクタ
+        /// </summary>
+        public EvObjectSpawned(SerialReader reader) : base(EvType.ObjectSpawned, reader)
+        {
+            Object = new NetObject(reader);
+        }
+    }
+}
@@ -0,0 +1,25 @@
+﻿using System.Collections.Generic;
+
+namespace WSNet2
+{
+    /// <summary>
+    ///   ネットワークオブジェクトの状態が更新されました
+    /// </summary>
+    public class EvObjectUpdated : Event
+    {
+        /// <summary>オブジェクトのID</summary>
+        public string ObjectID { get; private set; }
+
+        /// <summary>変更された状態 (削除されたキーはnull)</summary>
+        public Dictionary<string, object> State { get; private set; }
+
+        /// <summary>
+        ///   コンストラクタ
+        /// </summary>
+        public EvObjectUpdated(SerialReader reader) : base(EvType.ObjectUpdated, reader)
+        {
+            ObjectID = reader.ReadString();
+            State = reader.ReadDict() ?? new Dictionary<string, object>();
+        }
+    }
+}
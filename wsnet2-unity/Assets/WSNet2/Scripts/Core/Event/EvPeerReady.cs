@@ -16,12 +16,30 @@
         /// </remarks>
         public int LastMsgSeqNum { get; private set; }
 
+        /// <summary>
+        ///   通常メッセージにサーバの時刻が付くか
+        /// </summary>
+        /// <remarks>
+        ///   <para>
+        ///     Wsnet2-EventTimestampヘッダで要求し、サーバが対応していればtrueになる。
+        ///   </para>
+        /// </remarks>
+        public bool EventTimestamp { get; private set; }
+
+        const int flagEventTimestamp = 1 << 0;
+
         /// <summary>
         ///   コンストラクタ
         /// </summary>
         public EvPeerReady(SerialReader reader) : base(EvType.PeerReady, reader)
         {
             LastMsgSeqNum = reader.Get24();
+
+            // 古いサーバはflagsを送ってこない
+            if (reader.GetRest().Count > 0)
+            {
+                EventTimestamp = (reader.Get8() & flagEventTimestamp) != 0;
+            }
         }
     }
 }
@@ -9,6 +9,15 @@ namespace WSNet2
         public ulong RTT { get; private set; }
         public uint WatcherCount { get; private set; }
 
+        /// <summary>
+        ///   サーバの時計とのずれ (サーバの時刻 - 手元の時刻, millisec)
+        /// </summary>
+        /// <remarks>
+        ///   サーバがPingの受信時刻とPongの送信時刻を送ってこないときは0 (HasClockOffset=false)
+        /// </remarks>
+        public long ClockOffset { get; private set; }
+        public bool HasClockOffset { get; private set; }
+
         Dictionary<string, ulong> lastMsgTimestamps;
 
         public EvPong(SerialReader reader) : base(EvType.Pong, reader)
@@ -18,16 +27,22 @@ namespace WSNet2
             PingTimestamp = reader.ReadULong();
             WatcherCount = reader.ReadUInt();
             RTT = now - PingTimestamp;
-        }
 
-        public void GetLastMsgTimestamps(Dictionary<string, ulong> output)
-        {
-            if (lastMsgTimestamps == null)
+            // サーバの時刻はlastMsgTimestampsの後ろにあるので先に読む
+            lastMsgTimestamps = reader.ReadULongDict() ?? new Dictionary<string, ulong>();
+
+            // 古いサーバは時刻を送ってこない
+            if (reader.GetRest().Count > 0)
             {
-                lastMsgTimestamps = reader.ReadIntoULongDict(output);
-                return;
+                var received = reader.ReadULong();
+                var sent = reader.ReadULong();
+                ClockOffset = ((long)received - (long)PingTimestamp + (long)sent - (long)now) / 2;
+                HasClockOffset = true;
             }
+        }
 
+        public void GetLastMsgTimestamps(Dictionary<string, ulong> output)
+        {
             foreach (var kv in lastMsgTimestamps)
             {
                 output[kv.Key] = kv.Value;
@@ -0,0 +1,42 @@
+﻿namespace WSNet2
+{
+    /// <summary>
+    ///   乱数の種類
+    /// </summary>
+    public enum RandomKind
+    {
+        /// <summary>0以上max未満の乱数</summary>
+        Values = 0,
+        /// <summary>0からcount-1までを並べ替えた順列</summary>
+        Shuffle,
+    }
+
+    /// <summary>
+    ///   サーバが乱数を生成しました
+    /// </summary>
+    public class EvRandom : Event
+    {
+        /// <summary>乱数を要求したPlayerのID</summary>
+        public string RequesterID { get; private set; }
+
+        /// <summary>要求時に指定したタグ</summary>
+        public string Tag { get; private set; }
+
+        /// <summary>乱数の種類</summary>
+        public RandomKind Kind { get; private set; }
+
+        /// <summary>生成された乱数</summary>
+        public uint[] Values { get; private set; }
+
+        /// <summary>
+        ///   コンストラクタ
+        /// </summary>
+        public EvRandom(SerialReader reader) : base(EvType.Random, reader)
+        {
+            RequesterID = reader.ReadString();
+            Tag = reader.ReadString();
+            Kind = (RandomKind)reader.ReadByte();
+            Values = reader.ReadUInts();
+        }
+    }
+}
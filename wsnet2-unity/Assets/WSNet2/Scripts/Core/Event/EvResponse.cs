@@ -12,6 +12,8 @@ namespace WSNet2
     ///     - Succeeded
     ///     - PermissionDenied
     ///     - TargetNotFound
+    ///     - RateLimited
+    ///     - InvalidPayload
     ///   </para>
     ///   <para>
     ///     レスポンスがあるのは次のMsg
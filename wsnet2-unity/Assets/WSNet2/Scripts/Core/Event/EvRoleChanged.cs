@@ -0,0 +1,47 @@
+﻿using System.Collections.Generic;
+
+namespace WSNet2
+{
+    /// <summary>
+    ///   クライアントがプレイヤーと観戦者の間で移動しました
+    /// </summary>
+    public class EvRoleChanged : Event
+    {
+        /// <summary>クライアントのID</summary>
+        public string ClientID { get; private set; }
+
+        /// <summary>プレイヤーになったらtrue、観戦者になったらfalse</summary>
+        public bool IsPlayer { get; private set; }
+
+        /// <summary>プロパティ（内部保持用）</summary>
+        Dictionary<string, object> props;
+
+        /// <summary>
+        ///   コンストラクタ
+        /// </summary>
+        public EvRoleChanged(SerialReader reader) : base(EvType.RoleChanged, reader)
+        {
+            ClientID = reader.ReadString();
+            IsPlayer = reader.ReadBool();
+            props = null;
+        }
+
+        /// <summary>
+        ///   Propを取得
+        /// </summary>
+        /// <remarks>
+        ///   <para>
+        ///     可能ならrecycleを再利用したいため、Unityのメインスレッドから呼ぶ必要がある。
+        ///   </para>
+        /// </remarks>
+        public Dictionary<string, object> GetProps(IDictionary<string, object> recycle = null)
+        {
+            if (props == null)
+            {
+                props = reader.ReadDict(recycle);
+            }
+
+            return props;
+        }
+    }
+}
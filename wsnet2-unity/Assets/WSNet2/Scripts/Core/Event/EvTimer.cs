@@ -0,0 +1,22 @@
+﻿namespace WSNet2
+{
+    /// <summary>
+    ///   サーバ側のタイマーが発火しました
+    /// </summary>
+    public class EvTimer : Event
+    {
+        /// <summary>タイマーのID</summary>
+        public string TimerID { get; private set; }
+
+        /// <summary>タイマー設定時に指定したデータ</summary>
+        public SerialReader Reader { get { return reader; } }
+
+        /// <summary>
+        ///   コンストラクタ
+        /// </summary>
+        public EvTimer(SerialReader reader) : base(EvType.Timer, reader)
+        {
+            TimerID = reader.ReadString();
+        }
+    }
+}
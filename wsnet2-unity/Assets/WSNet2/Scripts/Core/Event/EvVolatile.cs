@@ -0,0 +1,32 @@
+﻿namespace WSNet2
+{
+    /// <summary>
+    ///   揮発性の値（位置など）を受信しました
+    /// </summary>
+    /// <remarks>
+    ///   <para>
+    ///     通し番号を持たず、再接続時に再送されません。
+    ///     送信者とキー毎に最新の値のみが届きます。
+    ///   </para>
+    /// </remarks>
+    public class EvVolatile : Event
+    {
+        /// <summary>送信したPlayerのID</summary>
+        public string SenderID { get; private set; }
+
+        /// <summary>キー</summary>
+        public string Key { get; private set; }
+
+        /// <summary>値</summary>
+        public SerialReader Reader { get { return reader; } }
+
+        /// <summary>
+        ///   コンストラクタ
+        /// </summary>
+        public EvVolatile(SerialReader reader) : base(EvType.Volatile, reader)
+        {
+            SenderID = reader.ReadString();
+            Key = reader.ReadString();
+        }
+    }
+}
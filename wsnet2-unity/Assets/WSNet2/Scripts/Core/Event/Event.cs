@@ -9,6 +9,9 @@ namespace WSNet2
     {
         PeerReady = 1,
         Pong,
+        Migrated,
+        Volatile,
+        Batch,
 
         Joined = EvTypeExt.regularEvType,
         Left,
@@ -17,10 +20,25 @@ namespace WSNet2
         MasterSwitched,
         Message,
         Rejoined,
+        RoleChanged,
+        Timer,
+        LockstepFrame,
+        LockstepHistory,
+        ObjectSpawned,
+        ObjectUpdated,
+        ObjectOwnerChanged,
+        ObjectDespawned,
+        ObjectSnapshot,
+        Random,
 
         Succeeded = EvTypeExt.responseEvType,
         PermissionDenied,
         TargetNotFound,
+        RateLimited,
+        InvalidPayload,
+        Rejected,
+        PropConflict,
+        TimerList,
 
         Closed = EvTypeExt.localEvType,
     }
@@ -57,13 +75,33 @@ namespace WSNet2
         /// <summary>通し番号</summary>
         public uint SequenceNum { get; private set; }
 
+        /// <summary>
+        ///   サーバがイベントを生成した時刻 (unixtime millisec)
+        /// </summary>
+        /// <remarks>
+        ///   時刻を付けないサーバから受け取ったときやシステムイベントでは0
+        /// </remarks>
+        public ulong Timestamp { get; private set; }
+
         protected SerialReader reader;
 
         /// <summary>
         ///   受信バイト列からEventを構築
         /// </summary>
-        public static Event Parse(ArraySegment<byte> buf)
+        /// <param name="buf">受信バイト列</param>
+        /// <param name="timestamped">通常メッセージにサーバの時刻が付いている (EvPeerReadyで通知される)</param>
+        public static Event Parse(ArraySegment<byte> buf, bool timestamped = false)
         {
+            ulong timestamp = 0;
+            if (timestamped && buf.Count >= 13 && ((EvType)buf.Array[buf.Offset]).IsRegular())
+            {
+                // | type | seq32 | timestamp64 | payload |
+                // 時刻を取り出し、type,seqを詰めて時刻なしの形式にする
+                timestamp = WSNet2Serializer.NewReader(new ArraySegment<byte>(buf.Array, buf.Offset + 5, 8)).Get64();
+                Array.Copy(buf.Array, buf.Offset, buf.Array, buf.Offset + 8, 5);
+                buf = new ArraySegment<byte>(buf.Array, buf.Offset + 8, buf.Count - 8);
+            }
+
             var reader = WSNet2Serializer.NewReader(buf);
             var type = (EvType)reader.Get8();
 
@@ -76,6 +114,12 @@ namespace WSNet2
                 case EvType.Pong:
                     ev = new EvPong(reader);
                     break;
+                case EvType.Migrated:
+                    ev = new EvMigrated(reader);
+                    break;
+                case EvType.Volatile:
+                    ev = new EvVolatile(reader);
+                    break;
 
                 case EvType.Joined:
                     ev = new EvJoined(reader);
@@ -98,10 +142,45 @@ namespace WSNet2
                 case EvType.Rejoined:
                     ev = new EvRejoined(reader);
                     break;
+                case EvType.RoleChanged:
+                    ev = new EvRoleChanged(reader);
+                    break;
+                case EvType.Timer:
+                    ev = new EvTimer(reader);
+                    break;
+                case EvType.LockstepFrame:
+                    ev = new EvLockstepFrame(reader);
+                    break;
+                case EvType.LockstepHistory:
+                    ev = new EvLockstepHistory(reader);
+                    break;
+                case EvType.ObjectSpawned:
+                    ev = new EvObjectSpawned(reader);
+                    break;
+                case EvType.ObjectUpdated:
+                    ev = new EvObjectUpdated(reader);
+                    break;
+                case EvType.ObjectOwnerChanged:
+                    ev = new EvObjectOwnerChanged(reader);
+                    break;
+                case EvType.ObjectDespawned:
+                    ev = new EvObjectDespawned(reader);
+                    break;
+                case EvType.ObjectSnapshot:
+                    ev = new EvObjectSnapshot(reader);
+                    break;
+                case EvType.Random:
+                    ev = new EvRandom(reader);
+                    break;
 
                 case EvType.Succeeded:
                 case EvType.PermissionDenied:
                 case EvType.TargetNotFound:
+                case EvType.RateLimited:
+                case EvType.InvalidPayload:
+                case EvType.Rejected:
+                case EvType.PropConflict:
+                case EvType.TimerList:
                     ev = new EvResponse(type, reader);
                     break;
 
@@ -110,6 +189,7 @@ namespace WSNet2
             }
 
             ev.BufferArray = buf.Array;
+            ev.Timestamp = timestamp;
             return ev;
         }
 
@@ -33,4 +33,12 @@ namespace WSNet2
     {
         public RoomFullException(string message) : base(message) { }
     }
+
+    /// <summary>
+    ///   入室禁止されていて入室できなかった例外
+    /// </summary>
+    public class BannedException : LobbyNormalException
+    {
+        public BannedException(string message) : base(message) { }
+    }
 }
@@ -15,13 +15,16 @@ namespace WSNet2
         {
             this.hmac = hmac;
             this.hsize = hmac.HashSize / 8;
-            this.buf = new byte[9 + hsize];
+            this.buf = new byte[13 + hsize];
             buf[0] = (byte)MsgType.Ping;
 
             Value = new ArraySegment<byte>(buf);
         }
 
-        public ulong SetTimestamp()
+        /// <summary>
+        ///   現在時刻と前回のRTTを書き込む
+        /// </summary>
+        public ulong SetTimestamp(ulong rttMillisec)
         {
             var now = DateTime.UtcNow;
             var unix = (ulong)((DateTimeOffset)now).ToUnixTimeMilliseconds();
@@ -35,13 +38,19 @@ namespace WSNet2
             buf[7] = (byte)((unix & 0xff00) >> 8);
             buf[8] = (byte)(unix & 0xff);
 
+            var rtt = (uint)Math.Min(rttMillisec, uint.MaxValue);
+            buf[9] = (byte)((rtt & 0xff000000) >> 24);
+            buf[10] = (byte)((rtt & 0xff0000) >> 16);
+            buf[11] = (byte)((rtt & 0xff00) >> 8);
+            buf[12] = (byte)(rtt & 0xff);
+
             byte[] hash;
             lock (hmac)
             {
-                hash = hmac.ComputeHash(buf, 0, 9);
+                hash = hmac.ComputeHash(buf, 0, 13);
             }
 
-            Buffer.BlockCopy(hash, 0, buf, 9, hsize);
+            Buffer.BlockCopy(hash, 0, buf, 13, hsize);
 
             return unix;
         }
@@ -0,0 +1,33 @@
+﻿using System.Collections.Generic;
+
+namespace WSNet2
+{
+    /// <summary>
+    ///   部屋に登録されたネットワークオブジェクト
+    /// </summary>
+    public class NetObject
+    {
+        /// <summary>オブジェクトのID</summary>
+        public string ID { get; private set; }
+
+        /// <summary>オーナーのID (空ならオーナーなし)</summary>
+        public string Owner { get; internal set; }
+
+        /// <summary>クラスID</summary>
+        public byte ClassId { get; private set; }
+
+        /// <summary>状態</summary>
+        public Dictionary<string, object> State { get; private set; }
+
+        /// <summary>
+        ///   受信したイベントから読み取る
+        /// </summary>
+        internal NetObject(SerialReader reader)
+        {
+            ID = reader.ReadString();
+            Owner = reader.ReadString();
+            ClassId = reader.ReadByte();
+            State = reader.ReadDict() ?? new Dictionary<string, object>();
+        }
+    }
+}
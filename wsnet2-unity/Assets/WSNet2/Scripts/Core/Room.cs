@@ -30,15 +30,30 @@ namespace WSNet2
         /// <summary>部屋内の全Player</summary>
         public IReadOnlyDictionary<string, Player> Players { get => players; }
 
+        /// <summary>部屋内のネットワークオブジェクト</summary>
+        public IReadOnlyDictionary<string, NetObject> Objects { get => objects; }
+
         /// <summary>ルームの非公開プロパティ</summary>
         public IReadOnlyDictionary<string, object> PrivateProps { get => privateProps; }
 
         /// <summary>マスタークライアント</summary>
-        public Player Master { get => players[masterId]; }
+        /// <remarks>
+        ///   無人になった部屋などMasterがいないときはnull
+        /// </remarks>
+        public Player Master
+        {
+            get => (!string.IsNullOrEmpty(masterId) && players.TryGetValue(masterId, out var master)) ? master : null;
+        }
 
         /// <summary>Ping応答時間 (millisec)</summary>
         public ulong RttMillisec { get; private set; }
 
+        /// <summary>サーバの時計とのずれ (サーバの時刻 - 手元の時刻, millisec)</summary>
+        public long ServerClockOffsetMillisec { get; private set; }
+
+        /// <summary>サーバの時計での現在時刻 (unixtime millisec). Event.Timestampと比較できる</summary>
+        public ulong ServerNow { get => (ulong)(DateTimeOffset.Now.ToUnixTimeMilliseconds() + ServerClockOffsetMillisec); }
+
         /// <summary>全Playerの最終メッセージ受信時刻 (playerId => unixtime millisec)</summary>
         public IReadOnlyDictionary<string, ulong> LastMsgTimestamps { get => lastMsgTimestamps; }
 
@@ -71,12 +86,79 @@ namespace WSNet2
         /// OnOtherPlayerLeft(player, message)
         public Action<Player, string> OnOtherPlayerLeft;
 
+        /// <summary>
+        ///   プレイヤーと観戦者の間の移動通知
+        /// </summary>
+        /// OnRoleChanged(player, isPlayer)
+        public Action<Player, bool> OnRoleChanged;
+
         /// <summary>
         ///  マスタープレイヤーの変更通知
         /// </summary>
         /// OnMasterPlayerSwitched(previousMaster, newMaster)
+        /// <remarks>
+        ///   無人の部屋では previousMaster, 最後のPlayerが退室したときは newMaster がnull
+        /// </remarks>
         public Action<Player, Player> OnMasterPlayerSwitched;
 
+        /// <summary>
+        ///   サーバ側のタイマーの発火通知
+        /// </summary>
+        /// OnTimerFired(timerId, reader)
+        public Action<string, SerialReader> OnTimerFired;
+
+        /// <summary>
+        ///   ロックステップのフレームの通知
+        /// </summary>
+        /// <remarks>
+        ///   途中入室時は過去のフレームも順に通知される
+        /// </remarks>
+        /// OnLockstepFrame(frame, inputs)
+        public Action<uint, Dictionary<string, object>> OnLockstepFrame;
+
+        /// <summary>
+        ///   ネットワークオブジェクトの生成通知
+        /// </summary>
+        /// <remarks>
+        ///   入室時は既存のオブジェクトも生成順に通知される
+        /// </remarks>
+        public Action<NetObject> OnObjectSpawned;
+
+        /// <summary>
+        ///   ネットワークオブジェクトの状態の変更通知
+        /// </summary>
+        /// OnObjectUpdated(obj, modifiedState)
+        public Action<NetObject, Dictionary<string, object>> OnObjectUpdated;
+
+        /// <summary>
+        ///   ネットワークオブジェクトのオーナーの変更通知
+        /// </summary>
+        /// OnObjectOwnerChanged(obj, previousOwner)
+        public Action<NetObject, string> OnObjectOwnerChanged;
+
+        /// <summary>
+        ///   ネットワークオブジェクトの削除通知
+        /// </summary>
+        public Action<NetObject> OnObjectDespawned;
+
+        /// <summary>
+        ///   サーバが生成した乱数の通知
+        /// </summary>
+        /// OnRandomReceived(requesterId, tag, kind, values)
+        /// <remarks>
+        ///   要求したPlayerを含む全員に同じ値が通知されます。
+        /// </remarks>
+        public Action<string, string, RandomKind, uint[]> OnRandomReceived;
+
+        /// <summary>
+        ///   揮発性の値（位置など）の受信通知
+        /// </summary>
+        /// OnVolatileReceived(senderId, key, reader)
+        /// <remarks>
+        ///   再接続時に再送されず、途中の値は届かないことがあります。
+        /// </remarks>
+        public Action<string, string, SerialReader> OnVolatileReceived;
+
         /// <summary>
         ///   部屋のプロパティの変更通知
         /// </summary>
@@ -127,6 +209,7 @@ namespace WSNet2
         string myId;
         Dictionary<string, object> privateProps;
         Dictionary<string, Player> players;
+        Dictionary<string, NetObject> objects = new Dictionary<string, NetObject>();
         string masterId;
         uint clientDeadline;
         Dictionary<string, ulong> lastMsgTimestamps;
@@ -917,6 +1000,9 @@ namespace WSNet2
                 case EvLeft evLeft:
                     OnEvLeft(evLeft);
                     break;
+                case EvRoleChanged evRoleChanged:
+                    OnEvRoleChanged(evRoleChanged);
+                    break;
                 case EvRoomProp evRoomProp:
                     OnEvRoomProp(evRoomProp);
                     break;
@@ -929,6 +1015,36 @@ namespace WSNet2
                 case EvRPC evRpc:
                     OnEvRPC(evRpc);
                     break;
+                case EvTimer evTimer:
+                    OnEvTimer(evTimer);
+                    break;
+                case EvLockstepFrame evLockstepFrame:
+                    OnEvLockstepFrame(evLockstepFrame);
+                    break;
+                case EvLockstepHistory evLockstepHistory:
+                    OnEvLockstepHistory(evLockstepHistory);
+                    break;
+                case EvObjectSpawned evObjectSpawned:
+                    OnEvObjectSpawned(evObjectSpawned);
+                    break;
+                case EvObjectUpdated evObjectUpdated:
+                    OnEvObjectUpdated(evObjectUpdated);
+                    break;
+                case EvObjectOwnerChanged evObjectOwnerChanged:
+                    OnEvObjectOwnerChanged(evObjectOwnerChanged);
+                    break;
+                case EvObjectDespawned evObjectDespawned:
+                    OnEvObjectDespawned(evObjectDespawned);
+                    break;
+                case EvObjectSnapshot evObjectSnapshot:
+                    OnEvObjectSnapshot(evObjectSnapshot);
+                    break;
+                case EvRandom evRandom:
+                    OnEvRandom(evRandom);
+                    break;
+                case EvVolatile evVolatile:
+                    OnEvVolatile(evVolatile);
+                    break;
                 case EvClosed evClosed:
                     OnEvClosed(evClosed);
                     break;
@@ -955,6 +1071,10 @@ namespace WSNet2
             {
                 info.watchers = ev.WatcherCount;
                 RttMillisec = ev.RTT;
+                if (ev.HasClockOffset)
+                {
+                    ServerClockOffsetMillisec = ev.ClockOffset;
+                }
                 ev.GetLastMsgTimestamps(lastMsgTimestamps);
                 OnPongReceived?.Invoke(RttMillisec, info.watchers, lastMsgTimestamps);
             });
@@ -1036,6 +1156,38 @@ namespace WSNet2
             });
         }
 
+        /// <summary>
+        ///   プレイヤーと観戦者の間の移動イベント
+        /// </summary>
+        private void OnEvRoleChanged(EvRoleChanged ev)
+        {
+            logger?.Info("role changed: {0}: isPlayer={1}", ev.ClientID, ev.IsPlayer);
+
+            callbackPool.Add(() =>
+            {
+                Player player;
+                if (ev.IsPlayer)
+                {
+                    player = (ev.ClientID == myId) ? Me : new Player(ev.ClientID, null);
+                    player.Props = ev.GetProps(player.Props);
+                    players[player.Id] = player;
+                    lastMsgTimestamps[player.Id] = 0;
+                }
+                else
+                {
+                    if (!players.TryGetValue(ev.ClientID, out player))
+                    {
+                        player = new Player(ev.ClientID, ev.GetProps());
+                    }
+                    players.Remove(player.Id);
+                    lastMsgTimestamps.Remove(player.Id);
+                }
+
+                info.players = (uint)players.Count;
+                OnRoleChanged?.Invoke(player, ev.IsPlayer);
+            });
+        }
+
         /// <summary>
         ///   Roomプロパティ変更イベント
         /// </summary>
@@ -1146,12 +1298,178 @@ namespace WSNet2
 
             callbackPool.Add(() =>
             {
+                // 無人の部屋に入室したPlayerがMasterになったときは、以前のMasterはいない (null)
                 var prev = Master;
                 masterId = ev.NewMasterId;
                 OnMasterPlayerSwitched?.Invoke(prev, Master);
             });
         }
 
+        /// <summary>
+        ///   タイマー発火イベント
+        /// </summary>
+        private void OnEvTimer(EvTimer ev)
+        {
+            logger?.Debug("timer fired: {0}", ev.TimerID);
+
+            callbackPool.Add(() =>
+            {
+                OnTimerFired?.Invoke(ev.TimerID, ev.Reader);
+            });
+        }
+
+        /// <summary>
+        ///   ロックステップのフレームイベント
+        /// </summary>
+        private void OnEvLockstepFrame(EvLockstepFrame ev)
+        {
+            callbackPool.Add(() =>
+            {
+                OnLockstepFrame?.Invoke(ev.Frame, ev.Inputs);
+            });
+        }
+
+        /// <summary>
+        ///   ロックステップの過去のフレームイベント
+        /// </summary>
+        private void OnEvLockstepHistory(EvLockstepHistory ev)
+        {
+            logger?.Debug("lockstep history: {0}+{1}", ev.FirstFrame, ev.Frames.Count);
+
+            callbackPool.Add(() =>
+            {
+                for (var i = 0; i < ev.Frames.Count; i++)
+                {
+                    OnLockstepFrame?.Invoke(ev.FirstFrame + (uint)i, ev.Frames[i]);
+                }
+            });
+        }
+
+        /// <summary>
+        ///   ネットワークオブジェクト生成イベント
+        /// </summary>
+        private void OnEvObjectSpawned(EvObjectSpawned ev)
+        {
+            logger?.Debug("object spawned: {0}", ev.Object.ID);
+
+            callbackPool.Add(() =>
+            {
+                objects[ev.Object.ID] = ev.Object;
+                OnObjectSpawned?.Invoke(ev.Object);
+            });
+        }
+
+        /// <summary>
+        ///   ネットワークオブジェクト状態変更イベント
+        /// </summary>
+        private void OnEvObjectUpdated(EvObjectUpdated ev)
+        {
+            callbackPool.Add(() =>
+            {
+                if (!objects.TryGetValue(ev.ObjectID, out var obj))
+                {
+                    logger?.Warning("object not found: {0}", ev.ObjectID);
+                    return;
+                }
+
+                foreach (var kv in ev.State)
+                {
+                    if (kv.Value == null)
+                    {
+                        obj.State.Remove(kv.Key);
+                    }
+                    else
+                    {
+                        obj.State[kv.Key] = kv.Value;
+                    }
+                }
+
+                OnObjectUpdated?.Invoke(obj, ev.State);
+            });
+        }
+
+        /// <summary>
+        ///   ネットワークオブジェクトのオーナー変更イベント
+        /// </summary>
+        private void OnEvObjectOwnerChanged(EvObjectOwnerChanged ev)
+        {
+            logger?.Debug("object owner changed: {0}: {1}", ev.ObjectID, ev.Owner);
+
+            callbackPool.Add(() =>
+            {
+                if (!objects.TryGetValue(ev.ObjectID, out var obj))
+                {
+                    logger?.Warning("object not found: {0}", ev.ObjectID);
+                    return;
+                }
+
+                var prev = obj.Owner;
+                obj.Owner = ev.Owner;
+                OnObjectOwnerChanged?.Invoke(obj, prev);
+            });
+        }
+
+        /// <summary>
+        ///   ネットワークオブジェクト削除イベント
+        /// </summary>
+        private void OnEvObjectDespawned(EvObjectDespawned ev)
+        {
+            logger?.Debug("object despawned: {0}", ev.ObjectID);
+
+            callbackPool.Add(() =>
+            {
+                if (!objects.TryGetValue(ev.ObjectID, out var obj))
+                {
+                    logger?.Warning("object not found: {0}", ev.ObjectID);
+                    return;
+                }
+
+                objects.Remove(ev.ObjectID);
+                OnObjectDespawned?.Invoke(obj);
+            });
+        }
+
+        /// <summary>
+        ///   入室時のネットワークオブジェクト一覧イベント
+        /// </summary>
+        private void OnEvObjectSnapshot(EvObjectSnapshot ev)
+        {
+            logger?.Debug("object snapshot: {0}", ev.Objects.Count);
+
+            callbackPool.Add(() =>
+            {
+                foreach (var obj in ev.Objects)
+                {
+                    objects[obj.ID] = obj;
+                    OnObjectSpawned?.Invoke(obj);
+                }
+            });
+        }
+
+        /// <summary>
+        ///   乱数イベント
+        /// </summary>
+        private void OnEvRandom(EvRandom ev)
+        {
+            logger?.Debug("random: requester={0}, tag={1}", ev.RequesterID, ev.Tag);
+
+            callbackPool.Add(() =>
+            {
+                OnRandomReceived?.Invoke(ev.RequesterID, ev.Tag, ev.Kind, ev.Values);
+            });
+        }
+
+        /// <summary>
+        ///   揮発性の値のイベント
+        /// </summary>
+        private void OnEvVolatile(EvVolatile ev)
+        {
+            callbackPool.Add(() =>
+            {
+                OnVolatileReceived?.Invoke(ev.SenderID, ev.Key, ev.Reader);
+            });
+        }
+
         /// <summary>
         ///   RPCイベント
         /// </summary>
@@ -476,6 +476,8 @@ namespace WSNet2
                         throw new RoomNotFoundException(res.msg);
                     case LobbyResponseType.RoomFull:
                         throw new RoomFullException(res.msg);
+                    case LobbyResponseType.Banned:
+                        throw new BannedException(res.msg);
                 }
 
                 var logger = prepareLogger(roomLogger);